@@ -17,23 +17,51 @@ package docker
 import (
 	"context"
 	"encoding/json"
+	"io"
 	"os"
+	"time"
 
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/client"
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 )
 
 const (
 	// v1.27 is the oldest API version
 	// which has all the latest changes to the APIs we use.
 	minDockerAPIVersion = "1.27"
+
+	malformedStatsRetryInterval = 100 * time.Millisecond
 )
 
+// ErrNoValidStats is the cause of the error returned when Docker only returned malformed stats before the context was done
+var ErrNoValidStats = errors.New("Docker returned no valid stats")
+
 // Client is a wrapper for Docker SDK Client
 type Client interface {
+	ContainerCgroupnsMode(ctx context.Context, longContainerID string) (string, error)
+	ContainerEvents(ctx context.Context) (<-chan events.Message, <-chan error)
+	ContainerInspect(ctx context.Context, longContainerID string) (*types.ContainerJSON, error)
+	ContainerInspectWithSize(ctx context.Context, longContainerID string) (*types.ContainerJSON, error)
 	ContainerList(context.Context) ([]types.Container, error)
 	ContainerStats(ctx context.Context, longContainerID string) (*types.Stats, error)
+	ImageInspect(ctx context.Context, imageID string) (*types.ImageInspect, error)
+	Ping(ctx context.Context) error
+}
+
+// StatsFrameClient is implemented by clients which can read the parts of the stats payload from the Docker API
+// which are not in types.Stats: the GPU stats which some setups add, and the network stats
+type StatsFrameClient interface {
+	ContainerStatsFrame(ctx context.Context, longContainerID string) (*StatsFrame, error)
+}
+
+// NetworkStatsClient is implemented by clients which can read the container's network stats, which are in the
+// stats payload from the Docker API as the networks field, keyed by interface
+type NetworkStatsClient interface {
+	ContainerStatsWithNetworks(ctx context.Context, longContainerID string) (*types.Stats, map[string]types.NetworkStats, error)
 }
 
 type dockerClient struct {
@@ -62,18 +90,155 @@ func (c *dockerClient) ContainerList(ctx context.Context) ([]types.Container, er
 	return c.sdkClient.ContainerList(ctx, types.ContainerListOptions{})
 }
 
+// ContainerEvents streams the events of containers which are created, started, stopped, or removed, until the
+// context is done or the stream fails, which is reported on the error channel
+func (c *dockerClient) ContainerEvents(ctx context.Context) (<-chan events.Message, <-chan error) {
+	return c.sdkClient.Events(ctx, types.EventsOptions{
+		Filters: filters.NewArgs(
+			filters.Arg("type", events.ContainerEventType),
+			filters.Arg("event", "create"),
+			filters.Arg("event", "start"),
+			filters.Arg("event", "die"),
+			filters.Arg("event", "destroy"),
+		),
+	})
+}
+
+// ContainerInspect returns the low-level information Docker has about the container
+func (c *dockerClient) ContainerInspect(ctx context.Context, longContainerID string) (*types.ContainerJSON, error) {
+	data, err := c.sdkClient.ContainerInspect(ctx, longContainerID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to inspect container %s", longContainerID)
+	}
+	return &data, nil
+}
+
+// ImageInspect returns the low-level information Docker has about the image
+func (c *dockerClient) ImageInspect(ctx context.Context, imageID string) (*types.ImageInspect, error) {
+	data, _, err := c.sdkClient.ImageInspectWithRaw(ctx, imageID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to inspect image %s", imageID)
+	}
+	return &data, nil
+}
+
+// Ping checks that the Docker daemon is reachable
+func (c *dockerClient) Ping(ctx context.Context) error {
+	if _, err := c.sdkClient.Ping(ctx); err != nil {
+		return errors.Wrap(err, "failed to ping the Docker daemon")
+	}
+	return nil
+}
+
+// ContainerInspectWithSize returns the same information as ContainerInspect, and the sizes of the container's
+// filesystem, which Docker computes by walking it, and so is much slower
+func (c *dockerClient) ContainerInspectWithSize(ctx context.Context, longContainerID string) (*types.ContainerJSON, error) {
+	data, _, err := c.sdkClient.ContainerInspectWithRaw(ctx, longContainerID, true)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to inspect the size of container %s", longContainerID)
+	}
+	return &data, nil
+}
+
+// ContainerCgroupnsMode returns the container's cgroup namespace mode, host or private, which is empty for daemons
+// without cgroup namespace support. The Docker SDK's HostConfig predates the mode, so it is read from the raw inspect response.
+func (c *dockerClient) ContainerCgroupnsMode(ctx context.Context, longContainerID string) (string, error) {
+	_, raw, err := c.sdkClient.ContainerInspectWithRaw(ctx, longContainerID, false)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to inspect container %s", longContainerID)
+	}
+	mode, err := parseCgroupnsMode(raw)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read the cgroup namespace mode of container %s", longContainerID)
+	}
+	return mode, nil
+}
+
+// parseCgroupnsMode reads HostConfig.CgroupnsMode from a raw container inspect response
+func parseCgroupnsMode(raw []byte) (string, error) {
+	var inspect struct {
+		HostConfig *struct {
+			CgroupnsMode string
+		}
+	}
+	if err := json.Unmarshal(raw, &inspect); err != nil {
+		return "", err
+	}
+	if inspect.HostConfig == nil {
+		return "", nil
+	}
+	return inspect.HostConfig.CgroupnsMode, nil
+}
+
 func (c *dockerClient) ContainerStats(ctx context.Context, longContainerID string) (*types.Stats, error) {
-	resp, err := c.sdkClient.ContainerStats(ctx, longContainerID, false)
+	frame, err := c.readStatsFrame(ctx, longContainerID)
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to get docker stats for %s", longContainerID)
+		return nil, err
 	}
+	return &frame.Stats, nil
+}
 
-	decoder := json.NewDecoder(resp.Body)
-	data := new(types.Stats)
-	err = decoder.Decode(data)
-	defer resp.Body.Close()
+// ContainerStatsFrame returns a single frame of the container's stats, including the GPU and network stats
+func (c *dockerClient) ContainerStatsFrame(ctx context.Context, longContainerID string) (*StatsFrame, error) {
+	return c.readStatsFrame(ctx, longContainerID)
+}
+
+// ContainerStatsWithNetworks returns the container's stats, and its network stats, which are nil if the container
+// has no network interfaces of its own, such as with the host network
+func (c *dockerClient) ContainerStatsWithNetworks(ctx context.Context, longContainerID string) (*types.Stats, map[string]types.NetworkStats, error) {
+	frame, err := c.readStatsFrame(ctx, longContainerID)
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to get docker stats for %s", longContainerID)
+		return nil, nil, err
+	}
+	return &frame.Stats, frame.Networks, nil
+}
+
+// readStatsFrame reads a single frame of the container's stats
+func (c *dockerClient) readStatsFrame(ctx context.Context, longContainerID string) (*StatsFrame, error) {
+	return readValidStats(ctx, longContainerID, func() (io.ReadCloser, error) {
+		resp, err := c.sdkClient.ContainerStats(ctx, longContainerID, false)
+		if err != nil {
+			return nil, err
+		}
+		return resp.Body, nil
+	})
+}
+
+// StatsFrame is a single frame of stats from Docker
+// GPUStats is nil if the payload has none; Networks is nil if the container has no network interfaces of its own,
+// such as with the host network
+type StatsFrame struct {
+	types.Stats
+	GPUStats json.RawMessage               `json:"gpu_stats,omitempty"`
+	Networks map[string]types.NetworkStats `json:"networks,omitempty"`
+}
+
+// readValidStats reads stats from Docker until a frame can be decoded, since the daemon occasionally returns
+// a malformed or empty frame. nextFrame requests the next frame.
+func readValidStats(ctx context.Context, longContainerID string, nextFrame func() (io.ReadCloser, error)) (*StatsFrame, error) {
+	malformed := false
+	for {
+		body, err := nextFrame()
+		if err != nil {
+			if malformed && ctx.Err() != nil {
+				return nil, errors.Wrapf(ErrNoValidStats, "failed to get docker stats for %s", longContainerID)
+			}
+			return nil, errors.Wrapf(err, "failed to get docker stats for %s", longContainerID)
+		}
+
+		data := new(StatsFrame)
+		err = json.NewDecoder(body).Decode(data)
+		body.Close()
+		if err == nil {
+			return data, nil
+		}
+		logrus.Debugf("Skipping malformed docker stats for %s: %s", longContainerID, err)
+		malformed = true
+
+		select {
+		case <-ctx.Done():
+			return nil, errors.Wrapf(ErrNoValidStats, "failed to get docker stats for %s", longContainerID)
+		case <-time.After(malformedStatsRetryInterval):
+		}
 	}
-	return data, nil
 }
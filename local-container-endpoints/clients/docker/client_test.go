@@ -0,0 +1,124 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package docker
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadValidStatsSkipsMalformedFrames(t *testing.T) {
+	frames := []string{
+		`{"read": "2019-03-01T20:55:11.064236631Z", "cpu_stats": {`,
+		``,
+		`{"read": "2019-03-01T20:55:12.064236631Z", "cpu_stats": {"system_cpu_usage": 1000}}`,
+	}
+	requests := 0
+	nextFrame := func() (io.ReadCloser, error) {
+		frame := frames[requests]
+		requests++
+		return ioutil.NopCloser(strings.NewReader(frame)), nil
+	}
+
+	stats, err := readValidStats(context.Background(), "container", nextFrame)
+	if assert.NoError(t, err, "Expected malformed frames to be skipped") {
+		assert.Equal(t, uint64(1000), stats.CPUStats.SystemUsage, "Expected stats from the first valid frame")
+		assert.Equal(t, 3, requests, "Expected a request for each frame")
+	}
+}
+
+func TestReadValidStatsGPUStats(t *testing.T) {
+	gpuStats := `[{"id":"0","utilization_gpu":42,"memory_used":1024}]`
+	frames := []string{
+		`{"read": "2019-03-01T20:55:12.064236631Z", "cpu_stats": {"system_cpu_usage": 1000}}`,
+		`{"read": "2019-03-01T20:55:12.064236631Z", "cpu_stats": {"system_cpu_usage": 1000}, "gpu_stats": ` + gpuStats + `}`,
+	}
+	for i, frame := range frames {
+		nextFrame := func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(strings.NewReader(frame)), nil
+		}
+
+		stats, err := readValidStats(context.Background(), "container", nextFrame)
+		if assert.NoError(t, err, "Unexpected error reading stats") {
+			assert.Equal(t, uint64(1000), stats.CPUStats.SystemUsage, "Expected the Docker stats to be decoded")
+			if i == 0 {
+				assert.Nil(t, stats.GPUStats, "Expected no GPU stats when the payload has none")
+			} else {
+				assert.JSONEq(t, gpuStats, string(stats.GPUStats), "Expected the GPU stats to be passed through")
+			}
+		}
+	}
+}
+
+func TestParseCgroupnsMode(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		raw      string
+		expected string
+	}{
+		{
+			name:     "private cgroupns",
+			raw:      `{"Id": "8f5a4e3b", "HostConfig": {"NetworkMode": "bridge", "CgroupnsMode": "private", "IpcMode": "private"}}`,
+			expected: "private",
+		},
+		{
+			name:     "host cgroupns",
+			raw:      `{"Id": "8f5a4e3b", "HostConfig": {"NetworkMode": "bridge", "CgroupnsMode": "host"}}`,
+			expected: "host",
+		},
+		{
+			name:     "daemon without cgroupns support",
+			raw:      `{"Id": "8f5a4e3b", "HostConfig": {"NetworkMode": "bridge"}}`,
+			expected: "",
+		},
+		{
+			name:     "no host config",
+			raw:      `{"Id": "8f5a4e3b"}`,
+			expected: "",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			mode, err := parseCgroupnsMode([]byte(testCase.raw))
+			assert.NoError(t, err, "Unexpected error reading the cgroup namespace mode")
+			assert.Equal(t, testCase.expected, mode, "Expected cgroup namespace mode to match")
+		})
+	}
+}
+
+func TestParseCgroupnsModeMalformed(t *testing.T) {
+	_, err := parseCgroupnsMode([]byte(`{"HostConfig": `))
+	assert.Error(t, err, "Expected error for a malformed inspect response")
+}
+
+func TestReadValidStatsNoValidFrame(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	nextFrame := func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(strings.NewReader(`{"read": `)), nil
+	}
+
+	_, err := readValidStats(ctx, "container", nextFrame)
+	if assert.Error(t, err, "Expected error when no valid frame arrives") {
+		assert.Equal(t, ErrNoValidStats, errors.Cause(err), "Expected the error to be caused by malformed stats")
+	}
+}
@@ -22,6 +22,7 @@ import (
 	reflect "reflect"
 
 	types "github.com/docker/docker/api/types"
+	events "github.com/docker/docker/api/types/events"
 	gomock "github.com/golang/mock/gomock"
 )
 
@@ -48,6 +49,58 @@ func (m *MockClient) EXPECT() *MockClientMockRecorder {
 	return m.recorder
 }
 
+// ContainerCgroupnsMode mocks base method
+func (m *MockClient) ContainerCgroupnsMode(arg0 context.Context, arg1 string) (string, error) {
+	ret := m.ctrl.Call(m, "ContainerCgroupnsMode", arg0, arg1)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ContainerCgroupnsMode indicates an expected call of ContainerCgroupnsMode
+func (mr *MockClientMockRecorder) ContainerCgroupnsMode(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ContainerCgroupnsMode", reflect.TypeOf((*MockClient)(nil).ContainerCgroupnsMode), arg0, arg1)
+}
+
+// ContainerEvents mocks base method
+func (m *MockClient) ContainerEvents(arg0 context.Context) (<-chan events.Message, <-chan error) {
+	ret := m.ctrl.Call(m, "ContainerEvents", arg0)
+	ret0, _ := ret[0].(<-chan events.Message)
+	ret1, _ := ret[1].(<-chan error)
+	return ret0, ret1
+}
+
+// ContainerEvents indicates an expected call of ContainerEvents
+func (mr *MockClientMockRecorder) ContainerEvents(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ContainerEvents", reflect.TypeOf((*MockClient)(nil).ContainerEvents), arg0)
+}
+
+// ContainerInspect mocks base method
+func (m *MockClient) ContainerInspect(arg0 context.Context, arg1 string) (*types.ContainerJSON, error) {
+	ret := m.ctrl.Call(m, "ContainerInspect", arg0, arg1)
+	ret0, _ := ret[0].(*types.ContainerJSON)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ContainerInspect indicates an expected call of ContainerInspect
+func (mr *MockClientMockRecorder) ContainerInspect(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ContainerInspect", reflect.TypeOf((*MockClient)(nil).ContainerInspect), arg0, arg1)
+}
+
+// ContainerInspectWithSize mocks base method
+func (m *MockClient) ContainerInspectWithSize(arg0 context.Context, arg1 string) (*types.ContainerJSON, error) {
+	ret := m.ctrl.Call(m, "ContainerInspectWithSize", arg0, arg1)
+	ret0, _ := ret[0].(*types.ContainerJSON)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ContainerInspectWithSize indicates an expected call of ContainerInspectWithSize
+func (mr *MockClientMockRecorder) ContainerInspectWithSize(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ContainerInspectWithSize", reflect.TypeOf((*MockClient)(nil).ContainerInspectWithSize), arg0, arg1)
+}
+
 // ContainerList mocks base method
 func (m *MockClient) ContainerList(arg0 context.Context) ([]types.Container, error) {
 	ret := m.ctrl.Call(m, "ContainerList", arg0)
@@ -73,3 +126,28 @@ func (m *MockClient) ContainerStats(arg0 context.Context, arg1 string) (*types.S
 func (mr *MockClientMockRecorder) ContainerStats(arg0, arg1 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ContainerStats", reflect.TypeOf((*MockClient)(nil).ContainerStats), arg0, arg1)
 }
+
+// ImageInspect mocks base method
+func (m *MockClient) ImageInspect(arg0 context.Context, arg1 string) (*types.ImageInspect, error) {
+	ret := m.ctrl.Call(m, "ImageInspect", arg0, arg1)
+	ret0, _ := ret[0].(*types.ImageInspect)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ImageInspect indicates an expected call of ImageInspect
+func (mr *MockClientMockRecorder) ImageInspect(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImageInspect", reflect.TypeOf((*MockClient)(nil).ImageInspect), arg0, arg1)
+}
+
+// Ping mocks base method
+func (m *MockClient) Ping(arg0 context.Context) error {
+	ret := m.ctrl.Call(m, "Ping", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Ping indicates an expected call of Ping
+func (mr *MockClientMockRecorder) Ping(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Ping", reflect.TypeOf((*MockClient)(nil).Ping), arg0)
+}
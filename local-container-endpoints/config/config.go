@@ -18,20 +18,179 @@ package config
 const (
 	// PortEnvVar defines the port that metadata and credentials listen at
 	PortVar = "ECS_LOCAL_METADATA_PORT"
+	// BindRetryVar defines how long to keep retrying when the port is already in use
+	BindRetryVar = "ECS_LOCAL_BIND_RETRY"
+	// MaxConnectionsVar caps the number of connections which are served at once; further connections wait to be accepted
+	MaxConnectionsVar = "ECS_LOCAL_MAX_CONNECTIONS"
+	// TaskAliasVar enables the /task path, an alias for the V3 task metadata of the caller
+	TaskAliasVar = "ECS_LOCAL_TASK_ALIAS"
+	// MetadataVersionsVar enables MetadataVersionsPath, which lists the metadata versions that are served
+	MetadataVersionsVar = "ECS_LOCAL_METADATA_VERSIONS"
+	// DebugEndpointsVar enables the paths which help to debug Local Endpoints' configuration
+	DebugEndpointsVar = "ECS_LOCAL_DEBUG_ENDPOINTS"
+	// RecentErrorsVar sets how many of the most recent errors are served at RecentErrorsPath
+	RecentErrorsVar = "ECS_LOCAL_RECENT_ERRORS"
+	// AccessLogFormatVar enables HTTP access logs, and sets their format
+	AccessLogFormatVar = "ECS_LOCAL_ACCESS_LOG_FORMAT"
+	// EnablePprofVar enables the Go profiling paths under PprofPath
+	EnablePprofVar = "ECS_LOCAL_ENABLE_PPROF"
+	// PprofPortVar sets a separate port for the profiling paths, so that they are not served with the endpoints
+	PprofPortVar = "ECS_LOCAL_PPROF_PORT"
+	// EnableMetricsVar enables the latency metrics served at MetricsPath, and the container metrics served at ContainerMetricsPath
+	EnableMetricsVar = "ECS_LOCAL_ENABLE_METRICS"
+	// EnableHealthzVar enables HealthzPath, which reports the health of Local Endpoints and its dependencies
+	EnableHealthzVar = "ECS_LOCAL_ENABLE_HEALTHZ"
+	// EnableSchemaVar enables the JSON schemas of the metadata and stats responses, served under SchemaPath
+	EnableSchemaVar = "ECS_LOCAL_ENABLE_SCHEMA"
+	// RunAsUIDVar and RunAsGIDVar set the uid and gid to switch to once the server is listening
+	RunAsUIDVar = "ECS_LOCAL_RUN_AS_UID"
+	RunAsGIDVar = "ECS_LOCAL_RUN_AS_GID"
+	// TLSCertFileVar and TLSKeyFileVar enable TLS, with the certificate and private key in the given PEM files
+	TLSCertFileVar = "ECS_LOCAL_TLS_CERT_FILE"
+	TLSKeyFileVar  = "ECS_LOCAL_TLS_KEY_FILE"
+	// TLSMinVersionVar sets the minimum TLS version which clients must use
+	TLSMinVersionVar = "ECS_LOCAL_TLS_MIN_VERSION"
+	// TLSCipherSuitesVar restricts the cipher suites which can be negotiated for TLS 1.2 and earlier
+	TLSCipherSuitesVar = "ECS_LOCAL_TLS_CIPHER_SUITES"
+	// StartupBannerVar logs a summary of the features which are enabled when Local Endpoints starts
+	StartupBannerVar = "ECS_LOCAL_STARTUP_BANNER"
+	// RequestIDHeaderVar enables request IDs, which are read from and echoed in the header it names, and included in logs
+	RequestIDHeaderVar = "ECS_LOCAL_REQUEST_ID_HEADER"
+	// TrustedProxiesVar lists the CIDR blocks of the proxies whose X-Forwarded-For headers are trusted
+	TrustedProxiesVar = "ECS_LOCAL_TRUSTED_PROXIES"
+
+	// Credentials related
+	CredentialsRefreshWindowVar = "ECS_LOCAL_CREDS_REFRESH_WINDOW"
+	MinCredsTTLVar              = "ECS_LOCAL_MIN_CREDS_TTL"
+	CredsNegativeCacheTTLVar    = "ECS_LOCAL_CREDS_NEGATIVE_CACHE_TTL"
+	AllowedRolesVar             = "ECS_LOCAL_ALLOWED_ROLES"
+	DeniedRoleStatusVar         = "ECS_LOCAL_DENIED_ROLE_STATUS"
+	CredsSourceOrderVar         = "ECS_LOCAL_CREDS_SOURCE_ORDER"
+	DefaultRoleARNVar           = "ECS_LOCAL_DEFAULT_ROLE_ARN"
+	AllowExpiredCredsVar        = "ECS_LOCAL_ALLOW_EXPIRED_CREDS"
+	CredsRetryAfterVar          = "ECS_LOCAL_CREDS_RETRY_AFTER"
+	CredsRoleRPSVar             = "ECS_LOCAL_CREDS_ROLE_RPS"
+	WarnDeprecatedPathsVar      = "ECS_LOCAL_WARN_DEPRECATED_PATHS"
+	SessionNamePerContainerVar  = "ECS_LOCAL_SESSION_NAME_PER_CONTAINER"
+	RotateSessionNameVar        = "ECS_LOCAL_ROTATE_SESSION_NAME"
+	SourceIdentityVar           = "ECS_LOCAL_SOURCE_IDENTITY"
+	AuditLogVar                 = "ECS_LOCAL_AUDIT_LOG"
+	CredsRequireTLSVar          = "ECS_LOCAL_CREDS_REQUIRE_TLS"
+	CredsQueryTokenParamVar     = "ECS_LOCAL_CREDS_QUERY_TOKEN_PARAM"
+	CredsQueryTokenValueVar     = "ECS_LOCAL_CREDS_QUERY_TOKEN_VALUE"
+	RegionHeaderVar             = "ECS_LOCAL_REGION_HEADER"
+	IMDSStyleCredsVar           = "ECS_LOCAL_IMDS_STYLE_CREDS"
+	IMDSStyleErrorsVar          = "ECS_LOCAL_IMDS_STYLE_ERRORS"
+	CredsCacheFileVar           = "ECS_LOCAL_CREDS_CACHE_FILE"
+	RoleNamePatternVar          = "ECS_LOCAL_ROLE_NAME_PATTERN"
+	UpstreamCredsURIVar         = "ECS_LOCAL_UPSTREAM_CREDS_URI"
+	ProfileVar                  = "AWS_PROFILE"
 
 	// Metadata related
 	ClusterARNVar            = "CLUSTER_ARN"
 	TaskARNVar               = "TASK_ARN"
 	TDFamilyVar              = "TASK_DEFINITION_FAMILY"
 	TDRevisionVar            = "TASK_DEFINITION_REVISION"
+	TaskDefARNVar            = "ECS_LOCAL_TASK_DEF_ARN"
+	SynthesizeTaskDefARNVar  = "ECS_LOCAL_SYNTHESIZE_TASK_DEF_ARN"
 	ContainerInstanceTagsVar = "CONTAINER_INSTANCE_TAGS"
 	TaskTagsVar              = "TASK_TAGS_VAR"
+	ContainerTaskMapVar      = "ECS_LOCAL_CONTAINER_TASK_MAP"
+	SynthesizePullTimingsVar = "ECS_LOCAL_SYNTHESIZE_PULL_TIMINGS"
+	MetadataSoftDeadlineVar  = "ECS_LOCAL_METADATA_SOFT_DEADLINE"
+	TaskNetworkStrategyVar   = "ECS_LOCAL_TASK_NETWORK_STRATEGY"
+	NetworkModeMapVar        = "ECS_LOCAL_NETWORK_MODE_MAP"
+	WarmContainersVar        = "ECS_LOCAL_WARM_CONTAINERS"
+	Warmup503Var             = "ECS_LOCAL_WARMUP_503"
+	IncludeSequenceVar       = "ECS_LOCAL_INCLUDE_SEQUENCE"
+	MetadataSnapshotTTLVar   = "ECS_LOCAL_METADATA_SNAPSHOT_TTL"
+	AutoIncrementRevisionVar = "ECS_LOCAL_AUTO_INCREMENT_REVISION"
+	AvailabilityZoneVar      = "ECS_LOCAL_AVAILABILITY_ZONE"
+	TimestampFormatVar       = "ECS_LOCAL_TIMESTAMP_FORMAT"
+	IncludeRelativeTimesVar  = "ECS_LOCAL_INCLUDE_RELATIVE_TIMES"
+	IncludeGeneratedAtVar    = "ECS_LOCAL_INCLUDE_GENERATED_AT"
+	PlatformFamilyVar        = "ECS_LOCAL_PLATFORM_FAMILY"
+	PlatformVersionVar       = "ECS_LOCAL_PLATFORM_VERSION"
+	DeploymentIDVar          = "ECS_LOCAL_DEPLOYMENT_ID"
+	VPCIDVar                 = "ECS_LOCAL_VPC_ID"
+	SubnetIDVar              = "ECS_LOCAL_SUBNET_ID"
+	EphemeralStorageGiBVar   = "ECS_LOCAL_EPHEMERAL_STORAGE_GIB"
+	RegionAZMapVar           = "ECS_LOCAL_REGION_AZ_MAP"
+	ValidateARNsVar          = "ECS_LOCAL_VALIDATE_ARNS"
+	RegionVar                = "AWS_REGION"
+
+	// Stats related
+	StatsSampleIntervalVar      = "ECS_LOCAL_STATS_SAMPLE_INTERVAL"
+	UnlimitedMemoryBehaviorVar  = "ECS_LOCAL_UNLIMITED_MEM_BEHAVIOR"
+	StatsMovingAverageWindowVar = "ECS_LOCAL_STATS_MOVING_AVERAGE_WINDOW"
+	CPUPercentModeVar           = "ECS_LOCAL_CPU_PERCENT_MODE"
+	IncludeGPUStatsVar          = "ECS_LOCAL_INCLUDE_GPU_STATS"
+	IncludeCPUThrottlingVar     = "ECS_LOCAL_INCLUDE_CPU_THROTTLING"
+	IncludeMemoryBreakdownVar   = "ECS_LOCAL_INCLUDE_MEMORY_BREAKDOWN"
+	IncludeStorageStatsVar      = "ECS_LOCAL_INCLUDE_STORAGE_STATS"
+	StorageStatsTTLVar          = "ECS_LOCAL_STORAGE_STATS_TTL"
+
+	// Container Metadata related
+	IncludeSecurityOptsVar      = "ECS_LOCAL_INCLUDE_SECURITY_OPTS"
+	IncludeProcessInfoVar       = "ECS_LOCAL_INCLUDE_PROCESS_INFO"
+	IncludeInitVar              = "ECS_LOCAL_INCLUDE_INIT"
+	IncludeEntrypointFormVar    = "ECS_LOCAL_INCLUDE_ENTRYPOINT_FORM"
+	IncludeUlimitsVar           = "ECS_LOCAL_INCLUDE_ULIMITS"
+	IncludeDevicesVar           = "ECS_LOCAL_INCLUDE_DEVICES"
+	IncludeHealthCheckConfigVar = "ECS_LOCAL_INCLUDE_HEALTHCHECK_CONFIG"
+	IncludeCapabilitiesVar      = "ECS_LOCAL_INCLUDE_CAPABILITIES"
+	IncludeSysctlsVar           = "ECS_LOCAL_INCLUDE_SYSCTLS"
+	IncludeStorageOptVar        = "ECS_LOCAL_INCLUDE_STORAGE_OPT"
+	IncludeShmSizeVar           = "ECS_LOCAL_INCLUDE_SHM_SIZE"
+	IncludeRuntimeVar           = "ECS_LOCAL_INCLUDE_RUNTIME"
+	IncludeEnvNamesVar          = "ECS_LOCAL_INCLUDE_ENV_NAMES"
+	IncludeGroupAddVar          = "ECS_LOCAL_INCLUDE_GROUP_ADD"
+	IncludeNamespaceModesVar    = "ECS_LOCAL_INCLUDE_NAMESPACE_MODES"
+	IncludeCgroupnsModeVar      = "ECS_LOCAL_INCLUDE_CGROUPNS_MODE"
+	CollapsePortRangesVar       = "ECS_LOCAL_COLLAPSE_PORT_RANGES"
+	IncludeOomScoreAdjVar       = "ECS_LOCAL_INCLUDE_OOM_SCORE_ADJ"
+	IncludeBlkioWeightVar       = "ECS_LOCAL_INCLUDE_BLKIO_WEIGHT"
+	IncludeCpusetVar            = "ECS_LOCAL_INCLUDE_CPUSET"
+	IncludeCPURealtimeVar       = "ECS_LOCAL_INCLUDE_CPU_REALTIME"
+	IncludeDNSVar               = "ECS_LOCAL_INCLUDE_DNS"
+	HealthLabelVar              = "ECS_LOCAL_HEALTH_LABEL"
+	IncludeUptimeVar            = "ECS_LOCAL_INCLUDE_UPTIME"
+	IncludeStartupLatencyVar    = "ECS_LOCAL_INCLUDE_STARTUP_LATENCY"
+	IncludeImageRepoDigestVar   = "ECS_LOCAL_INCLUDE_IMAGE_REPO_DIGEST"
+	IncludeImageSourceVar       = "ECS_LOCAL_INCLUDE_IMAGE_SOURCE"
+	PausedStatusVar             = "ECS_LOCAL_PAUSED_STATUS"
+	RestartingStatusVar         = "ECS_LOCAL_RESTARTING_STATUS"
+	IncludeLabelsVar            = "ECS_LOCAL_INCLUDE_LABELS"
+	LabelIncludeKeysVar         = "ECS_LOCAL_LABEL_INCLUDE_KEYS"
+	MaxLabelsVar                = "ECS_LOCAL_MAX_LABELS"
+	HealthLogEntriesVar         = "ECS_LOCAL_HEALTH_LOG_ENTRIES"
+	IncludeLabelHashVar         = "ECS_LOCAL_INCLUDE_LABEL_HASH"
+	IncludeDockerLabelsAliasVar = "ECS_LOCAL_INCLUDE_DOCKER_LABELS_ALIAS"
+	GroupLabelsVar              = "ECS_LOCAL_GROUP_LABELS"
+	IncludeComposeLabelsVar     = "ECS_LOCAL_INCLUDE_COMPOSE_LABELS"
+	AnnotationLabelPrefixVar    = "ECS_LOCAL_ANNOTATION_LABEL_PREFIX"
+	ComposeFileVar              = "ECS_LOCAL_COMPOSE_FILE"
 )
 
 // Defaults
 const (
 	// DefaultPort is the default port the server listens at
 	DefaultPort = "80"
+	// DefaultBindRetry is the default bind retry period, which disables retries
+	DefaultBindRetry = "0s"
+	// DefaultTLSMinVersion is the default minimum TLS version
+	DefaultTLSMinVersion = "1.2"
+	// DefaultRecentErrors is the default number of recent errors which are kept
+	DefaultRecentErrors = 50
+
+	// Credentials related
+	DefaultCredentialsRefreshWindow = "5m"
+	DefaultMinCredsTTL              = "0s"
+	DefaultCredsNegativeCacheTTL    = "0s"
+	DefaultDeniedRoleStatus         = "403"
+	DefaultCredsRetryAfter          = "5s"
+	// DefaultRoleNamePattern matches the names which IAM allows for roles
+	DefaultRoleNamePattern = `^[\w+=,.@-]{1,64}$`
 
 	// Metadata related
 	DefaultContainerType = "NORMAL"
@@ -39,11 +198,101 @@ const (
 	DefaultTaskARN       = "arn:aws:ecs:us-west-2:111111111111:task/ecs-local-cluster/37e873f6-37b4-42a7-af47-eac7275c6152"
 	DefaultTDFamily      = "esc-local-task-definition"
 	DefaultTDRevision    = "1"
+	DefaultIncludeLabels = IncludeLabelsAll
+	// DefaultMetadataSoftDeadline disables the soft deadline
+	DefaultMetadataSoftDeadline = "0s"
+	DefaultTimestampFormat      = TimestampFormatRFC3339Nano
+	// DefaultMetadataSnapshotTTL disables the snapshot of containers
+	DefaultMetadataSnapshotTTL = "0s"
+
+	// Container Metadata related
+	DefaultRestartingStatus = "PENDING"
+
+	// Stats related
+	DefaultStatsSampleInterval     = "0s"
+	DefaultUnlimitedMemoryBehavior = UnlimitedMemoryHost
+	DefaultCPUPercentMode          = CPUPercentModeTotal
+	DefaultStorageStatsTTL         = "1m"
+)
+
+// Values for IncludeLabelsVar
+const (
+	// IncludeLabelsAll emits all Docker labels
+	IncludeLabelsAll = "all"
+	// IncludeLabelsECS emits only the labels with the com.amazonaws.ecs prefix
+	IncludeLabelsECS = "ecs"
+	// IncludeLabelsNone emits no labels
+	IncludeLabelsNone = "none"
+)
+
+// Values for AccessLogFormatVar
+const (
+	// AccessLogFormatCLF is the Common Log Format
+	AccessLogFormatCLF = "clf"
+	// AccessLogFormatCombined is the Combined Log Format, which adds the referer and user agent to the Common Log Format
+	AccessLogFormatCombined = "combined"
+)
+
+// Values for TaskNetworkStrategyVar
+const (
+	// TaskNetworkPrimary uses the networks of the primary container, which is the container that made the request
+	TaskNetworkPrimary = "primary"
+	// TaskNetworkAll lists the networks of all containers in the task
+	TaskNetworkAll = "all"
+)
+
+// Values for TimestampFormatVar
+const (
+	// TimestampFormatRFC3339Nano emits timestamps as RFC 3339 with sub-second precision, which is what the ECS Agent returns
+	TimestampFormatRFC3339Nano = "rfc3339nano"
+	// TimestampFormatRFC3339 emits timestamps as RFC 3339 without sub-second precision
+	TimestampFormatRFC3339 = "rfc3339"
+	// TimestampFormatUnix emits timestamps as the number of seconds since the Unix epoch
+	TimestampFormatUnix = "unix"
+)
+
+// Values for UnlimitedMemoryBehaviorVar
+const (
+	// UnlimitedMemoryHost reports the memory utilization of containers without a memory limit as a percentage of the host's memory
+	UnlimitedMemoryHost = "host"
+	// UnlimitedMemoryOmit omits the memory utilization of containers without a memory limit
+	UnlimitedMemoryOmit = "omit"
+)
+
+// Values for CPUPercentModeVar
+const (
+	// CPUPercentModeTotal reports CPU utilization summed across cores, in the same way as the Docker CLI, up to 100 for each core
+	CPUPercentModeTotal = "total"
+	// CPUPercentModePerCore reports CPU utilization normalized to a single core, from 0 to 100
+	CPUPercentModePerCore = "per-core"
+)
+
+// Values for CredsSourceOrderVar
+const (
+	// CredsSourceStatic is the access keys set in the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables
+	CredsSourceStatic = "static"
+	// CredsSourceRole is the role set in DefaultRoleARNVar
+	CredsSourceRole = "role"
+	// CredsSourceProfile is the AWS CLI Profile set in AWS_PROFILE, or the default profile
+	CredsSourceProfile = "profile"
+	// CredsSourceEC2 is the EC2 Instance Role
+	CredsSourceEC2 = "ec2"
+)
+
+// Values for AuditLogVar
+const (
+	// AuditLogStderr writes the audit events to stderr; any other value is the path of the file to append them to
+	AuditLogStderr = "stderr"
 )
 
 // Settings
 const (
 	HTTPTimeoutDuration = "5s"
+	// MaxStatsSampleInterval leaves room within the HTTP timeout for the Docker API calls around the sampling interval
+	MaxStatsSampleInterval = "3s"
+	// MinEphemeralStorageGiB and MaxEphemeralStorageGiB are the ephemeral storage sizes which Fargate supports
+	MinEphemeralStorageGiB = 20
+	MaxEphemeralStorageGiB = 200
 )
 
 // URL Paths
@@ -59,6 +308,11 @@ const (
 	TempCredentialsPath = "/creds"
 	// TempCredentialsPathWithSlash adds a trailing slash
 	TempCredentialsPathWithSlash = TempCredentialsPath + "/"
+
+	// CredentialSourcesPath is the debug path which reports the configured credential sources
+	CredentialSourcesPath = "/creds/sources"
+	// CredentialSourcesPathWithSlash adds a trailing slash
+	CredentialSourcesPathWithSlash = CredentialSourcesPath + "/"
 )
 
 // V3
@@ -100,6 +354,78 @@ const (
 	V3TaskStatsPathWithIdentifierAndSlash = V3TaskStatsPathWithIdentifier + "/"
 )
 
+// Aliases
+const (
+	// TaskAliasPath is an unversioned alias for V3TaskMetadataPath
+	TaskAliasPath = "/task"
+	// TaskAliasPathWithSlash adds a trailing slash
+	TaskAliasPathWithSlash = TaskAliasPath + "/"
+)
+
+// Versions
+const (
+	// MetadataVersionsPath lists the metadata versions which are served, and their paths
+	MetadataVersionsPath = "/metadata/versions"
+	// MetadataVersionsPathWithSlash adds a trailing slash
+	MetadataVersionsPathWithSlash = MetadataVersionsPath + "/"
+)
+
+// Metrics
+const (
+	// MetricsPath serves Local Endpoints' metrics in the OpenMetrics format
+	MetricsPath = "/metrics"
+	// ContainerMetricsPath serves the stats of each container as Prometheus gauges
+	ContainerMetricsPath = "/metrics/containers"
+)
+
+// Health
+const (
+	// HealthzPath reports whether Local Endpoints and its dependencies are healthy
+	HealthzPath = "/healthz"
+	// HealthzPathWithSlash adds a trailing slash
+	HealthzPathWithSlash = HealthzPath + "/"
+)
+
+// Schemas
+const (
+	// SchemaPath is the prefix of the paths which serve the JSON schemas of the responses
+	SchemaPath = "/schema"
+
+	// SchemaV3TaskMetadataPath is the path for the schema of V3 task metadata
+	SchemaV3TaskMetadataPath = SchemaPath + V3TaskMetadataPath
+	// SchemaV3TaskMetadataPathWithSlash adds a trailing slash
+	SchemaV3TaskMetadataPathWithSlash = SchemaV3TaskMetadataPath + "/"
+
+	// SchemaV3TaskStatsPath is the path for the schema of V3 task stats
+	SchemaV3TaskStatsPath = SchemaPath + V3TaskStatsPath
+	// SchemaV3TaskStatsPathWithSlash adds a trailing slash
+	SchemaV3TaskStatsPathWithSlash = SchemaV3TaskStatsPath + "/"
+
+	// SchemaV3ContainerMetadataPath is the path for the schema of V3 container metadata
+	SchemaV3ContainerMetadataPath = SchemaPath + V3ContainerMetadataPath
+	// SchemaV3ContainerMetadataPathWithSlash adds a trailing slash
+	SchemaV3ContainerMetadataPathWithSlash = SchemaV3ContainerMetadataPath + "/"
+
+	// SchemaV3ContainerStatsPath is the path for the schema of V3 container stats
+	SchemaV3ContainerStatsPath = SchemaPath + V3ContainerStatsPath
+	// SchemaV3ContainerStatsPathWithSlash adds a trailing slash
+	SchemaV3ContainerStatsPathWithSlash = SchemaV3ContainerStatsPath + "/"
+)
+
+// Debug
+const (
+	// RecentErrorsPath is the debug path which reports the most recent errors encountered while serving requests
+	RecentErrorsPath = "/debug/errors"
+	// RecentErrorsPathWithSlash adds a trailing slash
+	RecentErrorsPathWithSlash = RecentErrorsPath + "/"
+)
+
+// Profiling
+const (
+	// PprofPath is the prefix of the paths served by net/http/pprof
+	PprofPath = "/debug/pprof/"
+)
+
 // V2
 const (
 	// V2TaskMetadataPath is the V2 Task Metadata path
@@ -0,0 +1,177 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/utils"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	auditOutcomeSuccess = "success"
+	auditOutcomeFailure = "failure"
+
+	auditSourceRole      = "role"
+	auditSourceTemporary = "temporary"
+	auditSourceUpstream  = "upstream"
+)
+
+// auditEvent records a credentials request. It must never include the credentials themselves.
+type auditEvent struct {
+	Time string `json:"time"`
+	// SourceIP and UserAgent identify the caller
+	SourceIP  string `json:"source_ip"`
+	UserAgent string `json:"user_agent,omitempty"`
+	Path      string `json:"path"`
+	// Source is where the credentials come from: an assumed role, the temporary credentials, or the upstream endpoint
+	Source  string `json:"source"`
+	Role    string `json:"role,omitempty"`
+	Outcome string `json:"outcome"`
+	Status  int    `json:"status"`
+	Error   string `json:"error,omitempty"`
+	// RequestID is only set if ECS_LOCAL_REQUEST_ID_HEADER is set
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// auditLog writes an audit event as a line of JSON for each credentials request, if ECS_LOCAL_AUDIT_LOG is set
+type auditLog struct {
+	out io.Writer
+	// lock keeps the events of concurrent requests from being interleaved
+	lock sync.Mutex
+}
+
+// newAuditLog returns the audit log which writes to the sink set in ECS_LOCAL_AUDIT_LOG, or nil if it is not set
+func newAuditLog() (*auditLog, error) {
+	sink := utils.GetValue("", config.AuditLogVar)
+	switch sink {
+	case "":
+		return nil, nil
+	case config.AuditLogStderr:
+		return &auditLog{out: os.Stderr}, nil
+	}
+	file, err := os.OpenFile(sink, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open the audit log set in %s", config.AuditLogVar)
+	}
+	return &auditLog{out: file}, nil
+}
+
+func (log *auditLog) record(event auditEvent) {
+	line, err := json.Marshal(event)
+	if err != nil {
+		logrus.Warnf("Failed to encode audit event: %s", err)
+		return
+	}
+	log.lock.Lock()
+	defer log.lock.Unlock()
+	if _, err = log.out.Write(append(line, '\n')); err != nil {
+		logrus.Warnf("Failed to write audit event: %s", err)
+	}
+}
+
+// audited wraps a credentials handler so that each request is recorded in the audit log, if there is one
+func (service *CredentialService) audited(handler func(w http.ResponseWriter, r *http.Request) error) func(w http.ResponseWriter, r *http.Request) error {
+	if service.auditLog == nil {
+		return handler
+	}
+	return func(w http.ResponseWriter, r *http.Request) error {
+		recorder := &statusRecorder{ResponseWriter: w}
+		err := handler(recorder, r)
+		service.auditLog.record(newAuditEvent(r, recorder.status(err), err))
+		return err
+	}
+}
+
+func newAuditEvent(r *http.Request, status int, err error) auditEvent {
+	sourceIP, _, splitErr := net.SplitHostPort(r.RemoteAddr)
+	if splitErr != nil {
+		sourceIP = r.RemoteAddr
+	}
+	event := auditEvent{
+		Time:      time.Now().UTC().Format(time.RFC3339Nano),
+		SourceIP:  sourceIP,
+		UserAgent: r.UserAgent(),
+		Path:      r.URL.Path,
+		Status:    status,
+		Outcome:   auditOutcomeSuccess,
+		RequestID: utils.GetRequestID(r),
+	}
+
+	// the role in the path takes precedence over the role query parameter, as it does for the handlers
+	event.Role = mux.Vars(r)["role"]
+	if event.Role == "" {
+		event.Role = r.URL.Query().Get("role")
+	}
+	switch {
+	case event.Role != "":
+		event.Source = auditSourceRole
+	case utils.GetValue("", config.UpstreamCredsURIVar) != "":
+		event.Source = auditSourceUpstream
+	default:
+		event.Source = auditSourceTemporary
+	}
+
+	if err != nil || status >= http.StatusBadRequest {
+		event.Outcome = auditOutcomeFailure
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	return event
+}
+
+// statusRecorder records the status code of the response
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (recorder *statusRecorder) WriteHeader(code int) {
+	if recorder.code == 0 {
+		recorder.code = code
+	}
+	recorder.ResponseWriter.WriteHeader(code)
+}
+
+func (recorder *statusRecorder) Write(data []byte) (int, error) {
+	if recorder.code == 0 {
+		recorder.code = http.StatusOK
+	}
+	return recorder.ResponseWriter.Write(data)
+}
+
+// status returns the status code which the response has, or will have once the handler's error is written
+func (recorder *statusRecorder) status(err error) int {
+	if err != nil {
+		if e, ok := err.(Error); ok {
+			return e.Status()
+		}
+		return http.StatusInternalServerError
+	}
+	if recorder.code == 0 {
+		return http.StatusOK
+	}
+	return recorder.code
+}
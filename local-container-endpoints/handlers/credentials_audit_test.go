@@ -0,0 +1,151 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditLogCredentialRequests(t *testing.T) {
+	iamMock, stsMock := setupMocks(t)
+	credsService := newCredentialServiceInTest(iamMock, stsMock)
+	var out bytes.Buffer
+	credsService.auditLog = &auditLog{out: &out}
+	router := mux.NewRouter()
+	credsService.SetupRoutes(router)
+
+	expiration := time.Now().Add(time.Hour)
+	iamMock.EXPECT().GetRole(gomock.Any()).Return(&iam.GetRoleOutput{
+		Role: &iam.Role{
+			Arn: aws.String(roleARN),
+		},
+	}, nil)
+	stsMock.EXPECT().AssumeRole(gomock.Any()).Return(&sts.AssumeRoleOutput{
+		Credentials: &sts.Credentials{
+			AccessKeyId:     aws.String(accessKey),
+			SecretAccessKey: aws.String(secretKey),
+			SessionToken:    aws.String(sessionToken),
+			Expiration:      &expiration,
+		},
+	}, nil)
+	stsMock.EXPECT().GetSessionToken(gomock.Any()).Return(nil, fmt.Errorf("Some API Error"))
+
+	request := httptest.NewRequest("GET", "/role/"+roleName, nil)
+	request.Header.Set("User-Agent", "aws-sdk-go/1.17.9")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code to match")
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", "/creds", nil))
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code, "Expected status code to match")
+
+	assert.NotContains(t, out.String(), secretKey, "Expected the audit log to never include the secret key")
+	assert.NotContains(t, out.String(), sessionToken, "Expected the audit log to never include the session token")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if !assert.Len(t, lines, 2, "Expected an audit event for each request") {
+		return
+	}
+	var success, failure auditEvent
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &success), "Unexpected error decoding the audit event")
+	assert.NoError(t, json.Unmarshal([]byte(lines[1]), &failure), "Unexpected error decoding the audit event")
+
+	assert.Equal(t, auditOutcomeSuccess, success.Outcome, "Expected outcome to match")
+	assert.Equal(t, http.StatusOK, success.Status, "Expected status to match")
+	assert.Equal(t, auditSourceRole, success.Source, "Expected source to match")
+	assert.Equal(t, roleName, success.Role, "Expected role to match")
+	assert.Equal(t, "192.0.2.1", success.SourceIP, "Expected source IP to match")
+	assert.Equal(t, "aws-sdk-go/1.17.9", success.UserAgent, "Expected user agent to match")
+	assert.Empty(t, success.Error, "Expected no error for a successful request")
+	_, err := time.Parse(time.RFC3339Nano, success.Time)
+	assert.NoError(t, err, "Expected the time of the event")
+
+	assert.Equal(t, auditOutcomeFailure, failure.Outcome, "Expected outcome to match")
+	assert.Equal(t, http.StatusInternalServerError, failure.Status, "Expected status to match")
+	assert.Equal(t, auditSourceTemporary, failure.Source, "Expected source to match")
+	assert.Equal(t, "/creds", failure.Path, "Expected path to match")
+	assert.Empty(t, failure.Role, "Expected no role for temporary credentials")
+	assert.Contains(t, failure.Error, "Some API Error", "Expected the error of the failed request")
+}
+
+func TestAuditLogDeniedRole(t *testing.T) {
+	os.Setenv(config.AllowedRolesVar, "other_role")
+	defer os.Unsetenv(config.AllowedRolesVar)
+
+	// No calls to IAM or STS are expected for a denied role
+	iamMock, stsMock := setupMocks(t)
+	credsService := newCredentialServiceInTest(iamMock, stsMock)
+	var out bytes.Buffer
+	credsService.auditLog = &auditLog{out: &out}
+
+	request := mux.SetURLVars(httptest.NewRequest("GET", "/creds?role="+roleName, nil), map[string]string{})
+	recorder := httptest.NewRecorder()
+	serveCredentialsHTTP(credsService.audited(credsService.getTemporaryCredentialHandler()))(recorder, request)
+
+	var event auditEvent
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &event), "Unexpected error decoding the audit event")
+	assert.Equal(t, auditOutcomeFailure, event.Outcome, "Expected outcome to match")
+	assert.Equal(t, recorder.Code, event.Status, "Expected the status of the response")
+	assert.Equal(t, roleName, event.Role, "Expected the role in the query parameter")
+}
+
+func TestNewAuditLog(t *testing.T) {
+	log, err := newAuditLog()
+	assert.NoError(t, err, "Unexpected error without an audit log")
+	assert.Nil(t, log, "Expected no audit log by default")
+
+	os.Setenv(config.AuditLogVar, config.AuditLogStderr)
+	defer os.Unsetenv(config.AuditLogVar)
+	log, err = newAuditLog()
+	if assert.NoError(t, err, "Unexpected error creating the audit log") {
+		assert.Equal(t, os.Stderr, log.out, "Expected the audit log to write to stderr")
+	}
+
+	dir, err := ioutil.TempDir("", "audit")
+	assert.NoError(t, err, "Unexpected error creating temp dir")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+	os.Setenv(config.AuditLogVar, path)
+	log, err = newAuditLog()
+	if assert.NoError(t, err, "Unexpected error creating the audit log") {
+		log.record(auditEvent{Path: "/creds", Outcome: auditOutcomeSuccess})
+		log.out.(*os.File).Close()
+		data, err := ioutil.ReadFile(path)
+		assert.NoError(t, err, "Unexpected error reading the audit log")
+		assert.Contains(t, string(data), `"outcome":"success"`, "Expected the event to be appended to the file")
+	}
+
+	os.Setenv(config.AuditLogVar, filepath.Join(dir, "missing", "audit.log"))
+	_, err = newAuditLog()
+	assert.Error(t, err, "Expected error when the audit log can not be opened")
+}
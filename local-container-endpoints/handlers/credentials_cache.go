@@ -0,0 +1,294 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/utils"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	temporaryCredentialsCacheKey   = "creds"
+	roleCredentialsCacheKey        = "role/"
+	defaultRoleCredentialsCacheKey = "default-role"
+)
+
+// credentialsFetcher obtains a fresh set of credentials
+type credentialsFetcher func() (*CredentialResponse, error)
+
+// credentialsCache holds the most recently vended credentials for each source
+// The zero value is an empty cache ready for use
+type credentialsCache struct {
+	lock    sync.Mutex
+	entries map[string]*credentialsCacheEntry
+	// file is where the cache is persisted across restarts, if set
+	file string
+}
+
+// credentialsCacheEntry holds the credentials for a single source
+// Readers share lock while a single writer, holding refreshLock, fetches new credentials
+// and then swaps them in, so that a reader always sees a complete set of credentials
+type credentialsCacheEntry struct {
+	lock        sync.RWMutex
+	response    *CredentialResponse
+	expiration  time.Time
+	refreshLock sync.Mutex
+	refreshing  int32
+	// persist is called after the credentials are refreshed
+	persist func()
+	// err is the last error fetching the credentials, which is served until errExpiration,
+	// if ECS_LOCAL_CREDS_NEGATIVE_CACHE_TTL is set
+	err           error
+	errExpiration time.Time
+}
+
+// persistedCredentials is how each set of credentials is stored in the cache file
+type persistedCredentials struct {
+	Credentials *CredentialResponse
+	IssuedAt    time.Time
+}
+
+func (cache *credentialsCache) get(key string, fetch credentialsFetcher) (*CredentialResponse, error) {
+	return cache.entry(key).get(fetch)
+}
+
+func (cache *credentialsCache) entry(key string) *credentialsCacheEntry {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	if cache.entries == nil {
+		cache.entries = make(map[string]*credentialsCacheEntry)
+	}
+	entry, ok := cache.entries[key]
+	if !ok {
+		entry = &credentialsCacheEntry{
+			persist: cache.persist,
+		}
+		cache.entries[key] = entry
+	}
+	return entry
+}
+
+func (entry *credentialsCacheEntry) get(fetch credentialsFetcher) (*CredentialResponse, error) {
+	refreshWindow := utils.GetDurationValue(config.DefaultCredentialsRefreshWindow, config.CredentialsRefreshWindowVar)
+	minTTL := utils.GetDurationValue(config.DefaultMinCredsTTL, config.MinCredsTTLVar)
+
+	response, expiration := entry.current()
+	remaining := expiration.Sub(time.Now())
+	if response != nil && remaining > refreshWindow && remaining > minTTL {
+		return response, nil
+	}
+
+	// Credentials which expire within the minimum TTL are never served; requests wait for the refresh instead
+	usable := response != nil && remaining > minTTL
+	if usable {
+		// The cached credentials are expiring, but are still valid.
+		// Serve them to everyone except the single request which refreshes them.
+		if !atomic.CompareAndSwapInt32(&entry.refreshing, 0, 1) {
+			return response, nil
+		}
+		defer atomic.StoreInt32(&entry.refreshing, 0)
+	}
+
+	entry.refreshLock.Lock()
+	defer entry.refreshLock.Unlock()
+
+	// another request may have refreshed the credentials while we waited
+	response, expiration = entry.current()
+	remaining = expiration.Sub(time.Now())
+	if response != nil && remaining > refreshWindow && remaining > minTTL {
+		return response, nil
+	}
+
+	// a recent error is served again, unless there are still valid credentials to serve instead
+	if response == nil || remaining <= minTTL {
+		if err := entry.cachedError(); err != nil {
+			return nil, err
+		}
+	}
+
+	refreshed, err := entry.refresh(fetch)
+	entry.cacheError(err)
+	if err != nil && response != nil && remaining > minTTL {
+		logrus.Warnf("Serving cached credentials which expire at %s; failed to refresh them: %s", response.Expiration, err)
+		return response, nil
+	}
+	return refreshed, err
+}
+
+func (entry *credentialsCacheEntry) current() (*CredentialResponse, time.Time) {
+	entry.lock.RLock()
+	defer entry.lock.RUnlock()
+	return entry.response, entry.expiration
+}
+
+func (entry *credentialsCacheEntry) refresh(fetch credentialsFetcher) (*CredentialResponse, error) {
+	response, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+	response.issuedAt = time.Now()
+
+	// Credentials without an expiration can not be cached
+	expiration, err := time.Parse(CredentialExpirationTimeFormat, response.Expiration)
+	if err != nil {
+		logrus.Debug("Not caching credentials which have no expiration")
+		return response, nil
+	}
+
+	entry.lock.Lock()
+	entry.response = response
+	entry.expiration = expiration
+	entry.lock.Unlock()
+
+	if entry.persist != nil {
+		entry.persist()
+	}
+	return response, nil
+}
+
+// cachedError returns the last error fetching the credentials, if it has not expired
+// It is only called while holding refreshLock
+func (entry *credentialsCacheEntry) cachedError() error {
+	if entry.err != nil && time.Now().Before(entry.errExpiration) {
+		return entry.err
+	}
+	return nil
+}
+
+// cacheError keeps the error for ECS_LOCAL_CREDS_NEGATIVE_CACHE_TTL, so that clients which keep retrying a
+// failing request do not call AWS each time; a nil error clears it
+// Errors which are worth retrying, such as throttling, are never cached
+// It is only called while holding refreshLock
+func (entry *credentialsCacheEntry) cacheError(err error) {
+	ttl := utils.GetDurationValue(config.DefaultCredsNegativeCacheTTL, config.CredsNegativeCacheTTLVar)
+	if err == nil || ttl <= 0 || isRetryableError(err) {
+		entry.err = nil
+		return
+	}
+	entry.err = err
+	entry.errExpiration = time.Now().Add(ttl)
+}
+
+// clear removes all cached credentials, so that the next request for each source fetches new ones
+func (cache *credentialsCache) clear() {
+	cache.lock.Lock()
+	cache.entries = nil
+	cache.lock.Unlock()
+
+	cache.persist()
+}
+
+// load restores the still valid credentials from the file, and persists the cache to it from then on
+func (cache *credentialsCache) load(file string) error {
+	cache.file = file
+
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "Failed to read the credentials cache %s", file)
+	}
+	var persisted map[string]persistedCredentials
+	if err = json.Unmarshal(data, &persisted); err != nil {
+		return errors.Wrapf(err, "Failed to parse the credentials cache %s", file)
+	}
+
+	loaded := 0
+	for key, credentials := range persisted {
+		if credentials.Credentials == nil {
+			continue
+		}
+		expiration, err := time.Parse(CredentialExpirationTimeFormat, credentials.Credentials.Expiration)
+		if err != nil || !expiration.After(time.Now()) {
+			continue
+		}
+		credentials.Credentials.issuedAt = credentials.IssuedAt
+
+		entry := cache.entry(key)
+		entry.lock.Lock()
+		entry.response = credentials.Credentials
+		entry.expiration = expiration
+		entry.lock.Unlock()
+		loaded++
+	}
+	logrus.Infof("Loaded %d cached credentials from %s", loaded, file)
+	return nil
+}
+
+// persist writes the still valid credentials to the cache file
+func (cache *credentialsCache) persist() {
+	if cache.file == "" {
+		return
+	}
+
+	// holding the lock for the write ensures that an older snapshot never replaces a newer one
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	persisted := make(map[string]persistedCredentials)
+	for key, entry := range cache.entries {
+		response, expiration := entry.current()
+		if response == nil || !expiration.After(time.Now()) {
+			continue
+		}
+		persisted[key] = persistedCredentials{
+			Credentials: response,
+			IssuedAt:    response.issuedAt,
+		}
+	}
+
+	if err := writeCacheFile(cache.file, persisted); err != nil {
+		logrus.Warnf("Failed to persist the credentials cache to %s: %s", cache.file, err)
+	}
+}
+
+// writeCacheFile writes to a temporary file first, so a crash never leaves a partial cache file
+// The temporary file is created readable only by its owner, since it holds credentials
+func writeCacheFile(file string, persisted map[string]persistedCredentials) error {
+	data, err := json.Marshal(persisted)
+	if err != nil {
+		return err
+	}
+	tempFile, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file))
+	if err != nil {
+		return err
+	}
+	err = tempFile.Chmod(0600)
+	if err == nil {
+		_, err = tempFile.Write(data)
+	}
+	closeErr := tempFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tempFile.Name(), file)
+	}
+	if err != nil {
+		os.Remove(tempFile.Name())
+	}
+	return err
+}
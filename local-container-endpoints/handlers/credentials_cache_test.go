@@ -0,0 +1,219 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCredentialsCacheServesCachedCredentials(t *testing.T) {
+	var cache credentialsCache
+	var fetches int32
+
+	fetch := func() (*CredentialResponse, error) {
+		atomic.AddInt32(&fetches, 1)
+		return newCredentialResponseInTest("AKID", time.Now().Add(time.Hour)), nil
+	}
+
+	for i := 0; i < 3; i++ {
+		response, err := cache.get(temporaryCredentialsCacheKey, fetch)
+		assert.NoError(t, err, "Unexpected error getting cached credentials")
+		assert.Equal(t, "AKID", response.AccessKeyID, "Expected access key to match")
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches), "Expected credentials to be fetched once")
+}
+
+func TestCredentialsCacheConcurrentRefresh(t *testing.T) {
+	var cache credentialsCache
+	var fetches int32
+
+	// seed the cache with credentials which are inside the refresh window, but still valid
+	_, err := cache.get(temporaryCredentialsCacheKey, func() (*CredentialResponse, error) {
+		return newCredentialResponseInTest("OLD", time.Now().Add(time.Minute)), nil
+	})
+	assert.NoError(t, err, "Unexpected error seeding the cache")
+
+	fetch := func() (*CredentialResponse, error) {
+		atomic.AddInt32(&fetches, 1)
+		time.Sleep(50 * time.Millisecond)
+		return newCredentialResponseInTest("NEW", time.Now().Add(time.Hour)), nil
+	}
+
+	var wg sync.WaitGroup
+	responses := make(chan *CredentialResponse, 50)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			response, err := cache.get(temporaryCredentialsCacheKey, fetch)
+			assert.NoError(t, err, "Unexpected error getting cached credentials")
+			responses <- response
+		}()
+	}
+	wg.Wait()
+	close(responses)
+
+	for response := range responses {
+		if assert.NotNil(t, response, "Expected credentials to be served during refresh") {
+			assert.NotEmpty(t, response.AccessKeyID, "Expected complete credentials")
+			assert.Equal(t, response.AccessKeyID+"-secret", response.SecretAccessKey, "Expected credentials to come from a single fetch")
+			assert.Equal(t, response.AccessKeyID+"-token", response.Token, "Expected credentials to come from a single fetch")
+		}
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches), "Expected a single writer to refresh the credentials")
+
+	response, err := cache.get(temporaryCredentialsCacheKey, fetch)
+	assert.NoError(t, err, "Unexpected error getting cached credentials")
+	assert.Equal(t, "NEW", response.AccessKeyID, "Expected the refreshed credentials to be swapped in")
+}
+
+func TestCredentialsCacheRefreshErrorServesValidCredentials(t *testing.T) {
+	var cache credentialsCache
+
+	_, err := cache.get(temporaryCredentialsCacheKey, func() (*CredentialResponse, error) {
+		return newCredentialResponseInTest("OLD", time.Now().Add(time.Minute)), nil
+	})
+	assert.NoError(t, err, "Unexpected error seeding the cache")
+
+	response, err := cache.get(temporaryCredentialsCacheKey, func() (*CredentialResponse, error) {
+		return nil, fmt.Errorf("Some API Error")
+	})
+	assert.NoError(t, err, "Expected still valid credentials to be served when refresh fails")
+	assert.Equal(t, "OLD", response.AccessKeyID, "Expected the cached credentials to be served")
+}
+
+func TestCredentialsCacheMinTTLTriggersRefresh(t *testing.T) {
+	var cache credentialsCache
+
+	// outside of the default refresh window
+	_, err := cache.get(temporaryCredentialsCacheKey, func() (*CredentialResponse, error) {
+		return newCredentialResponseInTest("OLD", time.Now().Add(10*time.Minute)), nil
+	})
+	assert.NoError(t, err, "Unexpected error seeding the cache")
+
+	fetch := func() (*CredentialResponse, error) {
+		return newCredentialResponseInTest("NEW", time.Now().Add(time.Hour)), nil
+	}
+
+	response, err := cache.get(temporaryCredentialsCacheKey, fetch)
+	assert.NoError(t, err, "Unexpected error getting cached credentials")
+	assert.Equal(t, "OLD", response.AccessKeyID, "Expected the cached credentials to be served")
+
+	os.Setenv(config.MinCredsTTLVar, "15m")
+	defer os.Unsetenv(config.MinCredsTTLVar)
+
+	response, err = cache.get(temporaryCredentialsCacheKey, fetch)
+	assert.NoError(t, err, "Unexpected error getting cached credentials")
+	assert.Equal(t, "NEW", response.AccessKeyID, "Expected credentials within the minimum TTL to be refreshed before serving")
+}
+
+func TestCredentialsCacheNegativeCacheExpires(t *testing.T) {
+	os.Setenv(config.CredsNegativeCacheTTLVar, "50ms")
+	defer os.Unsetenv(config.CredsNegativeCacheTTLVar)
+
+	var cache credentialsCache
+	var fetches int32
+	failing := true
+	fetch := func() (*CredentialResponse, error) {
+		atomic.AddInt32(&fetches, 1)
+		if failing {
+			return nil, fmt.Errorf("Some API Error")
+		}
+		return newCredentialResponseInTest("AKID", time.Now().Add(time.Hour)), nil
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err := cache.get(temporaryCredentialsCacheKey, fetch)
+		assert.Error(t, err, "Expected the error to be served")
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches), "Expected the error to be cached")
+
+	failing = false
+	time.Sleep(100 * time.Millisecond)
+	response, err := cache.get(temporaryCredentialsCacheKey, fetch)
+	if assert.NoError(t, err, "Expected credentials to be fetched once the error expired") {
+		assert.Equal(t, "AKID", response.AccessKeyID, "Expected access key to match")
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&fetches), "Expected credentials to be fetched again")
+}
+
+func TestCredentialsCachePersistsAcrossRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ecs-local-creds-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cacheFile := filepath.Join(dir, "creds-cache.json")
+
+	var cache credentialsCache
+	err = cache.load(cacheFile)
+	assert.NoError(t, err, "Unexpected error loading a cache file which does not exist yet")
+
+	_, err = cache.get(roleCredentialsCacheKey+"expired", func() (*CredentialResponse, error) {
+		return newCredentialResponseInTest("EXPIRED", time.Now().Add(-time.Minute)), nil
+	})
+	assert.NoError(t, err, "Unexpected error seeding the cache")
+	_, err = cache.get(temporaryCredentialsCacheKey, func() (*CredentialResponse, error) {
+		return newCredentialResponseInTest("VALID", time.Now().Add(time.Hour)), nil
+	})
+	assert.NoError(t, err, "Unexpected error seeding the cache")
+
+	info, err := os.Stat(cacheFile)
+	if assert.NoError(t, err, "Expected the cache to be persisted") {
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), "Expected the cache file to be readable only by its owner")
+	}
+	data, err := ioutil.ReadFile(cacheFile)
+	assert.NoError(t, err, "Unexpected error reading the cache file")
+	assert.NotContains(t, string(data), "EXPIRED", "Expected expired credentials to not be persisted")
+
+	// simulate a restart
+	var restarted credentialsCache
+	err = restarted.load(cacheFile)
+	assert.NoError(t, err, "Unexpected error loading the cache file")
+
+	response, err := restarted.get(temporaryCredentialsCacheKey, func() (*CredentialResponse, error) {
+		return nil, fmt.Errorf("Expected the persisted credentials to be served")
+	})
+	if assert.NoError(t, err, "Unexpected error getting cached credentials") {
+		assert.Equal(t, "VALID", response.AccessKeyID, "Expected the persisted credentials to be served")
+		assert.False(t, response.issuedAt.IsZero(), "Expected the issuance time to be persisted")
+	}
+
+	var fetches int32
+	_, err = restarted.get(roleCredentialsCacheKey+"expired", func() (*CredentialResponse, error) {
+		atomic.AddInt32(&fetches, 1)
+		return newCredentialResponseInTest("NEW", time.Now().Add(time.Hour)), nil
+	})
+	assert.NoError(t, err, "Unexpected error getting credentials")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches), "Expected expired credentials to be fetched again")
+}
+
+func newCredentialResponseInTest(accessKeyID string, expiration time.Time) *CredentialResponse {
+	return &CredentialResponse{
+		AccessKeyID:     accessKeyID,
+		SecretAccessKey: accessKeyID + "-secret",
+		Token:           accessKeyID + "-token",
+		Expiration:      expiration.Format(CredentialExpirationTimeFormat),
+	}
+}
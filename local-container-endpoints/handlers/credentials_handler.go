@@ -14,16 +14,31 @@
 package handlers
 
 import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/iam"
 	"github.com/aws/aws-sdk-go/service/iam/iamiface"
 	"github.com/aws/aws-sdk-go/service/sts"
 	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/clients/docker"
 	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/clients/useragent"
 	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
 	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/utils"
@@ -35,8 +50,19 @@ import (
 const (
 	temporaryCredentialsDurationInS = 3600
 	roleSessionNameLength           = 64
+	shortContainerIDLength          = 12
+	imdsSuccessCode                 = "Success"
+	imdsCredentialsType             = "AWS-HMAC"
+	// regionHeader overrides the region of the STS endpoint for a request, if ECS_LOCAL_REGION_HEADER is set
+	regionHeader = "X-ECS-Local-Region"
 )
 
+// invalidRoleSessionNameChars matches the characters which STS does not allow in role session names
+var invalidRoleSessionNameChars = regexp.MustCompile(`[^\w+=,.@-]`)
+
+// validSourceIdentity matches the source identities which STS allows
+var validSourceIdentity = regexp.MustCompile(`^[\w+=,.@-]{2,64}$`)
+
 const (
 	// CredentialExpirationTimeFormat is the time stamp format used in the Local Credentials Service HTTP response
 	CredentialExpirationTimeFormat = time.RFC3339
@@ -47,6 +73,26 @@ type CredentialService struct {
 	iamClient      iamiface.IAMAPI
 	stsClient      stsiface.STSAPI
 	currentSession *session.Session
+	cache          credentialsCache
+	// dockerClient is used to find the container which made a request, if ECS_LOCAL_SESSION_NAME_PER_CONTAINER is set
+	dockerClient docker.Client
+	// credsSources, credsSourceOrder, and defaultCredsChain are reported at the credential sources debug path
+	credsSources      map[string]credentials.Provider
+	credsSourceOrder  []string
+	defaultCredsChain bool
+	// lastRotation is the suffix of the most recently rotated role session name, if ECS_LOCAL_ROTATE_SESSION_NAME is set
+	lastRotation     int64
+	lastRotationLock sync.Mutex
+	// regionalSTSClients are the STS clients for the regions requested in the X-ECS-Local-Region header
+	regionalSTSClients     map[string]stsiface.STSAPI
+	regionalSTSClientsLock sync.Mutex
+	// auditLog records each credentials request, if ECS_LOCAL_AUDIT_LOG is set
+	auditLog *auditLog
+	// roleRateLimiter limits the requests for each role ARN, if ECS_LOCAL_CREDS_ROLE_RPS is set
+	roleRateLimiter roleRateLimiter
+	// roleARNs are the ARNs of the role names which the requests were limited for
+	roleARNs     map[string]string
+	roleARNsLock sync.Mutex
 }
 
 // NewCredentialService returns a struct that handles credentials requests
@@ -57,11 +103,58 @@ func NewCredentialService() (*CredentialService, error) {
 	if err != nil {
 		return nil, err
 	}
+	sources := newCredentialSources(sess)
+	sourceOrder := defaultChainSources
+	order := utils.GetValue("", config.CredsSourceOrderVar)
+	if order != "" {
+		creds, err := newOrderedCredentials(order, sources)
+		if err != nil {
+			return nil, err
+		}
+		sess = sess.Copy(&aws.Config{
+			Credentials: creds,
+		})
+		// newOrderedCredentials already validated the order
+		sourceOrder, _ = parseCredsSourceOrder(order)
+	}
 	iamClient := iam.New(sess)
 	iamClient.Handlers.Build.PushBackNamed(useragent.CustomUserAgentHandler())
 	stsClient := sts.New(sess)
 	stsClient.Handlers.Build.PushBackNamed(useragent.CustomUserAgentHandler())
-	return NewCredentialServiceWithClients(iamClient, stsClient, sess), nil
+	service := NewCredentialServiceWithClients(iamClient, stsClient, sess)
+	service.credsSources = sources
+	service.credsSourceOrder = sourceOrder
+	service.defaultCredsChain = order == ""
+
+	if cacheFile := utils.GetValue("", config.CredsCacheFileVar); cacheFile != "" {
+		// Local Endpoints can still vend credentials without the cached ones
+		if err = service.cache.load(cacheFile); err != nil {
+			logrus.Warn(err)
+		}
+	}
+
+	if utils.GetBoolValue(false, config.SessionNamePerContainerVar) {
+		service.dockerClient, err = docker.NewDockerClient()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if service.auditLog, err = newAuditLog(); err != nil {
+		return nil, err
+	}
+
+	if err = validateSourceIdentity(utils.GetValue("", config.SourceIdentityVar)); err != nil {
+		return nil, err
+	}
+
+	if utils.GetBoolValue(false, config.CredsRequireTLSVar) && utils.GetValue("", config.TLSCertFileVar) == "" {
+		logrus.Warnf("%s is set but TLS is not enabled, so all credentials requests will be rejected", config.CredsRequireTLSVar)
+	}
+	if (utils.GetValue("", config.CredsQueryTokenParamVar) == "") != (utils.GetValue("", config.CredsQueryTokenValueVar) == "") {
+		logrus.Warnf("Both %s and %s must be set to require a query token; no token is required", config.CredsQueryTokenParamVar, config.CredsQueryTokenValueVar)
+	}
+	return service, nil
 }
 
 // NewCredentialServiceWithClients returns a struct that handles credentials requests with the given clients
@@ -73,20 +166,47 @@ func NewCredentialServiceWithClients(iamClient iamiface.IAMAPI, stsClient stsifa
 	}
 }
 
+// Reload discards the cached credentials, so that changes to the credentials configuration, such as to the
+// AWS CLI profiles, apply to the next request. It is called when Local Endpoints receives SIGHUP.
+// All credentials are discarded, since role credentials are assumed with the base credentials.
+func (service *CredentialService) Reload() {
+	logrus.Info("Reloading credentials; discarding the cached credentials")
+	if service.currentSession != nil && service.currentSession.Config != nil && service.currentSession.Config.Credentials != nil {
+		// the SDK caches credentials too, and only reads the shared credentials file again once they expire
+		service.currentSession.Config.Credentials.Expire()
+	}
+	service.cache.clear()
+}
+
 // SetupRoutes sets up the credentials paths in mux
 func (service *CredentialService) SetupRoutes(router *mux.Router) {
-	router.HandleFunc(config.RoleCredentialsPath, ServeHTTP(service.getRoleHandler()))
-	router.HandleFunc(config.RoleCredentialsPathWithSlash, ServeHTTP(service.getRoleHandler()))
+	router.HandleFunc(config.RoleCredentialsPath, serveCredentialsHTTP(service.audited(requireTLS(requireQueryToken(service.getRoleHandler())))))
+	router.HandleFunc(config.RoleCredentialsPathWithSlash, serveCredentialsHTTP(service.audited(requireTLS(requireQueryToken(service.getRoleHandler())))))
+
+	router.HandleFunc(config.TempCredentialsPath, serveCredentialsHTTP(service.audited(requireTLS(requireQueryToken(service.getTemporaryCredentialHandler())))))
+	router.HandleFunc(config.TempCredentialsPathWithSlash, serveCredentialsHTTP(service.audited(requireTLS(requireQueryToken(service.getTemporaryCredentialHandler())))))
 
-	router.HandleFunc(config.TempCredentialsPath, ServeHTTP(service.getTemporaryCredentialHandler()))
-	router.HandleFunc(config.TempCredentialsPathWithSlash, ServeHTTP(service.getTemporaryCredentialHandler()))
+	if utils.GetBoolValue(false, config.DebugEndpointsVar) {
+		router.HandleFunc(config.CredentialSourcesPath, ServeHTTP(service.getCredentialSourcesHandler()))
+		router.HandleFunc(config.CredentialSourcesPathWithSlash, ServeHTTP(service.getCredentialSourcesHandler()))
+	}
+}
+
+// getCredentialSourcesHandler returns the handler for the credential sources debug path
+func (service *CredentialService) getCredentialSourcesHandler() func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		utils.RequestLogger(r).Debug("Received credential sources request")
+		writeJSONResponse(w, service.getCredentialSourcesResponse())
+		return nil
+	}
 }
 
 // GetRoleHandler returns the Task IAM Role handler
 func (service *CredentialService) getRoleHandler() func(w http.ResponseWriter, r *http.Request) error {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		logrus.Debug("Received role credentials request")
+		utils.RequestLogger(r).Debug("Received role credentials request")
 
+		// the role in the path takes precedence over the role query parameter
 		vars := mux.Vars(r)
 		roleName := vars["role"]
 		if roleName == "" {
@@ -95,18 +215,430 @@ func (service *CredentialService) getRoleHandler() func(w http.ResponseWriter, r
 				Err:  fmt.Errorf("Invalid URL path %s; expected '/role/<IAM Role Name>'", r.URL.Path),
 			}
 		}
+		if utils.GetBoolValue(false, config.WarnDeprecatedPathsVar) {
+			warnDeprecatedPath(w, fmt.Sprintf("%s?role=%s", config.TempCredentialsPath, url.QueryEscape(roleName)))
+		}
+		return service.roleCredentialsResponse(w, r, roleName)
+	}
+}
 
-		response, err := service.getRoleCredentials(roleName)
-		if err != nil {
-			return err
+// requireTLS wraps a credentials handler so that requests which were not made over TLS are rejected,
+// if ECS_LOCAL_CREDS_REQUIRE_TLS is set
+func requireTLS(handler func(w http.ResponseWriter, r *http.Request) error) func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if r.TLS == nil && utils.GetBoolValue(false, config.CredsRequireTLSVar) {
+			return HTTPError{
+				Code: http.StatusForbidden,
+				Err: fmt.Errorf("Credentials are only served over HTTPS; request https://%s%s instead", r.Host,
+					utils.RedactQueryParam(r.URL.RequestURI(), utils.GetValue("", config.CredsQueryTokenParamVar))),
+			}
 		}
+		return handler(w, r)
+	}
+}
+
+// requireQueryToken wraps a credentials handler so that requests are rejected unless their
+// ECS_LOCAL_CREDS_QUERY_TOKEN_PARAM query parameter is ECS_LOCAL_CREDS_QUERY_TOKEN_VALUE, if both are set
+func requireQueryToken(handler func(w http.ResponseWriter, r *http.Request) error) func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		param := utils.GetValue("", config.CredsQueryTokenParamVar)
+		expected := utils.GetValue("", config.CredsQueryTokenValueVar)
+		if param == "" || expected == "" {
+			return handler(w, r)
+		}
+		// the comparison takes the same time however much of the token matches, so it can't be guessed byte by byte
+		token := r.URL.Query().Get(param)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+			return HTTPError{
+				Code: http.StatusUnauthorized,
+				Err:  fmt.Errorf("Missing or invalid %q query parameter", param),
+			}
+		}
+		return handler(w, r)
+	}
+}
+
+// warnDeprecatedPath sets the headers which tell clients that the path they requested is deprecated,
+// and which path to use instead
+func warnDeprecatedPath(w http.ResponseWriter, replacement string) {
+	w.Header().Set("Deprecation", "true")
+	w.Header().Set("Warning", fmt.Sprintf(`299 - "This path is deprecated; use %s instead"`, replacement))
+}
 
-		writeJSONResponse(w, response)
+// roleCredentialsResponse writes the credentials for the role, which is requested either
+// at /role/<IAM Role Name> or at /creds?role=<IAM Role Name>
+func (service *CredentialService) roleCredentialsResponse(w http.ResponseWriter, r *http.Request, roleName string) error {
+	if !isRoleNameValid(roleName) {
+		return HTTPError{
+			Code: http.StatusBadRequest,
+			Err:  fmt.Errorf("Invalid role name %q; it does not match %s", roleName, config.RoleNamePatternVar),
+		}
+	}
+
+	if !isRoleAllowed(roleName) {
+		return HTTPError{
+			Code: deniedRoleStatus(),
+			Err:  fmt.Errorf("Role %s is not in %s", roleName, config.AllowedRolesVar),
+		}
+	}
+
+	// the role ARN is resolved before the request is limited, so that names which IAM resolves to the same role,
+	// such as names which only differ in case, share the role's limit
+	var roleARN string
+	if rps := utils.GetIntValue(0, config.CredsRoleRPSVar); rps > 0 {
+		var err error
+		if roleARN, err = service.getRoleARN(roleName); err != nil {
+			return retryableError(err)
+		}
+		if allowed, retryAfter := service.roleRateLimiter.allow(roleARN, rps, time.Now()); !allowed {
+			return HTTPError{
+				Code:       http.StatusTooManyRequests,
+				Err:        fmt.Errorf("Too many requests for role %s; it is limited to %d requests per second by %s", roleName, rps, config.CredsRoleRPSVar),
+				RetryAfter: retryAfter,
+			}
+		}
+	}
+
+	region, err := getRequestRegion(r)
+	if err != nil {
+		return err
+	}
+
+	cacheKey := roleCredentialsCacheKey + roleName
+	stsClient := service.stsClient
+	if region != "" {
+		// the credentials come from another STS endpoint, so they are cached separately
+		cacheKey += "@" + region
+		stsClient = service.regionalSTSClient(region)
+	}
+	sessionName := getRoleSessionName(roleName, "")
+	if utils.GetBoolValue(false, config.SessionNamePerContainerVar) {
+		if containerID := service.findCallerContainerID(r); containerID != "" {
+			// each container gets its own session, so it needs its own credentials
+			cacheKey += "/" + containerID
+			sessionName = getRoleSessionName(roleName, containerID)
+		}
+	}
+
+	response, err := service.cache.get(cacheKey, func() (*CredentialResponse, error) {
+		if roleARN != "" {
+			return service.assumeRoleARN(stsClient, roleARN, service.rotateSessionName(sessionName))
+		}
+		return service.assumeRole(stsClient, roleName, service.rotateSessionName(sessionName))
+	})
+	if err != nil {
+		return retryableError(err)
+	}
+	if err = checkExpiration(response); err != nil {
+		return err
+	}
+
+	writeCredentialResponse(w, response)
+	return nil
+}
+
+// serveCredentialsHTTP wraps a credentials HTTP Handler, whose errors are in the same shape as the EC2 Instance
+// Metadata Service's if ECS_LOCAL_IMDS_STYLE_ERRORS is set, for clients which parse them
+func serveCredentialsHTTP(handler func(w http.ResponseWriter, r *http.Request) error) func(w http.ResponseWriter, r *http.Request) {
+	return serveHTTP(handler, writeCredentialsError)
+}
+
+func writeCredentialsError(w http.ResponseWriter, err error, message string, code int) {
+	if !utils.GetBoolValue(false, config.IMDSStyleErrorsVar) {
+		writeTextError(w, err, message, code)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(CredentialErrorResponse{
+		Code:        imdsErrorCode(err, code),
+		Message:     message,
+		LastUpdated: time.Now().UTC().Format(CredentialExpirationTimeFormat),
+	})
+}
+
+// imdsErrorCode returns the AWS error code for errors from AWS, such as AccessDenied, and otherwise
+// names the error after its HTTP status, such as NotFound
+func imdsErrorCode(err error, code int) string {
+	if herr, ok := err.(HTTPError); ok {
+		err = herr.Err
+	}
+	if awsErr, ok := errors.Cause(err).(awserr.Error); ok {
+		return awsErr.Code()
+	}
+	return strings.Replace(http.StatusText(code), " ", "", -1)
+}
+
+// writeCredentialResponse writes the credentials, in the same shape as the EC2 Instance Metadata Service if
+// ECS_LOCAL_IMDS_STYLE_CREDS is set
+func writeCredentialResponse(w http.ResponseWriter, response *CredentialResponse) {
+	if utils.GetBoolValue(false, config.IMDSStyleCredsVar) {
+		// copy the response, since it may be cached
+		imdsResponse := *response
+		imdsResponse.Code = imdsSuccessCode
+		imdsResponse.Type = imdsCredentialsType
+		imdsResponse.LastUpdated = response.issuedAt.UTC().Format(CredentialExpirationTimeFormat)
+		response = &imdsResponse
+	}
+	writeJSONResponse(w, response)
+}
+
+// retryableError converts throttling and transient errors from AWS into errors which tell clients
+// when to retry, since the SDKs honor Retry-After on 429 and 503 responses
+func retryableError(err error) error {
+	cause := errors.Cause(err)
+	var status int
+	switch {
+	case request.IsErrorThrottle(cause):
+		status = http.StatusTooManyRequests
+	case isRetryableError(cause):
+		status = http.StatusServiceUnavailable
+	default:
+		return err
+	}
+	return HTTPError{
+		Code:       status,
+		Err:        err,
+		RetryAfter: utils.GetDurationValue(config.DefaultCredsRetryAfter, config.CredsRetryAfterVar),
+	}
+}
+
+// isRetryableError reports whether the error from AWS is throttling or transient, and so is worth retrying
+func isRetryableError(err error) bool {
+	cause := errors.Cause(err)
+	return request.IsErrorThrottle(cause) || request.IsErrorRetryable(cause) || isServerError(cause)
+}
+
+func isServerError(err error) bool {
+	if requestFailure, ok := err.(awserr.RequestFailure); ok {
+		return requestFailure.StatusCode() >= http.StatusInternalServerError
+	}
+	return false
+}
+
+// checkExpiration returns an error for credentials which have already expired, since clients would
+// otherwise keep requesting new credentials and receiving the same expired ones
+func checkExpiration(response *CredentialResponse) error {
+	expiration, err := time.Parse(CredentialExpirationTimeFormat, response.Expiration)
+	if err != nil || time.Now().Before(expiration) {
 		return nil
 	}
+	if utils.GetBoolValue(false, config.AllowExpiredCredsVar) {
+		logrus.Warnf("Serving credentials which expired at %s", response.Expiration)
+		return nil
+	}
+	return HTTPError{
+		Code: http.StatusInternalServerError,
+		Err: fmt.Errorf("Credentials expired at %s; check your credential source and your system clock, or set %s to serve them anyway",
+			response.Expiration, config.AllowExpiredCredsVar),
+	}
+}
+
+// isRoleNameValid checks the requested role name against ECS_LOCAL_ROLE_NAME_PATTERN, so that
+// malformed names are rejected before they reach IAM or STS
+func isRoleNameValid(roleName string) bool {
+	pattern := utils.GetValue(config.DefaultRoleNamePattern, config.RoleNamePatternVar)
+	roleNameRegexp, err := regexp.Compile(pattern)
+	if err != nil {
+		logrus.Warnf("Ignoring invalid value for %s: %s", config.RoleNamePatternVar, err)
+		roleNameRegexp = regexp.MustCompile(config.DefaultRoleNamePattern)
+	}
+	return roleNameRegexp.MatchString(roleName)
+}
+
+// isRoleAllowed checks the role against the allowlist; all roles are allowed when there is no allowlist
+func isRoleAllowed(roleName string) bool {
+	allowedRoles := utils.GetValue("", config.AllowedRolesVar)
+	if allowedRoles == "" {
+		return true
+	}
+	for _, allowedRole := range strings.Split(allowedRoles, ",") {
+		if strings.TrimSpace(allowedRole) == roleName {
+			return true
+		}
+	}
+	return false
+}
+
+// deniedRoleStatus returns the status for requests for roles which are not allowed
+// A 404 hides whether the role exists from untrusted clients
+func deniedRoleStatus() int {
+	status := utils.GetValue(config.DefaultDeniedRoleStatus, config.DeniedRoleStatusVar)
+	switch status {
+	case "403":
+		return http.StatusForbidden
+	case "404":
+		return http.StatusNotFound
+	default:
+		logrus.Warnf("Invalid value %s for %s; expected 403 or 404", status, config.DeniedRoleStatusVar)
+		return http.StatusForbidden
+	}
+}
+
+// findCallerContainerID returns the ID of the container which made the request, or an empty string if it can not be found
+func (service *CredentialService) findCallerContainerID(r *http.Request) string {
+	if service.dockerClient == nil {
+		return ""
+	}
+	callerIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return ""
+	}
+
+	timeout, _ := time.ParseDuration(config.HTTPTimeoutDuration)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	containers, err := service.dockerClient.ContainerList(ctx)
+	if err != nil {
+		logrus.Warnf("Using the default role session name: %s", err)
+		return ""
+	}
+	container, err := findContainer(containers, "", callerIP)
+	if err != nil {
+		logrus.Warnf("Using the default role session name: %s", err)
+		return ""
+	}
+	return container.ID
+}
+
+// getRoleSessionName returns the role session name, which includes the short ID of the container, if given,
+// so that CloudTrail events can be attributed to the container
+func getRoleSessionName(roleName, containerID string) string {
+	sessionName := fmt.Sprintf("ecs-local-%s", roleName)
+	if containerID != "" {
+		sessionName = fmt.Sprintf("ecs-local-%s-%s", utils.Truncate(containerID, shortContainerIDLength), roleName)
+	}
+	return utils.Truncate(invalidRoleSessionNameChars.ReplaceAllString(sessionName, "-"), roleSessionNameLength)
+}
+
+// validateSourceIdentity returns an error if STS would reject the source identity, so that a misconfiguration
+// is found at startup rather than by each credentials request
+func validateSourceIdentity(sourceIdentity string) error {
+	if sourceIdentity == "" {
+		return nil
+	}
+	if !validSourceIdentity.MatchString(sourceIdentity) {
+		return fmt.Errorf("Invalid value for %s: %q must be 2 to 64 letters, digits, or any of _+=,.@-", config.SourceIdentityVar, sourceIdentity)
+	}
+	// the prefix is reserved for AWS
+	if strings.HasPrefix(strings.ToLower(sourceIdentity), "aws:") {
+		return fmt.Errorf("Invalid value for %s: %q must not start with aws:", config.SourceIdentityVar, sourceIdentity)
+	}
+	return nil
+}
+
+// withSourceIdentity sets the SourceIdentity of an AssumeRole request, which CloudTrail records for the session,
+// and which is kept across role chains. The AWS SDK which Local Endpoints uses predates source identities, so the
+// parameter is added to the request's form-encoded body once it is built.
+func withSourceIdentity(sourceIdentity string) request.Option {
+	return func(r *request.Request) {
+		r.Handlers.Build.PushBack(func(r *request.Request) {
+			if r.Error != nil {
+				return
+			}
+			body, err := ioutil.ReadAll(r.GetBody())
+			if err != nil {
+				r.Error = errors.Wrap(err, "failed to add the source identity to the request")
+				return
+			}
+			params := url.Values{}
+			params.Set("SourceIdentity", sourceIdentity)
+			r.SetStringBody(string(body) + "&" + params.Encode())
+		})
+	}
+}
+
+// rotateSessionName appends a suffix which is unique to each refresh to the role session name, if ECS_LOCAL_ROTATE_SESSION_NAME
+// is set, so that the sessions of successive refreshes can be told apart in CloudTrail
+// The session name is truncated to make room for the suffix, so that it stays within the length which STS allows
+func (service *CredentialService) rotateSessionName(sessionName string) string {
+	if !utils.GetBoolValue(false, config.RotateSessionNameVar) {
+		return sessionName
+	}
+	suffix := fmt.Sprintf("-%d", service.nextRotation())
+	return utils.Truncate(sessionName, roleSessionNameLength-len(suffix)) + suffix
+}
+
+// nextRotation returns the current time in milliseconds, or one more than the previous rotation if it was within the same millisecond
+func (service *CredentialService) nextRotation() int64 {
+	service.lastRotationLock.Lock()
+	defer service.lastRotationLock.Unlock()
+
+	rotation := time.Now().UnixNano() / int64(time.Millisecond)
+	if rotation <= service.lastRotation {
+		rotation = service.lastRotation + 1
+	}
+	service.lastRotation = rotation
+	return rotation
+}
+
+// getRequestRegion returns the region in the X-ECS-Local-Region header, if ECS_LOCAL_REGION_HEADER is set,
+// or an empty string to use the configured region
+func getRequestRegion(r *http.Request) (string, error) {
+	if !utils.GetBoolValue(false, config.RegionHeaderVar) {
+		return "", nil
+	}
+	region := r.Header.Get(regionHeader)
+	if region == "" {
+		return "", nil
+	}
+	// the partitions also match regions which are newer than the SDK
+	if _, ok := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), region); !ok {
+		return "", HTTPError{
+			Code: http.StatusBadRequest,
+			Err:  fmt.Errorf("Invalid region %q in the %s header", region, regionHeader),
+		}
+	}
+	return region, nil
+}
+
+// regionalSTSClient returns the STS client whose endpoint is resolved for the region
+func (service *CredentialService) regionalSTSClient(region string) stsiface.STSAPI {
+	service.regionalSTSClientsLock.Lock()
+	defer service.regionalSTSClientsLock.Unlock()
+
+	if stsClient, ok := service.regionalSTSClients[region]; ok {
+		return stsClient
+	}
+	if service.currentSession == nil {
+		return service.stsClient
+	}
+	stsClient := sts.New(service.currentSession, aws.NewConfig().WithRegion(region))
+	stsClient.Handlers.Build.PushBackNamed(useragent.CustomUserAgentHandler())
+	if service.regionalSTSClients == nil {
+		service.regionalSTSClients = make(map[string]stsiface.STSAPI)
+	}
+	service.regionalSTSClients[region] = stsClient
+	return stsClient
+}
+
+// getRoleARN returns the ARN of the role, which is only looked up once for each role name
+func (service *CredentialService) getRoleARN(roleName string) (string, error) {
+	service.roleARNsLock.Lock()
+	defer service.roleARNsLock.Unlock()
+
+	if roleARN, ok := service.roleARNs[roleName]; ok {
+		return roleARN, nil
+	}
+	output, err := service.iamClient.GetRole(&iam.GetRoleInput{
+		RoleName: aws.String(roleName),
+	})
+	if err != nil {
+		return "", err
+	}
+	if service.roleARNs == nil {
+		service.roleARNs = make(map[string]string)
+	}
+	roleARN := aws.StringValue(output.Role.Arn)
+	service.roleARNs[roleName] = roleARN
+	return roleARN, nil
 }
 
 func (service *CredentialService) getRoleCredentials(roleName string) (*CredentialResponse, error) {
+	return service.assumeRole(service.stsClient, roleName, getRoleSessionName(roleName, ""))
+}
+
+func (service *CredentialService) assumeRole(stsClient stsiface.STSAPI, roleName, sessionName string) (*CredentialResponse, error) {
 	logrus.Debugf("Requesting credentials for %s", roleName)
 
 	output, err := service.iamClient.GetRole(&iam.GetRoleInput{
@@ -116,11 +648,22 @@ func (service *CredentialService) getRoleCredentials(roleName string) (*Credenti
 		return nil, err
 	}
 
-	creds, err := service.stsClient.AssumeRole(&sts.AssumeRoleInput{
-		RoleArn:         output.Role.Arn,
+	return service.assumeRoleARN(stsClient, aws.StringValue(output.Role.Arn), sessionName)
+}
+
+func (service *CredentialService) assumeRoleARN(stsClient stsiface.STSAPI, roleARN, sessionName string) (*CredentialResponse, error) {
+	input := &sts.AssumeRoleInput{
+		RoleArn:         aws.String(roleARN),
 		DurationSeconds: aws.Int64(temporaryCredentialsDurationInS),
-		RoleSessionName: aws.String(utils.Truncate(fmt.Sprintf("ecs-local-%s", roleName), roleSessionNameLength)),
-	})
+		RoleSessionName: aws.String(sessionName),
+	}
+	var creds *sts.AssumeRoleOutput
+	var err error
+	if sourceIdentity := utils.GetValue("", config.SourceIdentityVar); sourceIdentity != "" {
+		creds, err = stsClient.AssumeRoleWithContext(aws.BackgroundContext(), input, withSourceIdentity(sourceIdentity))
+	} else {
+		creds, err = stsClient.AssumeRole(input)
+	}
 
 	if err != nil {
 		return nil, err
@@ -129,27 +672,95 @@ func (service *CredentialService) getRoleCredentials(roleName string) (*Credenti
 	return &CredentialResponse{
 		AccessKeyID:     aws.StringValue(creds.Credentials.AccessKeyId),
 		SecretAccessKey: aws.StringValue(creds.Credentials.SecretAccessKey),
-		RoleArn:         aws.StringValue(output.Role.Arn),
+		RoleArn:         roleARN,
 		Token:           aws.StringValue(creds.Credentials.SessionToken),
 		Expiration:      creds.Credentials.Expiration.Format(CredentialExpirationTimeFormat),
 	}, nil
 }
 
+// getDefaultRoleARNForCreds returns ECS_LOCAL_DEFAULT_ROLE_ARN if it is the only credentials configuration, in which
+// case /creds vends the role's credentials: neither ECS_LOCAL_CREDS_SOURCE_ORDER nor AWS_PROFILE is set, and there
+// are no access keys in the environment
+// Otherwise, the role is only used where ECS_LOCAL_CREDS_SOURCE_ORDER lists it
+func getDefaultRoleARNForCreds() string {
+	if utils.GetValue("", config.CredsSourceOrderVar) != "" || utils.GetValue("", config.ProfileVar) != "" {
+		return ""
+	}
+	// the same environment variables as the static credential source, and the default credential chain, read
+	if _, err := credentials.NewEnvCredentials().Get(); err == nil {
+		return ""
+	}
+	return utils.GetValue("", config.DefaultRoleARNVar)
+}
+
 // GetTemporaryCredentialHandler returns a handler which vends temporary credentials for the local IAM identity
 func (service *CredentialService) getTemporaryCredentialHandler() func(w http.ResponseWriter, r *http.Request) error {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		logrus.Debug("Received temporary local credentials request")
+		utils.RequestLogger(r).Debug("Received temporary local credentials request")
 
-		response, err := service.getTemporaryCredentials()
+		if roleName := r.URL.Query().Get("role"); roleName != "" {
+			return service.roleCredentialsResponse(w, r, roleName)
+		}
+
+		if upstreamURI := utils.GetValue("", config.UpstreamCredsURIVar); upstreamURI != "" {
+			return proxyUpstreamCredentials(w, r, upstreamURI)
+		}
+
+		cacheKey, fetch := temporaryCredentialsCacheKey, service.getTemporaryCredentials
+		if roleARN := getDefaultRoleARNForCreds(); roleARN != "" {
+			cacheKey = defaultRoleCredentialsCacheKey
+			fetch = func() (*CredentialResponse, error) {
+				return service.assumeRoleARN(service.stsClient, roleARN, defaultRoleSessionName)
+			}
+		}
+
+		response, err := service.cache.get(cacheKey, fetch)
 		if err != nil {
+			return retryableError(err)
+		}
+		if err = checkExpiration(response); err != nil {
 			return err
 		}
 
-		writeJSONResponse(w, response)
+		writeCredentialResponse(w, response)
 		return nil
 	}
 }
 
+// proxyUpstreamCredentials passes through the response of the upstream credentials endpoint, including its errors
+// The request's Authorization header is forwarded, for upstream endpoints which require a token
+func proxyUpstreamCredentials(w http.ResponseWriter, r *http.Request, upstreamURI string) error {
+	timeout, _ := time.ParseDuration(config.HTTPTimeoutDuration)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	request, err := http.NewRequest(http.MethodGet, upstreamURI, nil)
+	if err != nil {
+		return errors.Wrapf(err, "Invalid value for %s", config.UpstreamCredsURIVar)
+	}
+	if authorization := r.Header.Get("Authorization"); authorization != "" {
+		request.Header.Set("Authorization", authorization)
+	}
+
+	response, err := http.DefaultClient.Do(request.WithContext(ctx))
+	if err != nil {
+		return HTTPError{
+			Code: http.StatusBadGateway,
+			Err:  errors.Wrapf(err, "Failed to get credentials from %s", config.UpstreamCredsURIVar),
+		}
+	}
+	defer response.Body.Close()
+
+	if contentType := response.Header.Get("Content-Type"); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.WriteHeader(response.StatusCode)
+	if _, err = io.Copy(w, response.Body); err != nil {
+		utils.RequestLogger(r).Warnf("Failed to pass through the response from %s: %s", config.UpstreamCredsURIVar, err)
+	}
+	return nil
+}
+
 func (service *CredentialService) getTemporaryCredentials() (*CredentialResponse, error) {
 	// check if the current session already was built on temp creds
 	// because temp creds do not have the power to call GetSessionToken
@@ -14,18 +14,32 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/iam"
 	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/clients/docker/mock_docker"
 	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/clients/iam/mock_iamiface"
 	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/clients/sts/mock_stsiface"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/testingutils"
+	"github.com/docker/docker/api/types"
 	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -119,6 +133,745 @@ func TestGetRoleCredentialsSTSError(t *testing.T) {
 
 }
 
+func TestGetRoleHandlerDeniedRole(t *testing.T) {
+	var testCases = []struct {
+		status   string
+		expected int
+	}{
+		{
+			status:   "",
+			expected: http.StatusForbidden,
+		},
+		{
+			status:   "403",
+			expected: http.StatusForbidden,
+		},
+		{
+			status:   "404",
+			expected: http.StatusNotFound,
+		},
+	}
+
+	os.Setenv(config.AllowedRolesVar, "some_other_role, another_role")
+	defer os.Unsetenv(config.AllowedRolesVar)
+
+	for _, testCase := range testCases {
+		t.Run(testCase.status, func(t *testing.T) {
+			os.Setenv(config.DeniedRoleStatusVar, testCase.status)
+			defer os.Unsetenv(config.DeniedRoleStatusVar)
+
+			// No calls to IAM or STS are expected for a denied role
+			iamMock, stsMock := setupMocks(t)
+			credsService := newCredentialServiceInTest(iamMock, stsMock)
+
+			request := mux.SetURLVars(httptest.NewRequest("GET", "/role/"+roleName, nil), map[string]string{"role": roleName})
+			recorder := httptest.NewRecorder()
+			ServeHTTP(credsService.getRoleHandler())(recorder, request)
+			assert.Equal(t, testCase.expected, recorder.Code, "Expected status code to match")
+		})
+	}
+}
+
+func TestGetRoleHandlerRoleNamePattern(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		pattern  string
+		roleName string
+		expected bool
+	}{
+		{
+			name:     "default pattern",
+			roleName: roleName,
+			expected: true,
+		},
+		{
+			name:     "default pattern with invalid characters",
+			roleName: "clyde_task_role/../../admin",
+			expected: false,
+		},
+		{
+			name:     "default pattern with too long name",
+			roleName: strings.Repeat("a", 65),
+			expected: false,
+		},
+		{
+			name:     "custom pattern",
+			pattern:  "^clyde_[a-z_]+$",
+			roleName: roleName,
+			expected: true,
+		},
+		{
+			name:     "custom pattern mismatch",
+			pattern:  "^clyde_[a-z_]+$",
+			roleName: "admin_role",
+			expected: false,
+		},
+		{
+			name:     "invalid pattern falls back to default",
+			pattern:  "[",
+			roleName: "role;rm",
+			expected: false,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			os.Setenv(config.RoleNamePatternVar, testCase.pattern)
+			defer os.Unsetenv(config.RoleNamePatternVar)
+
+			assert.Equal(t, testCase.expected, isRoleNameValid(testCase.roleName), "Expected role name validation to match")
+		})
+	}
+}
+
+func TestGetRoleHandlerInvalidRoleName(t *testing.T) {
+	os.Setenv(config.RoleNamePatternVar, "^clyde_[a-z_]+$")
+	defer os.Unsetenv(config.RoleNamePatternVar)
+
+	// No calls to IAM or STS are expected for an invalid role name
+	iamMock, stsMock := setupMocks(t)
+	credsService := newCredentialServiceInTest(iamMock, stsMock)
+
+	request := mux.SetURLVars(httptest.NewRequest("GET", "/role/admin", nil), map[string]string{"role": "admin"})
+	recorder := httptest.NewRecorder()
+	ServeHTTP(credsService.getRoleHandler())(recorder, request)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code, "Expected status code to match")
+}
+
+func TestGetRoleHandlerExpiredCredentials(t *testing.T) {
+	var testCases = []struct {
+		allowExpired string
+		expected     int
+	}{
+		{
+			allowExpired: "",
+			expected:     http.StatusInternalServerError,
+		},
+		{
+			allowExpired: "true",
+			expected:     http.StatusOK,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.allowExpired, func(t *testing.T) {
+			os.Setenv(config.AllowExpiredCredsVar, testCase.allowExpired)
+			defer os.Unsetenv(config.AllowExpiredCredsVar)
+
+			iamMock, stsMock := setupMocks(t)
+			credsService := newCredentialServiceInTest(iamMock, stsMock)
+
+			expiration := time.Now().Add(-1 * time.Minute)
+			iamMock.EXPECT().GetRole(gomock.Any()).Return(&iam.GetRoleOutput{
+				Role: &iam.Role{
+					Arn: aws.String(roleARN),
+				},
+			}, nil)
+			stsMock.EXPECT().AssumeRole(gomock.Any()).Return(&sts.AssumeRoleOutput{
+				Credentials: &sts.Credentials{
+					AccessKeyId:     aws.String(accessKey),
+					SecretAccessKey: aws.String(secretKey),
+					SessionToken:    aws.String(sessionToken),
+					Expiration:      &expiration,
+				},
+			}, nil)
+
+			request := mux.SetURLVars(httptest.NewRequest("GET", "/role/"+roleName, nil), map[string]string{"role": roleName})
+			recorder := httptest.NewRecorder()
+			ServeHTTP(credsService.getRoleHandler())(recorder, request)
+			assert.Equal(t, testCase.expected, recorder.Code, "Expected status code to match")
+		})
+	}
+}
+
+func TestGetRoleHandlerThrottlingError(t *testing.T) {
+	var testCases = []struct {
+		retryAfter string
+		expected   string
+	}{
+		{
+			retryAfter: "",
+			expected:   "5",
+		},
+		{
+			retryAfter: "1500ms",
+			expected:   "2",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.retryAfter, func(t *testing.T) {
+			os.Setenv(config.CredsRetryAfterVar, testCase.retryAfter)
+			defer os.Unsetenv(config.CredsRetryAfterVar)
+
+			iamMock, stsMock := setupMocks(t)
+			credsService := newCredentialServiceInTest(iamMock, stsMock)
+
+			iamMock.EXPECT().GetRole(gomock.Any()).Return(&iam.GetRoleOutput{
+				Role: &iam.Role{
+					Arn: aws.String(roleARN),
+				},
+			}, nil)
+			stsMock.EXPECT().AssumeRole(gomock.Any()).Return(nil, awserr.New("Throttling", "Rate exceeded", nil))
+
+			request := mux.SetURLVars(httptest.NewRequest("GET", "/role/"+roleName, nil), map[string]string{"role": roleName})
+			recorder := httptest.NewRecorder()
+			ServeHTTP(credsService.getRoleHandler())(recorder, request)
+			assert.Equal(t, http.StatusTooManyRequests, recorder.Code, "Expected status code to match")
+			assert.Equal(t, testCase.expected, recorder.Header().Get("Retry-After"), "Expected Retry-After header to match")
+		})
+	}
+}
+
+func TestGetRoleHandlerPerRoleRateLimit(t *testing.T) {
+	os.Setenv(config.CredsRoleRPSVar, "2")
+	defer os.Unsetenv(config.CredsRoleRPSVar)
+
+	iamMock, stsMock := setupMocks(t)
+	credsService := newCredentialServiceInTest(iamMock, stsMock)
+	otherRoleName := "pudding_task_role"
+	expiration := time.Now().Add(time.Hour)
+
+	// each role's credentials are only fetched once, and are cached for the later requests
+	iamMock.EXPECT().GetRole(gomock.Any()).DoAndReturn(func(input *iam.GetRoleInput) (*iam.GetRoleOutput, error) {
+		return &iam.GetRoleOutput{
+			Role: &iam.Role{
+				Arn: aws.String("arn:aws:iam::111111111111111:role/" + aws.StringValue(input.RoleName)),
+			},
+		}, nil
+	}).Times(2)
+	stsMock.EXPECT().AssumeRole(gomock.Any()).Return(&sts.AssumeRoleOutput{
+		Credentials: &sts.Credentials{
+			AccessKeyId:     aws.String(accessKey),
+			SecretAccessKey: aws.String(secretKey),
+			SessionToken:    aws.String(sessionToken),
+			Expiration:      &expiration,
+		},
+	}, nil).Times(2)
+
+	requestRole := func(name string) *httptest.ResponseRecorder {
+		request := mux.SetURLVars(httptest.NewRequest("GET", "/role/"+name, nil), map[string]string{"role": name})
+		recorder := httptest.NewRecorder()
+		ServeHTTP(credsService.getRoleHandler())(recorder, request)
+		return recorder
+	}
+
+	for i := 0; i < 2; i++ {
+		recorder := requestRole(roleName)
+		assert.Equal(t, http.StatusOK, recorder.Code, "Expected requests within the burst to be served")
+	}
+	recorder := requestRole(roleName)
+	assert.Equal(t, http.StatusTooManyRequests, recorder.Code, "Expected requests beyond the burst to be limited")
+	assert.Equal(t, "1", recorder.Header().Get("Retry-After"), "Expected Retry-After header to match")
+
+	recorder = requestRole(otherRoleName)
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected the other role not to be limited")
+}
+
+func TestGetRoleHandlerPerRoleRateLimitByARN(t *testing.T) {
+	os.Setenv(config.CredsRoleRPSVar, "2")
+	defer os.Unsetenv(config.CredsRoleRPSVar)
+
+	iamMock, stsMock := setupMocks(t)
+	credsService := newCredentialServiceInTest(iamMock, stsMock)
+	// IAM role names are case insensitive, so both names are the same role
+	otherName := strings.ToUpper(roleName)
+	expiration := time.Now().Add(time.Hour)
+
+	// each name's ARN is only looked up once
+	iamMock.EXPECT().GetRole(gomock.Any()).Return(&iam.GetRoleOutput{
+		Role: &iam.Role{
+			Arn: aws.String(roleARN),
+		},
+	}, nil).Times(2)
+	stsMock.EXPECT().AssumeRole(gomock.Any()).Do(func(input *sts.AssumeRoleInput) {
+		assert.Equal(t, roleARN, aws.StringValue(input.RoleArn), "Expected the resolved role ARN to be assumed")
+	}).Return(&sts.AssumeRoleOutput{
+		Credentials: &sts.Credentials{
+			AccessKeyId:     aws.String(accessKey),
+			SecretAccessKey: aws.String(secretKey),
+			SessionToken:    aws.String(sessionToken),
+			Expiration:      &expiration,
+		},
+	}, nil).Times(2)
+
+	requestRole := func(name string) *httptest.ResponseRecorder {
+		request := mux.SetURLVars(httptest.NewRequest("GET", "/role/"+name, nil), map[string]string{"role": name})
+		recorder := httptest.NewRecorder()
+		ServeHTTP(credsService.getRoleHandler())(recorder, request)
+		return recorder
+	}
+
+	assert.Equal(t, http.StatusOK, requestRole(roleName).Code, "Expected requests within the burst to be served")
+	assert.Equal(t, http.StatusOK, requestRole(otherName).Code, "Expected requests within the burst to be served")
+	assert.Equal(t, http.StatusTooManyRequests, requestRole(roleName).Code, "Expected both names to share the role's limit")
+	assert.Equal(t, http.StatusTooManyRequests, requestRole(otherName).Code, "Expected both names to share the role's limit")
+}
+
+func TestRoleRateLimiterRefills(t *testing.T) {
+	var limiter roleRateLimiter
+	now := time.Now()
+
+	allowed, _ := limiter.allow(roleName, 1, now)
+	assert.True(t, allowed, "Expected the first request to be allowed")
+	allowed, retryAfter := limiter.allow(roleName, 1, now.Add(250*time.Millisecond))
+	assert.False(t, allowed, "Expected the bucket to be empty")
+	assert.Equal(t, 750*time.Millisecond, retryAfter, "Expected to retry once the next token is refilled")
+	allowed, _ = limiter.allow(roleName, 1, now.Add(time.Second))
+	assert.True(t, allowed, "Expected the bucket to be refilled")
+}
+
+func TestGetRoleHandlerNonRetryableError(t *testing.T) {
+	iamMock, stsMock := setupMocks(t)
+	credsService := newCredentialServiceInTest(iamMock, stsMock)
+
+	iamMock.EXPECT().GetRole(gomock.Any()).Return(nil, awserr.New("AccessDenied", "Not authorized", nil))
+
+	request := mux.SetURLVars(httptest.NewRequest("GET", "/role/"+roleName, nil), map[string]string{"role": roleName})
+	recorder := httptest.NewRecorder()
+	ServeHTTP(credsService.getRoleHandler())(recorder, request)
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code, "Expected status code to match")
+	assert.Empty(t, recorder.Header().Get("Retry-After"), "Expected no Retry-After header")
+}
+
+func TestGetRoleHandlerNegativeCache(t *testing.T) {
+	os.Setenv(config.CredsNegativeCacheTTLVar, "1m")
+	defer os.Unsetenv(config.CredsNegativeCacheTTLVar)
+
+	var testCases = []struct {
+		name          string
+		err           error
+		expectedCalls int
+		expectedCode  int
+	}{
+		{
+			name:          "AccessDenied",
+			err:           awserr.New("AccessDenied", "Not authorized", nil),
+			expectedCalls: 1,
+			expectedCode:  http.StatusInternalServerError,
+		},
+		{
+			name:          "Throttling",
+			err:           awserr.New("Throttling", "Rate exceeded", nil),
+			expectedCalls: 2,
+			expectedCode:  http.StatusTooManyRequests,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			iamMock, stsMock := setupMocks(t)
+			credsService := newCredentialServiceInTest(iamMock, stsMock)
+
+			iamMock.EXPECT().GetRole(gomock.Any()).Return(&iam.GetRoleOutput{
+				Role: &iam.Role{
+					Arn: aws.String(roleARN),
+				},
+			}, nil).Times(testCase.expectedCalls)
+			stsMock.EXPECT().AssumeRole(gomock.Any()).Return(nil, testCase.err).Times(testCase.expectedCalls)
+
+			for i := 0; i < 2; i++ {
+				request := mux.SetURLVars(httptest.NewRequest("GET", "/role/"+roleName, nil), map[string]string{"role": roleName})
+				recorder := httptest.NewRecorder()
+				ServeHTTP(credsService.getRoleHandler())(recorder, request)
+				assert.Equal(t, testCase.expectedCode, recorder.Code, "Expected status code to match")
+			}
+		})
+	}
+}
+
+func TestGetRoleHandlerIMDSStyleErrors(t *testing.T) {
+	iamMock, stsMock := setupMocks(t)
+	credsService := newCredentialServiceInTest(iamMock, stsMock)
+
+	iamMock.EXPECT().GetRole(gomock.Any()).Return(nil, awserr.New("AccessDenied", "Not authorized", nil)).Times(2)
+
+	request := mux.SetURLVars(httptest.NewRequest("GET", "/role/"+roleName, nil), map[string]string{"role": roleName})
+	recorder := httptest.NewRecorder()
+	serveCredentialsHTTP(credsService.getRoleHandler())(recorder, request)
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code, "Expected status code to match")
+	assert.NotEqual(t, "application/json", recorder.Header().Get("Content-Type"), "Expected a plain text error by default")
+
+	os.Setenv(config.IMDSStyleErrorsVar, "true")
+	defer os.Unsetenv(config.IMDSStyleErrorsVar)
+
+	recorder = httptest.NewRecorder()
+	serveCredentialsHTTP(credsService.getRoleHandler())(recorder, request)
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code, "Expected status code to be unchanged")
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"), "Expected a JSON error")
+
+	// the EC2 Instance Metadata Service's errors have exactly these fields
+	var response map[string]string
+	err := json.Unmarshal(recorder.Body.Bytes(), &response)
+	if assert.NoError(t, err, "Unexpected error unmarshalling response") {
+		assert.Len(t, response, 3, "Expected only the fields of the IMDS error schema")
+		assert.Equal(t, "AccessDenied", response["Code"], "Expected the AWS error code")
+		assert.Contains(t, response["Message"], "Not authorized", "Expected the error message")
+		_, err = time.Parse(CredentialExpirationTimeFormat, response["LastUpdated"])
+		assert.NoError(t, err, "Expected LastUpdated to be a timestamp")
+	}
+
+	// errors which did not come from AWS are named after their HTTP status
+	recorder = httptest.NewRecorder()
+	invalidRequest := mux.SetURLVars(httptest.NewRequest("GET", "/role/invalid:role", nil), map[string]string{"role": "invalid:role"})
+	serveCredentialsHTTP(credsService.getRoleHandler())(recorder, invalidRequest)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code, "Expected status code to match")
+	err = json.Unmarshal(recorder.Body.Bytes(), &response)
+	if assert.NoError(t, err, "Unexpected error unmarshalling response") {
+		assert.Equal(t, "BadRequest", response["Code"], "Expected the code to be named after the HTTP status")
+	}
+}
+
+func TestGetRoleHandlerSessionNamePerContainer(t *testing.T) {
+	os.Setenv(config.SessionNamePerContainerVar, "true")
+	defer os.Unsetenv(config.SessionNamePerContainerVar)
+
+	iamMock, stsMock := setupMocks(t)
+	dockerMock := mock_docker.NewMockClient(gomock.NewController(t))
+	credsService := newCredentialServiceInTest(iamMock, stsMock)
+	credsService.dockerClient = dockerMock
+
+	containers := []types.Container{
+		testingutils.BaseDockerContainer(containerName1, longID1).WithNetwork(network1, ipAddress1).Get(),
+		testingutils.BaseDockerContainer(containerName2, longID2).WithNetwork(network1, ipAddress2).Get(),
+	}
+	expiration := time.Now().Add(time.Hour)
+
+	gomock.InOrder(
+		dockerMock.EXPECT().ContainerList(gomock.Any()).Return(containers, nil),
+		iamMock.EXPECT().GetRole(gomock.Any()).Return(&iam.GetRoleOutput{
+			Role: &iam.Role{
+				Arn: aws.String(roleARN),
+			},
+		}, nil),
+		stsMock.EXPECT().AssumeRole(gomock.Any()).Do(func(x interface{}) {
+			input := x.(*sts.AssumeRoleInput)
+			assert.Equal(t, "ecs-local-457129ed3bd0-clyde_task_role", aws.StringValue(input.RoleSessionName), "Expected session name to include the container ID")
+		}).Return(&sts.AssumeRoleOutput{
+			Credentials: &sts.Credentials{
+				AccessKeyId:     aws.String(accessKey),
+				SecretAccessKey: aws.String(secretKey),
+				SessionToken:    aws.String(sessionToken),
+				Expiration:      &expiration,
+			},
+		}, nil),
+	)
+
+	request := mux.SetURLVars(httptest.NewRequest("GET", "/role/"+roleName, nil), map[string]string{"role": roleName})
+	request.RemoteAddr = ipAddress2 + ":43210"
+	recorder := httptest.NewRecorder()
+	ServeHTTP(credsService.getRoleHandler())(recorder, request)
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code to match")
+}
+
+func TestGetRoleHandlerRotateSessionName(t *testing.T) {
+	os.Setenv(config.RotateSessionNameVar, "true")
+	defer os.Unsetenv(config.RotateSessionNameVar)
+
+	iamMock, stsMock := setupMocks(t)
+	credsService := newCredentialServiceInTest(iamMock, stsMock)
+
+	// the credentials are within the refresh window, so each request refreshes them
+	expiration := time.Now().Add(time.Minute)
+	var sessionNames []string
+	iamMock.EXPECT().GetRole(gomock.Any()).Return(&iam.GetRoleOutput{
+		Role: &iam.Role{
+			Arn: aws.String(roleARN),
+		},
+	}, nil).Times(2)
+	stsMock.EXPECT().AssumeRole(gomock.Any()).Do(func(x interface{}) {
+		sessionNames = append(sessionNames, aws.StringValue(x.(*sts.AssumeRoleInput).RoleSessionName))
+	}).Return(&sts.AssumeRoleOutput{
+		Credentials: &sts.Credentials{
+			AccessKeyId:     aws.String(accessKey),
+			SecretAccessKey: aws.String(secretKey),
+			SessionToken:    aws.String(sessionToken),
+			Expiration:      &expiration,
+		},
+	}, nil).Times(2)
+
+	for i := 0; i < 2; i++ {
+		request := mux.SetURLVars(httptest.NewRequest("GET", "/role/"+roleName, nil), map[string]string{"role": roleName})
+		recorder := httptest.NewRecorder()
+		ServeHTTP(credsService.getRoleHandler())(recorder, request)
+		assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code to match")
+	}
+
+	if assert.Len(t, sessionNames, 2, "Expected the credentials to be refreshed") {
+		assert.NotEqual(t, sessionNames[0], sessionNames[1], "Expected each refresh to use a distinct session name")
+		for _, sessionName := range sessionNames {
+			assert.Regexp(t, `^ecs-local-clyde_task_role-\d+$`, sessionName, "Expected the session name to end with the rotation")
+		}
+	}
+}
+
+func TestCredentialsRequireTLS(t *testing.T) {
+	os.Setenv(config.CredsRequireTLSVar, "true")
+	defer os.Unsetenv(config.CredsRequireTLSVar)
+
+	iamMock, stsMock := setupMocks(t)
+	credsService := newCredentialServiceInTest(iamMock, stsMock)
+	router := mux.NewRouter()
+	credsService.SetupRoutes(router)
+
+	// No calls to IAM or STS are expected for plaintext requests
+	for _, path := range []string{"/role/" + roleName, "/creds", "/creds?role=" + roleName} {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest("GET", "http://169.254.170.2"+path, nil))
+		assert.Equal(t, http.StatusForbidden, recorder.Code, "Expected plaintext requests for %s to be rejected", path)
+		assert.Contains(t, recorder.Body.String(), "https://169.254.170.2"+path, "Expected the message to point to HTTPS")
+	}
+
+	expiration := time.Now().Add(time.Hour)
+	iamMock.EXPECT().GetRole(gomock.Any()).Return(&iam.GetRoleOutput{
+		Role: &iam.Role{
+			Arn: aws.String(roleARN),
+		},
+	}, nil)
+	stsMock.EXPECT().AssumeRole(gomock.Any()).Return(&sts.AssumeRoleOutput{
+		Credentials: &sts.Credentials{
+			AccessKeyId:     aws.String(accessKey),
+			SecretAccessKey: aws.String(secretKey),
+			SessionToken:    aws.String(sessionToken),
+			Expiration:      &expiration,
+		},
+	}, nil)
+
+	// httptest sets the TLS connection state for https URLs
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", "https://169.254.170.2/role/"+roleName, nil))
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected requests over TLS to be served")
+}
+
+func TestCredentialsQueryToken(t *testing.T) {
+	os.Setenv(config.CredsQueryTokenParamVar, "token")
+	defer os.Unsetenv(config.CredsQueryTokenParamVar)
+	os.Setenv(config.CredsQueryTokenValueVar, "s3cr3t")
+	defer os.Unsetenv(config.CredsQueryTokenValueVar)
+
+	iamMock, stsMock := setupMocks(t)
+	credsService := newCredentialServiceInTest(iamMock, stsMock)
+	router := mux.NewRouter()
+	credsService.SetupRoutes(router)
+
+	// No calls to IAM or STS are expected for requests without the token
+	var testCases = []struct {
+		name string
+		path string
+	}{
+		{"missing token", "/role/" + roleName},
+		{"missing token with other parameters", "/creds?role=" + roleName},
+		{"empty token", "/role/" + roleName + "?token="},
+		{"wrong token", "/role/" + roleName + "?token=guess"},
+		{"token in another parameter", "/role/" + roleName + "?other=s3cr3t"},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest("GET", test.path, nil))
+			assert.Equal(t, http.StatusUnauthorized, recorder.Code, "Expected status code to match")
+			assert.NotContains(t, recorder.Body.String(), "s3cr3t", "Expected the token not to be revealed")
+		})
+	}
+
+	expiration := time.Now().Add(time.Hour)
+	iamMock.EXPECT().GetRole(gomock.Any()).Return(&iam.GetRoleOutput{
+		Role: &iam.Role{
+			Arn: aws.String(roleARN),
+		},
+	}, nil)
+	stsMock.EXPECT().AssumeRole(gomock.Any()).Return(&sts.AssumeRoleOutput{
+		Credentials: &sts.Credentials{
+			AccessKeyId:     aws.String(accessKey),
+			SecretAccessKey: aws.String(secretKey),
+			SessionToken:    aws.String(sessionToken),
+			Expiration:      &expiration,
+		},
+	}, nil)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", "/role/"+roleName+"?token=s3cr3t", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected requests with the token to be served")
+}
+
+func TestCredentialsQueryTokenRequiresBothSettings(t *testing.T) {
+	os.Setenv(config.CredsQueryTokenParamVar, "token")
+	defer os.Unsetenv(config.CredsQueryTokenParamVar)
+
+	called := false
+	handler := requireQueryToken(func(w http.ResponseWriter, r *http.Request) error {
+		called = true
+		return nil
+	})
+	err := handler(httptest.NewRecorder(), httptest.NewRequest("GET", "/creds", nil))
+	assert.NoError(t, err, "Expected no token to be required without a value")
+	assert.True(t, called, "Expected the request to be served")
+}
+
+func TestGetRoleCredentialsWithSourceIdentity(t *testing.T) {
+	os.Setenv(config.SourceIdentityVar, "alice@example.com")
+	defer os.Unsetenv(config.SourceIdentityVar)
+
+	iamMock, stsMock := setupMocks(t)
+	credsService := newCredentialServiceInTest(iamMock, stsMock)
+
+	// a client with static credentials builds the request which would be sent to STS
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Credentials: credentials.NewStaticCredentials(accessKey, secretKey, ""),
+	}))
+	var sentBody string
+
+	expiration := time.Now().Add(time.Hour)
+	iamMock.EXPECT().GetRole(gomock.Any()).Return(&iam.GetRoleOutput{
+		Role: &iam.Role{
+			Arn: aws.String(roleARN),
+		},
+	}, nil)
+	stsMock.EXPECT().AssumeRoleWithContext(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, input *sts.AssumeRoleInput, opts ...request.Option) (*sts.AssumeRoleOutput, error) {
+			assert.Equal(t, roleARN, aws.StringValue(input.RoleArn), "Expected the role to match")
+			req, _ := sts.New(sess).AssumeRoleRequest(input)
+			req.ApplyOptions(opts...)
+			assert.NoError(t, req.Build(), "Unexpected error building the request")
+			body, err := ioutil.ReadAll(req.GetBody())
+			assert.NoError(t, err, "Unexpected error reading the request")
+			sentBody = string(body)
+			return &sts.AssumeRoleOutput{
+				Credentials: &sts.Credentials{
+					AccessKeyId:     aws.String(accessKey),
+					SecretAccessKey: aws.String(secretKey),
+					SessionToken:    aws.String(sessionToken),
+					Expiration:      &expiration,
+				},
+			}, nil
+		})
+
+	creds, err := credsService.getRoleCredentials(roleName)
+	assert.NoError(t, err, "Unexpected error getting credentials")
+	assert.Equal(t, accessKey, creds.AccessKeyID, "Expected access key to match")
+	assert.Contains(t, sentBody, "SourceIdentity=alice%40example.com", "Expected the source identity to be sent to STS")
+	assert.Contains(t, sentBody, "Action=AssumeRole", "Expected the rest of the request to be kept")
+}
+
+func TestValidateSourceIdentity(t *testing.T) {
+	for _, sourceIdentity := range []string{"", "alice", "alice@example.com", "ci-job_42+retry=1,team.a"} {
+		assert.NoError(t, validateSourceIdentity(sourceIdentity), "Expected %q to be valid", sourceIdentity)
+	}
+	for _, sourceIdentity := range []string{"a", strings.Repeat("a", 65), "alice smith", "alice/bob", "aws:alice", "AWS:alice"} {
+		assert.Error(t, validateSourceIdentity(sourceIdentity), "Expected %q to be invalid", sourceIdentity)
+	}
+}
+
+func TestRotateSessionNameLength(t *testing.T) {
+	os.Setenv(config.RotateSessionNameVar, "true")
+	defer os.Unsetenv(config.RotateSessionNameVar)
+
+	credsService := &CredentialService{}
+	sessionName := credsService.rotateSessionName(getRoleSessionName(strings.Repeat("a", 100), ""))
+	assert.Len(t, sessionName, roleSessionNameLength, "Expected the rotated session name to stay within the length STS allows")
+	assert.Regexp(t, `-\d+$`, sessionName, "Expected the rotation to be kept when the session name is truncated")
+}
+
+func TestGetRoleSessionName(t *testing.T) {
+	assert.Equal(t, "ecs-local-clyde_task_role", getRoleSessionName(roleName, ""), "Expected default session name")
+	assert.Equal(t, "ecs-local-e18ab3d25b38-clyde_task_role", getRoleSessionName(roleName, longID1), "Expected session name to include the short container ID")
+	assert.Equal(t, "ecs-local-some-role-name", getRoleSessionName("some role:name", ""), "Expected characters which STS does not allow to be replaced")
+	assert.Len(t, getRoleSessionName(strings.Repeat("a", 100), longID1), roleSessionNameLength, "Expected session name to be truncated")
+}
+
+func TestGetRoleHandlerRegionHeader(t *testing.T) {
+	os.Setenv(config.RegionHeaderVar, "true")
+	defer os.Unsetenv(config.RegionHeaderVar)
+
+	iamMock, stsMock := setupMocks(t)
+	credsService := newCredentialServiceInTest(iamMock, stsMock)
+	credsService.currentSession = session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String("us-west-2"),
+		Credentials: credentials.NewStaticCredentials(accessKey, secretKey, sessionToken),
+	}))
+
+	// in this SDK version, the STS endpoint for us-west-2 is the global endpoint, but ap-northeast-2 has its own
+	regionalClient := credsService.regionalSTSClient("ap-northeast-2")
+	if assert.IsType(t, &sts.STS{}, regionalClient, "Expected an STS client for the region") {
+		assert.Equal(t, "https://sts.ap-northeast-2.amazonaws.com", regionalClient.(*sts.STS).Endpoint, "Expected the STS endpoint to be resolved for the requested region")
+		assert.Equal(t, "ap-northeast-2", regionalClient.(*sts.STS).SigningRegion, "Expected requests to be signed for the requested region")
+	}
+	assert.Equal(t, regionalClient, credsService.regionalSTSClient("ap-northeast-2"), "Expected the client for each region to be reused")
+
+	// the credentials for the requested region are assumed with its client
+	regionalMock := mock_stsiface.NewMockSTSAPI(gomock.NewController(t))
+	credsService.regionalSTSClients["eu-west-1"] = regionalMock
+	expiration := time.Now().Add(time.Hour)
+	iamMock.EXPECT().GetRole(gomock.Any()).Return(&iam.GetRoleOutput{
+		Role: &iam.Role{
+			Arn: aws.String(roleARN),
+		},
+	}, nil)
+	regionalMock.EXPECT().AssumeRole(gomock.Any()).Return(&sts.AssumeRoleOutput{
+		Credentials: &sts.Credentials{
+			AccessKeyId:     aws.String(accessKey),
+			SecretAccessKey: aws.String(secretKey),
+			SessionToken:    aws.String(sessionToken),
+			Expiration:      &expiration,
+		},
+	}, nil)
+
+	request := mux.SetURLVars(httptest.NewRequest("GET", "/role/"+roleName, nil), map[string]string{"role": roleName})
+	request.Header.Set(regionHeader, "eu-west-1")
+	recorder := httptest.NewRecorder()
+	ServeHTTP(credsService.getRoleHandler())(recorder, request)
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code to match")
+
+	request = mux.SetURLVars(httptest.NewRequest("GET", "/role/"+roleName, nil), map[string]string{"role": roleName})
+	request.Header.Set(regionHeader, "not-a-region")
+	recorder = httptest.NewRecorder()
+	ServeHTTP(credsService.getRoleHandler())(recorder, request)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code, "Expected invalid regions to be rejected")
+}
+
+func TestGetRoleHandlerIMDSStyleCredentials(t *testing.T) {
+	os.Setenv(config.IMDSStyleCredsVar, "true")
+	defer os.Unsetenv(config.IMDSStyleCredsVar)
+
+	iamMock, stsMock := setupMocks(t)
+	credsService := newCredentialServiceInTest(iamMock, stsMock)
+
+	expiration := time.Now().Add(time.Hour)
+	iamMock.EXPECT().GetRole(gomock.Any()).Return(&iam.GetRoleOutput{
+		Role: &iam.Role{
+			Arn: aws.String(roleARN),
+		},
+	}, nil)
+	stsMock.EXPECT().AssumeRole(gomock.Any()).Return(&sts.AssumeRoleOutput{
+		Credentials: &sts.Credentials{
+			AccessKeyId:     aws.String(accessKey),
+			SecretAccessKey: aws.String(secretKey),
+			SessionToken:    aws.String(sessionToken),
+			Expiration:      &expiration,
+		},
+	}, nil)
+
+	request := mux.SetURLVars(httptest.NewRequest("GET", "/role/"+roleName, nil), map[string]string{"role": roleName})
+	recorder := httptest.NewRecorder()
+	ServeHTTP(credsService.getRoleHandler())(recorder, request)
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code to match")
+
+	var actual map[string]interface{}
+	err := json.Unmarshal(recorder.Body.Bytes(), &actual)
+	assert.NoError(t, err, "Unexpected error unmarshalling response")
+
+	// The EC2 Instance Metadata Service credentials schema
+	for _, field := range []string{"Code", "LastUpdated", "Type", "AccessKeyId", "SecretAccessKey", "Token", "Expiration"} {
+		assert.Contains(t, actual, field, "Expected the EC2 Instance Metadata Service credentials field")
+	}
+	assert.Equal(t, "Success", actual["Code"], "Expected code to match")
+	assert.Equal(t, "AWS-HMAC", actual["Type"], "Expected type to match")
+	lastUpdated, err := time.Parse(CredentialExpirationTimeFormat, actual["LastUpdated"].(string))
+	assert.NoError(t, err, "Expected LastUpdated to be a timestamp")
+	assert.WithinDuration(t, time.Now(), lastUpdated, time.Minute, "Expected LastUpdated to be the issuance time")
+}
+
 func TestGetTemporaryCredentials(t *testing.T) {
 	iamMock, stsMock := setupMocks(t)
 
@@ -219,3 +972,215 @@ func newCredentialServiceInTest(iamMock *mock_iamiface.MockIAMAPI, stsMock *mock
 		currentSession: nil,
 	}
 }
+
+func TestGetTemporaryCredentialHandlerUpstream(t *testing.T) {
+	upstreamResponse := `{"AccessKeyId":"UPSTREAM","SecretAccessKey":"SKID","Token":"token","Expiration":"2109-11-10T23:00:00Z"}`
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "team-token" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, upstreamResponse)
+	}))
+	defer upstream.Close()
+
+	os.Setenv(config.UpstreamCredsURIVar, upstream.URL)
+	defer os.Unsetenv(config.UpstreamCredsURIVar)
+
+	// the local credential sources are not used
+	iamMock, stsMock := setupMocks(t)
+	credsService := newCredentialServiceInTest(iamMock, stsMock)
+
+	request := httptest.NewRequest("GET", "/creds", nil)
+	request.Header.Set("Authorization", "team-token")
+	recorder := httptest.NewRecorder()
+	ServeHTTP(credsService.getTemporaryCredentialHandler())(recorder, request)
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code to match")
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"), "Expected content type to be passed through")
+	assert.Equal(t, upstreamResponse, recorder.Body.String(), "Expected the upstream response to be passed through")
+
+	recorder = httptest.NewRecorder()
+	ServeHTTP(credsService.getTemporaryCredentialHandler())(recorder, httptest.NewRequest("GET", "/creds", nil))
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code, "Expected the upstream error to be passed through")
+
+	upstream.Close()
+	recorder = httptest.NewRecorder()
+	ServeHTTP(credsService.getTemporaryCredentialHandler())(recorder, httptest.NewRequest("GET", "/creds", nil))
+	assert.Equal(t, http.StatusBadGateway, recorder.Code, "Expected a bad gateway error when the upstream is unreachable")
+}
+
+func TestGetTemporaryCredentialHandlerRoleQueryParameter(t *testing.T) {
+	iamMock, stsMock := setupMocks(t)
+	credsService := newCredentialServiceInTest(iamMock, stsMock)
+
+	expectAssumeRoleInTest(t, iamMock, stsMock, roleName)
+
+	// GetSessionToken is not called
+	recorder := httptest.NewRecorder()
+	ServeHTTP(credsService.getTemporaryCredentialHandler())(recorder, httptest.NewRequest("GET", "/creds?role="+roleName, nil))
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code to match")
+
+	var response CredentialResponse
+	err := json.Unmarshal(recorder.Body.Bytes(), &response)
+	assert.NoError(t, err, "Unexpected error unmarshalling response")
+	assert.Equal(t, accessKey, response.AccessKeyID, "Expected the role's credentials")
+
+	recorder = httptest.NewRecorder()
+	ServeHTTP(credsService.getTemporaryCredentialHandler())(recorder, httptest.NewRequest("GET", "/creds?role=invalid/role", nil))
+	assert.Equal(t, http.StatusBadRequest, recorder.Code, "Expected the role name to be validated as in the path form")
+}
+
+func TestGetTemporaryCredentialHandlerDefaultRole(t *testing.T) {
+	iamMock, stsMock := setupMocks(t)
+	credsService := newCredentialServiceInTest(iamMock, stsMock)
+
+	os.Setenv(config.DefaultRoleARNVar, roleARN)
+	defer os.Unsetenv(config.DefaultRoleARNVar)
+	os.Unsetenv(config.ProfileVar)
+
+	expiration := time.Now().Add(time.Hour)
+	// the role is assumed by its ARN, so neither IAM nor GetSessionToken is called
+	stsMock.EXPECT().AssumeRole(gomock.Any()).Do(func(x interface{}) {
+		input := x.(*sts.AssumeRoleInput)
+		assert.Equal(t, roleARN, aws.StringValue(input.RoleArn), "Expected the default role to be assumed")
+		assert.Equal(t, defaultRoleSessionName, aws.StringValue(input.RoleSessionName), "Expected the default role's session name")
+	}).Return(&sts.AssumeRoleOutput{
+		Credentials: &sts.Credentials{
+			AccessKeyId:     aws.String(accessKey),
+			SecretAccessKey: aws.String(secretKey),
+			SessionToken:    aws.String(sessionToken),
+			Expiration:      &expiration,
+		},
+	}, nil)
+
+	recorder := httptest.NewRecorder()
+	ServeHTTP(credsService.getTemporaryCredentialHandler())(recorder, httptest.NewRequest("GET", "/creds", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code to match")
+
+	var response CredentialResponse
+	err := json.Unmarshal(recorder.Body.Bytes(), &response)
+	assert.NoError(t, err, "Unexpected error unmarshalling response")
+	assert.Equal(t, accessKey, response.AccessKeyID, "Expected the default role's credentials")
+	assert.Equal(t, roleARN, response.RoleArn, "Expected the default role's ARN")
+}
+
+func TestGetDefaultRoleARNForCreds(t *testing.T) {
+	os.Unsetenv(config.ProfileVar)
+	assert.Empty(t, getDefaultRoleARNForCreds(), "Expected no default role unless it is configured")
+
+	os.Setenv(config.DefaultRoleARNVar, roleARN)
+	defer os.Unsetenv(config.DefaultRoleARNVar)
+	assert.Equal(t, roleARN, getDefaultRoleARNForCreds(), "Expected the default role when it is the only configuration")
+
+	os.Setenv(config.ProfileVar, "meow")
+	assert.Empty(t, getDefaultRoleARNForCreds(), "Expected the profile to take precedence")
+	os.Unsetenv(config.ProfileVar)
+
+	os.Setenv("AWS_ACCESS_KEY_ID", accessKey)
+	os.Setenv("AWS_SECRET_ACCESS_KEY", secretKey)
+	assert.Empty(t, getDefaultRoleARNForCreds(), "Expected the access keys in the environment to take precedence")
+	os.Unsetenv("AWS_ACCESS_KEY_ID")
+	os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+	assert.Equal(t, roleARN, getDefaultRoleARNForCreds(), "Expected the default role once the access keys are unset")
+
+	os.Setenv(config.CredsSourceOrderVar, "profile,role")
+	defer os.Unsetenv(config.CredsSourceOrderVar)
+	assert.Empty(t, getDefaultRoleARNForCreds(), "Expected the source order to take precedence")
+}
+
+func TestGetRoleHandlerPathTakesPrecedence(t *testing.T) {
+	iamMock, stsMock := setupMocks(t)
+	credsService := newCredentialServiceInTest(iamMock, stsMock)
+
+	expectAssumeRoleInTest(t, iamMock, stsMock, roleName)
+
+	request := mux.SetURLVars(httptest.NewRequest("GET", "/role/"+roleName+"?role=other_role", nil), map[string]string{"role": roleName})
+	recorder := httptest.NewRecorder()
+	ServeHTTP(credsService.getRoleHandler())(recorder, request)
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code to match")
+}
+
+func expectAssumeRoleInTest(t *testing.T, iamMock *mock_iamiface.MockIAMAPI, stsMock *mock_stsiface.MockSTSAPI, expectedRoleName string) {
+	expiration := time.Now().Add(time.Hour)
+	gomock.InOrder(
+		iamMock.EXPECT().GetRole(gomock.Any()).Do(func(x interface{}) {
+			input := x.(*iam.GetRoleInput)
+			assert.Equal(t, expectedRoleName, aws.StringValue(input.RoleName), "Expected role name to match")
+		}).Return(&iam.GetRoleOutput{
+			Role: &iam.Role{
+				Arn: aws.String(roleARN),
+			},
+		}, nil),
+		stsMock.EXPECT().AssumeRole(gomock.Any()).Return(&sts.AssumeRoleOutput{
+			Credentials: &sts.Credentials{
+				AccessKeyId:     aws.String(accessKey),
+				SecretAccessKey: aws.String(secretKey),
+				SessionToken:    aws.String(sessionToken),
+				Expiration:      &expiration,
+			},
+		}, nil),
+	)
+}
+
+func TestReloadDiscardsCachedCredentials(t *testing.T) {
+	provider := &CustomProvider{
+		expiration: time.Now().Add(time.Hour),
+		creds: credentials.Value{
+			AccessKeyID:     "OLD",
+			SecretAccessKey: secretKey,
+			SessionToken:    sessionToken,
+		},
+	}
+	sess, err := session.NewSession(aws.NewConfig().WithCredentials(credentials.NewCredentials(provider)))
+	assert.NoError(t, err, "Unexpected error creating new session")
+	credsService := &CredentialService{
+		currentSession: sess,
+	}
+
+	getAccessKey := func() string {
+		recorder := httptest.NewRecorder()
+		ServeHTTP(credsService.getTemporaryCredentialHandler())(recorder, httptest.NewRequest("GET", "/creds", nil))
+		var response CredentialResponse
+		err := json.Unmarshal(recorder.Body.Bytes(), &response)
+		assert.NoError(t, err, "Unexpected error unmarshalling response")
+		return response.AccessKeyID
+	}
+
+	assert.Equal(t, "OLD", getAccessKey(), "Expected the credentials from the current configuration")
+
+	// simulate a change to the credentials configuration
+	provider.creds.AccessKeyID = "NEW"
+	assert.Equal(t, "OLD", getAccessKey(), "Expected the cached credentials to be served until a reload")
+
+	credsService.Reload()
+	assert.Equal(t, "NEW", getAccessKey(), "Expected the credentials to be resolved again after a reload")
+}
+
+func TestGetRoleHandlerWarnDeprecatedPaths(t *testing.T) {
+	iamMock, stsMock := setupMocks(t)
+	credsService := newCredentialServiceInTest(iamMock, stsMock)
+
+	expectAssumeRoleInTest(t, iamMock, stsMock, roleName)
+
+	request := mux.SetURLVars(httptest.NewRequest("GET", "/role/"+roleName, nil), map[string]string{"role": roleName})
+	recorder := httptest.NewRecorder()
+	ServeHTTP(credsService.getRoleHandler())(recorder, request)
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code to match")
+	assert.Empty(t, recorder.Header().Get("Deprecation"), "Expected no deprecation header by default")
+	assert.Empty(t, recorder.Header().Get("Warning"), "Expected no warning header by default")
+
+	os.Setenv(config.WarnDeprecatedPathsVar, "true")
+	defer os.Unsetenv(config.WarnDeprecatedPathsVar)
+
+	// the credentials are cached
+	recorder = httptest.NewRecorder()
+	ServeHTTP(credsService.getRoleHandler())(recorder, request)
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected the legacy path to still be served")
+	assert.Equal(t, "true", recorder.Header().Get("Deprecation"), "Expected a deprecation header on the legacy path")
+	assert.Equal(t, `299 - "This path is deprecated; use /creds?role=clyde_task_role instead"`, recorder.Header().Get("Warning"), "Expected a warning header naming the replacement")
+
+	recorder = httptest.NewRecorder()
+	ServeHTTP(credsService.getTemporaryCredentialHandler())(recorder, httptest.NewRequest("GET", "/creds?role="+roleName, nil))
+	assert.Empty(t, recorder.Header().Get("Deprecation"), "Expected no deprecation header on the replacement path")
+}
@@ -0,0 +1,65 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"sync"
+	"time"
+)
+
+// roleRateLimiter limits the rate of requests for each role independently, with a token bucket per role, so that
+// clients which request one role very often can not starve clients of other roles
+// The zero value is a limiter ready for use
+type roleRateLimiter struct {
+	lock    sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// tokenBucket holds up to one second's worth of requests; each request takes a token, and tokens are refilled
+// continuously at the rate limit
+type tokenBucket struct {
+	tokens     float64
+	refilledAt time.Time
+}
+
+// allow takes a token from the bucket of the role, which is keyed by its ARN, which holds up to rps tokens. If the bucket is empty, it returns
+// false, and how long until the next token is available.
+func (limiter *roleRateLimiter) allow(roleARN string, rps int, now time.Time) (bool, time.Duration) {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+
+	if limiter.buckets == nil {
+		limiter.buckets = make(map[string]*tokenBucket)
+	}
+	bucket, ok := limiter.buckets[roleARN]
+	if !ok {
+		bucket = &tokenBucket{
+			tokens:     float64(rps),
+			refilledAt: now,
+		}
+		limiter.buckets[roleARN] = bucket
+	}
+
+	bucket.tokens += now.Sub(bucket.refilledAt).Seconds() * float64(rps)
+	if bucket.tokens > float64(rps) {
+		bucket.tokens = float64(rps)
+	}
+	bucket.refilledAt = now
+
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / float64(rps) * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
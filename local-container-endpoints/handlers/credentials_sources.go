@@ -0,0 +1,156 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/clients/useragent"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const defaultRoleSessionName = "ecs-local-default-role"
+
+// allCredentialSources lists every credential source which can be ordered with ECS_LOCAL_CREDS_SOURCE_ORDER
+var allCredentialSources = []string{
+	config.CredsSourceStatic,
+	config.CredsSourceRole,
+	config.CredsSourceProfile,
+	config.CredsSourceEC2,
+}
+
+// defaultChainSources lists the credential sources in the AWS SDK for Go's default credential chain, in order
+var defaultChainSources = []string{
+	config.CredsSourceStatic,
+	config.CredsSourceProfile,
+	config.CredsSourceEC2,
+}
+
+// newCredentialSources returns the providers for each of the credential sources which can be ordered with ECS_LOCAL_CREDS_SOURCE_ORDER
+func newCredentialSources(sess *session.Session) map[string]credentials.Provider {
+	sources := map[string]credentials.Provider{
+		config.CredsSourceStatic:  &credentials.EnvProvider{},
+		config.CredsSourceProfile: &credentials.SharedCredentialsProvider{},
+		config.CredsSourceEC2: &ec2rolecreds.EC2RoleProvider{
+			Client: ec2metadata.New(sess),
+		},
+	}
+
+	if roleARN := utils.GetValue("", config.DefaultRoleARNVar); roleARN != "" {
+		stsClient := sts.New(sess)
+		stsClient.Handlers.Build.PushBackNamed(useragent.CustomUserAgentHandler())
+		sources[config.CredsSourceRole] = &stscreds.AssumeRoleProvider{
+			Client:          stsClient,
+			RoleARN:         roleARN,
+			RoleSessionName: defaultRoleSessionName,
+			Duration:        temporaryCredentialsDurationInS * time.Second,
+		}
+	}
+
+	return sources
+}
+
+// parseCredsSourceOrder returns the names of the credential sources in ECS_LOCAL_CREDS_SOURCE_ORDER
+func parseCredsSourceOrder(order string) ([]string, error) {
+	var names []string
+	for _, name := range strings.Split(order, ",") {
+		name = strings.TrimSpace(name)
+		switch name {
+		case config.CredsSourceStatic, config.CredsSourceRole, config.CredsSourceProfile, config.CredsSourceEC2:
+		default:
+			return nil, fmt.Errorf("Invalid credential source '%s' in %s; expected one of %s, %s, %s, or %s", name, config.CredsSourceOrderVar,
+				config.CredsSourceStatic, config.CredsSourceRole, config.CredsSourceProfile, config.CredsSourceEC2)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// newOrderedCredentials returns credentials which are resolved from the first source in the given order which yields credentials
+func newOrderedCredentials(order string, sources map[string]credentials.Provider) (*credentials.Credentials, error) {
+	names, err := parseCredsSourceOrder(order)
+	if err != nil {
+		return nil, err
+	}
+
+	var providers []credentials.Provider
+	for _, name := range names {
+		provider, ok := sources[name]
+		if !ok {
+			logrus.Debugf("Skipping credential source %s, which is not configured", name)
+			continue
+		}
+		providers = append(providers, provider)
+	}
+
+	return credentials.NewCredentials(&credentials.ChainProvider{
+		Providers:     providers,
+		VerboseErrors: true,
+	}), nil
+}
+
+// getCredentialSource returns the name of the credential source which the AWS SDK for Go's provider name belongs to
+func getCredentialSource(providerName string) string {
+	switch {
+	case providerName == credentials.EnvProviderName || providerName == session.EnvProviderName:
+		return config.CredsSourceStatic
+	case providerName == credentials.SharedCredsProviderName || strings.HasPrefix(providerName, "SharedConfigCredentials"):
+		return config.CredsSourceProfile
+	case providerName == ec2rolecreds.ProviderName:
+		return config.CredsSourceEC2
+	case providerName == stscreds.ProviderName:
+		return config.CredsSourceRole
+	default:
+		// a source outside of Local Endpoints' control, such as a credential process in the shared config
+		return providerName
+	}
+}
+
+// getCredentialSourcesResponse reports the configured credential sources, and the one which the credentials currently come from.
+// Only the source names are reported, never the credentials.
+func (service *CredentialService) getCredentialSourcesResponse() *CredentialSourcesResponse {
+	response := &CredentialSourcesResponse{
+		Order:        service.credsSourceOrder,
+		DefaultChain: service.defaultCredsChain,
+	}
+	for _, name := range allCredentialSources {
+		_, configured := service.credsSources[name]
+		response.Sources = append(response.Sources, CredentialSourceResponse{
+			Name:       name,
+			Configured: configured,
+		})
+	}
+
+	if service.currentSession == nil || service.currentSession.Config == nil || service.currentSession.Config.Credentials == nil {
+		response.Error = "No credentials are configured"
+		return response
+	}
+	value, err := service.currentSession.Config.Credentials.Get()
+	if err != nil {
+		response.Error = err.Error()
+		return response
+	}
+	response.Selected = getCredentialSource(value.ProviderName)
+	return response
+}
@@ -0,0 +1,169 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewOrderedCredentials(t *testing.T) {
+	sources := map[string]credentials.Provider{
+		config.CredsSourceStatic: &credentials.StaticProvider{
+			Value: credentials.Value{AccessKeyID: "STATIC", SecretAccessKey: secretKey},
+		},
+		config.CredsSourceProfile: &credentials.StaticProvider{
+			Value: credentials.Value{AccessKeyID: "PROFILE", SecretAccessKey: secretKey},
+		},
+		// a source which is configured, but yields no credentials
+		config.CredsSourceEC2: &credentials.StaticProvider{},
+	}
+
+	var testCases = []struct {
+		order    string
+		expected string
+	}{
+		{
+			order:    "static,role,profile,ec2",
+			expected: "STATIC",
+		},
+		{
+			order:    "profile,static",
+			expected: "PROFILE",
+		},
+		{
+			order:    "ec2, role, profile, static",
+			expected: "PROFILE",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.order, func(t *testing.T) {
+			creds, err := newOrderedCredentials(testCase.order, sources)
+			assert.NoError(t, err, "Unexpected error creating credentials")
+			value, err := creds.Get()
+			assert.NoError(t, err, "Unexpected error resolving credentials")
+			assert.Equal(t, testCase.expected, value.AccessKeyID, "Expected credentials to come from the first source which yields them")
+		})
+	}
+}
+
+func TestNewOrderedCredentialsNoSourceYieldsCredentials(t *testing.T) {
+	sources := map[string]credentials.Provider{
+		config.CredsSourceStatic: &credentials.StaticProvider{},
+	}
+
+	creds, err := newOrderedCredentials("static,role", sources)
+	assert.NoError(t, err, "Unexpected error creating credentials")
+	_, err = creds.Get()
+	assert.Error(t, err, "Expected error when no source yields credentials")
+}
+
+func TestNewOrderedCredentialsInvalidSource(t *testing.T) {
+	_, err := newOrderedCredentials("static,environment", map[string]credentials.Provider{})
+	assert.Error(t, err, "Expected error for an invalid credential source")
+}
+
+func TestCredentialSourcesHandler(t *testing.T) {
+	os.Setenv("AWS_ACCESS_KEY_ID", accessKey)
+	defer os.Unsetenv("AWS_ACCESS_KEY_ID")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", secretKey)
+	defer os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+
+	sources := map[string]credentials.Provider{
+		config.CredsSourceStatic: &credentials.EnvProvider{},
+		config.CredsSourceProfile: &credentials.SharedCredentialsProvider{
+			Filename: "/does/not/exist",
+		},
+	}
+	order := "profile,role,static"
+	creds, err := newOrderedCredentials(order, sources)
+	assert.NoError(t, err, "Unexpected error creating credentials")
+	sess := session.Must(session.NewSession(&aws.Config{
+		Credentials: creds,
+	}))
+
+	iamMock, stsMock := setupMocks(t)
+	credsService := NewCredentialServiceWithClients(iamMock, stsMock, sess)
+	credsService.credsSources = sources
+	credsService.credsSourceOrder, _ = parseCredsSourceOrder(order)
+
+	os.Setenv(config.DebugEndpointsVar, "true")
+	defer os.Unsetenv(config.DebugEndpointsVar)
+	router := mux.NewRouter()
+	credsService.SetupRoutes(router)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", config.CredentialSourcesPath, nil))
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code to match")
+	assert.NotContains(t, recorder.Body.String(), secretKey, "Expected no credentials in the response")
+
+	actual := &CredentialSourcesResponse{}
+	err = json.Unmarshal(recorder.Body.Bytes(), actual)
+	assert.NoError(t, err, "Unexpected error unmarshalling response")
+
+	expected := &CredentialSourcesResponse{
+		Order: []string{config.CredsSourceProfile, config.CredsSourceRole, config.CredsSourceStatic},
+		Sources: []CredentialSourceResponse{
+			CredentialSourceResponse{Name: config.CredsSourceStatic, Configured: true},
+			CredentialSourceResponse{Name: config.CredsSourceRole, Configured: false},
+			CredentialSourceResponse{Name: config.CredsSourceProfile, Configured: true},
+			CredentialSourceResponse{Name: config.CredsSourceEC2, Configured: false},
+		},
+		// the profile source yields no credentials, and the role source is not configured
+		Selected: config.CredsSourceStatic,
+	}
+	assert.Equal(t, expected, actual, "Expected the reported sources to match the configuration")
+}
+
+func TestCredentialSourcesHandlerNoSession(t *testing.T) {
+	iamMock, stsMock := setupMocks(t)
+	credsService := NewCredentialServiceWithClients(iamMock, stsMock, nil)
+
+	os.Setenv(config.DebugEndpointsVar, "true")
+	defer os.Unsetenv(config.DebugEndpointsVar)
+	router := mux.NewRouter()
+	credsService.SetupRoutes(router)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", config.CredentialSourcesPath, nil))
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code to match")
+
+	actual := &CredentialSourcesResponse{}
+	err := json.Unmarshal(recorder.Body.Bytes(), actual)
+	assert.NoError(t, err, "Unexpected error unmarshalling response")
+	assert.Empty(t, actual.Selected, "Expected no selected source without a session")
+	assert.NotEmpty(t, actual.Error, "Expected an error without a session")
+}
+
+func TestCredentialSourcesHandlerDisabled(t *testing.T) {
+	iamMock, stsMock := setupMocks(t)
+	credsService := newCredentialServiceInTest(iamMock, stsMock)
+	router := mux.NewRouter()
+	credsService.SetupRoutes(router)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", config.CredentialSourcesPath, nil))
+	assert.NotEqual(t, http.StatusOK, recorder.Code, "Expected the debug path to not be served by default")
+}
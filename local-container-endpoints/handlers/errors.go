@@ -0,0 +1,131 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/utils"
+	"github.com/gorilla/mux"
+)
+
+// urlUserInfo matches the user info in URLs, such as that of an upstream credentials endpoint, which can hold a password
+var urlUserInfo = regexp.MustCompile(`://[^/@\s]+@`)
+
+// recentErrors holds the most recent errors from all handlers, if ECS_LOCAL_DEBUG_ENDPOINTS is set
+var recentErrors = &errorLog{}
+
+// ErrorResponse is an error which was encountered while serving a request
+type ErrorResponse struct {
+	Time string `json:"Time"`
+	// Route is the path template which matched the request, such as /role/{role}, or otherwise the request's path
+	Route  string `json:"Route"`
+	Method string `json:"Method"`
+	Status int    `json:"Status"`
+	// Error is the error message; the credentials which were requested are never part of it
+	Error string `json:"Error"`
+}
+
+// errorLog is a ring buffer of the most recent errors, up to ECS_LOCAL_RECENT_ERRORS
+type errorLog struct {
+	lock    sync.Mutex
+	entries []ErrorResponse
+	// next is the index of the entry which the next error replaces, once the buffer is full
+	next int
+}
+
+// SetupDebugRoutes sets up the debug path which reports the most recent errors, if ECS_LOCAL_DEBUG_ENDPOINTS is set
+func SetupDebugRoutes(router *mux.Router) {
+	if !utils.GetBoolValue(false, config.DebugEndpointsVar) {
+		return
+	}
+	router.HandleFunc(config.RecentErrorsPath, ServeHTTP(getRecentErrorsHandler(recentErrors)))
+	router.HandleFunc(config.RecentErrorsPathWithSlash, ServeHTTP(getRecentErrorsHandler(recentErrors)))
+}
+
+// getRecentErrorsHandler returns the handler for the recent errors debug path, which lists the errors oldest first
+func getRecentErrorsHandler(log *errorLog) func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		writeJSONResponse(w, log.list())
+		return nil
+	}
+}
+
+// record adds the error to the log, replacing the oldest error once there are ECS_LOCAL_RECENT_ERRORS
+func (log *errorLog) record(r *http.Request, status int, err error) {
+	if !utils.GetBoolValue(false, config.DebugEndpointsVar) {
+		return
+	}
+	size := utils.GetIntValue(config.DefaultRecentErrors, config.RecentErrorsVar)
+	if size <= 0 {
+		return
+	}
+	entry := ErrorResponse{
+		Time:   time.Now().UTC().Format(time.RFC3339Nano),
+		Route:  getRoute(r),
+		Method: r.Method,
+		Status: status,
+		Error:  urlUserInfo.ReplaceAllString(err.Error(), "://REDACTED@"),
+	}
+
+	log.lock.Lock()
+	defer log.lock.Unlock()
+	if cap(log.entries) != size {
+		log.resize(size)
+	}
+	if len(log.entries) < size {
+		log.entries = append(log.entries, entry)
+		return
+	}
+	log.entries[log.next] = entry
+	log.next = (log.next + 1) % size
+}
+
+// resize changes the capacity of the buffer, keeping the most recent errors
+func (log *errorLog) resize(size int) {
+	entries := log.ordered()
+	if len(entries) > size {
+		entries = entries[len(entries)-size:]
+	}
+	log.entries = append(make([]ErrorResponse, 0, size), entries...)
+	log.next = 0
+}
+
+// list returns a copy of the errors, oldest first
+func (log *errorLog) list() []ErrorResponse {
+	log.lock.Lock()
+	defer log.lock.Unlock()
+	return log.ordered()
+}
+
+func (log *errorLog) ordered() []ErrorResponse {
+	entries := make([]ErrorResponse, 0, len(log.entries))
+	entries = append(entries, log.entries[log.next:]...)
+	return append(entries, log.entries[:log.next]...)
+}
+
+// getRoute returns the path template of the route which matched the request, so that errors for the same route
+// can be grouped, or otherwise the request's path
+func getRoute(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	return r.URL.Path
+}
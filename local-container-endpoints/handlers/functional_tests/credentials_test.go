@@ -40,7 +40,7 @@ const (
 	secretKey            = "SKID"
 	accessKey            = "AKID"
 	sessionToken         = "token"
-	expirationTimeString = "2009-11-10T23:00:00Z"
+	expirationTimeString = "2109-11-10T23:00:00Z"
 )
 
 func TestGetRoleCredentials(t *testing.T) {
@@ -112,6 +112,7 @@ func TestV2Handler_TaskMetadata(t *testing.T) {
 
 	gomock.InOrder(
 		dockerMock.EXPECT().ContainerList(gomock.Any()).Return(dockerAPIResponse, nil),
+		dockerMock.EXPECT().ContainerInspect(gomock.Any(), gomock.Any()).Return(&types.ContainerJSON{}, nil).AnyTimes(),
 	)
 
 	metadataService, err := handlers.NewMetadataServiceWithClient(dockerMock)
@@ -200,6 +201,7 @@ func TestV2Handler_TaskMetadata_TrailingSlash(t *testing.T) {
 
 	gomock.InOrder(
 		dockerMock.EXPECT().ContainerList(gomock.Any()).Return(dockerAPIResponse, nil),
+		dockerMock.EXPECT().ContainerInspect(gomock.Any(), gomock.Any()).Return(&types.ContainerJSON{}, nil).AnyTimes(),
 	)
 
 	metadataService, err := handlers.NewMetadataServiceWithClient(dockerMock)
@@ -256,6 +258,27 @@ func TestV2Handler_TaskMetadata_DockerAPIError(t *testing.T) {
 	assert.True(t, strings.Contains(response.Status, strconv.Itoa(http.StatusInternalServerError)), "Expected http response status to be internal server error")
 }
 
+func TestV2Handler_TaskMetadata_MethodNotAllowed(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	dockerMock := mock_docker.NewMockClient(ctrl)
+
+	metadataService, err := handlers.NewMetadataServiceWithClient(dockerMock)
+	assert.NoError(t, err, "Unexpected error creating new metadata service")
+
+	// create a testing server
+	router := mux.NewRouter()
+	metadataService.SetupV2Routes(router)
+	testServer := httptest.NewServer(router)
+	defer testServer.Close()
+
+	// make a request to the testing server
+	response, err := http.Post(fmt.Sprintf("%s/v2/metadata", testServer.URL), "application/json", strings.NewReader("{}"))
+	assert.NoError(t, err, "Unexpected error making HTTP Request")
+	response.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, response.StatusCode, "Expected http response status to be method not allowed")
+	assert.Equal(t, "GET, HEAD", response.Header.Get("Allow"), "Expected Allow header to list the allowed methods")
+}
+
 // Tests Path: /v2/metadata/<container ID>
 func TestV2Handler_ContainerMetadata(t *testing.T) {
 	// Docker API Containers
@@ -279,6 +302,7 @@ func TestV2Handler_ContainerMetadata(t *testing.T) {
 
 	gomock.InOrder(
 		dockerMock.EXPECT().ContainerList(gomock.Any()).Return(dockerAPIResponse, nil),
+		dockerMock.EXPECT().ContainerInspect(gomock.Any(), gomock.Any()).Return(&types.ContainerJSON{}, nil).AnyTimes(),
 	)
 
 	metadataService, err := handlers.NewMetadataServiceWithClient(dockerMock)
@@ -350,6 +374,7 @@ func TestV2Handler_ContainerMetadata_TrailingSlash(t *testing.T) {
 
 	gomock.InOrder(
 		dockerMock.EXPECT().ContainerList(gomock.Any()).Return(dockerAPIResponse, nil),
+		dockerMock.EXPECT().ContainerInspect(gomock.Any(), gomock.Any()).Return(&types.ContainerJSON{}, nil).AnyTimes(),
 	)
 
 	metadataService, err := handlers.NewMetadataServiceWithClient(dockerMock)
@@ -398,6 +423,7 @@ func TestV2Handler_ContainerStats(t *testing.T) {
 	gomock.InOrder(
 		dockerMock.EXPECT().ContainerList(gomock.Any()).Return(dockerAPIResponse, nil),
 		dockerMock.EXPECT().ContainerStats(gomock.Any(), longID1).Return(expectedStats, nil),
+		dockerMock.EXPECT().ContainerInspect(gomock.Any(), longID1).Return(&types.ContainerJSON{}, nil).AnyTimes(),
 	)
 
 	metadataService, err := handlers.NewMetadataServiceWithClient(dockerMock)
@@ -446,6 +472,7 @@ func TestV2Handler_ContainerStats_TrailingSlash(t *testing.T) {
 	gomock.InOrder(
 		dockerMock.EXPECT().ContainerList(gomock.Any()).Return(dockerAPIResponse, nil),
 		dockerMock.EXPECT().ContainerStats(gomock.Any(), longID1).Return(expectedStats, nil),
+		dockerMock.EXPECT().ContainerInspect(gomock.Any(), longID1).Return(&types.ContainerJSON{}, nil).AnyTimes(),
 	)
 
 	metadataService, err := handlers.NewMetadataServiceWithClient(dockerMock)
@@ -506,6 +533,7 @@ func TestV2Handler_TaskStats(t *testing.T) {
 	dockerMock.EXPECT().ContainerStats(gomock.Any(), longID2).Return(container2Stats, nil)
 	dockerMock.EXPECT().ContainerStats(gomock.Any(), longID3).Return(container3Stats, nil)
 	dockerMock.EXPECT().ContainerStats(gomock.Any(), endpointsLongID).Return(endpointsStats, nil)
+	dockerMock.EXPECT().ContainerInspect(gomock.Any(), gomock.Any()).Return(&types.ContainerJSON{}, nil).AnyTimes()
 
 	metadataService, err := handlers.NewMetadataServiceWithClient(dockerMock)
 	assert.NoError(t, err, "Unexpected error creating new metadata service")
@@ -565,6 +593,7 @@ func TestV2Handler_TaskStats_TrailingSlash(t *testing.T) {
 	dockerMock.EXPECT().ContainerStats(gomock.Any(), longID2).Return(container2Stats, nil)
 	dockerMock.EXPECT().ContainerStats(gomock.Any(), longID3).Return(container3Stats, nil)
 	dockerMock.EXPECT().ContainerStats(gomock.Any(), endpointsLongID).Return(endpointsStats, nil)
+	dockerMock.EXPECT().ContainerInspect(gomock.Any(), gomock.Any()).Return(&types.ContainerJSON{}, nil).AnyTimes()
 
 	metadataService, err := handlers.NewMetadataServiceWithClient(dockerMock)
 	assert.NoError(t, err, "Unexpected error creating new metadata service")
@@ -616,6 +645,7 @@ func TestV2Handler_TaskStats_DockerAPIError(t *testing.T) {
 	dockerMock.EXPECT().ContainerStats(gomock.Any(), longID2).Return(container2Stats, nil)
 	dockerMock.EXPECT().ContainerStats(gomock.Any(), longID3).Return(nil, fmt.Errorf("Some error"))
 	dockerMock.EXPECT().ContainerStats(gomock.Any(), endpointsLongID).Return(endpointsStats, nil)
+	dockerMock.EXPECT().ContainerInspect(gomock.Any(), gomock.Any()).Return(&types.ContainerJSON{}, nil).AnyTimes()
 
 	metadataService, err := handlers.NewMetadataServiceWithClient(dockerMock)
 	assert.NoError(t, err, "Unexpected error creating new metadata service")
@@ -91,6 +91,7 @@ func TestV3Handler_TaskMetadata(t *testing.T) {
 
 	gomock.InOrder(
 		dockerMock.EXPECT().ContainerList(gomock.Any()).Return(dockerAPIResponse, nil),
+		dockerMock.EXPECT().ContainerInspect(gomock.Any(), gomock.Any()).Return(&types.ContainerJSON{}, nil).AnyTimes(),
 	)
 
 	metadataService, err := handlers.NewMetadataServiceWithClient(dockerMock)
@@ -178,6 +179,7 @@ func TestV3Handler_TaskMetadata_TrailingSlash(t *testing.T) {
 
 	gomock.InOrder(
 		dockerMock.EXPECT().ContainerList(gomock.Any()).Return(dockerAPIResponse, nil),
+		dockerMock.EXPECT().ContainerInspect(gomock.Any(), gomock.Any()).Return(&types.ContainerJSON{}, nil).AnyTimes(),
 	)
 
 	metadataService, err := handlers.NewMetadataServiceWithClient(dockerMock)
@@ -211,6 +213,81 @@ func TestV3Handler_TaskMetadata_TrailingSlash(t *testing.T) {
 
 }
 
+// Tests Path: /task
+func TestTaskAlias_TaskMetadata(t *testing.T) {
+	// the test server sees requests from the loopback address
+	callerContainer := testingutils.BaseDockerContainer(containerName1, longID1).WithNetwork(network1, "127.0.0.1").WithComposeProject(projectName).Get()
+	container2 := testingutils.BaseDockerContainer(containerName2, longID2).WithNetwork(network1, ipAddress2).WithComposeProject(projectName).Get()
+	container3 := testingutils.BaseDockerContainer(containerName3, longID3).WithNetwork(network2, ipAddress1).WithComposeProject(projectName2).Get()
+
+	dockerAPIResponse := []types.Container{
+		callerContainer,
+		container2,
+		container3,
+	}
+
+	os.Setenv(config.TaskAliasVar, "true")
+	defer os.Clearenv()
+
+	ctrl := gomock.NewController(t)
+	dockerMock := mock_docker.NewMockClient(ctrl)
+
+	dockerMock.EXPECT().ContainerList(gomock.Any()).Return(dockerAPIResponse, nil).Times(2)
+	dockerMock.EXPECT().ContainerInspect(gomock.Any(), gomock.Any()).Return(&types.ContainerJSON{}, nil).AnyTimes()
+
+	metadataService, err := handlers.NewMetadataServiceWithClient(dockerMock)
+	assert.NoError(t, err, "Unexpected error creating new metadata service")
+
+	// create a testing server
+	router := mux.NewRouter()
+	metadataService.SetupV3Routes(router)
+	testServer := httptest.NewServer(router)
+	defer testServer.Close()
+
+	// the alias is served the same response as the V3 task metadata path, for the same caller
+	getTaskMetadata := func(path string) map[string]interface{} {
+		res, err := http.Get(testServer.URL + path)
+		assert.NoError(t, err, "Unexpected error making HTTP Request")
+		response, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		assert.NoError(t, err, "Unexpected error reading HTTP response")
+		assert.Equal(t, http.StatusOK, res.StatusCode, "Expected http response status to be OK")
+
+		var metadata map[string]interface{}
+		err = json.Unmarshal(response, &metadata)
+		assert.NoError(t, err, "Unexpected error unmarshalling response")
+		return metadata
+	}
+
+	aliasMetadata := getTaskMetadata(config.TaskAliasPath)
+	v3Metadata := getTaskMetadata(config.V3TaskMetadataPath)
+
+	assert.Equal(t, v3Metadata, aliasMetadata, "Expected the alias to respond with the caller's V3 task metadata")
+	assert.Equal(t, config.DefaultTaskARN, aliasMetadata["TaskARN"], "Expected TaskARN to match")
+	containers, _ := aliasMetadata["Containers"].([]interface{})
+	assert.Len(t, containers, 2, "Expected only the containers in the caller's task")
+}
+
+func TestTaskAlias_Disabled(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	dockerMock := mock_docker.NewMockClient(ctrl)
+
+	metadataService, err := handlers.NewMetadataServiceWithClient(dockerMock)
+	assert.NoError(t, err, "Unexpected error creating new metadata service")
+
+	// create a testing server
+	router := mux.NewRouter()
+	metadataService.SetupV3Routes(router)
+	testServer := httptest.NewServer(router)
+	defer testServer.Close()
+
+	// make a request to the testing server
+	response, err := http.Get(fmt.Sprintf("%s/task", testServer.URL))
+	assert.NoError(t, err, "Unexpected error making HTTP Request")
+	response.Body.Close()
+	assert.Equal(t, http.StatusNotFound, response.StatusCode, "Expected the alias to not be served by default")
+}
+
 func TestV3Handler_TaskMetadata_DockerAPIError(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	dockerMock := mock_docker.NewMockClient(ctrl)
@@ -279,6 +356,7 @@ func TestV3Handler_ContainerStats(t *testing.T) {
 	gomock.InOrder(
 		dockerMock.EXPECT().ContainerList(gomock.Any()).Return(dockerAPIResponse, nil),
 		dockerMock.EXPECT().ContainerStats(gomock.Any(), longID1).Return(expectedStats, nil),
+		dockerMock.EXPECT().ContainerInspect(gomock.Any(), longID1).Return(&types.ContainerJSON{}, nil).AnyTimes(),
 	)
 
 	metadataService, err := handlers.NewMetadataServiceWithClient(dockerMock)
@@ -326,6 +404,7 @@ func TestV3Handler_ContainerStats_TrailingSlash(t *testing.T) {
 	gomock.InOrder(
 		dockerMock.EXPECT().ContainerList(gomock.Any()).Return(dockerAPIResponse, nil),
 		dockerMock.EXPECT().ContainerStats(gomock.Any(), longID1).Return(expectedStats, nil),
+		dockerMock.EXPECT().ContainerInspect(gomock.Any(), longID1).Return(&types.ContainerJSON{}, nil).AnyTimes(),
 	)
 
 	metadataService, err := handlers.NewMetadataServiceWithClient(dockerMock)
@@ -386,6 +465,7 @@ func TestV3Handler_TaskStats(t *testing.T) {
 	dockerMock.EXPECT().ContainerStats(gomock.Any(), longID2).Return(container2Stats, nil)
 	dockerMock.EXPECT().ContainerStats(gomock.Any(), longID3).Return(container3Stats, nil)
 	dockerMock.EXPECT().ContainerStats(gomock.Any(), endpointsLongID).Return(endpointsStats, nil)
+	dockerMock.EXPECT().ContainerInspect(gomock.Any(), gomock.Any()).Return(&types.ContainerJSON{}, nil).AnyTimes()
 
 	metadataService, err := handlers.NewMetadataServiceWithClient(dockerMock)
 	assert.NoError(t, err, "Unexpected error creating new metadata service")
@@ -444,6 +524,7 @@ func TestV3Handler_TaskStats_TrailingSlash(t *testing.T) {
 	dockerMock.EXPECT().ContainerStats(gomock.Any(), longID2).Return(container2Stats, nil)
 	dockerMock.EXPECT().ContainerStats(gomock.Any(), longID3).Return(container3Stats, nil)
 	dockerMock.EXPECT().ContainerStats(gomock.Any(), endpointsLongID).Return(endpointsStats, nil)
+	dockerMock.EXPECT().ContainerInspect(gomock.Any(), gomock.Any()).Return(&types.ContainerJSON{}, nil).AnyTimes()
 
 	metadataService, err := handlers.NewMetadataServiceWithClient(dockerMock)
 	assert.NoError(t, err, "Unexpected error creating new metadata service")
@@ -494,6 +575,7 @@ func TestV3Handler_TaskStats_DockerAPIError(t *testing.T) {
 	dockerMock.EXPECT().ContainerStats(gomock.Any(), longID2).Return(container2Stats, nil)
 	dockerMock.EXPECT().ContainerStats(gomock.Any(), longID3).Return(nil, fmt.Errorf("Some error"))
 	dockerMock.EXPECT().ContainerStats(gomock.Any(), endpointsLongID).Return(endpointsStats, nil)
+	dockerMock.EXPECT().ContainerInspect(gomock.Any(), gomock.Any()).Return(&types.ContainerJSON{}, nil).AnyTimes()
 
 	metadataService, err := handlers.NewMetadataServiceWithClient(dockerMock)
 	assert.NoError(t, err, "Unexpected error creating new metadata service")
@@ -0,0 +1,154 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/utils"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// Statuses reported at the health path
+const (
+	healthStatusHealthy   = "healthy"
+	healthStatusDegraded  = "degraded"
+	healthStatusUnhealthy = "unhealthy"
+	healthStatusStale     = "stale"
+	healthStatusSkipped   = "skipped"
+)
+
+// SetupHealthRoutes sets up the path which reports the health of Local Endpoints, and of the Docker daemon, STS
+// and the container snapshot it depends on, if ECS_LOCAL_ENABLE_HEALTHZ is set
+func SetupHealthRoutes(router *mux.Router, metadataService *MetadataService, credentialsService *CredentialService) {
+	if !utils.GetBoolValue(false, config.EnableHealthzVar) {
+		return
+	}
+	handler := ServeHTTP(getHealthHandler(metadataService, credentialsService))
+	router.HandleFunc(config.HealthzPath, handler)
+	router.HandleFunc(config.HealthzPathWithSlash, handler)
+}
+
+// getHealthHandler returns the handler for the health path, which responds with a 503 if any dependency is unhealthy
+// Each dependency's health is only included with ?detail=true
+func getHealthHandler(metadataService *MetadataService, credentialsService *CredentialService) func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		timeout, _ := time.ParseDuration(config.HTTPTimeoutDuration)
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		detail := HealthResponse{
+			Docker: metadataService.dockerHealth(ctx),
+			STS:    credentialsService.stsHealth(ctx),
+			Cache:  metadataService.cacheHealth(),
+		}
+		detail.Status = getOverallHealth(detail.Docker, detail.STS, detail.Cache)
+
+		response := detail
+		if r.URL.Query().Get("detail") != "true" {
+			response = HealthResponse{
+				Status: detail.Status,
+			}
+		}
+
+		code := http.StatusOK
+		if response.Status == healthStatusUnhealthy {
+			code = http.StatusServiceUnavailable
+		}
+		writeJSONResponseWithCode(w, response, code)
+		return nil
+	}
+}
+
+// getOverallHealth is unhealthy if any dependency is unhealthy, and degraded if any cache is stale
+func getOverallHealth(dependencies ...*DependencyHealthResponse) string {
+	status := healthStatusHealthy
+	for _, dependency := range dependencies {
+		switch dependency.Status {
+		case healthStatusUnhealthy:
+			return healthStatusUnhealthy
+		case healthStatusStale:
+			status = healthStatusDegraded
+		}
+	}
+	return status
+}
+
+// dockerHealth pings the Docker daemon
+func (service *MetadataService) dockerHealth(ctx context.Context) *DependencyHealthResponse {
+	if err := service.dockerClient.Ping(ctx); err != nil {
+		logrus.Warnf("Health check failed for Docker: %s", err)
+		return &DependencyHealthResponse{
+			Status: healthStatusUnhealthy,
+			Error:  err.Error(),
+		}
+	}
+	return &DependencyHealthResponse{
+		Status: healthStatusHealthy,
+	}
+}
+
+// stsHealth calls sts:GetCallerIdentity, which checks both that STS is reachable and that the base credentials are valid
+// It is skipped if credentials come from ECS_LOCAL_UPSTREAM_CREDS_URI, since STS is not used
+func (service *CredentialService) stsHealth(ctx context.Context) *DependencyHealthResponse {
+	if utils.GetValue("", config.UpstreamCredsURIVar) != "" {
+		return &DependencyHealthResponse{
+			Status: healthStatusSkipped,
+		}
+	}
+	if _, err := service.stsClient.GetCallerIdentityWithContext(ctx, &sts.GetCallerIdentityInput{}); err != nil {
+		logrus.Warnf("Health check failed for STS: %s", err)
+		return &DependencyHealthResponse{
+			Status: healthStatusUnhealthy,
+			Error:  err.Error(),
+		}
+	}
+	return &DependencyHealthResponse{
+		Status: healthStatusHealthy,
+	}
+}
+
+// cacheHealth reports the age of the snapshot of containers, which is stale once it is older than
+// ECS_LOCAL_METADATA_SNAPSHOT_TTL; it is skipped if the snapshot is not enabled
+func (service *MetadataService) cacheHealth() *DependencyHealthResponse {
+	ttl := utils.GetDurationValue(config.DefaultMetadataSnapshotTTL, config.MetadataSnapshotTTLVar)
+	if ttl <= 0 {
+		return &DependencyHealthResponse{
+			Status: healthStatusSkipped,
+		}
+	}
+	list := service.containerSnapshot.load()
+	if list == nil {
+		return &DependencyHealthResponse{
+			Status: healthStatusStale,
+			Error:  "no snapshot of containers has been taken",
+		}
+	}
+	age := time.Since(list.takenAt)
+	health := &DependencyHealthResponse{
+		Status:     healthStatusHealthy,
+		AgeSeconds: age.Seconds(),
+	}
+	if age >= ttl {
+		health.Status = healthStatusStale
+		health.Error = fmt.Sprintf("the snapshot of containers is older than %s", ttl)
+	}
+	return health
+}
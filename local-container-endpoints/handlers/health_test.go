@@ -0,0 +1,103 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/clients/docker/mock_docker"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthzDisabledByDefault(t *testing.T) {
+	router := mux.NewRouter()
+	SetupHealthRoutes(router, &MetadataService{}, &CredentialService{})
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", config.HealthzPath, nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code, "Expected no health path by default")
+}
+
+func TestHealthzDetailWithDockerUpAndSTSDown(t *testing.T) {
+	os.Setenv(config.EnableHealthzVar, "true")
+	defer os.Unsetenv(config.EnableHealthzVar)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	dockerMock := mock_docker.NewMockClient(ctrl)
+	iamMock, stsMock := setupMocks(t)
+
+	gomock.InOrder(
+		dockerMock.EXPECT().Ping(gomock.Any()).Return(nil),
+		stsMock.EXPECT().GetCallerIdentityWithContext(gomock.Any(), &sts.GetCallerIdentityInput{}).Return(nil, fmt.Errorf("Some API Error")),
+	)
+
+	router := mux.NewRouter()
+	SetupHealthRoutes(router, &MetadataService{dockerClient: dockerMock}, newCredentialServiceInTest(iamMock, stsMock))
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", config.HealthzPath+"?detail=true", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code, "Expected a 503 while a dependency is unhealthy")
+
+	var response HealthResponse
+	err := json.Unmarshal(recorder.Body.Bytes(), &response)
+	assert.NoError(t, err, "Unexpected error unmarshalling response")
+
+	expected := HealthResponse{
+		Status: healthStatusUnhealthy,
+		Docker: &DependencyHealthResponse{
+			Status: healthStatusHealthy,
+		},
+		STS: &DependencyHealthResponse{
+			Status: healthStatusUnhealthy,
+			Error:  "Some API Error",
+		},
+		Cache: &DependencyHealthResponse{
+			Status: healthStatusSkipped,
+		},
+	}
+	assert.Equal(t, expected, response, "Expected the health of each dependency")
+}
+
+func TestHealthzSummary(t *testing.T) {
+	os.Setenv(config.EnableHealthzVar, "true")
+	defer os.Unsetenv(config.EnableHealthzVar)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	dockerMock := mock_docker.NewMockClient(ctrl)
+	iamMock, stsMock := setupMocks(t)
+
+	gomock.InOrder(
+		dockerMock.EXPECT().Ping(gomock.Any()).Return(nil),
+		stsMock.EXPECT().GetCallerIdentityWithContext(gomock.Any(), &sts.GetCallerIdentityInput{}).Return(&sts.GetCallerIdentityOutput{}, nil),
+	)
+
+	router := mux.NewRouter()
+	SetupHealthRoutes(router, &MetadataService{dockerClient: dockerMock}, newCredentialServiceInTest(iamMock, stsMock))
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", config.HealthzPath, nil))
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected a 200 while all dependencies are healthy")
+	assert.JSONEq(t, `{"Status":"healthy"}`, recorder.Body.String(), "Expected only the overall status without detail")
+}
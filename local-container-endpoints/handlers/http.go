@@ -17,9 +17,12 @@ package handlers
 import (
 	"encoding/json"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
-	"github.com/sirupsen/logrus"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/utils"
 )
 
 // Error wraps built-in error and adds a status code
@@ -32,6 +35,8 @@ type Error interface {
 type HTTPError struct {
 	Code int
 	Err  error
+	// RetryAfter is sent in the Retry-After header, if set
+	RetryAfter time.Duration
 }
 
 // Error satisfies the error interface.
@@ -44,29 +49,49 @@ func (herr HTTPError) Status() int {
 	return herr.Code
 }
 
+// errorWriter writes the response for an error returned by a handler, with the given message and status code
+type errorWriter func(w http.ResponseWriter, err error, message string, code int)
+
 // ServeHTTP wraps an HTTP Handler
 func ServeHTTP(handler func(w http.ResponseWriter, r *http.Request) error) func(w http.ResponseWriter, r *http.Request) {
+	return serveHTTP(handler, writeTextError)
+}
+
+func serveHTTP(handler func(w http.ResponseWriter, r *http.Request) error, writeError errorWriter) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		err := handler(w, r)
 		if err != nil {
 			switch e := err.(type) {
 			case Error:
 				// Return the specific error code and error message
-				logrus.Errorf("HTTP %d - %s", e.Status(), err)
-				http.Error(w, e.Error(), e.Status())
+				utils.RequestLogger(r).Errorf("HTTP %d - %s", e.Status(), err)
+				recentErrors.record(r, e.Status(), err)
+				if herr, ok := e.(HTTPError); ok && herr.RetryAfter > 0 {
+					w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(herr.RetryAfter.Seconds()))))
+				}
+				writeError(w, err, e.Error(), e.Status())
 			default:
 				// default to HTTP 500 for all other errors
-				logrus.Errorf("HTTP 500 - %s", err)
+				utils.RequestLogger(r).Errorf("HTTP 500 - %s", err)
+				recentErrors.record(r, http.StatusInternalServerError, err)
 				// Internal Server Error: <actual error message>
-				http.Error(w, fmt.Sprintf("%s: %s", http.StatusText(http.StatusInternalServerError), err.Error()),
+				writeError(w, err, fmt.Sprintf("%s: %s", http.StatusText(http.StatusInternalServerError), err.Error()),
 					http.StatusInternalServerError)
 			}
 		}
 	}
 }
 
+func writeTextError(w http.ResponseWriter, err error, message string, code int) {
+	http.Error(w, message, code)
+}
+
 func writeJSONResponse(w http.ResponseWriter, response interface{}) {
+	writeJSONResponseWithCode(w, response, http.StatusOK)
+}
+
+func writeJSONResponseWithCode(w http.ResponseWriter, response interface{}, code int) {
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
+	w.WriteHeader(code)
 	json.NewEncoder(w).Encode(response)
 }
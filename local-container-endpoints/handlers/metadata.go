@@ -21,8 +21,11 @@ import (
 	"strings"
 	"time"
 
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/clients/docker"
 	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
 	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/metadata"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/stats"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/utils"
 	"github.com/docker/docker/api/types"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -30,8 +33,20 @@ import (
 
 const (
 	composeProjectNameLabel = "com.docker.compose.project"
+
+	// perCPUQueryParameter requests the per-core CPU usage in stats responses, for example /v3/stats?percpu=true
+	perCPUQueryParameter = "percpu"
+	// rawQueryParameter requests a single stats frame from Docker, as is, for example /v3/stats?raw=true
+	rawQueryParameter = "raw"
 )
 
+// statsOptions are the query parameters of stats requests
+type statsOptions struct {
+	includePerCPU bool
+	// raw skips the second sample of ECS_LOCAL_STATS_SAMPLE_INTERVAL, and the values which Local Endpoints derives
+	raw bool
+}
+
 const (
 	requestTypeContainerMetadata = iota + 1
 	requestTypeContainerStats
@@ -39,27 +54,45 @@ const (
 	requestTypeTaskStats
 )
 
-func (service *MetadataService) containerStatsResponse(w http.ResponseWriter, identifier string, callerIP string) error {
+func (service *MetadataService) containerStatsResponse(w http.ResponseWriter, identifier string, callerIP string, options statsOptions) error {
 	timeout, _ := time.ParseDuration(config.HTTPTimeoutDuration)
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
-	containers, err := service.dockerClient.ContainerList(ctx)
+	containers, err := service.listContainers(ctx)
 	if err != nil {
 		return errors.Wrap(err, "failed to list running containers")
 	}
+	service.statsHistory.Prune(containers)
 
 	container, err := findContainer(containers, identifier, callerIP)
 	if err != nil {
 		return err
 	}
 
-	stats, err := service.dockerClient.ContainerStats(ctx, container.ID)
+	if options.raw {
+		containerStats, err := service.dockerClient.ContainerStats(ctx, container.ID)
+		if err != nil {
+			return statsError(err)
+		}
+		writeJSONResponse(w, containerStats)
+		return nil
+	}
+
+	frame, err := service.sampleContainerStats(ctx, container.ID)
 	if err != nil {
-		return errors.Wrap(err, "failed to get container stats")
+		return statsError(err)
+	}
+
+	response := stats.GetContainerStats(&frame.Stats, service.inspectContainer(ctx, container.ID))
+	response.GPUStats = frame.GPUStats
+	service.addStorageStats(ctx, container.ID, response)
+	service.statsHistory.AddMovingAverages(container.ID, response, frame.Networks)
+	if !options.includePerCPU {
+		response.OmitPerCPUUsage()
 	}
 
-	writeJSONResponse(w, stats)
+	writeJSONResponse(w, response)
 	return nil
 }
 
@@ -68,19 +101,37 @@ func (service *MetadataService) containerMetadataResponse(w http.ResponseWriter,
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
-	containers, err := service.dockerClient.ContainerList(ctx)
+	list, err := service.listContainersWithTime(ctx)
 	if err != nil {
 		return errors.Wrap(err, "Failed to list running containers")
 	}
+	containers := list.containers
 	container, err := findContainer(containers, identifier, callerIP)
 	if err != nil {
 		return err
 	}
 
-	response := metadata.GetContainerMetadata(container)
+	response := metadata.GetContainerMetadata(container, service.inspectContainer(ctx, container.ID))
+	includeRepoDigest := utils.GetBoolValue(false, config.IncludeImageRepoDigestVar)
+	includeImageSource := utils.GetBoolValue(false, config.IncludeImageSourceVar)
+	if includeRepoDigest || includeImageSource {
+		image := service.inspectImage(ctx, response.ImageID)
+		if includeRepoDigest {
+			metadata.AddImageRepoDigest(response, image)
+		}
+		if includeImageSource {
+			metadata.AddImageSource(response, image)
+		}
+	}
+	if utils.GetBoolValue(false, config.IncludeCgroupnsModeVar) {
+		response.CgroupnsMode = service.cgroupnsMode(ctx, container.ID)
+	}
+	if utils.GetBoolValue(false, config.IncludeSequenceVar) {
+		response.Sequence = service.stateSequence.current()
+	}
+	response.GeneratedAt = getGeneratedAt(list)
 
-	writeJSONResponse(w, response)
-	return nil
+	return writeMetadataResponse(w, response)
 }
 
 func (service *MetadataService) taskMetadataResponse(w http.ResponseWriter, identifier string, callerIP string) error {
@@ -88,41 +139,199 @@ func (service *MetadataService) taskMetadataResponse(w http.ResponseWriter, iden
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
-	containers, err := service.dockerClient.ContainerList(ctx)
+	list, err := service.listContainersWithTime(ctx)
 	if err != nil {
 		return err
 	}
+	containers := list.containers
 	taskContainers := getTaskContainers(containers, identifier, callerIP)
 
-	response := metadata.GetTaskMetadata(taskContainers, service.containerInstanceTags, service.taskTags)
+	containerInspects, partial := service.inspectContainers(ctx, taskContainers)
+	if partial {
+		taskContainers = filterInspected(taskContainers, containerInspects)
+	}
 
-	writeJSONResponse(w, response)
+	response := metadata.GetTaskMetadata(taskContainers, containerInspects, service.containerInstanceTags, service.taskTags)
+	response.Partial = partial
+	if service.taskRevision != "" {
+		response.Revision = service.taskRevision
+	}
+	metadata.AddLabeledTaskRevision(response)
+	metadata.AddTaskDefinitionARN(response)
+	var primaryContainerID string
+	if callerContainer, err := findContainer(taskContainers, identifier, callerIP); err == nil {
+		primaryContainerID = callerContainer.ID
+	}
+	metadata.AddTaskNetworks(response, primaryContainerID)
+	includeRepoDigest := utils.GetBoolValue(false, config.IncludeImageRepoDigestVar)
+	includeImageSource := utils.GetBoolValue(false, config.IncludeImageSourceVar)
+	if includeRepoDigest || includeImageSource {
+		// containers in a task often share an image, which only needs to be inspected once
+		images := make(map[string]*types.ImageInspect)
+		for i := range response.Containers {
+			imageID := response.Containers[i].ImageID
+			if _, ok := images[imageID]; !ok {
+				images[imageID] = service.inspectImage(ctx, imageID)
+			}
+			if includeRepoDigest {
+				metadata.AddImageRepoDigest(&response.Containers[i], images[imageID])
+			}
+			if includeImageSource {
+				metadata.AddImageSource(&response.Containers[i], images[imageID])
+			}
+		}
+	}
+	if utils.GetBoolValue(false, config.IncludeCgroupnsModeVar) {
+		for i := range response.Containers {
+			response.Containers[i].CgroupnsMode = service.cgroupnsMode(ctx, response.Containers[i].ID)
+		}
+	}
+	if utils.GetBoolValue(false, config.IncludeSequenceVar) {
+		response.Sequence = service.stateSequence.current()
+	}
+	response.GeneratedAt = getGeneratedAt(list)
+
+	return writeMetadataResponse(w, response)
+}
+
+// getGeneratedAt returns when the containers in the response were listed, if ECS_LOCAL_INCLUDE_GENERATED_AT is set
+// With ECS_LOCAL_METADATA_SNAPSHOT_TTL, this is when the snapshot was taken, so that clients can tell how fresh it is
+func getGeneratedAt(list *containerList) *time.Time {
+	if !utils.GetBoolValue(false, config.IncludeGeneratedAtVar) {
+		return nil
+	}
+	generatedAt := list.takenAt.UTC()
+	return &generatedAt
+}
+
+// writeMetadataResponse writes the metadata response with its timestamps in the format set in ECS_LOCAL_TIMESTAMP_FORMAT,
+// and how long ago each of them was, if ECS_LOCAL_INCLUDE_RELATIVE_TIMES is set
+func writeMetadataResponse(w http.ResponseWriter, response interface{}) error {
+	if utils.GetBoolValue(false, config.IncludeRelativeTimesVar) {
+		var err error
+		if response, err = metadata.AddRelativeTimes(response, time.Now()); err != nil {
+			return err
+		}
+	}
+	formatted, err := metadata.FormatTimestamps(response, utils.GetValue(config.DefaultTimestampFormat, config.TimestampFormatVar))
+	if err != nil {
+		return err
+	}
+	writeJSONResponse(w, formatted)
 	return nil
 }
 
-func (service *MetadataService) taskStatsResponse(w http.ResponseWriter, identifier string, callerIP string) error {
+// inspectContainer returns the Docker inspect response for the container, or nil if it could not be inspected
+// Metadata can still be served without it, just with fewer values
+func (service *MetadataService) inspectContainer(ctx context.Context, containerID string) *types.ContainerJSON {
+	if inspect := service.inspectCache.take(containerID); inspect != nil {
+		return inspect
+	}
+	inspect, err := service.dockerClient.ContainerInspect(ctx, containerID)
+	if err != nil {
+		logrus.Warn(err)
+		return nil
+	}
+	return inspect
+}
+
+// inspectImage returns the Docker inspect response for the image, or nil if it could not be inspected
+func (service *MetadataService) inspectImage(ctx context.Context, imageID string) *types.ImageInspect {
+	if imageID == "" {
+		return nil
+	}
+	image, err := service.dockerClient.ImageInspect(ctx, imageID)
+	if err != nil {
+		logrus.Warn(err)
+		return nil
+	}
+	return image
+}
+
+// cgroupnsMode returns the container's cgroup namespace mode, or an empty string if it could not be read
+func (service *MetadataService) cgroupnsMode(ctx context.Context, containerID string) string {
+	mode, err := service.dockerClient.ContainerCgroupnsMode(ctx, containerID)
+	if err != nil {
+		logrus.Warn(err)
+		return ""
+	}
+	return mode
+}
+
+// inspectContainers inspects the containers concurrently. If ECS_LOCAL_METADATA_SOFT_DEADLINE passes first,
+// it returns the inspect responses it has so far, and reports that they are partial.
+func (service *MetadataService) inspectContainers(ctx context.Context, containers []types.Container) (map[string]*types.ContainerJSON, bool) {
+	inspects := make(map[string]*types.ContainerJSON)
+	inspectChan := make(chan dockerInspect, len(containers))
+	for _, container := range containers {
+		go func(containerID string) {
+			inspectChan <- dockerInspect{
+				containerID: containerID,
+				inspect:     service.inspectContainer(ctx, containerID),
+			}
+		}(container.ID)
+	}
+
+	// a nil channel blocks forever, so there is no soft deadline unless one is configured
+	var deadline <-chan time.Time
+	if softDeadline := utils.GetDurationValue(config.DefaultMetadataSoftDeadline, config.MetadataSoftDeadlineVar); softDeadline > 0 {
+		timer := time.NewTimer(softDeadline)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	for range containers {
+		select {
+		case <-deadline:
+			logrus.Warnf("Returning partial metadata; %d of %d containers were inspected within %s", len(inspects), len(containers), config.MetadataSoftDeadlineVar)
+			return inspects, true
+		case result := <-inspectChan:
+			inspects[result.containerID] = result.inspect
+		}
+	}
+	return inspects, false
+}
+
+// simple struct that inspectContainers sends over a channel
+type dockerInspect struct {
+	containerID string
+	inspect     *types.ContainerJSON
+}
+
+func filterInspected(dockerContainers []types.Container, inspects map[string]*types.ContainerJSON) []types.Container {
+	var filtered []types.Container
+	for _, container := range dockerContainers {
+		if _, ok := inspects[container.ID]; ok {
+			filtered = append(filtered, container)
+		}
+	}
+	return filtered
+}
+
+func (service *MetadataService) taskStatsResponse(w http.ResponseWriter, identifier string, callerIP string, options statsOptions) error {
 	timeout, _ := time.ParseDuration(config.HTTPTimeoutDuration)
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
-	containers, err := service.dockerClient.ContainerList(ctx)
+	containers, err := service.listContainers(ctx)
 	if err != nil {
 		return err
 	}
-	response := make(map[string]types.Stats)
+	service.statsHistory.Prune(containers)
+	response := make(map[string]stats.ContainerStatsResponse)
 
 	statsChan := make(chan dockerStats, len(containers))
 
 	for _, container := range containers {
-		go service.getContainerStatsWithChannel(ctx, statsChan, container.ID)
+		go service.getContainerStatsWithChannel(ctx, statsChan, container.ID, options.raw)
 	}
 
 	for range containers {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		case stats := <-statsChan:
-			if stats.err != nil {
+		case containerStats := <-statsChan:
+			if containerStats.err != nil {
 				// cancel the context
 				cancel()
 				// Question for @sharanyad and @clareliguori: it's safe to return here, right?
@@ -131,9 +340,12 @@ func (service *MetadataService) taskStatsResponse(w http.ResponseWriter, identif
 				// Also calling cancel() ends the context,
 				// so none of the Docker API requests can get stuck.
 				// This also applies for the above case where we return ctx.Err().
-				return stats.err
+				return containerStats.err
+			}
+			if !options.includePerCPU && !options.raw {
+				containerStats.stats.OmitPerCPUUsage()
 			}
-			response[stats.containerID] = *stats.stats
+			response[containerStats.containerID] = *containerStats.stats
 		}
 	}
 
@@ -144,24 +356,139 @@ func (service *MetadataService) taskStatsResponse(w http.ResponseWriter, identif
 // simple struct that () sends over a channel
 type dockerStats struct {
 	containerID string
-	stats       *types.Stats
+	stats       *stats.ContainerStatsResponse
 	err         error
 }
 
-func (service *MetadataService) getContainerStatsWithChannel(ctx context.Context, statsChan chan dockerStats, containerID string) {
-	stats, err := service.dockerClient.ContainerStats(ctx, containerID)
-
+func (service *MetadataService) getContainerStatsWithChannel(ctx context.Context, statsChan chan dockerStats, containerID string, raw bool) {
 	response := dockerStats{
-		stats:       stats,
-		err:         err,
 		containerID: containerID,
 	}
+	if raw {
+		containerStats, err := service.dockerClient.ContainerStats(ctx, containerID)
+		if err != nil {
+			response.err = statsError(err)
+		} else {
+			// none of the derived values are set, so the frame is marshalled as Docker returned it
+			response.stats = &stats.ContainerStatsResponse{
+				Stats: *containerStats,
+			}
+		}
+		statsChan <- response
+		return
+	}
+	frame, err := service.sampleContainerStats(ctx, containerID)
+	if err != nil {
+		response.err = statsError(err)
+	} else {
+		response.stats = stats.GetContainerStats(&frame.Stats, service.inspectContainer(ctx, containerID))
+		response.stats.GPUStats = frame.GPUStats
+		service.addStorageStats(ctx, containerID, response.stats)
+		service.statsHistory.AddMovingAverages(containerID, response.stats, frame.Networks)
+	}
 	// send the response on the channel
 	statsChan <- response
 }
 
-// A Local 'Task' is defined as all containers in the same Docker Compose Project as the caller container
+// addStorageStats adds the sizes of the container's filesystem, if ECS_LOCAL_INCLUDE_STORAGE_STATS is set
+// The sizes are cached for ECS_LOCAL_STORAGE_STATS_TTL, since Docker computes them by walking the filesystem
+func (service *MetadataService) addStorageStats(ctx context.Context, containerID string, response *stats.ContainerStatsResponse) {
+	if !utils.GetBoolValue(false, config.IncludeStorageStatsVar) {
+		return
+	}
+	ttl := utils.GetDurationValue(config.DefaultStorageStatsTTL, config.StorageStatsTTLVar)
+	size, ok := service.sizeCache.get(containerID, ttl)
+	if !ok {
+		inspect, err := service.dockerClient.ContainerInspectWithSize(ctx, containerID)
+		if err != nil {
+			logrus.Warn(err)
+			return
+		}
+		if inspect.ContainerJSONBase != nil {
+			size.sizeRw = inspect.SizeRw
+			size.sizeRootFs = inspect.SizeRootFs
+		}
+		size.fetchedAt = time.Now()
+		service.sizeCache.put(containerID, size, ttl)
+	}
+	response.SizeRw = size.sizeRw
+	response.SizeRootFs = size.sizeRootFs
+}
+
+// statsError returns an HTTP 502 if Docker only returned malformed stats
+func statsError(err error) error {
+	if errors.Cause(err) == docker.ErrNoValidStats {
+		return HTTPError{
+			Code: http.StatusBadGateway,
+			Err:  err,
+		}
+	}
+	return errors.Wrap(err, "failed to get container stats")
+}
+
+// sampleContainerStats returns the container's stats, where the 'pre' values are from a sample taken
+// ECS_LOCAL_STATS_SAMPLE_INTERVAL earlier, so that rates computed from the response use that interval
+// By default, Docker's own 'pre' values are used
+// The GPU and network stats, if any, are from the latest sample
+func (service *MetadataService) sampleContainerStats(ctx context.Context, containerID string) (*docker.StatsFrame, error) {
+	sample, err := service.readContainerStats(ctx, containerID)
+	if err != nil || service.statsSampleInterval <= 0 {
+		return sample, err
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(service.statsSampleInterval):
+	}
+
+	next, err := service.readContainerStats(ctx, containerID)
+	if err != nil {
+		return nil, err
+	}
+	next.PreRead = sample.Read
+	next.PreCPUStats = sample.CPUStats
+	return next, nil
+}
+
+// readContainerStats returns the container's stats from Docker, with its GPU stats if ECS_LOCAL_INCLUDE_GPU_STATS is set,
+// and its network stats, if the Docker client can read them
+func (service *MetadataService) readContainerStats(ctx context.Context, containerID string) (*docker.StatsFrame, error) {
+	if frameClient, ok := service.dockerClient.(docker.StatsFrameClient); ok {
+		frame, err := frameClient.ContainerStatsFrame(ctx, containerID)
+		if err != nil {
+			return nil, err
+		}
+		if !utils.GetBoolValue(false, config.IncludeGPUStatsVar) {
+			frame.GPUStats = nil
+		}
+		return frame, nil
+	}
+	containerStats, err := service.dockerClient.ContainerStats(ctx, containerID)
+	if err != nil {
+		return nil, err
+	}
+	return &docker.StatsFrame{
+		Stats: *containerStats,
+	}, nil
+}
+
+// getStatsSampleInterval returns ECS_LOCAL_STATS_SAMPLE_INTERVAL, limited to MaxStatsSampleInterval
+// It is only called when the MetadataService is created, so that an invalid interval is only logged once
+func getStatsSampleInterval() time.Duration {
+	interval := utils.GetDurationValue(config.DefaultStatsSampleInterval, config.StatsSampleIntervalVar)
+	maxInterval, _ := time.ParseDuration(config.MaxStatsSampleInterval)
+	if interval > maxInterval {
+		logrus.Warnf("%s of %s is too long to sample within a request; using %s", config.StatsSampleIntervalVar, interval, maxInterval)
+		return maxInterval
+	}
+	return interval
+}
+
+// A Local 'Task' is defined as all containers mapped to the same task ARN as the caller container
+// OR all containers in the same Docker Compose Project as the caller container
 // OR all containers running on this machine if the user is not using Compose
+// In each case, only the containers labeled with the same task revision as the caller container are included
 func getTaskContainers(allContainers []types.Container, identifier string, callerIP string) []types.Container {
 	callerContainer, err := findContainer(allContainers, identifier, callerIP)
 	if err != nil {
@@ -170,14 +497,64 @@ func getTaskContainers(allContainers []types.Container, identifier string, calle
 		return allContainers
 	}
 
+	revision, _ := metadata.GetLabeledTaskRevision(callerContainer)
+	return filterByTaskRevision(getCallerTaskContainers(allContainers, callerContainer), revision)
+}
+
+func getCallerTaskContainers(allContainers []types.Container, callerContainer *types.Container) []types.Container {
+	if taskARN, ok := metadata.GetMappedTaskARN(callerContainer); ok {
+		return filterByTaskARN(allContainers, taskARN)
+	}
+
+	// containers which are mapped to a task ARN are never part of the default 'local task'
+	unmappedContainers := filterUnmapped(allContainers)
+
 	projectName := callerContainer.Labels[composeProjectNameLabel]
 
 	if projectName == "" {
 		logrus.Info("Will use all containers to represent one 'local task': The container which made the request is not in a Docker Compose Project")
-		return allContainers
+		return unmappedContainers
+	}
+
+	return filterByComposeProject(unmappedContainers, projectName)
+}
+
+// filterByTaskRevision returns the containers labeled with the given task revision, or the unlabeled containers
+// if the revision is empty
+func filterByTaskRevision(dockerContainers []types.Container, revision string) []types.Container {
+	var filteredContainers []types.Container
+
+	for _, container := range dockerContainers {
+		if labeled, _ := metadata.GetLabeledTaskRevision(&container); labeled == revision {
+			filteredContainers = append(filteredContainers, container)
+		}
+	}
+
+	return filteredContainers
+}
+
+func filterByTaskARN(dockerContainers []types.Container, taskARN string) []types.Container {
+	var filteredContainers []types.Container
+
+	for _, container := range dockerContainers {
+		if mappedARN, ok := metadata.GetMappedTaskARN(&container); ok && mappedARN == taskARN {
+			filteredContainers = append(filteredContainers, container)
+		}
+	}
+
+	return filteredContainers
+}
+
+func filterUnmapped(dockerContainers []types.Container) []types.Container {
+	var filteredContainers []types.Container
+
+	for _, container := range dockerContainers {
+		if _, ok := metadata.GetMappedTaskARN(&container); !ok {
+			filteredContainers = append(filteredContainers, container)
+		}
 	}
 
-	return filterByComposeProject(allContainers, projectName)
+	return filteredContainers
 }
 
 func filterByComposeProject(dockerContainers []types.Container, projectName string) []types.Container {
@@ -0,0 +1,193 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/utils"
+	"github.com/docker/docker/api/types"
+	"github.com/sirupsen/logrus"
+)
+
+// warmUpRetryAfter is when clients should retry requests which were rejected during the background warm-up
+const warmUpRetryAfter = time.Second
+
+// inspectCache holds the inspect responses of the containers warmed at startup
+// Each response is only served once, so that later requests reflect the container's current state
+// The zero value is an empty cache ready for use
+type inspectCache struct {
+	lock     sync.Mutex
+	inspects map[string]*types.ContainerJSON
+}
+
+func (cache *inspectCache) put(containerID string, inspect *types.ContainerJSON) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	if cache.inspects == nil {
+		cache.inspects = make(map[string]*types.ContainerJSON)
+	}
+	cache.inspects[containerID] = inspect
+}
+
+// take returns the cached inspect response for the container, and removes it from the cache
+func (cache *inspectCache) take(containerID string) *types.ContainerJSON {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	inspect, ok := cache.inspects[containerID]
+	if ok {
+		delete(cache.inspects, containerID)
+	}
+	return inspect
+}
+
+// sizeCache holds the sizes of the containers' filesystems, which are slow for Docker to compute
+// The zero value is an empty cache ready for use
+type sizeCache struct {
+	lock  sync.Mutex
+	sizes map[string]containerSize
+}
+
+// containerSize is the size of a container's writable layer and of its whole root filesystem, in bytes
+type containerSize struct {
+	sizeRw     *int64
+	sizeRootFs *int64
+	fetchedAt  time.Time
+}
+
+// put caches the size of the container, and removes the expired sizes, so that the sizes of removed containers
+// are not kept forever
+func (cache *sizeCache) put(containerID string, size containerSize, ttl time.Duration) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	if cache.sizes == nil {
+		cache.sizes = make(map[string]containerSize)
+	}
+	for id, cached := range cache.sizes {
+		if time.Since(cached.fetchedAt) >= ttl {
+			delete(cache.sizes, id)
+		}
+	}
+	cache.sizes[containerID] = size
+}
+
+// get returns the cached size of the container, if it was fetched within the TTL
+func (cache *sizeCache) get(containerID string, ttl time.Duration) (containerSize, bool) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	size, ok := cache.sizes[containerID]
+	if !ok || time.Since(size.fetchedAt) >= ttl {
+		return containerSize{}, false
+	}
+	return size, true
+}
+
+// warmContainers inspects the containers selected with ECS_LOCAL_WARM_CONTAINERS, so that the first
+// metadata request for each of them does not wait for Docker to inspect it
+func (service *MetadataService) warmContainers() {
+	selectors := parseWarmContainers(utils.GetValue("", config.WarmContainersVar))
+	if len(selectors) == 0 {
+		return
+	}
+
+	timeout, _ := time.ParseDuration(config.HTTPTimeoutDuration)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	containers, err := service.dockerClient.ContainerList(ctx)
+	if err != nil {
+		logrus.Warnf("Failed to warm the metadata of %s: %s", config.WarmContainersVar, err)
+		return
+	}
+
+	warmed := 0
+	for _, container := range containers {
+		if !matchesWarmContainers(container, selectors) {
+			continue
+		}
+		if inspect := service.inspectContainer(ctx, container.ID); inspect != nil {
+			service.inspectCache.put(container.ID, inspect)
+			warmed++
+		}
+	}
+	logrus.Infof("Warmed the metadata of %d containers", warmed)
+}
+
+// warmUp warms the containers and takes the first snapshot of the running containers in the background,
+// if ECS_LOCAL_WARMUP_503 is set, so that metadata requests are rejected with a 503 until the cache is filled,
+// rather than waiting for slow Docker API calls
+func (service *MetadataService) warmUp() {
+	defer close(service.warmedUp)
+	service.warmContainers()
+
+	if utils.GetDurationValue(config.DefaultMetadataSnapshotTTL, config.MetadataSnapshotTTLVar) <= 0 {
+		return
+	}
+	timeout, _ := time.ParseDuration(config.HTTPTimeoutDuration)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if _, err := service.listContainers(ctx); err != nil {
+		logrus.Warnf("Failed to take the first snapshot of containers: %s", err)
+	}
+}
+
+// isWarmedUp returns true unless the background warm-up is still running
+func (service *MetadataService) isWarmedUp() bool {
+	if service.warmedUp == nil {
+		return true
+	}
+	select {
+	case <-service.warmedUp:
+		return true
+	default:
+		return false
+	}
+}
+
+// parseWarmContainers splits the comma separated list of container names and label key=value pairs
+func parseWarmContainers(value string) []string {
+	var selectors []string
+	for _, selector := range strings.Split(value, ",") {
+		if selector = strings.TrimSpace(selector); selector != "" {
+			selectors = append(selectors, selector)
+		}
+	}
+	return selectors
+}
+
+func matchesWarmContainers(container types.Container, selectors []string) bool {
+	for _, selector := range selectors {
+		if separator := strings.Index(selector, "="); separator > 0 {
+			if value, ok := container.Labels[selector[:separator]]; ok && value == selector[separator+1:] {
+				return true
+			}
+			continue
+		}
+		for _, name := range container.Names {
+			// Docker prefixes container names with a slash
+			if strings.TrimPrefix(name, "/") == selector {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -17,10 +17,16 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/clients/docker"
 	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/metadata"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/stats"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/utils"
 	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
 )
 
 // MetadataService vends docker metadata to containers
@@ -28,6 +34,26 @@ type MetadataService struct {
 	dockerClient          docker.Client
 	containerInstanceTags map[string]string
 	taskTags              map[string]string
+	// taskRevision overrides the task definition revision, if set
+	taskRevision string
+	// statsSampleInterval is ECS_LOCAL_STATS_SAMPLE_INTERVAL, which is validated when the service is created
+	statsSampleInterval time.Duration
+	// statsHistory is used to compute moving averages of the stats of each container
+	statsHistory stats.History
+	// inspectCache holds the inspect responses of the containers warmed at startup
+	inspectCache inspectCache
+	// sizeCache holds the sizes of the containers' filesystems, if ECS_LOCAL_INCLUDE_STORAGE_STATS is set
+	sizeCache sizeCache
+	// containerSnapshot holds the most recent list of containers, if ECS_LOCAL_METADATA_SNAPSHOT_TTL is set
+	containerSnapshot containerSnapshot
+	// stateSequence numbers the changes to the state of the containers, if ECS_LOCAL_INCLUDE_SEQUENCE is set
+	stateSequence stateSequence
+	// stopWatchingContainerEvents stops counting container events into the stateSequence, and waits for the watcher
+	// to exit, if ECS_LOCAL_INCLUDE_SEQUENCE is set
+	stopWatchingContainerEvents func()
+	// warmedUp is closed once the background warm-up finishes, if ECS_LOCAL_WARMUP_503 is set
+	// Until then, requests are rejected; a nil channel means there is no background warm-up
+	warmedUp chan struct{}
 }
 
 // NewMetadataService returns a struct that handles metadata requests
@@ -41,8 +67,37 @@ func NewMetadataService() (*MetadataService, error) {
 
 // NewMetadataServiceWithClient returns a struct that handles metadata requests using the given Docker Client
 func NewMetadataServiceWithClient(dockerClient docker.Client) (*MetadataService, error) {
-	metadata := &MetadataService{
-		dockerClient: dockerClient,
+	service := &MetadataService{
+		dockerClient:        dockerClient,
+		statsSampleInterval: getStatsSampleInterval(),
+	}
+
+	if utils.GetBoolValue(false, config.ValidateARNsVar) {
+		if err := metadata.ValidateARNs(); err != nil {
+			return nil, err
+		}
+	}
+
+	if stateFile := utils.GetValue("", config.AutoIncrementRevisionVar); stateFile != "" {
+		revision, err := metadata.IncrementRevision(stateFile)
+		if err != nil {
+			return nil, err
+		}
+		logrus.Infof("Using task definition revision %s", revision)
+		service.taskRevision = revision
+	}
+
+	if utils.GetBoolValue(false, config.IncludeSequenceVar) {
+		// the first state is number 1
+		service.stateSequence.increment()
+		service.stopWatchingContainerEvents = service.startWatchingContainerEvents()
+	}
+
+	if utils.GetBoolValue(false, config.Warmup503Var) {
+		service.warmedUp = make(chan struct{})
+		go service.warmUp()
+	} else {
+		service.warmContainers()
 	}
 
 	// TODO: re-enable tagging when supporting the new V2 and V3 metdata with Tags paths
@@ -51,7 +106,7 @@ func NewMetadataServiceWithClient(dockerClient docker.Client) (*MetadataService,
 	// 	if err != nil {
 	// 		return nil, err
 	// 	}
-	// 	metadata.containerInstanceTags = tags
+	// 	service.containerInstanceTags = tags
 	// }
 	//
 	// if taskTagVal := os.Getenv(config.TaskTagsVar); taskTagVal != "" {
@@ -59,10 +114,10 @@ func NewMetadataServiceWithClient(dockerClient docker.Client) (*MetadataService,
 	// 	if err != nil {
 	// 		return nil, err
 	// 	}
-	// 	metadata.taskTags = tags
+	// 	service.taskTags = tags
 	// }
 
-	return metadata, nil
+	return service, nil
 }
 
 // SetupV2Routes sets up the V2 Metadata routes
@@ -101,11 +156,34 @@ func (service *MetadataService) SetupV3Routes(router *mux.Router) {
 	router.HandleFunc(config.V3TaskStatsPathWithSlash, ServeHTTP(service.getMetadataHandler(requestTypeTaskStats)))
 	router.HandleFunc(config.V3TaskStatsPathWithIdentifier, ServeHTTP(service.getMetadataHandler(requestTypeTaskStats)))
 	router.HandleFunc(config.V3TaskStatsPathWithIdentifierAndSlash, ServeHTTP(service.getMetadataHandler(requestTypeTaskStats)))
+
+	// /task serves the same task metadata as /v3/task, if ECS_LOCAL_TASK_ALIAS is set
+	if utils.GetBoolValue(false, config.TaskAliasVar) {
+		router.HandleFunc(config.TaskAliasPath, ServeHTTP(service.getMetadataHandler(requestTypeTaskMetadata)))
+		router.HandleFunc(config.TaskAliasPathWithSlash, ServeHTTP(service.getMetadataHandler(requestTypeTaskMetadata)))
+	}
 }
 
 // getMetadataHandler returns a metadata handler given a requestType
 func (service *MetadataService) getMetadataHandler(requestType int) func(w http.ResponseWriter, r *http.Request) error {
 	return func(w http.ResponseWriter, r *http.Request) error {
+		// Metadata and stats are read only
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			return HTTPError{
+				Code: http.StatusMethodNotAllowed,
+				Err:  fmt.Errorf("Method %s is not allowed for %s", r.Method, r.URL.Path),
+			}
+		}
+
+		if !service.isWarmedUp() {
+			return HTTPError{
+				Code:       http.StatusServiceUnavailable,
+				Err:        fmt.Errorf("Local Endpoints is warming up; retry %s", r.URL.Path),
+				RetryAfter: warmUpRetryAfter,
+			}
+		}
+
 		callerIP, _, err := net.SplitHostPort(r.RemoteAddr)
 		if err != nil {
 			// Failed to get the callerIP
@@ -113,18 +191,22 @@ func (service *MetadataService) getMetadataHandler(requestType int) func(w http.
 		}
 		vars := mux.Vars(r)
 		identifier := vars["identifier"]
-		return service.handleRequest(requestType, w, identifier, callerIP)
+		var options statsOptions
+		// the per-core CPU usage can be large, so it is only included in stats when requested
+		options.includePerCPU, _ = strconv.ParseBool(r.URL.Query().Get(perCPUQueryParameter))
+		options.raw, _ = strconv.ParseBool(r.URL.Query().Get(rawQueryParameter))
+		return service.handleRequest(requestType, w, identifier, callerIP, options)
 	}
 }
 
-func (service *MetadataService) handleRequest(requestType int, w http.ResponseWriter, identifier string, callerIP string) error {
+func (service *MetadataService) handleRequest(requestType int, w http.ResponseWriter, identifier string, callerIP string, options statsOptions) error {
 	switch requestType {
 	case requestTypeTaskMetadata:
 		return service.taskMetadataResponse(w, identifier, callerIP)
 	case requestTypeTaskStats:
-		return service.taskStatsResponse(w, identifier, callerIP)
+		return service.taskStatsResponse(w, identifier, callerIP, options)
 	case requestTypeContainerStats:
-		return service.containerStatsResponse(w, identifier, callerIP)
+		return service.containerStatsResponse(w, identifier, callerIP, options)
 	case requestTypeContainerMetadata:
 		return service.containerMetadataResponse(w, identifier, callerIP)
 	}
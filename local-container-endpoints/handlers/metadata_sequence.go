@@ -0,0 +1,92 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/sirupsen/logrus"
+)
+
+// containerEventsRetryInterval is how long to wait before reopening the stream of container events after it fails
+const containerEventsRetryInterval = time.Second
+
+// stateSequence numbers the changes to the state of the containers, which are counted from Docker's container
+// events, so that consumers which poll metadata can tell when they have missed an update
+// The zero value is ready for use
+type stateSequence struct {
+	number uint64
+}
+
+// current returns the sequence number of the containers' current state
+func (sequence *stateSequence) current() uint64 {
+	return atomic.LoadUint64(&sequence.number)
+}
+
+func (sequence *stateSequence) increment() {
+	atomic.AddUint64(&sequence.number, 1)
+}
+
+// startWatchingContainerEvents watches container events in the background, and returns a function which stops
+// watching them and waits for the watcher to exit
+func (service *MetadataService) startWatchingContainerEvents() func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		service.watchContainerEvents(ctx)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// watchContainerEvents increments the sequence for each container which is created, started, stopped, or removed,
+// until the context is done
+// If the stream of events fails, it is reopened, and the sequence is incremented since events may have been missed.
+func (service *MetadataService) watchContainerEvents(ctx context.Context) {
+	for {
+		messages, errs := service.dockerClient.ContainerEvents(ctx)
+		err := service.stateSequence.count(ctx, messages, errs)
+		if ctx.Err() != nil {
+			return
+		}
+		logrus.Warnf("Failed to watch container events; retrying in %s: %s", containerEventsRetryInterval, err)
+		service.stateSequence.increment()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(containerEventsRetryInterval):
+		}
+	}
+}
+
+// count increments the sequence for each event, until the stream of events fails or the context is done
+func (sequence *stateSequence) count(ctx context.Context, messages <-chan events.Message, errs <-chan error) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-errs:
+			return err
+		case <-messages:
+			sequence.increment()
+		}
+	}
+}
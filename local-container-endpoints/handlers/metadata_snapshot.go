@@ -0,0 +1,124 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/utils"
+	"github.com/docker/docker/api/types"
+	"github.com/sirupsen/logrus"
+)
+
+// containerListFetcher lists the containers running on the host
+type containerListFetcher func(ctx context.Context) ([]types.Container, error)
+
+// containerSnapshot holds the most recent list of containers, if ECS_LOCAL_METADATA_SNAPSHOT_TTL is set
+// Each refresh builds a new snapshot and atomically swaps it in, so that readers never take a lock
+// Readers must not modify the containers in a snapshot, since it is shared by every request
+// The zero value is an empty snapshot ready for use
+type containerSnapshot struct {
+	current     atomic.Value
+	refreshLock sync.Mutex
+	refreshing  int32
+}
+
+// containerList is a single snapshot of the running containers
+type containerList struct {
+	containers []types.Container
+	takenAt    time.Time
+}
+
+// listContainers lists the running containers, from the snapshot if ECS_LOCAL_METADATA_SNAPSHOT_TTL is set
+func (service *MetadataService) listContainers(ctx context.Context) ([]types.Container, error) {
+	list, err := service.listContainersWithTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return list.containers, nil
+}
+
+// listContainersWithTime lists the running containers along with when they were listed, which is when the snapshot
+// was taken if ECS_LOCAL_METADATA_SNAPSHOT_TTL is set
+func (service *MetadataService) listContainersWithTime(ctx context.Context) (*containerList, error) {
+	ttl := utils.GetDurationValue(config.DefaultMetadataSnapshotTTL, config.MetadataSnapshotTTLVar)
+	if ttl <= 0 {
+		containers, err := service.dockerClient.ContainerList(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return &containerList{
+			containers: containers,
+			takenAt:    time.Now(),
+		}, nil
+	}
+	return service.containerSnapshot.listWithTime(ctx, ttl, service.dockerClient.ContainerList)
+}
+
+func (snapshot *containerSnapshot) list(ctx context.Context, ttl time.Duration, fetch containerListFetcher) ([]types.Container, error) {
+	list, err := snapshot.listWithTime(ctx, ttl, fetch)
+	if err != nil {
+		return nil, err
+	}
+	return list.containers, nil
+}
+
+func (snapshot *containerSnapshot) listWithTime(ctx context.Context, ttl time.Duration, fetch containerListFetcher) (*containerList, error) {
+	list := snapshot.load()
+	if list != nil && time.Since(list.takenAt) < ttl {
+		return list, nil
+	}
+
+	if list != nil {
+		// The snapshot is stale, but is still served to everyone except the single request which refreshes it
+		if !atomic.CompareAndSwapInt32(&snapshot.refreshing, 0, 1) {
+			return list, nil
+		}
+		defer atomic.StoreInt32(&snapshot.refreshing, 0)
+	}
+
+	snapshot.refreshLock.Lock()
+	defer snapshot.refreshLock.Unlock()
+
+	// another request may have taken the first snapshot while we waited
+	if list == nil {
+		if list = snapshot.load(); list != nil {
+			return list, nil
+		}
+	}
+
+	containers, err := fetch(ctx)
+	if err != nil {
+		if list != nil {
+			logrus.Warnf("Serving the snapshot of containers from %s; failed to refresh it: %s", list.takenAt.Format(time.RFC3339), err)
+			return list, nil
+		}
+		return nil, err
+	}
+	list = &containerList{
+		containers: containers,
+		takenAt:    time.Now(),
+	}
+	snapshot.current.Store(list)
+	return list, nil
+}
+
+func (snapshot *containerSnapshot) load() *containerList {
+	list, _ := snapshot.current.Load().(*containerList)
+	return list
+}
@@ -0,0 +1,146 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContainerSnapshotServesSnapshot(t *testing.T) {
+	var snapshot containerSnapshot
+	var fetches int32
+
+	fetch := func(ctx context.Context) ([]types.Container, error) {
+		atomic.AddInt32(&fetches, 1)
+		return []types.Container{types.Container{ID: "first"}}, nil
+	}
+
+	for i := 0; i < 3; i++ {
+		containers, err := snapshot.list(context.Background(), time.Hour, fetch)
+		assert.NoError(t, err, "Unexpected error listing containers")
+		assert.Equal(t, "first", containers[0].ID, "Expected the snapshot to be served")
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches), "Expected containers to be listed once")
+}
+
+func TestContainerSnapshotReadsDoNotWaitForRefresh(t *testing.T) {
+	var snapshot containerSnapshot
+	ttl := 10 * time.Millisecond
+
+	_, err := snapshot.list(context.Background(), ttl, func(ctx context.Context) ([]types.Container, error) {
+		return []types.Container{types.Container{ID: "old"}}, nil
+	})
+	assert.NoError(t, err, "Unexpected error taking the first snapshot")
+	time.Sleep(2 * ttl)
+
+	// the refresh blocks until every other read has been served
+	refreshStarted := make(chan struct{})
+	release := make(chan struct{})
+	var fetches int32
+	fetch := func(ctx context.Context) ([]types.Container, error) {
+		atomic.AddInt32(&fetches, 1)
+		close(refreshStarted)
+		<-release
+		return []types.Container{types.Container{ID: "new"}}, nil
+	}
+
+	refreshed := make(chan []types.Container, 1)
+	go func() {
+		containers, _ := snapshot.list(context.Background(), ttl, fetch)
+		refreshed <- containers
+	}()
+	<-refreshStarted
+
+	var wg sync.WaitGroup
+	reads := make(chan []types.Container, 50)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			containers, err := snapshot.list(context.Background(), ttl, fetch)
+			assert.NoError(t, err, "Unexpected error listing containers")
+			reads <- containers
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected reads to be served while the snapshot is refreshed")
+	}
+	close(reads)
+	for containers := range reads {
+		assert.Equal(t, "old", containers[0].ID, "Expected the previous snapshot to be served during the refresh")
+	}
+
+	close(release)
+	assert.Equal(t, "new", (<-refreshed)[0].ID, "Expected the refreshing request to be served the new snapshot")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches), "Expected a single request to refresh the snapshot")
+
+	containers, err := snapshot.list(context.Background(), time.Hour, fetch)
+	assert.NoError(t, err, "Unexpected error listing containers")
+	assert.Equal(t, "new", containers[0].ID, "Expected the refreshed snapshot to be swapped in")
+}
+
+func TestContainerSnapshotRefreshErrorServesSnapshot(t *testing.T) {
+	var snapshot containerSnapshot
+
+	_, err := snapshot.list(context.Background(), time.Nanosecond, func(ctx context.Context) ([]types.Container, error) {
+		return []types.Container{types.Container{ID: "old"}}, nil
+	})
+	assert.NoError(t, err, "Unexpected error taking the first snapshot")
+
+	containers, err := snapshot.list(context.Background(), time.Nanosecond, func(ctx context.Context) ([]types.Container, error) {
+		return nil, fmt.Errorf("Some API Error")
+	})
+	assert.NoError(t, err, "Expected the previous snapshot to be served when the refresh fails")
+	assert.Equal(t, "old", containers[0].ID, "Expected the previous snapshot to be served")
+}
+
+func TestContainerSnapshotFirstListError(t *testing.T) {
+	var snapshot containerSnapshot
+
+	_, err := snapshot.list(context.Background(), time.Hour, func(ctx context.Context) ([]types.Container, error) {
+		return nil, fmt.Errorf("Some API Error")
+	})
+	assert.Error(t, err, "Expected an error when there is no snapshot to serve")
+}
+
+func BenchmarkContainerSnapshotList(b *testing.B) {
+	var snapshot containerSnapshot
+	fetch := func(ctx context.Context) ([]types.Container, error) {
+		return []types.Container{types.Container{ID: "container"}}, nil
+	}
+	snapshot.list(context.Background(), time.Hour, fetch)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			snapshot.list(context.Background(), time.Hour, fetch)
+		}
+	})
+}
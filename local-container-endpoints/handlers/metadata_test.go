@@ -14,10 +14,25 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"testing"
+	"time"
 
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/clients/docker"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/clients/docker/mock_docker"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/metadata"
 	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/testingutils"
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -40,6 +55,7 @@ const (
 	network2         = "app-network"
 	projectName      = "project"
 	projectName2     = "operation-clyde-undercover"
+	taskARN1         = "arn:aws:ecs:us-west-2:111111111111:task/ecs-local-cluster/a1ce6a35-0a1b-4c5e-9d3e-5d3b1c1d2d5a"
 )
 
 func TestFindContainerWithIdentifierID(t *testing.T) {
@@ -346,6 +362,124 @@ func TestGetTaskContainersOneContainerReturned(t *testing.T) {
 
 }
 
+func TestGetTaskContainersWithTaskMap(t *testing.T) {
+	endpointsContainer := testingutils.BaseDockerContainer("endpoints", endpointsLongID).WithNetwork(network1, ipAddress).Get()
+	container1 := testingutils.BaseDockerContainer(containerName1, longID1).WithNetwork(network1, ipAddress1).Get()
+	container2 := testingutils.BaseDockerContainer(containerName2, longID2).WithNetwork(network1, ipAddress2).Get()
+	container3 := testingutils.BaseDockerContainer(containerName3, longID3).WithNetwork(network1, ipAddress3).Get()
+
+	containers := []types.Container{
+		container3,
+		container1,
+		container2,
+		endpointsContainer,
+	}
+
+	os.Setenv(config.ContainerTaskMapVar, fmt.Sprintf("%s=%s,%s=%s", containerName1, taskARN1, containerName2, taskARN1))
+	defer os.Unsetenv(config.ContainerTaskMapVar)
+
+	var testCases = []struct {
+		name     string
+		callerIP string
+		expected []types.Container
+	}{
+		{
+			name:     "mapped container",
+			callerIP: ipAddress2,
+			expected: []types.Container{container1, container2},
+		},
+		{
+			name:     "unmapped container",
+			callerIP: ipAddress3,
+			expected: []types.Container{container3, endpointsContainer},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			result := getTaskContainers(containers, "", testCase.callerIP)
+			assert.ElementsMatch(t, testCase.expected, result, "Expected containers returned by getTaskContainers to be from the correct task")
+		})
+	}
+}
+
+func TestGetTaskContainersWithTaskRevisionLabels(t *testing.T) {
+	endpointsContainer := testingutils.BaseDockerContainer("endpoints", endpointsLongID).WithNetwork(network1, ipAddress).WithComposeProject(projectName).Get()
+	container1 := testingutils.BaseDockerContainer(containerName1, longID1).WithNetwork(network1, ipAddress1).WithComposeProject(projectName).Get()
+	container2 := testingutils.BaseDockerContainer(containerName2, longID2).WithNetwork(network1, ipAddress2).WithComposeProject(projectName).Get()
+	container3 := testingutils.BaseDockerContainer(containerName3, longID3).WithNetwork(network1, ipAddress3).WithComposeProject(projectName).Get()
+	container1.Labels["com.aws.ecs.local.task-revision"] = "3"
+	container2.Labels["com.aws.ecs.local.task-revision"] = "3"
+	container3.Labels["com.aws.ecs.local.task-revision"] = "4"
+
+	containers := []types.Container{
+		container3,
+		container1,
+		container2,
+		endpointsContainer,
+	}
+
+	var testCases = []struct {
+		name     string
+		callerIP string
+		expected []types.Container
+	}{
+		{
+			name:     "revision 3",
+			callerIP: ipAddress2,
+			expected: []types.Container{container1, container2},
+		},
+		{
+			name:     "revision 4",
+			callerIP: ipAddress3,
+			expected: []types.Container{container3},
+		},
+		{
+			name:     "unlabeled container",
+			callerIP: ipAddress,
+			expected: []types.Container{endpointsContainer},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			result := getTaskContainers(containers, "", testCase.callerIP)
+			assert.ElementsMatch(t, testCase.expected, result, "Expected containers returned by getTaskContainers to have the same revision")
+		})
+	}
+}
+
+func TestTaskMetadataResponseWithTaskRevisionLabels(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	dockerMock := mock_docker.NewMockClient(ctrl)
+	service := &MetadataService{
+		dockerClient: dockerMock,
+		taskRevision: "7",
+	}
+
+	container1 := testingutils.BaseDockerContainer(containerName1, longID1).WithNetwork(network1, ipAddress1).WithComposeProject(projectName).Get()
+	container2 := testingutils.BaseDockerContainer(containerName2, longID2).WithNetwork(network1, ipAddress2).WithComposeProject(projectName).Get()
+	container1.Labels["com.aws.ecs.local.task-revision"] = "3"
+	container2.Labels["com.aws.ecs.local.task-revision"] = "4"
+
+	dockerMock.EXPECT().ContainerList(gomock.Any()).Return([]types.Container{container1, container2}, nil)
+	dockerMock.EXPECT().ContainerInspect(gomock.Any(), longID2).Return(testingutils.BaseDockerInspect(containerName2, longID2).Get(), nil)
+
+	recorder := httptest.NewRecorder()
+	err := service.taskMetadataResponse(recorder, "", ipAddress2)
+	assert.NoError(t, err, "Unexpected error getting task metadata")
+
+	var response metadata.TaskResponse
+	err = json.Unmarshal(recorder.Body.Bytes(), &response)
+	assert.NoError(t, err, "Unexpected error unmarshalling response")
+	assert.Equal(t, "4", response.Revision, "Expected the labeled revision to take precedence")
+	if assert.Len(t, response.Containers, 1, "Expected only the containers with the caller's revision") {
+		assert.Equal(t, longID2, response.Containers[0].ID, "Expected container ID to match")
+		assert.Equal(t, "4", response.Containers[0].TaskRevision, "Expected the container's revision")
+	}
+}
+
 // TODO: re-enable test once metadata with Tags field is added
 // func TestNewMetadataServiceWithTags(t *testing.T) {
 // 	os.Setenv(config.ContainerInstanceTagsVar, "mitchell=webb,thats=numberwang")
@@ -367,3 +501,596 @@ func TestGetTaskContainersOneContainerReturned(t *testing.T) {
 // 	assert.Equal(t, expectedCITags, service.containerInstanceTags, "Expected container instance tags to match")
 // 	assert.Equal(t, expectedTaskTags, service.taskTags, "Expected task tags to match")
 // }
+
+func TestSampleContainerStatsHonorsInterval(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	dockerMock := mock_docker.NewMockClient(ctrl)
+	service := &MetadataService{
+		dockerClient:        dockerMock,
+		statsSampleInterval: 200 * time.Millisecond,
+	}
+
+	first := &types.Stats{
+		Read: time.Now(),
+	}
+	first.CPUStats.CPUUsage.TotalUsage = 100
+	second := &types.Stats{
+		Read: first.Read.Add(200 * time.Millisecond),
+	}
+	second.CPUStats.CPUUsage.TotalUsage = 200
+	second.PreCPUStats.CPUUsage.TotalUsage = 190
+
+	var sampledAt []time.Time
+	recordSample := func(ctx context.Context, containerID string) {
+		sampledAt = append(sampledAt, time.Now())
+	}
+	gomock.InOrder(
+		dockerMock.EXPECT().ContainerStats(gomock.Any(), longID1).Do(recordSample).Return(first, nil),
+		dockerMock.EXPECT().ContainerStats(gomock.Any(), longID1).Do(recordSample).Return(second, nil),
+	)
+
+	stats, err := service.sampleContainerStats(context.Background(), longID1)
+	assert.NoError(t, err, "Unexpected error sampling stats")
+	if assert.Len(t, sampledAt, 2, "Expected two samples") {
+		assert.True(t, sampledAt[1].Sub(sampledAt[0]) >= 200*time.Millisecond, "Expected samples to be spaced by the sampling interval")
+	}
+	assert.Equal(t, uint64(200), stats.CPUStats.CPUUsage.TotalUsage, "Expected stats from the second sample")
+	assert.Equal(t, uint64(100), stats.PreCPUStats.CPUUsage.TotalUsage, "Expected pre stats from the first sample")
+	assert.Equal(t, second.Read, stats.Read, "Expected the read timestamp from the second sample")
+	assert.Equal(t, first.Read, stats.PreRead, "Expected the preread timestamp from the first sample")
+}
+
+func TestContainerStatsResponseRaw(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	dockerMock := mock_docker.NewMockClient(ctrl)
+	// the second sample is skipped even if a sampling interval is set
+	service := &MetadataService{
+		dockerClient:        dockerMock,
+		statsSampleInterval: 200 * time.Millisecond,
+	}
+
+	container1 := testingutils.BaseDockerContainer("caller", longID1).Get()
+	frame := &types.Stats{
+		Read: time.Now(),
+	}
+	frame.CPUStats.CPUUsage.TotalUsage = 100
+	frame.CPUStats.CPUUsage.PercpuUsage = []uint64{60, 40}
+	frame.MemoryStats.Usage = 1024
+	frame.MemoryStats.Limit = 2048
+
+	dockerMock.EXPECT().ContainerList(gomock.Any()).Return([]types.Container{container1}, nil)
+	dockerMock.EXPECT().ContainerStats(gomock.Any(), longID1).Return(frame, nil).Times(1)
+
+	recorder := httptest.NewRecorder()
+	err := service.containerStatsResponse(recorder, longID1, "", statsOptions{raw: true})
+	assert.NoError(t, err, "Unexpected error getting raw stats")
+
+	expected, err := json.Marshal(frame)
+	assert.NoError(t, err, "Unexpected error marshalling stats")
+	assert.JSONEq(t, string(expected), recorder.Body.String(), "Expected the stats frame from Docker as is")
+}
+
+func TestContainerStatsResponseNoValidStats(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	dockerMock := mock_docker.NewMockClient(ctrl)
+	service := &MetadataService{
+		dockerClient: dockerMock,
+	}
+
+	container1 := testingutils.BaseDockerContainer("caller", longID1).Get()
+	dockerMock.EXPECT().ContainerList(gomock.Any()).Return([]types.Container{container1}, nil)
+	dockerMock.EXPECT().ContainerStats(gomock.Any(), longID1).Return(nil, errors.Wrap(docker.ErrNoValidStats, "failed to get docker stats"))
+
+	err := service.containerStatsResponse(httptest.NewRecorder(), longID1, "", statsOptions{})
+	if assert.IsType(t, HTTPError{}, err, "Expected an HTTP error") {
+		assert.Equal(t, http.StatusBadGateway, err.(HTTPError).Code, "Expected a bad gateway error when Docker returns no valid stats")
+	}
+}
+
+func TestGetStatsSampleInterval(t *testing.T) {
+	assert.Equal(t, time.Duration(0), getStatsSampleInterval(), "Expected no sampling interval by default")
+
+	os.Setenv(config.StatsSampleIntervalVar, "1h")
+	defer os.Unsetenv(config.StatsSampleIntervalVar)
+	maxInterval, _ := time.ParseDuration(config.MaxStatsSampleInterval)
+	assert.Equal(t, maxInterval, getStatsSampleInterval(), "Expected sampling interval to be limited")
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	service, err := NewMetadataServiceWithClient(mock_docker.NewMockClient(ctrl))
+	assert.NoError(t, err, "Unexpected error creating new metadata service")
+	assert.Equal(t, maxInterval, service.statsSampleInterval, "Expected the sampling interval to be validated when the service is created")
+}
+
+func TestTaskMetadataResponseSoftDeadline(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	dockerMock := mock_docker.NewMockClient(ctrl)
+	service := &MetadataService{
+		dockerClient: dockerMock,
+	}
+
+	slowContainer := testingutils.BaseDockerContainer("slow", longID1).WithNetwork(network1, ipAddress1).Get()
+	fastContainers := []types.Container{
+		testingutils.BaseDockerContainer(containerName2, longID2).WithNetwork(network1, ipAddress2).Get(),
+		testingutils.BaseDockerContainer(containerName3, longID3).WithNetwork(network1, ipAddress3).Get(),
+		testingutils.BaseDockerContainer("endpoints", endpointsLongID).WithNetwork(network1, ipAddress).Get(),
+	}
+	containers := append([]types.Container{slowContainer}, fastContainers...)
+
+	slowInspect := make(chan struct{})
+	defer close(slowInspect)
+	dockerMock.EXPECT().ContainerList(gomock.Any()).Return(containers, nil)
+	dockerMock.EXPECT().ContainerInspect(gomock.Any(), longID1).Do(func(ctx context.Context, containerID string) {
+		<-slowInspect
+	}).Return(&types.ContainerJSON{}, nil).AnyTimes()
+	for _, container := range fastContainers {
+		dockerMock.EXPECT().ContainerInspect(gomock.Any(), container.ID).Return(&types.ContainerJSON{}, nil)
+	}
+
+	os.Setenv(config.MetadataSoftDeadlineVar, "100ms")
+	defer os.Unsetenv(config.MetadataSoftDeadlineVar)
+
+	recorder := httptest.NewRecorder()
+	err := service.taskMetadataResponse(recorder, "", "")
+	assert.NoError(t, err, "Unexpected error getting task metadata")
+
+	var response metadata.TaskResponse
+	err = json.Unmarshal(recorder.Body.Bytes(), &response)
+	assert.NoError(t, err, "Unexpected error unmarshalling response")
+	assert.True(t, response.Partial, "Expected the response to be flagged as partial")
+	if assert.Len(t, response.Containers, len(fastContainers), "Expected only the containers which were inspected in time") {
+		for i, container := range fastContainers {
+			assert.Equal(t, container.ID, response.Containers[i].ID, "Expected container ID to match")
+		}
+	}
+}
+
+func TestNewMetadataServiceWarmsContainers(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	dockerMock := mock_docker.NewMockClient(ctrl)
+
+	namedContainer := testingutils.BaseDockerContainer("app", longID1).Get()
+	labeledContainer := testingutils.BaseDockerContainer(containerName2, longID2).WithComposeProject("warm").Get()
+	otherContainer := testingutils.BaseDockerContainer(containerName3, longID3).Get()
+	namedInspect := testingutils.BaseDockerInspect("app", longID1).Get()
+	labeledInspect := testingutils.BaseDockerInspect(containerName2, longID2).Get()
+
+	// only the warmed containers are inspected, and only once, at startup
+	dockerMock.EXPECT().ContainerList(gomock.Any()).Return([]types.Container{namedContainer, labeledContainer, otherContainer}, nil)
+	dockerMock.EXPECT().ContainerInspect(gomock.Any(), longID1).Return(namedInspect, nil)
+	dockerMock.EXPECT().ContainerInspect(gomock.Any(), longID2).Return(labeledInspect, nil)
+
+	os.Setenv(config.WarmContainersVar, "app, com.docker.compose.project=warm")
+	defer os.Unsetenv(config.WarmContainersVar)
+
+	service, err := NewMetadataServiceWithClient(dockerMock)
+	assert.NoError(t, err, "Unexpected error creating the metadata service")
+	assert.Len(t, service.inspectCache.inspects, 2, "Expected the warmed containers to be cached before any request")
+	assert.Equal(t, namedInspect, service.inspectCache.inspects[longID1], "Expected the container selected by name to be cached")
+	assert.Equal(t, labeledInspect, service.inspectCache.inspects[longID2], "Expected the container selected by label to be cached")
+
+	assert.Equal(t, namedInspect, service.inspectContainer(context.Background(), longID1), "Expected the cached inspect response to be served")
+	assert.Nil(t, service.inspectCache.take(longID1), "Expected the cached inspect response to only be served once")
+}
+
+func TestNewMetadataServiceWarmUp503(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	dockerMock := mock_docker.NewMockClient(ctrl)
+
+	container1 := testingutils.BaseDockerContainer("caller", longID1).WithNetwork(network1, ipAddress1).Get()
+	inspect1 := testingutils.BaseDockerInspect("caller", longID1).Get()
+
+	// the first snapshot is only taken once the test releases it, and serves the request after the warm-up
+	release := make(chan struct{})
+	dockerMock.EXPECT().ContainerList(gomock.Any()).Do(func(ctx context.Context) {
+		<-release
+	}).Return([]types.Container{container1}, nil)
+	dockerMock.EXPECT().ContainerInspect(gomock.Any(), longID1).Return(inspect1, nil)
+
+	os.Setenv(config.Warmup503Var, "true")
+	defer os.Unsetenv(config.Warmup503Var)
+	os.Setenv(config.MetadataSnapshotTTLVar, "1m")
+	defer os.Unsetenv(config.MetadataSnapshotTTLVar)
+
+	service, err := NewMetadataServiceWithClient(dockerMock)
+	assert.NoError(t, err, "Unexpected error creating the metadata service")
+	router := mux.NewRouter()
+	service.SetupV3Routes(router)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", "/v3/containers/caller", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code, "Expected a 503 before the warm-up completes")
+	assert.Equal(t, "1", recorder.Header().Get("Retry-After"), "Expected the Retry-After header")
+
+	close(release)
+	select {
+	case <-service.warmedUp:
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the warm-up to complete")
+	}
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", "/v3/containers/caller", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected a 200 after the warm-up completes")
+}
+
+func TestContainerStatsResponsePerCPUUsage(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	dockerMock := mock_docker.NewMockClient(ctrl)
+	service := &MetadataService{
+		dockerClient: dockerMock,
+	}
+
+	container1 := testingutils.BaseDockerContainer("caller", longID1).Get()
+	dockerStats := func() *types.Stats {
+		containerStats := &types.Stats{}
+		containerStats.CPUStats.CPUUsage.TotalUsage = 300
+		containerStats.CPUStats.CPUUsage.PercpuUsage = []uint64{100, 200}
+		containerStats.PreCPUStats.CPUUsage.PercpuUsage = []uint64{50, 100}
+		return containerStats
+	}
+	dockerMock.EXPECT().ContainerList(gomock.Any()).Return([]types.Container{container1}, nil).Times(2)
+	dockerMock.EXPECT().ContainerStats(gomock.Any(), longID1).Return(dockerStats(), nil)
+	dockerMock.EXPECT().ContainerStats(gomock.Any(), longID1).Return(dockerStats(), nil)
+	dockerMock.EXPECT().ContainerInspect(gomock.Any(), longID1).Return(&types.ContainerJSON{}, nil).Times(2)
+
+	router := mux.NewRouter()
+	service.SetupV3Routes(router)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", "/v3/containers/caller/stats", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code to match")
+	assert.NotContains(t, recorder.Body.String(), "percpu_usage", "Expected no per-core CPU usage by default")
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", "/v3/containers/caller/stats?percpu=true", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code to match")
+	var response types.Stats
+	err := json.Unmarshal(recorder.Body.Bytes(), &response)
+	assert.NoError(t, err, "Unexpected error unmarshalling response")
+	assert.Equal(t, []uint64{100, 200}, response.CPUStats.CPUUsage.PercpuUsage, "Expected the per-core CPU usage when requested")
+	assert.Equal(t, []uint64{50, 100}, response.PreCPUStats.CPUUsage.PercpuUsage, "Expected the previous per-core CPU usage when requested")
+}
+
+// gpuStatsClientInTest passes through GPU stats, in addition to the mocked Docker stats
+type gpuStatsClientInTest struct {
+	*mock_docker.MockClient
+	gpuStats json.RawMessage
+}
+
+func (client *gpuStatsClientInTest) ContainerStatsFrame(ctx context.Context, longContainerID string) (*docker.StatsFrame, error) {
+	containerStats, err := client.ContainerStats(ctx, longContainerID)
+	if err != nil {
+		return nil, err
+	}
+	return &docker.StatsFrame{
+		Stats:    *containerStats,
+		GPUStats: client.gpuStats,
+	}, nil
+}
+
+func TestContainerStatsResponseGPUStats(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	dockerMock := mock_docker.NewMockClient(ctrl)
+	gpuStats := `[{"id":"0","utilization_gpu":42,"memory_used":1024}]`
+	service := &MetadataService{
+		dockerClient: &gpuStatsClientInTest{
+			MockClient: dockerMock,
+			gpuStats:   json.RawMessage(gpuStats),
+		},
+	}
+
+	container1 := testingutils.BaseDockerContainer("caller", longID1).Get()
+	dockerMock.EXPECT().ContainerList(gomock.Any()).Return([]types.Container{container1}, nil).Times(2)
+	dockerMock.EXPECT().ContainerStats(gomock.Any(), longID1).Return(&types.Stats{}, nil).Times(2)
+	dockerMock.EXPECT().ContainerInspect(gomock.Any(), longID1).Return(&types.ContainerJSON{}, nil).Times(2)
+
+	recorder := httptest.NewRecorder()
+	err := service.containerStatsResponse(recorder, longID1, "", statsOptions{})
+	assert.NoError(t, err, "Unexpected error getting stats")
+	assert.NotContains(t, recorder.Body.String(), "gpu_stats", "Expected no GPU stats by default")
+
+	os.Setenv(config.IncludeGPUStatsVar, "true")
+	defer os.Unsetenv(config.IncludeGPUStatsVar)
+
+	recorder = httptest.NewRecorder()
+	err = service.containerStatsResponse(recorder, longID1, "", statsOptions{})
+	assert.NoError(t, err, "Unexpected error getting stats")
+	var response map[string]json.RawMessage
+	err = json.Unmarshal(recorder.Body.Bytes(), &response)
+	assert.NoError(t, err, "Unexpected error unmarshalling response")
+	assert.JSONEq(t, gpuStats, string(response["gpu_stats"]), "Expected the GPU stats to be passed through")
+}
+
+func TestContainerStatsResponseStorageStats(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	dockerMock := mock_docker.NewMockClient(ctrl)
+	service := &MetadataService{
+		dockerClient: dockerMock,
+	}
+
+	container1 := testingutils.BaseDockerContainer("caller", longID1).Get()
+	sizeInspect := testingutils.BaseDockerInspect("caller", longID1).Get()
+	sizeRw := int64(42 * 1024 * 1024)
+	sizeRootFs := int64(180 * 1024 * 1024)
+	sizeInspect.SizeRw = &sizeRw
+	sizeInspect.SizeRootFs = &sizeRootFs
+
+	dockerMock.EXPECT().ContainerList(gomock.Any()).Return([]types.Container{container1}, nil).Times(4)
+	dockerMock.EXPECT().ContainerStats(gomock.Any(), longID1).Return(&types.Stats{}, nil).Times(4)
+	dockerMock.EXPECT().ContainerInspect(gomock.Any(), longID1).Return(&types.ContainerJSON{}, nil).Times(4)
+	// the size is inspected once for the requests within the TTL, and again once it has passed
+	dockerMock.EXPECT().ContainerInspectWithSize(gomock.Any(), longID1).Return(sizeInspect, nil).Times(2)
+
+	getStats := func() map[string]interface{} {
+		recorder := httptest.NewRecorder()
+		err := service.containerStatsResponse(recorder, longID1, "", statsOptions{})
+		assert.NoError(t, err, "Unexpected error getting stats")
+		var response map[string]interface{}
+		err = json.Unmarshal(recorder.Body.Bytes(), &response)
+		assert.NoError(t, err, "Unexpected error unmarshalling response")
+		return response
+	}
+
+	response := getStats()
+	assert.NotContains(t, response, "size_rw", "Expected no storage stats by default")
+	assert.NotContains(t, response, "size_root_fs", "Expected no storage stats by default")
+
+	os.Setenv(config.IncludeStorageStatsVar, "true")
+	defer os.Unsetenv(config.IncludeStorageStatsVar)
+
+	for i := 0; i < 2; i++ {
+		response = getStats()
+		assert.Equal(t, float64(sizeRw), response["size_rw"], "Expected the size of the writable layer")
+		assert.Equal(t, float64(sizeRootFs), response["size_root_fs"], "Expected the size of the root filesystem")
+	}
+
+	os.Setenv(config.StorageStatsTTLVar, "1ns")
+	defer os.Unsetenv(config.StorageStatsTTLVar)
+	response = getStats()
+	assert.Equal(t, float64(sizeRw), response["size_rw"], "Expected the size of the writable layer")
+}
+
+func TestTaskMetadataResponseImageRepoDigest(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	dockerMock := mock_docker.NewMockClient(ctrl)
+	service := &MetadataService{
+		dockerClient: dockerMock,
+	}
+
+	// both containers run the same image
+	container1 := testingutils.BaseDockerContainer(containerName1, longID1).WithNetwork(network1, ipAddress1).Get()
+	container2 := testingutils.BaseDockerContainer(containerName2, longID2).WithNetwork(network1, ipAddress2).Get()
+	repoDigest := "nginx@sha256:0fd68ec4b64b8dbb2bef1f1a5de9d47b658afd3635dc9c45bf0cbeac46e72101"
+
+	dockerMock.EXPECT().ContainerList(gomock.Any()).Return([]types.Container{container1, container2}, nil).Times(2)
+	dockerMock.EXPECT().ContainerInspect(gomock.Any(), gomock.Any()).Return(&types.ContainerJSON{}, nil).AnyTimes()
+	dockerMock.EXPECT().ImageInspect(gomock.Any(), container1.ImageID).Return(&types.ImageInspect{
+		RepoDigests: []string{repoDigest},
+	}, nil)
+
+	getRepoDigests := func() []string {
+		recorder := httptest.NewRecorder()
+		err := service.taskMetadataResponse(recorder, "", "")
+		assert.NoError(t, err, "Unexpected error getting task metadata")
+		var response metadata.TaskResponse
+		err = json.Unmarshal(recorder.Body.Bytes(), &response)
+		assert.NoError(t, err, "Unexpected error unmarshalling response")
+		var repoDigests []string
+		for _, container := range response.Containers {
+			repoDigests = append(repoDigests, container.ImageRepoDigest)
+		}
+		return repoDigests
+	}
+
+	assert.Equal(t, []string{"", ""}, getRepoDigests(), "Expected no repo digests by default")
+
+	os.Setenv(config.IncludeImageRepoDigestVar, "true")
+	defer os.Unsetenv(config.IncludeImageRepoDigestVar)
+
+	assert.Equal(t, []string{repoDigest, repoDigest}, getRepoDigests(), "Expected the image's repo digest for each container")
+}
+
+func TestContainerMetadataResponseCgroupnsMode(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	dockerMock := mock_docker.NewMockClient(ctrl)
+	service := &MetadataService{
+		dockerClient: dockerMock,
+	}
+
+	container := testingutils.BaseDockerContainer(containerName1, longID1).WithNetwork(network1, ipAddress1).Get()
+
+	dockerMock.EXPECT().ContainerList(gomock.Any()).Return([]types.Container{container}, nil).Times(2)
+	dockerMock.EXPECT().ContainerInspect(gomock.Any(), longID1).Return(&types.ContainerJSON{}, nil).Times(2)
+	dockerMock.EXPECT().ContainerCgroupnsMode(gomock.Any(), longID1).Return("private", nil)
+
+	getCgroupnsMode := func() string {
+		recorder := httptest.NewRecorder()
+		err := service.containerMetadataResponse(recorder, "", ipAddress1)
+		assert.NoError(t, err, "Unexpected error getting container metadata")
+		var response metadata.ContainerResponse
+		err = json.Unmarshal(recorder.Body.Bytes(), &response)
+		assert.NoError(t, err, "Unexpected error unmarshalling response")
+		return response.CgroupnsMode
+	}
+
+	assert.Equal(t, "", getCgroupnsMode(), "Expected no cgroup namespace mode by default")
+
+	os.Setenv(config.IncludeCgroupnsModeVar, "true")
+	defer os.Unsetenv(config.IncludeCgroupnsModeVar)
+
+	assert.Equal(t, "private", getCgroupnsMode(), "Expected the container's cgroup namespace mode")
+}
+
+func TestContainerMetadataResponseRelativeTimes(t *testing.T) {
+	os.Setenv(config.IncludeRelativeTimesVar, "true")
+	defer os.Unsetenv(config.IncludeRelativeTimesVar)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	dockerMock := mock_docker.NewMockClient(ctrl)
+	service := &MetadataService{
+		dockerClient: dockerMock,
+	}
+
+	container := testingutils.BaseDockerContainer(containerName1, longID1).WithNetwork(network1, ipAddress1).Get()
+	startedAt := time.Now().Add(-10 * time.Minute)
+	inspect := &types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			State: &types.ContainerState{
+				Status:    "running",
+				StartedAt: startedAt.Format(time.RFC3339Nano),
+			},
+		},
+	}
+
+	dockerMock.EXPECT().ContainerList(gomock.Any()).Return([]types.Container{container}, nil)
+	dockerMock.EXPECT().ContainerInspect(gomock.Any(), longID1).Return(inspect, nil)
+
+	recorder := httptest.NewRecorder()
+	err := service.containerMetadataResponse(recorder, "", ipAddress1)
+	assert.NoError(t, err, "Unexpected error getting container metadata")
+	var response map[string]interface{}
+	err = json.Unmarshal(recorder.Body.Bytes(), &response)
+	assert.NoError(t, err, "Unexpected error unmarshalling response")
+
+	assert.Contains(t, response, "StartedAt", "Expected the absolute timestamp to be kept")
+	startedAtAgo, _ := response["StartedAtAgo"].(float64)
+	assert.InDelta(t, 600, startedAtAgo, 5, "Expected the container to have started about 10 minutes ago")
+	createdAtAgo, _ := response["CreatedAtAgo"].(float64)
+	assert.InDelta(t, time.Since(time.Unix(container.Created, 0)).Seconds(), createdAtAgo, 5, "Expected the age of the container")
+}
+
+func TestTaskMetadataResponseSequence(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	dockerMock := mock_docker.NewMockClient(ctrl)
+
+	container1 := testingutils.BaseDockerContainer(containerName1, longID1).WithNetwork(network1, ipAddress1).Get()
+	dockerMock.EXPECT().ContainerList(gomock.Any()).Return([]types.Container{container1}, nil).AnyTimes()
+	dockerMock.EXPECT().ContainerInspect(gomock.Any(), gomock.Any()).Return(&types.ContainerJSON{}, nil).AnyTimes()
+
+	getSequence := func(service *MetadataService) uint64 {
+		recorder := httptest.NewRecorder()
+		err := service.taskMetadataResponse(recorder, "", "")
+		assert.NoError(t, err, "Unexpected error getting task metadata")
+		var response metadata.TaskResponse
+		err = json.Unmarshal(recorder.Body.Bytes(), &response)
+		assert.NoError(t, err, "Unexpected error unmarshalling response")
+		return response.Sequence
+	}
+
+	service, err := NewMetadataServiceWithClient(dockerMock)
+	assert.NoError(t, err, "Unexpected error creating new metadata service")
+	assert.Zero(t, getSequence(service), "Expected no sequence number by default")
+
+	os.Setenv(config.IncludeSequenceVar, "true")
+	defer os.Unsetenv(config.IncludeSequenceVar)
+
+	messages := make(chan events.Message)
+	errs := make(chan error)
+	watching := make(chan struct{})
+	dockerMock.EXPECT().ContainerEvents(gomock.Any()).Do(func(ctx context.Context) {
+		close(watching)
+	}).Return((<-chan events.Message)(messages), (<-chan error)(errs))
+
+	service, err = NewMetadataServiceWithClient(dockerMock)
+	assert.NoError(t, err, "Unexpected error creating new metadata service")
+	<-watching
+	assert.Equal(t, uint64(1), getSequence(service), "Expected the first state to be number 1")
+	assert.Equal(t, uint64(1), getSequence(service), "Expected the sequence number to be unchanged without an event")
+
+	// simulate the container being stopped and started again between two requests, which ends in the same state
+	messages <- events.Message{Type: events.ContainerEventType, Action: "die", Actor: events.Actor{ID: longID1}}
+	messages <- events.Message{Type: events.ContainerEventType, Action: "start", Actor: events.Actor{ID: longID1}}
+	messages <- events.Message{Type: events.ContainerEventType, Action: "create", Actor: events.Actor{ID: longID2}}
+	// once the watcher exits, every event it received has been counted
+	service.stopWatchingContainerEvents()
+	assert.Equal(t, uint64(4), getSequence(service), "Expected the sequence number to count each container event")
+}
+
+func TestWatchContainerEvents(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	dockerMock := mock_docker.NewMockClient(ctrl)
+	service := &MetadataService{
+		dockerClient: dockerMock,
+	}
+
+	messages := make(chan events.Message)
+	dockerMock.EXPECT().ContainerEvents(gomock.Any()).Return((<-chan events.Message)(messages), (<-chan error)(make(chan error)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		service.watchContainerEvents(ctx)
+		close(done)
+	}()
+
+	for _, action := range []string{"create", "start", "die", "start", "die", "destroy"} {
+		messages <- events.Message{Type: events.ContainerEventType, Action: action, Actor: events.Actor{ID: longID1}}
+	}
+	cancel()
+	<-done
+	assert.Equal(t, uint64(6), service.stateSequence.current(), "Expected each container event to be counted")
+}
+
+func TestTaskMetadataResponseGeneratedAt(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	dockerMock := mock_docker.NewMockClient(ctrl)
+	service := &MetadataService{
+		dockerClient: dockerMock,
+	}
+
+	container := testingutils.BaseDockerContainer(containerName1, longID1).WithNetwork(network1, ipAddress1).Get()
+	dockerMock.EXPECT().ContainerList(gomock.Any()).Return([]types.Container{container}, nil).Times(2)
+	dockerMock.EXPECT().ContainerInspect(gomock.Any(), longID1).Return(&types.ContainerJSON{}, nil).AnyTimes()
+
+	getGeneratedAt := func() *time.Time {
+		recorder := httptest.NewRecorder()
+		err := service.taskMetadataResponse(recorder, "", "")
+		assert.NoError(t, err, "Unexpected error getting task metadata")
+		var response metadata.TaskResponse
+		err = json.Unmarshal(recorder.Body.Bytes(), &response)
+		assert.NoError(t, err, "Unexpected error unmarshalling response")
+		return response.GeneratedAt
+	}
+
+	assert.Nil(t, getGeneratedAt(), "Expected no generatedAt by default")
+
+	os.Setenv(config.IncludeGeneratedAtVar, "true")
+	defer os.Unsetenv(config.IncludeGeneratedAtVar)
+
+	// without a snapshot, the containers are listed live for each request
+	before := time.Now()
+	generatedAt := getGeneratedAt()
+	if assert.NotNil(t, generatedAt, "Expected generatedAt") {
+		assert.False(t, generatedAt.Before(before), "Expected generatedAt to be when the containers were listed")
+		assert.False(t, generatedAt.After(time.Now()), "Expected generatedAt to be when the containers were listed")
+	}
+
+	// with a snapshot, it is when the snapshot was taken
+	os.Setenv(config.MetadataSnapshotTTLVar, "1h")
+	defer os.Unsetenv(config.MetadataSnapshotTTLVar)
+	takenAt := time.Now().Add(-30 * time.Second)
+	service.containerSnapshot.current.Store(&containerList{
+		containers: []types.Container{container},
+		takenAt:    takenAt,
+	})
+	generatedAt = getGeneratedAt()
+	if assert.NotNil(t, generatedAt, "Expected generatedAt") {
+		assert.True(t, takenAt.Equal(*generatedAt), "Expected generatedAt to be when the snapshot was taken")
+	}
+}
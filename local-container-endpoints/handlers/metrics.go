@@ -0,0 +1,215 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/clients/docker"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/stats"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/utils"
+	"github.com/docker/docker/api/types"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// prometheusTextType is the content type of the Prometheus text format
+	prometheusTextType = "text/plain; version=0.0.4; charset=utf-8"
+
+	cpuUtilizationMetric   = "ecs_local_container_cpu_utilization_percent"
+	memoryUsageMetric      = "ecs_local_container_memory_usage_bytes"
+	memoryWorkingSetMetric = "ecs_local_container_memory_working_set_bytes"
+	memoryLimitMetric      = "ecs_local_container_memory_limit_bytes"
+	networkReceiveMetric   = "ecs_local_container_network_receive_bytes"
+	networkTransmitMetric  = "ecs_local_container_network_transmit_bytes"
+)
+
+// SetupMetricsRoutes sets up the path which serves the stats of each container as Prometheus gauges, if
+// ECS_LOCAL_ENABLE_METRICS is set
+func (service *MetadataService) SetupMetricsRoutes(router *mux.Router) {
+	if !utils.GetBoolValue(false, config.EnableMetricsVar) {
+		return
+	}
+	router.HandleFunc(config.ContainerMetricsPath, ServeHTTP(service.containerMetricsHandler))
+}
+
+// containerMetrics are the stats of a container which are served as gauges
+type containerMetrics struct {
+	name     string
+	id       string
+	stats    *types.Stats
+	networks map[string]types.NetworkStats
+}
+
+func (service *MetadataService) containerMetricsHandler(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		return HTTPError{
+			Code: http.StatusMethodNotAllowed,
+			Err:  fmt.Errorf("Method %s is not allowed for %s", r.Method, r.URL.Path),
+		}
+	}
+
+	timeout, _ := time.ParseDuration(config.HTTPTimeoutDuration)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	containers, err := service.listContainers(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to list running containers")
+	}
+
+	metricsChan := make(chan *containerMetrics, len(containers))
+	for _, container := range containers {
+		go func(container types.Container) {
+			metricsChan <- service.readContainerMetrics(ctx, container)
+		}(container)
+	}
+
+	var scraped []*containerMetrics
+	for range containers {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case metrics := <-metricsChan:
+			// a container which stopped since it was listed is left out of the scrape, rather than failing it
+			if metrics != nil {
+				scraped = append(scraped, metrics)
+			}
+		}
+	}
+	sort.Slice(scraped, func(i, j int) bool {
+		return scraped[i].name < scraped[j].name
+	})
+
+	w.Header().Set("Content-Type", prometheusTextType)
+	if err := writeContainerMetrics(w, scraped); err != nil {
+		logrus.Warnf("Failed to write container metrics: %s", err)
+	}
+	return nil
+}
+
+// readContainerMetrics returns the container's stats, and its network stats if the Docker client can read them,
+// or nil if its stats could not be read
+func (service *MetadataService) readContainerMetrics(ctx context.Context, container types.Container) *containerMetrics {
+	metrics := &containerMetrics{
+		id: container.ID,
+	}
+	if len(container.Names) > 0 {
+		metrics.name = strings.TrimPrefix(container.Names[0], "/")
+	}
+
+	var err error
+	if networkClient, ok := service.dockerClient.(docker.NetworkStatsClient); ok {
+		metrics.stats, metrics.networks, err = networkClient.ContainerStatsWithNetworks(ctx, container.ID)
+	} else {
+		metrics.stats, err = service.dockerClient.ContainerStats(ctx, container.ID)
+	}
+	if err != nil {
+		logrus.Warn(err)
+		return nil
+	}
+	return metrics
+}
+
+// writeContainerMetrics writes the gauges of each container in the Prometheus text format, which groups the
+// samples by metric
+func writeContainerMetrics(out io.Writer, scraped []*containerMetrics) error {
+	var lines strings.Builder
+	writeGauge := func(name, help string, value func(metrics *containerMetrics) *float64) {
+		fmt.Fprintf(&lines, "# HELP %s %s\n", name, help)
+		fmt.Fprintf(&lines, "# TYPE %s gauge\n", name)
+		for _, metrics := range scraped {
+			if sample := value(metrics); sample != nil {
+				fmt.Fprintf(&lines, "%s{%s} %s\n", name, containerLabels(metrics), formatGaugeValue(*sample))
+			}
+		}
+	}
+	writeNetworkGauge := func(name, help string, value func(network types.NetworkStats) uint64) {
+		fmt.Fprintf(&lines, "# HELP %s %s\n", name, help)
+		fmt.Fprintf(&lines, "# TYPE %s gauge\n", name)
+		for _, metrics := range scraped {
+			interfaces := make([]string, 0, len(metrics.networks))
+			for networkInterface := range metrics.networks {
+				interfaces = append(interfaces, networkInterface)
+			}
+			sort.Strings(interfaces)
+			for _, networkInterface := range interfaces {
+				fmt.Fprintf(&lines, "%s{%s,interface=\"%s\"} %d\n", name, containerLabels(metrics),
+					escapeMetricLabel(networkInterface), value(metrics.networks[networkInterface]))
+			}
+		}
+	}
+
+	writeGauge(cpuUtilizationMetric, "The container's CPU utilization between Docker's last two samples.", func(metrics *containerMetrics) *float64 {
+		return stats.GetCPUUtilization(metrics.stats)
+	})
+	writeGauge(memoryUsageMetric, "The memory used by the container, including the page cache.", func(metrics *containerMetrics) *float64 {
+		return memoryGauge(metrics.stats, metrics.stats.MemoryStats.Usage)
+	})
+	writeGauge(memoryWorkingSetMetric, "The memory used by the container, not counting the inactive page cache.", func(metrics *containerMetrics) *float64 {
+		if workingSet := stats.GetContainerStats(metrics.stats, nil).MemoryWorkingSet; workingSet != nil {
+			return memoryGauge(metrics.stats, *workingSet)
+		}
+		return nil
+	})
+	writeGauge(memoryLimitMetric, "The container's memory limit, which is the host's memory if it has no limit.", func(metrics *containerMetrics) *float64 {
+		return memoryGauge(metrics.stats, metrics.stats.MemoryStats.Limit)
+	})
+	writeNetworkGauge(networkReceiveMetric, "The bytes received on the container's network interface.", func(network types.NetworkStats) uint64 {
+		return network.RxBytes
+	})
+	writeNetworkGauge(networkTransmitMetric, "The bytes sent on the container's network interface.", func(network types.NetworkStats) uint64 {
+		return network.TxBytes
+	})
+
+	_, err := io.WriteString(out, lines.String())
+	return err
+}
+
+func containerLabels(metrics *containerMetrics) string {
+	return fmt.Sprintf("container_name=\"%s\",container_id=\"%s\"", escapeMetricLabel(metrics.name), escapeMetricLabel(metrics.id))
+}
+
+// memoryGauge returns the value as a gauge sample, including 0, or nil if Docker did not report the container's
+// memory stats at all
+func memoryGauge(dockerStats *types.Stats, value uint64) *float64 {
+	if reflect.DeepEqual(dockerStats.MemoryStats, types.MemoryStats{}) {
+		return nil
+	}
+	sample := float64(value)
+	return &sample
+}
+
+func escapeMetricLabel(value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, `"`, `\"`, -1)
+	value = strings.Replace(value, "\n", `\n`, -1)
+	return value
+}
+
+func formatGaugeValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,160 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/clients/docker/mock_docker"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/testingutils"
+	"github.com/docker/docker/api/types"
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+// networkStatsClientInTest adds network stats to the mocked Docker stats
+type networkStatsClientInTest struct {
+	*mock_docker.MockClient
+	networks map[string]map[string]types.NetworkStats
+}
+
+func (client *networkStatsClientInTest) ContainerStatsWithNetworks(ctx context.Context, longContainerID string) (*types.Stats, map[string]types.NetworkStats, error) {
+	containerStats, err := client.ContainerStats(ctx, longContainerID)
+	return containerStats, client.networks[longContainerID], err
+}
+
+func TestContainerMetricsDisabledByDefault(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	service := &MetadataService{
+		dockerClient: mock_docker.NewMockClient(ctrl),
+	}
+
+	router := mux.NewRouter()
+	service.SetupMetricsRoutes(router)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", config.ContainerMetricsPath, nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code, "Expected no container metrics by default")
+}
+
+func TestContainerMetrics(t *testing.T) {
+	os.Setenv(config.EnableMetricsVar, "true")
+	defer os.Unsetenv(config.EnableMetricsVar)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	dockerMock := mock_docker.NewMockClient(ctrl)
+	service := &MetadataService{
+		dockerClient: &networkStatsClientInTest{
+			MockClient: dockerMock,
+			networks: map[string]map[string]types.NetworkStats{
+				longID1: {
+					"eth0": {RxBytes: 1500, TxBytes: 900},
+				},
+			},
+		},
+	}
+
+	container1 := testingutils.BaseDockerContainer("web", longID1).Get()
+	container2 := testingutils.BaseDockerContainer("db", longID2).Get()
+	stats1 := &types.Stats{}
+	stats1.CPUStats.CPUUsage.TotalUsage = 300
+	stats1.CPUStats.SystemUsage = 2000
+	stats1.CPUStats.OnlineCPUs = 2
+	stats1.PreCPUStats.CPUUsage.TotalUsage = 200
+	stats1.PreCPUStats.SystemUsage = 1000
+	stats1.MemoryStats.Usage = 4096
+	stats1.MemoryStats.Limit = 8192
+	stats1.MemoryStats.Stats = map[string]uint64{
+		"total_inactive_file": 1024,
+	}
+	stats2 := &types.Stats{}
+	stats2.MemoryStats.Usage = 2048
+
+	dockerMock.EXPECT().ContainerList(gomock.Any()).Return([]types.Container{container1, container2}, nil)
+	dockerMock.EXPECT().ContainerStats(gomock.Any(), longID1).Return(stats1, nil)
+	dockerMock.EXPECT().ContainerStats(gomock.Any(), longID2).Return(stats2, nil)
+
+	router := mux.NewRouter()
+	service.SetupMetricsRoutes(router)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", config.ContainerMetricsPath, nil))
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code to match")
+	assert.Equal(t, prometheusTextType, recorder.Header().Get("Content-Type"), "Expected the Prometheus text format")
+
+	webLabels := `container_name="web",container_id="` + longID1 + `"`
+	dbLabels := `container_name="db",container_id="` + longID2 + `"`
+	body := recorder.Body.String()
+	assert.Contains(t, body, "# TYPE ecs_local_container_cpu_utilization_percent gauge\n", "Expected the CPU gauge")
+	assert.Contains(t, body, "ecs_local_container_cpu_utilization_percent{"+webLabels+"} 20\n", "Expected the CPU utilization across both CPUs")
+	assert.NotContains(t, body, "ecs_local_container_cpu_utilization_percent{"+dbLabels+"}", "Expected no CPU utilization without a previous sample")
+	assert.Contains(t, body, "ecs_local_container_memory_usage_bytes{"+dbLabels+"} 2048\n", "Expected the memory usage")
+	assert.Contains(t, body, "ecs_local_container_memory_usage_bytes{"+webLabels+"} 4096\n", "Expected the memory usage")
+	assert.Contains(t, body, "ecs_local_container_memory_working_set_bytes{"+webLabels+"} 3072\n", "Expected the memory working set")
+	assert.Contains(t, body, "ecs_local_container_memory_limit_bytes{"+webLabels+"} 8192\n", "Expected the memory limit")
+	assert.Contains(t, body, "ecs_local_container_network_receive_bytes{"+webLabels+`,interface="eth0"} 1500`+"\n", "Expected the bytes received")
+	assert.Contains(t, body, "ecs_local_container_network_transmit_bytes{"+webLabels+`,interface="eth0"} 900`+"\n", "Expected the bytes sent")
+	assert.NotContains(t, body, "ecs_local_container_network_receive_bytes{"+dbLabels, "Expected no network gauges without network stats")
+}
+
+func TestContainerMetricsZeroSamples(t *testing.T) {
+	os.Setenv(config.EnableMetricsVar, "true")
+	defer os.Unsetenv(config.EnableMetricsVar)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	dockerMock := mock_docker.NewMockClient(ctrl)
+	service := &MetadataService{
+		dockerClient: &networkStatsClientInTest{
+			MockClient: dockerMock,
+			networks: map[string]map[string]types.NetworkStats{
+				longID1: {
+					"eth0": {},
+				},
+			},
+		},
+	}
+
+	container1 := testingutils.BaseDockerContainer("web", longID1).Get()
+	container2 := testingutils.BaseDockerContainer("db", longID2).Get()
+	stats1 := &types.Stats{}
+	stats1.MemoryStats.Limit = 8192
+	// Docker reported no memory stats for the second container
+	stats2 := &types.Stats{}
+
+	dockerMock.EXPECT().ContainerList(gomock.Any()).Return([]types.Container{container1, container2}, nil)
+	dockerMock.EXPECT().ContainerStats(gomock.Any(), longID1).Return(stats1, nil)
+	dockerMock.EXPECT().ContainerStats(gomock.Any(), longID2).Return(stats2, nil)
+
+	router := mux.NewRouter()
+	service.SetupMetricsRoutes(router)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", config.ContainerMetricsPath, nil))
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code to match")
+
+	webLabels := `container_name="web",container_id="` + longID1 + `"`
+	dbLabels := `container_name="db",container_id="` + longID2 + `"`
+	body := recorder.Body.String()
+	assert.Contains(t, body, "ecs_local_container_memory_usage_bytes{"+webLabels+"} 0\n", "Expected a zero memory usage")
+	assert.Contains(t, body, "ecs_local_container_network_receive_bytes{"+webLabels+`,interface="eth0"} 0`+"\n", "Expected zero bytes received")
+	assert.Contains(t, body, "ecs_local_container_network_transmit_bytes{"+webLabels+`,interface="eth0"} 0`+"\n", "Expected zero bytes sent")
+	assert.NotContains(t, body, "ecs_local_container_memory_usage_bytes{"+dbLabels, "Expected no memory gauges without memory stats")
+	assert.NotContains(t, body, "ecs_local_container_network_receive_bytes{"+dbLabels, "Expected no network gauges without network stats")
+}
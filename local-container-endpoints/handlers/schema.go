@@ -0,0 +1,61 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/metadata"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/stats"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/utils"
+	"github.com/gorilla/mux"
+)
+
+// SetupSchemaRoutes sets up the paths which serve the JSON schemas of the V3 metadata and stats responses, if enabled
+func SetupSchemaRoutes(router *mux.Router) {
+	if !utils.GetBoolValue(false, config.EnableSchemaVar) {
+		return
+	}
+	router.HandleFunc(config.SchemaV3TaskMetadataPath, ServeHTTP(getSchemaHandler(metadata.TaskResponse{})))
+	router.HandleFunc(config.SchemaV3TaskMetadataPathWithSlash, ServeHTTP(getSchemaHandler(metadata.TaskResponse{})))
+
+	router.HandleFunc(config.SchemaV3TaskStatsPath, ServeHTTP(getSchemaHandler(map[string]stats.ContainerStatsResponse{})))
+	router.HandleFunc(config.SchemaV3TaskStatsPathWithSlash, ServeHTTP(getSchemaHandler(map[string]stats.ContainerStatsResponse{})))
+
+	router.HandleFunc(config.SchemaV3ContainerMetadataPath, ServeHTTP(getSchemaHandler(metadata.ContainerResponse{})))
+	router.HandleFunc(config.SchemaV3ContainerMetadataPathWithSlash, ServeHTTP(getSchemaHandler(metadata.ContainerResponse{})))
+
+	router.HandleFunc(config.SchemaV3ContainerStatsPath, ServeHTTP(getSchemaHandler(stats.ContainerStatsResponse{})))
+	router.HandleFunc(config.SchemaV3ContainerStatsPathWithSlash, ServeHTTP(getSchemaHandler(stats.ContainerStatsResponse{})))
+}
+
+// getSchemaHandler returns a handler which serves the JSON schema of the given response
+func getSchemaHandler(response interface{}) func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			return HTTPError{
+				Code: http.StatusMethodNotAllowed,
+				Err:  fmt.Errorf("Method %s is not allowed for %s", r.Method, r.URL.Path),
+			}
+		}
+
+		// The schema follows the timestamp format, which is read for each request like the metadata responses
+		timestampFormat := utils.GetValue(config.DefaultTimestampFormat, config.TimestampFormatVar)
+		writeJSONResponse(w, metadata.Schema(response, timestampFormat))
+		return nil
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+// schemaTypes are the types which a JSON schema can constrain a value to
+var schemaTypes = map[string]bool{
+	"null":    true,
+	"boolean": true,
+	"object":  true,
+	"array":   true,
+	"number":  true,
+	"string":  true,
+	"integer": true,
+}
+
+func TestSchemaRoutes(t *testing.T) {
+	os.Setenv(config.EnableSchemaVar, "true")
+	defer os.Unsetenv(config.EnableSchemaVar)
+
+	router := mux.NewRouter()
+	SetupSchemaRoutes(router)
+
+	var testCases = []struct {
+		path       string
+		properties []string
+	}{
+		{
+			path:       config.SchemaV3TaskMetadataPath,
+			properties: []string{"Cluster", "TaskARN", "Containers"},
+		},
+		{
+			path:       config.SchemaV3ContainerMetadataPath,
+			properties: []string{"DockerId", "Name", "Networks"},
+		},
+		{
+			path:       config.SchemaV3ContainerStatsPath,
+			properties: []string{"read", "cpu_stats", "memory_stats"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.path, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest("GET", testCase.path, nil))
+			assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code to match")
+
+			var schema map[string]interface{}
+			err := json.Unmarshal(recorder.Body.Bytes(), &schema)
+			assert.NoError(t, err, "Unexpected error decoding the schema")
+			assert.Equal(t, "http://json-schema.org/draft-07/schema#", schema["$schema"], "Expected schema dialect to match")
+			assert.Equal(t, "object", schema["type"], "Expected schema type to match")
+			properties, _ := schema["properties"].(map[string]interface{})
+			for _, property := range testCase.properties {
+				assert.Contains(t, properties, property, "Expected schema to include the property")
+			}
+			assertValidSchema(t, schema, "#")
+		})
+	}
+}
+
+func TestSchemaTaskStats(t *testing.T) {
+	os.Setenv(config.EnableSchemaVar, "true")
+	defer os.Unsetenv(config.EnableSchemaVar)
+
+	router := mux.NewRouter()
+	SetupSchemaRoutes(router)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", config.SchemaV3TaskStatsPath, nil))
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code to match")
+
+	var schema map[string]interface{}
+	err := json.Unmarshal(recorder.Body.Bytes(), &schema)
+	assert.NoError(t, err, "Unexpected error decoding the schema")
+	// Task stats are a map of container IDs to their stats
+	assert.Equal(t, "object", schema["type"], "Expected schema type to match")
+	stats, _ := schema["additionalProperties"].(map[string]interface{})
+	assert.Equal(t, "object", stats["type"], "Expected container stats schema type to match")
+	assertValidSchema(t, schema, "#")
+}
+
+func TestSchemaTimestampFormat(t *testing.T) {
+	os.Setenv(config.EnableSchemaVar, "true")
+	defer os.Unsetenv(config.EnableSchemaVar)
+	os.Setenv(config.TimestampFormatVar, config.TimestampFormatUnix)
+	defer os.Unsetenv(config.TimestampFormatVar)
+
+	router := mux.NewRouter()
+	SetupSchemaRoutes(router)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", config.SchemaV3ContainerMetadataPath, nil))
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code to match")
+
+	var schema struct {
+		Properties map[string]map[string]interface{} `json:"properties"`
+	}
+	err := json.Unmarshal(recorder.Body.Bytes(), &schema)
+	assert.NoError(t, err, "Unexpected error decoding the schema")
+	assert.Equal(t, "integer", schema.Properties["CreatedAt"]["type"], "Expected unix timestamps to be integers")
+}
+
+func TestSchemaRoutesDisabled(t *testing.T) {
+	router := mux.NewRouter()
+	SetupSchemaRoutes(router)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", config.SchemaV3TaskMetadataPath, nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code, "Expected status code to match")
+}
+
+// assertValidSchema checks that the schema only uses the keywords which the schema generator emits, and that they are well formed
+func assertValidSchema(t *testing.T, schema interface{}, pointer string) {
+	node, ok := schema.(map[string]interface{})
+	if !assert.True(t, ok, "Expected a schema object at %s", pointer) {
+		return
+	}
+	for keyword, value := range node {
+		switch keyword {
+		case "$schema", "format", "contentEncoding":
+			_, ok := value.(string)
+			assert.True(t, ok, "Expected %s/%s to be a string", pointer, keyword)
+		case "type":
+			typeName, _ := value.(string)
+			assert.True(t, schemaTypes[typeName], "Expected %s/type to be a valid type, got %v", pointer, value)
+		case "properties":
+			properties, ok := value.(map[string]interface{})
+			if !assert.True(t, ok, "Expected %s/properties to be an object", pointer) {
+				continue
+			}
+			for name, property := range properties {
+				assertValidSchema(t, property, pointer+"/properties/"+name)
+			}
+		case "required":
+			required, ok := value.([]interface{})
+			if !assert.True(t, ok, "Expected %s/required to be an array", pointer) {
+				continue
+			}
+			properties, _ := node["properties"].(map[string]interface{})
+			for _, name := range required {
+				name, _ := name.(string)
+				assert.Contains(t, properties, name, "Expected required property at %s to be defined", pointer)
+			}
+		case "items", "additionalProperties":
+			assertValidSchema(t, value, pointer+"/"+keyword)
+		case "anyOf":
+			schemas, ok := value.([]interface{})
+			if !assert.True(t, ok, "Expected %s/anyOf to be an array", pointer) {
+				continue
+			}
+			for _, subschema := range schemas {
+				assertValidSchema(t, subschema, pointer+"/anyOf")
+			}
+		default:
+			t.Errorf("Unexpected keyword %s at %s", keyword, pointer)
+		}
+	}
+}
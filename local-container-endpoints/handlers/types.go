@@ -13,6 +13,10 @@
 
 package handlers
 
+import (
+	"time"
+)
+
 // CredentialResponse is used to marshal the JSON response for the Credentials Service
 type CredentialResponse struct {
 	AccessKeyID     string `json:"AccessKeyId"`
@@ -20,4 +24,68 @@ type CredentialResponse struct {
 	RoleArn         string
 	SecretAccessKey string
 	Token           string
+	// Code, LastUpdated, and Type are only set in EC2 Instance Metadata Service style responses
+	Code        string `json:",omitempty"`
+	LastUpdated string `json:",omitempty"`
+	Type        string `json:",omitempty"`
+	// issuedAt is when Local Endpoints obtained the credentials
+	issuedAt time.Time
+}
+
+// MetadataVersionsResponse is used to marshal the JSON response which lists the metadata versions that are served
+type MetadataVersionsResponse struct {
+	Versions []MetadataVersionResponse
+}
+
+// MetadataVersionResponse is a metadata version, and the paths which serve it
+// The container paths also accept a container identifier, for example /v3/<container name>
+type MetadataVersionResponse struct {
+	Version               string
+	ContainerMetadataPath string
+	ContainerStatsPath    string
+	TaskMetadataPath      string
+	TaskStatsPath         string
+}
+
+// HealthResponse is used to marshal the JSON response for the health path
+// The health of each dependency is only included in the detailed response
+type HealthResponse struct {
+	Status string
+	Docker *DependencyHealthResponse `json:",omitempty"`
+	STS    *DependencyHealthResponse `json:",omitempty"`
+	Cache  *DependencyHealthResponse `json:",omitempty"`
+}
+
+// DependencyHealthResponse is the health of one of Local Endpoints' dependencies
+type DependencyHealthResponse struct {
+	Status string
+	Error  string `json:",omitempty"`
+	// AgeSeconds is the age of the cache, if it has been filled
+	AgeSeconds float64 `json:",omitempty"`
+}
+
+// CredentialErrorResponse is used to marshal credentials errors in the same shape as the EC2 Instance Metadata Service
+type CredentialErrorResponse struct {
+	Code        string
+	Message     string
+	LastUpdated string
+}
+
+// CredentialSourcesResponse is used to marshal the JSON response for the credential sources debug path
+type CredentialSourcesResponse struct {
+	// Order is the order in which the sources are tried
+	Order []string
+	// DefaultChain is true if the AWS SDK for Go's default credential chain is used, instead of ECS_LOCAL_CREDS_SOURCE_ORDER
+	DefaultChain bool
+	Sources      []CredentialSourceResponse
+	// Selected is the source which the credentials currently come from
+	Selected string `json:",omitempty"`
+	// Error is why no source yields credentials
+	Error string `json:",omitempty"`
+}
+
+// CredentialSourceResponse reports whether a credential source is configured
+type CredentialSourceResponse struct {
+	Name       string
+	Configured bool
 }
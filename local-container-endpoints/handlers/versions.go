@@ -0,0 +1,60 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/utils"
+	"github.com/gorilla/mux"
+)
+
+// metadataVersions are the metadata versions which SetupV2Routes and SetupV3Routes serve, oldest first
+var metadataVersions = []MetadataVersionResponse{
+	{
+		Version:               "v2",
+		ContainerMetadataPath: config.V2ContainerMetadataPath,
+		ContainerStatsPath:    config.V2ContainerStatsPath,
+		TaskMetadataPath:      config.V2TaskMetadataPath,
+		TaskStatsPath:         config.V2TaskStatsPath,
+	},
+	{
+		Version:               "v3",
+		ContainerMetadataPath: config.V3ContainerMetadataPath,
+		ContainerStatsPath:    config.V3ContainerStatsPath,
+		TaskMetadataPath:      config.V3TaskMetadataPath,
+		TaskStatsPath:         config.V3TaskStatsPath,
+	},
+}
+
+// SetupMetadataVersionsRoutes sets up the path which lists the metadata versions, and the paths each one is served
+// at, if ECS_LOCAL_METADATA_VERSIONS is set
+func SetupMetadataVersionsRoutes(router *mux.Router) {
+	if !utils.GetBoolValue(false, config.MetadataVersionsVar) {
+		return
+	}
+	router.HandleFunc(config.MetadataVersionsPath, ServeHTTP(getMetadataVersionsHandler()))
+	router.HandleFunc(config.MetadataVersionsPathWithSlash, ServeHTTP(getMetadataVersionsHandler()))
+}
+
+// getMetadataVersionsHandler returns the handler for the path which lists the metadata versions
+func getMetadataVersionsHandler() func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		writeJSONResponse(w, MetadataVersionsResponse{
+			Versions: metadataVersions,
+		})
+		return nil
+	}
+}
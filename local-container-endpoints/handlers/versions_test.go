@@ -0,0 +1,83 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"testing"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetadataVersionsDisabledByDefault(t *testing.T) {
+	router := mux.NewRouter()
+	SetupMetadataVersionsRoutes(router)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", config.MetadataVersionsPath, nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code, "Expected no versions path by default")
+}
+
+func TestMetadataVersionsMatchRoutes(t *testing.T) {
+	os.Setenv(config.MetadataVersionsVar, "true")
+	defer os.Unsetenv(config.MetadataVersionsVar)
+
+	service := &MetadataService{}
+	router := mux.NewRouter()
+	service.SetupV2Routes(router)
+	service.SetupV3Routes(router)
+	SetupMetadataVersionsRoutes(router)
+
+	// the versions of the registered metadata routes, such as v3 for /v3/task
+	versionPrefix := regexp.MustCompile(`^/(v\d+)(/|$)`)
+	registered := make(map[string]bool)
+	registeredVersions := make(map[string]bool)
+	router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		registered[template] = true
+		if match := versionPrefix.FindStringSubmatch(template); match != nil {
+			registeredVersions[match[1]] = true
+		}
+		return nil
+	})
+
+	for _, path := range []string{config.MetadataVersionsPath, config.MetadataVersionsPathWithSlash} {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+		assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code to match")
+
+		var response MetadataVersionsResponse
+		err := json.Unmarshal(recorder.Body.Bytes(), &response)
+		assert.NoError(t, err, "Unexpected error unmarshalling response")
+
+		advertisedVersions := make(map[string]bool)
+		for _, version := range response.Versions {
+			advertisedVersions[version.Version] = true
+			for _, versionPath := range []string{version.ContainerMetadataPath, version.ContainerStatsPath, version.TaskMetadataPath, version.TaskStatsPath} {
+				assert.True(t, registered[versionPath], "Expected %s of %s to be a registered route", versionPath, version.Version)
+				assert.Equal(t, version.Version, versionPrefix.FindStringSubmatch(versionPath)[1], "Expected %s to be under its version", versionPath)
+			}
+		}
+		assert.Equal(t, registeredVersions, advertisedVersions, "Expected the advertised versions to match the registered routes")
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metadata
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/utils"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const arnPrefix = "arn:"
+
+// configuredARN is an ARN which Local Endpoints emits or uses, and the setting it comes from
+type configuredARN struct {
+	source string
+	arn    arn.ARN
+}
+
+// ValidateARNs checks that the task, cluster, task definition, and role ARNs, and AWS_REGION, agree on the account and region,
+// so that the ARNs in metadata responses do not contradict each other
+// IAM ARNs have no region, and the cluster may be set as a name rather than an ARN; either is only checked for what it has
+func ValidateARNs() error {
+	arns, err := getConfiguredARNs()
+	if err != nil {
+		return err
+	}
+
+	var account, region configuredARN
+	if awsRegion := os.Getenv(config.RegionVar); awsRegion != "" {
+		region = configuredARN{
+			source: config.RegionVar,
+			arn:    arn.ARN{Region: awsRegion},
+		}
+	}
+	for _, configured := range arns {
+		if configured.arn.AccountID != "" {
+			if account.source == "" {
+				account = configured
+			} else if configured.arn.AccountID != account.arn.AccountID {
+				return fmt.Errorf("%s is in account %s, but %s is in account %s", configured.source,
+					configured.arn.AccountID, account.source, account.arn.AccountID)
+			}
+		}
+		if configured.arn.Region != "" {
+			if region.source == "" {
+				region = configured
+			} else if configured.arn.Region != region.arn.Region {
+				return fmt.Errorf("%s is in region %s, but %s is in region %s", configured.source,
+					configured.arn.Region, region.source, region.arn.Region)
+			}
+		}
+	}
+	return nil
+}
+
+// getConfiguredARNs returns the ARNs which are set, in a stable order
+// TASK_ARN is always included, since its default is emitted when it is not set
+func getConfiguredARNs() ([]configuredARN, error) {
+	taskARN, err := arn.Parse(utils.GetValue(config.DefaultTaskARN, config.TaskARNVar))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid value for %s", config.TaskARNVar)
+	}
+	arns := []configuredARN{{source: config.TaskARNVar, arn: taskARN}}
+
+	if cluster := os.Getenv(config.ClusterARNVar); strings.HasPrefix(cluster, arnPrefix) {
+		clusterARN, err := arn.Parse(cluster)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid value for %s", config.ClusterARNVar)
+		}
+		arns = append(arns, configuredARN{source: config.ClusterARNVar, arn: clusterARN})
+	}
+
+	if taskMapVal := os.Getenv(config.ContainerTaskMapVar); taskMapVal != "" {
+		taskMap, err := utils.GetTagsMap(taskMapVal)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid value for %s", config.ContainerTaskMapVar)
+		}
+		var containers []string
+		for container := range taskMap {
+			containers = append(containers, container)
+		}
+		sort.Strings(containers)
+		for _, container := range containers {
+			source := fmt.Sprintf("%s (%s)", config.ContainerTaskMapVar, container)
+			mappedARN, err := arn.Parse(taskMap[container])
+			if err != nil {
+				return nil, errors.Wrapf(err, "Invalid value for %s", source)
+			}
+			arns = append(arns, configuredARN{source: source, arn: mappedARN})
+		}
+	}
+
+	if taskDefARNVal := os.Getenv(config.TaskDefARNVar); taskDefARNVal != "" {
+		taskDefARN, err := arn.Parse(taskDefARNVal)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid value for %s", config.TaskDefARNVar)
+		}
+		arns = append(arns, configuredARN{source: config.TaskDefARNVar, arn: taskDefARN})
+	}
+
+	if roleARNVal := os.Getenv(config.DefaultRoleARNVar); roleARNVal != "" {
+		roleARN, err := arn.Parse(roleARNVal)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid value for %s", config.DefaultRoleARNVar)
+		}
+		arns = append(arns, configuredARN{source: config.DefaultRoleARNVar, arn: roleARN})
+	}
+	return arns, nil
+}
+
+// AddTaskDefinitionARN sets the task's TaskDefinitionArn to ECS_LOCAL_TASK_DEF_ARN, or, if
+// ECS_LOCAL_SYNTHESIZE_TASK_DEF_ARN is set, to an ARN in the task's partition, region, and account, with its family
+// and revision. It must be called once the task's revision is final.
+func AddTaskDefinitionARN(response *TaskResponse) {
+	if taskDefARN := os.Getenv(config.TaskDefARNVar); taskDefARN != "" {
+		response.TaskDefinitionARN = taskDefARN
+		return
+	}
+	if !utils.GetBoolValue(false, config.SynthesizeTaskDefARNVar) {
+		return
+	}
+	taskARN, err := arn.Parse(response.TaskARN)
+	if err != nil {
+		logrus.Warnf("Unable to synthesize a task definition ARN from the task ARN %s: %s", response.TaskARN, err)
+		return
+	}
+	response.TaskDefinitionARN = arn.ARN{
+		Partition: taskARN.Partition,
+		Service:   taskARN.Service,
+		Region:    taskARN.Region,
+		AccountID: taskARN.AccountID,
+		Resource:  fmt.Sprintf("task-definition/%s:%s", response.Family, response.Revision),
+	}.String()
+}
@@ -0,0 +1,531 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metadata
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	apicontainer "github.com/aws/amazon-ecs-agent/agent/api/container"
+	apicontainerstatus "github.com/aws/amazon-ecs-agent/agent/api/container/status"
+	"github.com/aws/amazon-ecs-agent/agent/handlers/v1"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/utils"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	awslogsDriver       = "awslogs"
+	awslogsGroupOption  = "awslogs-group"
+	awslogsRegionOption = "awslogs-region"
+	awslogsStreamOption = "awslogs-stream"
+
+	windowsPlatform = "windows"
+
+	entrypointFormShell = "shell"
+	entrypointFormExec  = "exec"
+)
+
+// shellCommandFlags are the flags which each shell takes to run a command string, keyed by the shell's executable name
+// Docker wraps shell form commands in the image's SHELL, which defaults to /bin/sh -c, or cmd /S /C on Windows
+var shellCommandFlags = map[string][]string{
+	"sh":             {"-c"},
+	"ash":            {"-c"},
+	"bash":           {"-c"},
+	"dash":           {"-c"},
+	"zsh":            {"-c"},
+	"cmd":            {"/c"},
+	"cmd.exe":        {"/c"},
+	"powershell":     {"-command", "-c"},
+	"powershell.exe": {"-command", "-c"},
+	"pwsh":           {"-command", "-c"},
+}
+
+// addInspectMetadata adds the values which are only available from the Docker inspect API to the response
+func addInspectMetadata(response *ContainerResponse, inspect *types.ContainerJSON) {
+	includeProcessInfo := utils.GetBoolValue(false, config.IncludeProcessInfoVar)
+
+	if state := getState(inspect); state != nil {
+		addStateMetadata(response, state)
+		response.RestartCount = inspect.RestartCount
+		if inspect.RestartCount > 0 {
+			// containers which restart shortly after each start are flapping
+			response.Restarted = true
+			response.StableFor = getUptime(state, response.StartedAt)
+		}
+		if includeProcessInfo {
+			response.Pid = state.Pid
+		}
+		if utils.GetBoolValue(false, config.IncludeUptimeVar) {
+			response.Uptime = getUptime(state, response.StartedAt)
+		}
+		if utils.GetBoolValue(false, config.IncludeStartupLatencyVar) {
+			response.StartupLatency = getStartupLatency(inspect.Created, state.StartedAt)
+		}
+	}
+
+	if containerConfig := getConfig(inspect); containerConfig != nil {
+		response.Entrypoint = containerConfig.Entrypoint
+		response.Cmd = containerConfig.Cmd
+		if utils.GetBoolValue(false, config.IncludeEntrypointFormVar) {
+			response.EntrypointForm, response.EntrypointShell = getEntrypointForm(containerConfig.Entrypoint, containerConfig.Cmd)
+		}
+		// StopSignal is only set when the image or the container overrides Docker's default, SIGTERM
+		response.StopSignal = containerConfig.StopSignal
+		// Docker's default hostname is the container's short ID; the domain name is only set with --domainname
+		response.Hostname = containerConfig.Hostname
+		response.Domainname = containerConfig.Domainname
+		if containerConfig.Hostname != "" && containerConfig.Domainname != "" {
+			response.FQDN = containerConfig.Hostname + "." + strings.TrimSuffix(containerConfig.Domainname, ".")
+		}
+		if utils.GetBoolValue(false, config.IncludeEnvNamesVar) {
+			response.EnvironmentNames = getEnvironmentNames(containerConfig.Env)
+		}
+		if containerConfig.Healthcheck != nil && utils.GetBoolValue(false, config.IncludeHealthCheckConfigVar) {
+			response.HealthCheck = &HealthCheckResponse{
+				Command:     containerConfig.Healthcheck.Test,
+				Interval:    int64(containerConfig.Healthcheck.Interval / time.Second),
+				Timeout:     int64(containerConfig.Healthcheck.Timeout / time.Second),
+				Retries:     containerConfig.Healthcheck.Retries,
+				StartPeriod: int64(containerConfig.Healthcheck.StartPeriod / time.Second),
+			}
+		}
+	}
+
+	response.Health = getHealthStatus(getState(inspect), getConfig(inspect))
+	response.HealthLog = getHealthLog(getState(inspect))
+
+	if networkSettings := getNetworkSettings(inspect); networkSettings != nil {
+		addNetworkEndpoints(response, networkSettings.Networks)
+	}
+
+	if hostConfig := getHostConfig(inspect); hostConfig != nil {
+		if utils.GetBoolValue(false, config.IncludeSecurityOptsVar) {
+			response.SecurityOptions = hostConfig.SecurityOpt
+		}
+		if includeProcessInfo {
+			response.CgroupParent = hostConfig.CgroupParent
+		}
+		if utils.GetBoolValue(false, config.IncludeInitVar) {
+			// Init is only set when the --init flag is given; otherwise the daemon's default applies,
+			// which Local Endpoints reports as false
+			usesInit := hostConfig.Init != nil && *hostConfig.Init
+			response.Init = &usesInit
+		}
+		if utils.GetBoolValue(false, config.IncludeUlimitsVar) {
+			for _, ulimit := range hostConfig.Ulimits {
+				response.Ulimits = append(response.Ulimits, UlimitResponse{
+					Name:      ulimit.Name,
+					SoftLimit: ulimit.Soft,
+					HardLimit: ulimit.Hard,
+				})
+			}
+		}
+		if utils.GetBoolValue(false, config.IncludeCapabilitiesVar) && (len(hostConfig.CapAdd) > 0 || len(hostConfig.CapDrop) > 0) {
+			response.Capabilities = &CapabilitiesResponse{
+				Add:  hostConfig.CapAdd,
+				Drop: hostConfig.CapDrop,
+			}
+		}
+		if utils.GetBoolValue(false, config.IncludeSysctlsVar) && len(hostConfig.Sysctls) > 0 {
+			response.Sysctls = hostConfig.Sysctls
+		}
+		if utils.GetBoolValue(false, config.IncludeStorageOptVar) && len(hostConfig.StorageOpt) > 0 {
+			// set with --storage-opt, such as size, the quota of the container's writable layer
+			response.StorageOptions = hostConfig.StorageOpt
+		}
+		if utils.GetBoolValue(false, config.IncludeShmSizeVar) {
+			// in bytes; Docker reports its default, 64MB, for containers which do not set it
+			response.ShmSize = hostConfig.ShmSize
+		}
+		if utils.GetBoolValue(false, config.IncludeRuntimeVar) {
+			response.Runtime = hostConfig.Runtime
+		}
+		if utils.GetBoolValue(false, config.IncludeGroupAddVar) {
+			// the supplementary groups, each either a group name or a GID
+			response.GroupAdd = hostConfig.GroupAdd
+		}
+		if utils.GetBoolValue(false, config.IncludeNamespaceModesVar) {
+			// host, or container:<name or ID> for a namespace shared with another container
+			response.PidMode = string(hostConfig.PidMode)
+			response.IpcMode = string(hostConfig.IpcMode)
+		}
+		if utils.GetBoolValue(false, config.IncludeOomScoreAdjVar) {
+			// from -1000 to 1000; the higher the adjustment, the more likely the OOM killer is to pick the container
+			response.OomScoreAdj = hostConfig.OomScoreAdj
+		}
+		if utils.GetBoolValue(false, config.IncludeDNSVar) {
+			response.DNSServers = hostConfig.DNS
+			response.DNSSearchDomains = hostConfig.DNSSearch
+		}
+		if utils.GetBoolValue(false, config.IncludeCpusetVar) {
+			// lists and ranges of CPU and NUMA node numbers, for example 0-3,6
+			response.CpusetCpus = hostConfig.CpusetCpus
+			response.CpusetMems = hostConfig.CpusetMems
+		}
+		if utils.GetBoolValue(false, config.IncludeCPURealtimeVar) {
+			// in microseconds; zero for containers which do not use the real-time scheduler
+			response.CPURealtimeRuntime = hostConfig.CPURealtimeRuntime
+			response.CPURealtimePeriod = hostConfig.CPURealtimePeriod
+		}
+		if utils.GetBoolValue(false, config.IncludeBlkioWeightVar) {
+			// from 10 to 1000; zero when the default weight applies
+			response.BlkioWeight = hostConfig.BlkioWeight
+			for _, device := range hostConfig.BlkioWeightDevice {
+				response.BlkioDeviceWeights = append(response.BlkioDeviceWeights, BlkioDeviceWeightResponse{
+					Path:   device.Path,
+					Weight: device.Weight,
+				})
+			}
+		}
+		if utils.GetBoolValue(false, config.IncludeDevicesVar) {
+			for _, device := range hostConfig.Devices {
+				response.Devices = append(response.Devices, DeviceResponse{
+					HostPath:      device.PathOnHost,
+					ContainerPath: device.PathInContainer,
+					Permissions:   device.CgroupPermissions,
+				})
+			}
+		}
+		response.ExtraHosts = parseExtraHosts(hostConfig.ExtraHosts)
+		response.ReadonlyRootfs = hostConfig.ReadonlyRootfs
+		response.Privileged = hostConfig.Privileged
+		if inspect.Platform == windowsPlatform && !hostConfig.Isolation.IsDefault() {
+			// process or hyperv; Linux containers have no isolation modes
+			response.Isolation = string(hostConfig.Isolation)
+		}
+		addTmpfsMounts(response, hostConfig.Tmpfs)
+		addAWSLogsMetadata(response, hostConfig.LogConfig)
+	}
+}
+
+// addTmpfsMounts adds the mounts created with --tmpfs, which Docker does not list with the container's other mounts
+func addTmpfsMounts(response *ContainerResponse, tmpfs map[string]string) {
+	var destinations []string
+	for destination := range tmpfs {
+		destinations = append(destinations, destination)
+	}
+	sort.Strings(destinations)
+
+	for _, destination := range destinations {
+		if hasVolume(response.Volumes, destination) {
+			continue
+		}
+		response.Volumes = append(response.Volumes, VolumeResponse{
+			VolumeResponse: v1.VolumeResponse{
+				Destination: destination,
+			},
+			Type: string(mount.TypeTmpfs),
+		})
+	}
+}
+
+// AddImageRepoDigest adds the first of the image's repo digests, which references the exact image the container runs
+// rather than the tag, which may since have been moved to another image
+func AddImageRepoDigest(response *ContainerResponse, image *types.ImageInspect) {
+	if image != nil && len(image.RepoDigests) > 0 {
+		response.ImageRepoDigest = image.RepoDigests[0]
+	}
+}
+
+// AddImageSource adds whether the container's image was built locally or pulled from a registry, unless the
+// container's ecs-local.image-source label already set it. This is a best guess: Docker records when an image was
+// last tagged locally, by a build or docker tag, but not when it was pulled, and only pulled or pushed images have
+// repo digests.
+func AddImageSource(response *ContainerResponse, image *types.ImageInspect) {
+	if response.ImageSource != "" || image == nil {
+		return
+	}
+	taggedLocally := !image.Metadata.LastTagTime.IsZero() &&
+		(response.CreatedAt == nil || !image.Metadata.LastTagTime.After(*response.CreatedAt))
+	if taggedLocally || len(image.RepoDigests) == 0 {
+		response.ImageSource = ImageSourceLocal
+	} else {
+		response.ImageSource = ImageSourcePulled
+	}
+}
+
+// addNetworkEndpoints adds the container's aliases and MAC address on each of its networks, which Docker only reports in the inspect API
+func addNetworkEndpoints(response *ContainerResponse, endpoints map[string]*network.EndpointSettings) {
+	for i := range response.Networks {
+		if endpoint, ok := endpoints[response.Networks[i].NetworkMode]; ok && endpoint != nil {
+			response.Networks[i].Aliases = endpoint.Aliases
+			response.Networks[i].MACAddress = endpoint.MacAddress
+		}
+	}
+}
+
+// addAWSLogsMetadata adds the CloudWatch log group and stream of containers which use the awslogs log driver,
+// in the same format as the ECS Agent's Task Metadata V4
+func addAWSLogsMetadata(response *ContainerResponse, logConfig container.LogConfig) {
+	if logConfig.Type != awslogsDriver {
+		return
+	}
+
+	response.LogDriver = logConfig.Type
+	response.LogOptions = make(map[string]string)
+	for _, option := range []string{awslogsGroupOption, awslogsRegionOption} {
+		if value, ok := logConfig.Config[option]; ok {
+			response.LogOptions[option] = value
+		}
+	}
+	// without an explicit stream, the awslogs driver names the stream after the container ID
+	response.LogOptions[awslogsStreamOption] = response.ID
+	if stream, ok := logConfig.Config[awslogsStreamOption]; ok {
+		response.LogOptions[awslogsStreamOption] = stream
+	}
+}
+
+// parseExtraHosts converts the container's extra /etc/hosts entries, each in the format host:IP, to a map
+// of host to IP. IPv6 addresses contain colons, so the host is everything before the first colon.
+func parseExtraHosts(extraHosts []string) map[string]string {
+	if len(extraHosts) == 0 {
+		return nil
+	}
+	hosts := make(map[string]string)
+	for _, extraHost := range extraHosts {
+		separator := strings.Index(extraHost, ":")
+		if separator < 1 {
+			logrus.Warnf("Ignoring invalid extra host: %s", extraHost)
+			continue
+		}
+		hosts[extraHost[:separator]] = extraHost[separator+1:]
+	}
+	return hosts
+}
+
+// getEntrypointForm returns whether the container's command is in shell form, wrapped in a shell which runs it as a
+// string, or in exec form, and the shell for shell form commands
+// The command is the entrypoint followed by cmd, which is how Docker runs them; containers without either have no form
+func getEntrypointForm(entrypoint, cmd []string) (string, string) {
+	command := append(append([]string{}, entrypoint...), cmd...)
+	if len(command) == 0 {
+		return "", ""
+	}
+
+	shell := command[0]
+	name := strings.ToLower(shell[strings.LastIndexAny(shell, `/\`)+1:])
+	flags, ok := shellCommandFlags[name]
+	if !ok {
+		return entrypointFormExec, ""
+	}
+	// Docker appends the command string after the shell's flags, such as /bin/bash -o pipefail -c <command>
+	for i := 1; i < len(command)-1; i++ {
+		for _, flag := range flags {
+			if strings.ToLower(command[i]) == flag {
+				return entrypointFormShell, shell
+			}
+		}
+	}
+	return entrypointFormExec, ""
+}
+
+// getEnvironmentNames returns the names of the environment variables, each in the format NAME=value, without their values
+func getEnvironmentNames(env []string) []string {
+	var names []string
+	for _, variable := range env {
+		name := variable
+		if separator := strings.Index(variable, "="); separator >= 0 {
+			name = variable[:separator]
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// getHealthStatus returns the container's health from its Docker health check, or, for containers without one,
+// from the configured health label
+func getHealthStatus(state *types.ContainerState, containerConfig *container.Config) *apicontainer.HealthStatus {
+	if state != nil && state.Health != nil && state.Health.Status != types.NoHealthcheck {
+		health := &apicontainer.HealthStatus{
+			Status: parseHealthStatus(state.Health.Status),
+		}
+		// like the ECS Agent, report the output of the most recent check
+		if len(state.Health.Log) > 0 {
+			lastCheck := state.Health.Log[len(state.Health.Log)-1]
+			health.ExitCode = lastCheck.ExitCode
+			health.Output = lastCheck.Output
+		}
+		return health
+	}
+
+	healthLabel := utils.GetValue("", config.HealthLabelVar)
+	if healthLabel == "" || containerConfig == nil {
+		return nil
+	}
+	value, ok := containerConfig.Labels[healthLabel]
+	if !ok {
+		return nil
+	}
+	return &apicontainer.HealthStatus{
+		Status: parseHealthStatus(value),
+	}
+}
+
+// getHealthLog returns the results of the most recent health checks, oldest first, up to ECS_LOCAL_HEALTH_LOG_ENTRIES
+// Docker only keeps the last 5 results, so there are never more than that
+func getHealthLog(state *types.ContainerState) []HealthLogResponse {
+	entries := utils.GetIntValue(0, config.HealthLogEntriesVar)
+	if entries <= 0 || state == nil || state.Health == nil {
+		return nil
+	}
+	log := state.Health.Log
+	if len(log) > entries {
+		log = log[len(log)-entries:]
+	}
+
+	var healthLog []HealthLogResponse
+	for _, result := range log {
+		if result == nil {
+			continue
+		}
+		healthLog = append(healthLog, HealthLogResponse{
+			ExitCode:   result.ExitCode,
+			Output:     result.Output,
+			StartedAt:  timeOrNil(result.Start),
+			FinishedAt: timeOrNil(result.End),
+		})
+	}
+	return healthLog
+}
+
+// timeOrNil returns nil for the zero time, such as the end of a health check which is still running
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// parseHealthStatus maps a Docker health status to the ECS health status; starting is UNKNOWN, as in ECS
+func parseHealthStatus(status string) apicontainerstatus.ContainerHealthStatus {
+	switch strings.ToLower(status) {
+	case types.Healthy:
+		return apicontainerstatus.ContainerHealthy
+	case types.Unhealthy:
+		return apicontainerstatus.ContainerUnhealthy
+	default:
+		return apicontainerstatus.ContainerHealthUnknown
+	}
+}
+
+func hasVolume(volumes []VolumeResponse, destination string) bool {
+	for _, volume := range volumes {
+		if volume.Destination == destination {
+			return true
+		}
+	}
+	return false
+}
+
+func addStateMetadata(response *ContainerResponse, state *types.ContainerState) {
+	if state.Paused {
+		// ECS has no paused status, so by default paused containers are still reported as RUNNING
+		response.Paused = true
+		response.KnownStatus = utils.GetValue(response.KnownStatus, config.PausedStatusVar)
+	}
+	if state.Restarting {
+		// a restarting container is neither running nor stopped, and so is PENDING by default
+		response.KnownStatus = utils.GetValue(config.DefaultRestartingStatus, config.RestartingStatusVar)
+	}
+
+	startedAt, ok := parseDockerTime(state.StartedAt)
+	if !ok {
+		return
+	}
+	response.StartedAt = &startedAt
+
+	// Docker keeps the time the container last exited, so a finish time before the
+	// last start means that the container was restarted
+	finishedAt, ok := parseDockerTime(state.FinishedAt)
+	if ok && finishedAt.Before(startedAt) {
+		response.PreviousFinishedAt = &finishedAt
+	}
+
+	if ok && !state.Running && !state.Restarting {
+		// the container has exited since it was listed
+		response.KnownStatus = ecs.DesiredStatusStopped
+		response.FinishedAt = &finishedAt
+		exitCode := state.ExitCode
+		response.ExitCode = &exitCode
+	}
+}
+
+// getUptime returns the number of seconds since the container last started, or zero if it is not running
+func getUptime(state *types.ContainerState, startedAt *time.Time) *int64 {
+	var uptime int64
+	if state.Running && startedAt != nil {
+		uptime = int64(time.Since(*startedAt) / time.Second)
+	}
+	return &uptime
+}
+
+// getStartupLatency returns the number of milliseconds between when the container was created and when it last
+// started, or nil if it has not started
+func getStartupLatency(created, started string) *int64 {
+	createdAt, ok := parseDockerTime(created)
+	if !ok {
+		return nil
+	}
+	startedAt, ok := parseDockerTime(started)
+	if !ok || startedAt.Before(createdAt) {
+		return nil
+	}
+	latency := int64(startedAt.Sub(createdAt) / time.Millisecond)
+	return &latency
+}
+
+// parseDockerTime parses a timestamp from the Docker inspect API, which uses the zero time for unset values
+func parseDockerTime(value string) (time.Time, bool) {
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil || t.IsZero() {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// The Docker inspect response is made up of optional sections, any of which may be missing
+func getState(inspect *types.ContainerJSON) *types.ContainerState {
+	if inspect == nil || inspect.ContainerJSONBase == nil {
+		return nil
+	}
+	return inspect.State
+}
+
+func getConfig(inspect *types.ContainerJSON) *container.Config {
+	if inspect == nil {
+		return nil
+	}
+	return inspect.Config
+}
+
+func getNetworkSettings(inspect *types.ContainerJSON) *types.NetworkSettings {
+	if inspect == nil {
+		return nil
+	}
+	return inspect.NetworkSettings
+}
+
+func getHostConfig(inspect *types.ContainerJSON) *container.HostConfig {
+	if inspect == nil || inspect.ContainerJSONBase == nil {
+		return nil
+	}
+	return inspect.HostConfig
+}
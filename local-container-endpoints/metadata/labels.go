@@ -0,0 +1,232 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metadata
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	ecsLabelPrefix = "com.amazonaws.ecs."
+	// ecsContainerNameLabel is the label which the ECS Agent sets to the container's name in the Task Definition
+	ecsContainerNameLabel = ecsLabelPrefix + "container-name"
+	// composeContainerNumberLabel is the label which Docker Compose sets to the number of the service's replica
+	composeContainerNumberLabel = "com.docker.compose.container-number"
+	// imageSourceLabel sets the container's ImageSource, for setups which know where the image came from
+	imageSourceLabel = "ecs-local.image-source"
+	// defaultLabelGroup holds the labels without a namespace, when labels are grouped
+	defaultLabelGroup = "default"
+)
+
+// Values for ImageSource
+const (
+	// ImageSourceLocal is an image which was built or tagged locally
+	ImageSourceLocal = "local"
+	// ImageSourcePulled is an image which was pulled from a registry
+	ImageSourcePulled = "pulled"
+)
+
+// getImageSourceLabel returns the container's image source from its ecs-local.image-source label, if it is valid
+func getImageSourceLabel(dockerLabels map[string]string) string {
+	source, ok := dockerLabels[imageSourceLabel]
+	if !ok {
+		return ""
+	}
+	switch source {
+	case ImageSourceLocal, ImageSourcePulled:
+		return source
+	default:
+		logrus.Warnf("Ignoring invalid value for the %s label: %s", imageSourceLabel, source)
+		return ""
+	}
+}
+
+// addComposeLabels adds the Docker Compose service which the container belongs to, and which of the service's
+// replicas it is, from the labels which Docker Compose sets
+func addComposeLabels(response *ContainerResponse, dockerLabels map[string]string) {
+	response.ComposeService = dockerLabels[composeServiceLabel]
+	number, ok := dockerLabels[composeContainerNumberLabel]
+	if !ok {
+		return
+	}
+	containerNumber, err := strconv.Atoi(number)
+	if err != nil {
+		logrus.Warnf("Ignoring invalid value for the %s label: %s", composeContainerNumberLabel, number)
+		return
+	}
+	response.ComposeContainerNumber = containerNumber
+}
+
+// convertLabels returns the Docker labels which should be emitted in the metadata response
+func convertLabels(dockerLabels map[string]string) map[string]string {
+	switch mode := utils.GetValue(config.DefaultIncludeLabels, config.IncludeLabelsVar); mode {
+	case config.IncludeLabelsAll:
+		return dockerLabels
+	case config.IncludeLabelsNone:
+		return nil
+	case config.IncludeLabelsECS:
+		var labels map[string]string
+		for key, value := range dockerLabels {
+			if strings.HasPrefix(key, ecsLabelPrefix) {
+				if labels == nil {
+					labels = make(map[string]string)
+				}
+				labels[key] = value
+			}
+		}
+		return labels
+	default:
+		logrus.Warnf("Ignoring invalid value for %s: %s", config.IncludeLabelsVar, mode)
+		return dockerLabels
+	}
+}
+
+// filterLabelKeys returns only the labels whose keys are listed in ECS_LOCAL_LABEL_INCLUDE_KEYS, if it is set
+func filterLabelKeys(labels map[string]string) map[string]string {
+	includeKeys := utils.GetValue("", config.LabelIncludeKeysVar)
+	if includeKeys == "" {
+		return labels
+	}
+	var filtered map[string]string
+	for _, key := range strings.Split(includeKeys, ",") {
+		key = strings.TrimSpace(key)
+		if value, ok := labels[key]; ok {
+			if filtered == nil {
+				filtered = make(map[string]string)
+			}
+			filtered[key] = value
+		}
+	}
+	return filtered
+}
+
+// getAnnotations returns the labels with the ECS_LOCAL_ANNOTATION_LABEL_PREFIX prefix, keyed by the rest of the label,
+// for consumers of Kubernetes-style annotations
+func getAnnotations(dockerLabels map[string]string) map[string]string {
+	prefix := utils.GetValue("", config.AnnotationLabelPrefixVar)
+	if prefix == "" {
+		return nil
+	}
+	var annotations map[string]string
+	for key, value := range dockerLabels {
+		if name := strings.TrimPrefix(key, prefix); name != key && name != "" {
+			if annotations == nil {
+				annotations = make(map[string]string)
+			}
+			annotations[name] = value
+		}
+	}
+	return annotations
+}
+
+// getLabelsHash returns a hash of all of the container's Docker labels, which is the same for identical labels in any
+// order, so that consumers can detect changes to the labels without comparing them. Every label is hashed, even ones
+// which are not emitted, since otherwise changes to them would go unnoticed.
+func getLabelsHash(dockerLabels map[string]string) string {
+	keys := make([]string, 0, len(dockerLabels))
+	for key := range dockerLabels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	hash := sha256.New()
+	// each key and value is prefixed with its length, so that labels can't run into each other
+	length := make([]byte, 8)
+	for _, key := range keys {
+		for _, field := range []string{key, dockerLabels[key]} {
+			binary.BigEndian.PutUint64(length, uint64(len(field)))
+			hash.Write(length)
+			hash.Write([]byte(field))
+		}
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil))
+}
+
+// groupLabels returns the labels grouped by their namespace, the part of the key before the last dot, keyed by
+// the rest of the key. Each group is named after the last part of its namespace, so com.docker.compose.project is
+// project in the compose group, unless namespaces share that name, in which case their groups are named after the
+// whole namespace. Labels without a namespace are in the default group.
+func groupLabels(labels map[string]string) LabelGroupsResponse {
+	if len(labels) == 0 {
+		return nil
+	}
+
+	// the namespaces which share each short name
+	namespaces := make(map[string]map[string]bool)
+	for key := range labels {
+		namespace, _ := splitLabelKey(key)
+		short := namespace[strings.LastIndex(namespace, ".")+1:]
+		if namespaces[short] == nil {
+			namespaces[short] = make(map[string]bool)
+		}
+		namespaces[short][namespace] = true
+	}
+
+	groups := make(LabelGroupsResponse)
+	for key, value := range labels {
+		namespace, name := splitLabelKey(key)
+		group := namespace[strings.LastIndex(namespace, ".")+1:]
+		if len(namespaces[group]) > 1 {
+			group = namespace
+		}
+		if group == "" {
+			group = defaultLabelGroup
+		}
+		if groups[group] == nil {
+			groups[group] = make(map[string]string)
+		}
+		groups[group][name] = value
+	}
+	return groups
+}
+
+// splitLabelKey splits the label's key into its namespace, which is empty for keys without a dot, and its name
+func splitLabelKey(key string) (string, string) {
+	separator := strings.LastIndex(key, ".")
+	if separator < 0 {
+		return "", key
+	}
+	return key[:separator], key[separator+1:]
+}
+
+// limitLabels caps the number of labels at ECS_LOCAL_MAX_LABELS, since some build systems add
+// thousands of labels to containers. The labels which are kept are the first in key order,
+// and the second return value reports whether any were dropped.
+func limitLabels(labels map[string]string) (map[string]string, bool) {
+	maxLabels := utils.GetIntValue(0, config.MaxLabelsVar)
+	if maxLabels <= 0 || len(labels) <= maxLabels {
+		return labels, false
+	}
+
+	var keys []string
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	limited := make(map[string]string, maxLabels)
+	for _, key := range keys[:maxLabels] {
+		limited[key] = labels[key]
+	}
+	return limited, true
+}
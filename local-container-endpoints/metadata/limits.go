@@ -0,0 +1,234 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metadata
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strconv"
+
+	"github.com/aws/amazon-ecs-agent/agent/handlers/v2"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/go-units"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	composeServiceLabel = "com.docker.compose.service"
+
+	// ECS expresses CPU in CPU units, where 1024 units are one vCPU
+	cpuUnitsPerCPU = 1024
+	bytesPerMiB    = 1024 * 1024
+	nanoCPUsPerCPU = 1e9
+
+	gpuCapability   = "gpu"
+	gpuResourceType = "GPU"
+)
+
+// composeFile is the subset of the Compose file format that holds resource limits and reservations
+type composeFile struct {
+	Services map[string]composeService `json:"services"`
+}
+
+type composeService struct {
+	Deploy struct {
+		Resources struct {
+			Limits struct {
+				CPUs   json.Number `json:"cpus"`
+				Memory interface{} `json:"memory"`
+			} `json:"limits"`
+			Reservations struct {
+				Devices []composeDevice `json:"devices"`
+			} `json:"reservations"`
+		} `json:"resources"`
+	} `json:"deploy"`
+}
+
+// composeDevice is a device reservation; GPUs are reserved with the gpu capability
+type composeDevice struct {
+	Capabilities []string `json:"capabilities"`
+	// Count is either a number, or 'all'
+	Count     interface{} `json:"count"`
+	DeviceIDs []string    `json:"device_ids"`
+}
+
+// convertLimits returns the container limits, from the Docker Host Config if they were applied by Docker,
+// or from the Compose file if one was provided
+func convertLimits(dockerContainer *types.Container, inspect *types.ContainerJSON) v2.LimitsResponse {
+	var limits v2.LimitsResponse
+
+	if hostConfig := getHostConfig(inspect); hostConfig != nil {
+		if hostConfig.CPUShares != 0 {
+			cpu := float64(hostConfig.CPUShares)
+			limits.CPU = &cpu
+		} else if hostConfig.NanoCPUs != 0 {
+			cpu := float64(hostConfig.NanoCPUs) / nanoCPUsPerCPU * cpuUnitsPerCPU
+			limits.CPU = &cpu
+		}
+		if hostConfig.Memory != 0 {
+			memory := hostConfig.Memory / bytesPerMiB
+			limits.Memory = &memory
+		}
+	}
+
+	composeFilePath := os.Getenv(config.ComposeFileVar)
+	serviceName := dockerContainer.Labels[composeServiceLabel]
+	if composeFilePath == "" || serviceName == "" || (limits.CPU != nil && limits.Memory != nil) {
+		return limits
+	}
+
+	composeLimits, err := getComposeLimits(composeFilePath, serviceName)
+	if err != nil {
+		logrus.Warn(err)
+		return limits
+	}
+	if limits.CPU == nil {
+		limits.CPU = composeLimits.CPU
+	}
+	if limits.Memory == nil {
+		limits.Memory = composeLimits.Memory
+	}
+	return limits
+}
+
+// addGPUMetadata adds the GPUs reserved for the container's service in the Compose file, in the same format
+// as the resource requirements of ECS Task Definitions
+// The Docker API version which Local Endpoints uses does not report the GPUs which Docker assigned to the container
+func addGPUMetadata(response *ContainerResponse, dockerContainer *types.Container) {
+	composeFilePath := os.Getenv(config.ComposeFileVar)
+	serviceName := dockerContainer.Labels[composeServiceLabel]
+	if composeFilePath == "" || serviceName == "" {
+		return
+	}
+
+	service, err := readComposeService(composeFilePath, serviceName)
+	if err != nil {
+		logrus.Warn(err)
+		return
+	}
+	if service == nil {
+		return
+	}
+
+	gpus, gpuIDs := getComposeGPUs(service)
+	if gpus == 0 {
+		return
+	}
+	response.ResourceRequirements = []ResourceRequirementResponse{
+		{
+			Type:  gpuResourceType,
+			Value: strconv.Itoa(gpus),
+		},
+	}
+	response.GPUIDs = gpuIDs
+}
+
+// getComposeGPUs returns the number of GPUs reserved for the service, and their IDs if they were given
+func getComposeGPUs(service *composeService) (int, []string) {
+	gpus := 0
+	var gpuIDs []string
+	for _, device := range service.Deploy.Resources.Reservations.Devices {
+		if !hasCapability(device.Capabilities, gpuCapability) {
+			continue
+		}
+		if len(device.DeviceIDs) > 0 {
+			gpus += len(device.DeviceIDs)
+			gpuIDs = append(gpuIDs, device.DeviceIDs...)
+			continue
+		}
+		switch count := device.Count.(type) {
+		case float64:
+			gpus += int(count)
+		case string:
+			// 'all' reserves every GPU of the host, which Local Endpoints can not count
+			if n, err := strconv.Atoi(count); err == nil {
+				gpus += n
+			}
+		}
+	}
+	return gpus, gpuIDs
+}
+
+func hasCapability(capabilities []string, capability string) bool {
+	for _, c := range capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// readComposeService reads the service from the Compose file, or returns nil if the file has no such service
+// The file must be in JSON, which is a subset of YAML; 'docker compose config --format json' converts a Compose file to JSON
+func readComposeService(composeFilePath, serviceName string) (*composeService, error) {
+	data, err := ioutil.ReadFile(composeFilePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read compose file %s", composeFilePath)
+	}
+
+	compose := composeFile{}
+	if err = json.Unmarshal(data, &compose); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse compose file %s", composeFilePath)
+	}
+
+	service, ok := compose.Services[serviceName]
+	if !ok {
+		return nil, nil
+	}
+	return &service, nil
+}
+
+// getComposeLimits reads the deploy resource limits for the service from the Compose file
+func getComposeLimits(composeFilePath, serviceName string) (*v2.LimitsResponse, error) {
+	service, err := readComposeService(composeFilePath, serviceName)
+	if err != nil {
+		return nil, err
+	}
+	if service == nil {
+		return &v2.LimitsResponse{}, nil
+	}
+	composeLimits := service.Deploy.Resources.Limits
+
+	limits := &v2.LimitsResponse{}
+	if composeLimits.CPUs != "" {
+		cpus, err := composeLimits.CPUs.Float64()
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid cpus limit for service %s", serviceName)
+		}
+		cpu := cpus * cpuUnitsPerCPU
+		limits.CPU = &cpu
+	}
+
+	var memoryBytes int64
+	switch memory := composeLimits.Memory.(type) {
+	case float64:
+		memoryBytes = int64(memory)
+	case string:
+		// the Compose file format allows memory to be a byte count, or a size with units, like '50M'
+		if memoryBytes, err = strconv.ParseInt(memory, 10, 64); err != nil {
+			if memoryBytes, err = units.RAMInBytes(memory); err != nil {
+				return nil, errors.Wrapf(err, "invalid memory limit for service %s", serviceName)
+			}
+		}
+	}
+	if memoryBytes != 0 {
+		memory := memoryBytes / bytesPerMiB
+		limits.Memory = &memory
+	}
+
+	return limits, nil
+}
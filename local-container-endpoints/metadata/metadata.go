@@ -15,6 +15,9 @@
 package metadata
 
 import (
+	"fmt"
+	"os"
+	"sort"
 	"strings"
 	"time"
 
@@ -25,69 +28,201 @@ import (
 	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
 	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/utils"
 	"github.com/docker/docker/api/types"
+	"github.com/sirupsen/logrus"
 )
 
+// pullStoppedAtEpsilon is how long before the earliest container start the synthesized PullStoppedAt is
+const pullStoppedAtEpsilon = time.Millisecond
+
 // GetTaskMetadata returns the task metadata for the given containers
-func GetTaskMetadata(dockerContainers []types.Container, containerInstanceTags, taskTags map[string]string) *v2.TaskResponse {
+// containerInspects holds the Docker inspect response for each container, keyed by container ID
+func GetTaskMetadata(dockerContainers []types.Container, containerInspects map[string]*types.ContainerJSON, containerInstanceTags, taskTags map[string]string) *TaskResponse {
 	response := newLocalTaskResponse(containerInstanceTags, taskTags)
 	ecsContainers := response.Containers
 	for _, container := range dockerContainers {
-		ecsContainer := GetContainerMetadata(&container)
+		ecsContainer := GetContainerMetadata(&container, containerInspects[container.ID])
 		ecsContainers = append(ecsContainers, *ecsContainer)
 	}
 	response.Containers = ecsContainers
+	if len(dockerContainers) > 0 {
+		// all containers in a local 'task' share a task ARN
+		response.TaskARN = GetTaskARN(&dockerContainers[0])
+	}
+	if utils.GetBoolValue(false, config.SynthesizePullTimingsVar) {
+		synthesizePullTimings(response)
+	}
+	setTaskStoppedStatus(response)
 	return response
 }
 
+// setTaskStoppedStatus marks the task as STOPPED once all of its containers have stopped,
+// and sets ExecutionStoppedAt to when the last of them finished
+func setTaskStoppedStatus(response *TaskResponse) {
+	var lastFinish *time.Time
+	for _, container := range response.Containers {
+		if container.KnownStatus != ecs.DesiredStatusStopped || container.FinishedAt == nil {
+			return
+		}
+		if lastFinish == nil || container.FinishedAt.After(*lastFinish) {
+			lastFinish = container.FinishedAt
+		}
+	}
+	if lastFinish == nil {
+		return
+	}
+	response.KnownStatus = ecs.DesiredStatusStopped
+	response.ExecutionStoppedAt = lastFinish
+}
+
+// synthesizePullTimings sets PullStoppedAt to just before the earliest container start,
+// since images are always pulled before containers are started
+func synthesizePullTimings(response *TaskResponse) {
+	var earliestStart *time.Time
+	for _, container := range response.Containers {
+		if container.StartedAt != nil && (earliestStart == nil || container.StartedAt.Before(*earliestStart)) {
+			earliestStart = container.StartedAt
+		}
+	}
+	if earliestStart == nil {
+		return
+	}
+	pullStoppedAt := earliestStart.Add(-pullStoppedAtEpsilon)
+	response.PullStoppedAt = &pullStoppedAt
+}
+
 // GetContainerMetadata creates a container metadata response using info from the docker API,
-// with other values mocked
-func GetContainerMetadata(dockerContainer *types.Container) *v2.ContainerResponse {
+// with other values mocked. The inspect response is optional.
+func GetContainerMetadata(dockerContainer *types.Container, inspect *types.ContainerJSON) *ContainerResponse {
 	response := newLocalContainerResponse()
+	response.TaskARN = GetTaskARN(dockerContainer)
+	response.TaskRevision, _ = GetLabeledTaskRevision(dockerContainer)
 	response.ID = dockerContainer.ID
-	response.Name = getContainerName(dockerContainer)
+	response.Name = getECSContainerName(dockerContainer)
 	response.DockerName = getContainerName(dockerContainer)
 	response.Image = dockerContainer.Image
 	response.ImageID = dockerContainer.ImageID
 	response.Ports = convertPorts(dockerContainer.Ports)
-	response.Labels = dockerContainer.Labels
+	response.Labels, response.LabelsTruncated = limitLabels(filterLabelKeys(convertLabels(dockerContainer.Labels)))
+	response.Annotations = getAnnotations(dockerContainer.Labels)
+	if utils.GetBoolValue(false, config.IncludeLabelHashVar) {
+		labelsCount := len(dockerContainer.Labels)
+		response.LabelsHash = getLabelsHash(dockerContainer.Labels)
+		response.LabelsCount = &labelsCount
+	}
+	if utils.GetBoolValue(false, config.IncludeImageSourceVar) {
+		response.ImageSource = getImageSourceLabel(dockerContainer.Labels)
+	}
+	if utils.GetBoolValue(false, config.IncludeComposeLabelsVar) {
+		addComposeLabels(response, dockerContainer.Labels)
+	}
+	if utils.GetBoolValue(false, config.IncludeDockerLabelsAliasVar) {
+		// ECS Task Definitions call these 'dockerLabels', so some consumers look for them under that name
+		response.DockerLabels = response.Labels
+	}
+	if utils.GetBoolValue(false, config.GroupLabelsVar) {
+		response.LabelGroups = groupLabels(response.Labels)
+	}
 	createTime := time.Unix(dockerContainer.Created, 0)
 	response.CreatedAt = &createTime
-	// we can't know the actual start time, but we err on the side of having as many values in the response as possible
+	// without the inspect response we can't know the actual start time, but we err on the side of having as many values in the response as possible
 	response.StartedAt = response.CreatedAt
-	response.Networks = convertNetworks(dockerContainer.NetworkSettings)
+	for _, network := range dedupeNetworks(convertNetworks(dockerContainer.NetworkSettings)) {
+		response.Networks = append(response.Networks, NetworkResponse{
+			Network: network,
+		})
+	}
+	response.NetworkMode = getECSNetworkMode(dockerContainer.HostConfig.NetworkMode)
 	response.Volumes = convertVolumes(dockerContainer.Mounts)
+	response.Limits = convertLimits(dockerContainer, inspect)
+	addGPUMetadata(response, dockerContainer)
+	addInspectMetadata(response, inspect)
 
 	return response
 }
 
-func newLocalContainerResponse() *v2.ContainerResponse {
-	return &v2.ContainerResponse{
-		DesiredStatus: ecs.DesiredStatusRunning,
-		KnownStatus:   ecs.DesiredStatusRunning,
-		Type:          config.DefaultContainerType,
+// GetTaskARN returns the synthetic task ARN configured for the container in the container to task map,
+// or the default task ARN if the container is not mapped
+func GetTaskARN(dockerContainer *types.Container) string {
+	if taskARN, ok := GetMappedTaskARN(dockerContainer); ok {
+		return taskARN
 	}
+	return utils.GetValue(config.DefaultTaskARN, config.TaskARNVar)
 }
 
-func newLocalTaskResponse(containerInstanceTags, taskTags map[string]string) *v2.TaskResponse {
-	return &v2.TaskResponse{
-		Cluster:               utils.GetValue(config.DefaultClusterName, config.ClusterARNVar),
-		TaskARN:               utils.GetValue(config.DefaultTaskARN, config.TaskARNVar),
-		Family:                utils.GetValue(config.DefaultTDFamily, config.TDFamilyVar),
-		Revision:              utils.GetValue(config.DefaultTDRevision, config.TDRevisionVar),
-		DesiredStatus:         ecs.DesiredStatusRunning,
-		KnownStatus:           ecs.DesiredStatusRunning,
-		TaskTags:              taskTags,
-		ContainerInstanceTags: containerInstanceTags,
+// GetMappedTaskARN returns the task ARN that the container is mapped to, and whether it was mapped at all
+func GetMappedTaskARN(dockerContainer *types.Container) (string, bool) {
+	taskMapVal := os.Getenv(config.ContainerTaskMapVar)
+	if taskMapVal == "" {
+		return "", false
+	}
+	taskMap, err := utils.GetTagsMap(taskMapVal)
+	if err != nil {
+		logrus.Warnf("Ignoring %s: %s", config.ContainerTaskMapVar, err)
+		return "", false
 	}
+	taskARN, ok := taskMap[getContainerName(dockerContainer)]
+	return taskARN, ok
 }
 
-func convertVolumes(mounts []types.MountPoint) []v1.VolumeResponse {
-	var ecsVolumes []v1.VolumeResponse
+func newLocalContainerResponse() *ContainerResponse {
+	return &ContainerResponse{
+		ContainerResponse: v2.ContainerResponse{
+			DesiredStatus: ecs.DesiredStatusRunning,
+			KnownStatus:   ecs.DesiredStatusRunning,
+			Type:          config.DefaultContainerType,
+		},
+	}
+}
+
+func newLocalTaskResponse(containerInstanceTags, taskTags map[string]string) *TaskResponse {
+	return &TaskResponse{
+		TaskResponse: v2.TaskResponse{
+			Cluster:               utils.GetValue(config.DefaultClusterName, config.ClusterARNVar),
+			TaskARN:               utils.GetValue(config.DefaultTaskARN, config.TaskARNVar),
+			Family:                utils.GetValue(config.DefaultTDFamily, config.TDFamilyVar),
+			Revision:              utils.GetValue(config.DefaultTDRevision, config.TDRevisionVar),
+			AvailabilityZone:      getAvailabilityZone(),
+			DesiredStatus:         ecs.DesiredStatusRunning,
+			KnownStatus:           ecs.DesiredStatusRunning,
+			TaskTags:              taskTags,
+			ContainerInstanceTags: containerInstanceTags,
+		},
+		PlatformFamily:          os.Getenv(config.PlatformFamilyVar),
+		PlatformVersion:         os.Getenv(config.PlatformVersionVar),
+		DeploymentID:            os.Getenv(config.DeploymentIDVar),
+		VPCID:                   os.Getenv(config.VPCIDVar),
+		SubnetID:                os.Getenv(config.SubnetIDVar),
+		EphemeralStorageMetrics: getEphemeralStorageMetrics(),
+	}
+}
+
+// getEphemeralStorageMetrics returns the ephemeral storage configured with ECS_LOCAL_EPHEMERAL_STORAGE_GIB,
+// if it is a size which Fargate supports
+func getEphemeralStorageMetrics() *EphemeralStorageMetricsResponse {
+	sizeInGiB := utils.GetIntValue(0, config.EphemeralStorageGiBVar)
+	if sizeInGiB == 0 {
+		return nil
+	}
+	if sizeInGiB < config.MinEphemeralStorageGiB || sizeInGiB > config.MaxEphemeralStorageGiB {
+		logrus.Warnf("Ignoring invalid value for %s: %d; Fargate supports %d to %d GiB", config.EphemeralStorageGiBVar,
+			sizeInGiB, config.MinEphemeralStorageGiB, config.MaxEphemeralStorageGiB)
+		return nil
+	}
+	return &EphemeralStorageMetricsResponse{
+		Reserved: int64(sizeInGiB) * 1024,
+	}
+}
+
+func convertVolumes(mounts []types.MountPoint) []VolumeResponse {
+	var ecsVolumes []VolumeResponse
 	for _, mount := range mounts {
-		ecsVolumes = append(ecsVolumes, v1.VolumeResponse{
-			DockerName:  mount.Name,
-			Source:      mount.Source,
-			Destination: mount.Destination,
+		ecsVolumes = append(ecsVolumes, VolumeResponse{
+			VolumeResponse: v1.VolumeResponse{
+				DockerName:  mount.Name,
+				Source:      mount.Source,
+				Destination: mount.Destination,
+			},
+			Type: string(mount.Type),
 		})
 	}
 	return ecsVolumes
@@ -114,18 +249,85 @@ func convertNetworks(dockerNetworkSettings *types.SummaryNetworkSettings) []cont
 	return ecsNetworks
 }
 
-func convertPorts(dockerPorts []types.Port) []v1.PortResponse {
-	var ecsPorts []v1.PortResponse
+func convertPorts(dockerPorts []types.Port) []PortResponse {
+	var ecsPorts []PortResponse
 	for _, port := range dockerPorts {
-		ecsPorts = append(ecsPorts, v1.PortResponse{
-			ContainerPort: port.PrivatePort,
-			HostPort:      port.PublicPort,
-			Protocol:      port.Type,
+		ecsPorts = append(ecsPorts, PortResponse{
+			PortResponse: v1.PortResponse{
+				ContainerPort: port.PrivatePort,
+				HostPort:      port.PublicPort,
+				Protocol:      port.Type,
+			},
 		})
 	}
+	if utils.GetBoolValue(false, config.CollapsePortRangesVar) {
+		return collapsePortRanges(ecsPorts)
+	}
 	return ecsPorts
 }
 
+// collapsePortRanges replaces each run of contiguous bindings, such as those of a published range like 8000-8010,
+// with a single range. Docker lists a binding for each host IP, so duplicate bindings are dropped first.
+func collapsePortRanges(ports []PortResponse) []PortResponse {
+	var bindings []v1.PortResponse
+	seen := make(map[v1.PortResponse]bool)
+	for _, port := range ports {
+		if !seen[port.PortResponse] {
+			seen[port.PortResponse] = true
+			bindings = append(bindings, port.PortResponse)
+		}
+	}
+	sort.Slice(bindings, func(i, j int) bool {
+		if bindings[i].Protocol != bindings[j].Protocol {
+			return bindings[i].Protocol < bindings[j].Protocol
+		}
+		if bindings[i].ContainerPort != bindings[j].ContainerPort {
+			return bindings[i].ContainerPort < bindings[j].ContainerPort
+		}
+		return bindings[i].HostPort < bindings[j].HostPort
+	})
+
+	var collapsed []PortResponse
+	for start := 0; start < len(bindings); {
+		end := start
+		for end+1 < len(bindings) && isNextBinding(bindings[end], bindings[end+1]) {
+			end++
+		}
+		if end == start {
+			collapsed = append(collapsed, PortResponse{PortResponse: bindings[start]})
+		} else {
+			collapsed = append(collapsed, newPortRange(bindings[start], bindings[end]))
+		}
+		start = end + 1
+	}
+	return collapsed
+}
+
+// isNextBinding returns true if the binding continues the range which ends with the previous binding
+// Ports which are not published continue ranges of other ports which are not published
+func isNextBinding(previous, binding v1.PortResponse) bool {
+	if binding.Protocol != previous.Protocol || binding.ContainerPort != previous.ContainerPort+1 {
+		return false
+	}
+	if previous.HostPort == 0 {
+		return binding.HostPort == 0
+	}
+	return binding.HostPort == previous.HostPort+1
+}
+
+func newPortRange(first, last v1.PortResponse) PortResponse {
+	portRange := PortResponse{
+		PortResponse: v1.PortResponse{
+			Protocol: first.Protocol,
+		},
+		ContainerPortRange: fmt.Sprintf("%d-%d", first.ContainerPort, last.ContainerPort),
+	}
+	if first.HostPort != 0 {
+		portRange.HostPortRange = fmt.Sprintf("%d-%d", first.HostPort, last.HostPort)
+	}
+	return portRange
+}
+
 // Docker API returns a list of container names, each prefixed by a slash
 // This function returns the first name in the list, and removes the slash (which is not present in the ECS Metadata response)
 func getContainerName(dockerContainer *types.Container) string {
@@ -134,3 +336,33 @@ func getContainerName(dockerContainer *types.Container) string {
 	}
 	return ""
 }
+
+// getECSContainerName returns the container's name in the Task Definition, which the ECS Agent sets in the
+// com.amazonaws.ecs.container-name label, or else the Docker container name
+func getECSContainerName(dockerContainer *types.Container) string {
+	if name := dockerContainer.Labels[ecsContainerNameLabel]; name != "" {
+		return name
+	}
+	return getContainerName(dockerContainer)
+}
+
+// getAvailabilityZone returns ECS_LOCAL_AVAILABILITY_ZONE, or else the availability zone for AWS_REGION,
+// which is either set in ECS_LOCAL_REGION_AZ_MAP or is the region's first zone
+func getAvailabilityZone() string {
+	if az := os.Getenv(config.AvailabilityZoneVar); az != "" {
+		return az
+	}
+	region := os.Getenv(config.RegionVar)
+	if region == "" {
+		return ""
+	}
+	if azMap := os.Getenv(config.RegionAZMapVar); azMap != "" {
+		zones, err := utils.GetTagsMap(azMap)
+		if err != nil {
+			logrus.Warnf("Ignoring %s: %s", config.RegionAZMapVar, err)
+		} else if az, ok := zones[region]; ok {
+			return az
+		}
+	}
+	return region + "a"
+}
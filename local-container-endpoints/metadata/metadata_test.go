@@ -14,14 +14,28 @@
 package metadata
 
 import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	apicontainerstatus "github.com/aws/amazon-ecs-agent/agent/api/container/status"
+	"github.com/aws/amazon-ecs-agent/agent/containermetadata"
+	"github.com/aws/amazon-ecs-agent/agent/handlers/v1"
 	"github.com/aws/amazon-ecs-agent/agent/handlers/v2"
 	"github.com/aws/aws-sdk-go/service/ecs"
 	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
 	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/testingutils"
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/blkiodev"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/strslice"
+	"github.com/docker/go-units"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -33,17 +47,20 @@ const (
 	projectName   = "meow-zedong"
 	ipAddress     = "127.0.0.5"
 	containerID   = "c3439823c17dc7a35c7e272b7dc51cb2dcdedcef428242fcd0f5473d2c724d0"
+	containerID2  = "e18ab3d25b38c8b6a287831767b62475a79853dc38a0b92a98efabb20718c0d90"
 	containerName = "ecs-local-endpoints"
 )
 
 func TestnewLocalTaskResponseWithEnvVars(t *testing.T) {
-	expected := &v2.TaskResponse{
-		Cluster:       cluster,
-		TaskARN:       taskARN,
-		Family:        family,
-		Revision:      revision,
-		DesiredStatus: ecs.DesiredStatusRunning,
-		KnownStatus:   ecs.DesiredStatusRunning,
+	expected := &TaskResponse{
+		TaskResponse: v2.TaskResponse{
+			Cluster:       cluster,
+			TaskARN:       taskARN,
+			Family:        family,
+			Revision:      revision,
+			DesiredStatus: ecs.DesiredStatusRunning,
+			KnownStatus:   ecs.DesiredStatusRunning,
+		},
 	}
 
 	os.Setenv(config.ClusterARNVar, cluster)
@@ -66,6 +83,24 @@ func TestGetTaskMetadata(t *testing.T) {
 		WithComposeProject(projectName).
 		WithNetwork("bridge", ipAddress).
 		Get()
+	expectedVolumes := []VolumeResponse{
+		VolumeResponse{
+			VolumeResponse: expectedContainer.Volumes[0],
+		},
+	}
+	expectedContainer.Volumes = nil
+	expectedNetworks := []NetworkResponse{
+		NetworkResponse{
+			Network: expectedContainer.Networks[0],
+		},
+	}
+	expectedContainer.Networks = nil
+	expectedPorts := []PortResponse{
+		PortResponse{
+			PortResponse: expectedContainer.Ports[0],
+		},
+	}
+	expectedContainer.Ports = nil
 
 	taskTags := map[string]string{
 		"task": "tags",
@@ -74,20 +109,2151 @@ func TestGetTaskMetadata(t *testing.T) {
 		"containerInstance": "tags",
 	}
 
-	expected := &v2.TaskResponse{
-		TaskTags:              taskTags,
-		ContainerInstanceTags: containerInstanceTags,
-		Cluster:               config.DefaultClusterName,
-		TaskARN:               config.DefaultTaskARN,
-		Family:                config.DefaultTDFamily,
-		Revision:              config.DefaultTDRevision,
-		DesiredStatus:         ecs.DesiredStatusRunning,
-		KnownStatus:           ecs.DesiredStatusRunning,
-		Containers: []v2.ContainerResponse{
-			expectedContainer,
+	expected := &TaskResponse{
+		TaskResponse: v2.TaskResponse{
+			TaskTags:              taskTags,
+			ContainerInstanceTags: containerInstanceTags,
+			Cluster:               config.DefaultClusterName,
+			TaskARN:               config.DefaultTaskARN,
+			Family:                config.DefaultTDFamily,
+			Revision:              config.DefaultTDRevision,
+			DesiredStatus:         ecs.DesiredStatusRunning,
+			KnownStatus:           ecs.DesiredStatusRunning,
+		},
+		Containers: []ContainerResponse{
+			ContainerResponse{
+				ContainerResponse: expectedContainer,
+				Networks:          expectedNetworks,
+				Ports:             expectedPorts,
+				TaskARN:           config.DefaultTaskARN,
+				Volumes:           expectedVolumes,
+			},
 		},
 	}
 
-	actual := GetTaskMetadata([]types.Container{dockerContainer}, containerInstanceTags, taskTags)
+	actual := GetTaskMetadata([]types.Container{dockerContainer}, nil, containerInstanceTags, taskTags)
 	assert.Equal(t, expected, actual, "Expected task response to match")
 }
+
+func TestGetTaskMetadataAvailabilityZone(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		env      map[string]string
+		expected string
+	}{
+		{
+			name:     "no region",
+			expected: "",
+		},
+		{
+			name: "region",
+			env: map[string]string{
+				config.RegionVar: "us-east-1",
+			},
+			expected: "us-east-1a",
+		},
+		{
+			name: "mapped region",
+			env: map[string]string{
+				config.RegionVar:      "us-east-1",
+				config.RegionAZMapVar: "us-east-1=us-east-1c,us-west-2=us-west-2b",
+			},
+			expected: "us-east-1c",
+		},
+		{
+			name: "override",
+			env: map[string]string{
+				config.RegionVar:           "us-east-1",
+				config.RegionAZMapVar:      "us-east-1=us-east-1c",
+				config.AvailabilityZoneVar: "eu-west-1b",
+			},
+			expected: "eu-west-1b",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			for key, value := range testCase.env {
+				os.Setenv(key, value)
+				defer os.Unsetenv(key)
+			}
+
+			actual := GetTaskMetadata(nil, nil, nil, nil)
+			assert.Equal(t, testCase.expected, actual.AvailabilityZone, "Expected availability zone to match")
+		})
+	}
+}
+
+func TestGetTaskMetadataPlatform(t *testing.T) {
+	actual := GetTaskMetadata(nil, nil, nil, nil)
+	fields := marshalInTest(t, actual)
+	assert.NotContains(t, fields, "PlatformFamily", "Expected no platform family by default")
+	assert.NotContains(t, fields, "PlatformVersion", "Expected no platform version by default")
+
+	os.Setenv(config.PlatformFamilyVar, "Linux")
+	defer os.Unsetenv(config.PlatformFamilyVar)
+	os.Setenv(config.PlatformVersionVar, "1.4.0")
+	defer os.Unsetenv(config.PlatformVersionVar)
+
+	actual = GetTaskMetadata(nil, nil, nil, nil)
+	fields = marshalInTest(t, actual)
+	assert.Equal(t, "Linux", fields["PlatformFamily"], "Expected platform family to match")
+	assert.Equal(t, "1.4.0", fields["PlatformVersion"], "Expected platform version to match")
+}
+
+func TestGetTaskMetadataDeploymentID(t *testing.T) {
+	actual := GetTaskMetadata(nil, nil, nil, nil)
+	fields := marshalInTest(t, actual)
+	assert.NotContains(t, fields, "DeploymentId", "Expected no deployment ID by default")
+
+	os.Setenv(config.DeploymentIDVar, "ecs-svc/1234567890123456789")
+	defer os.Unsetenv(config.DeploymentIDVar)
+
+	actual = GetTaskMetadata(nil, nil, nil, nil)
+	fields = marshalInTest(t, actual)
+	assert.Equal(t, "ecs-svc/1234567890123456789", fields["DeploymentId"], "Expected deployment ID to match")
+}
+
+func TestGetTaskMetadataVPC(t *testing.T) {
+	actual := GetTaskMetadata(nil, nil, nil, nil)
+	fields := marshalInTest(t, actual)
+	assert.NotContains(t, fields, "VPCID", "Expected no VPC ID by default")
+	assert.NotContains(t, fields, "SubnetId", "Expected no subnet ID by default")
+
+	os.Setenv(config.VPCIDVar, "vpc-0a1b2c3d4e5f67890")
+	defer os.Unsetenv(config.VPCIDVar)
+	os.Setenv(config.SubnetIDVar, "subnet-0123456789abcdef0")
+	defer os.Unsetenv(config.SubnetIDVar)
+
+	actual = GetTaskMetadata(nil, nil, nil, nil)
+	fields = marshalInTest(t, actual)
+	assert.Equal(t, "vpc-0a1b2c3d4e5f67890", fields["VPCID"], "Expected VPC ID to match")
+	assert.Equal(t, "subnet-0123456789abcdef0", fields["SubnetId"], "Expected subnet ID to match")
+}
+
+func TestGetTaskMetadataEphemeralStorage(t *testing.T) {
+	actual := GetTaskMetadata(nil, nil, nil, nil)
+	assert.Nil(t, actual.EphemeralStorageMetrics, "Expected no ephemeral storage by default")
+
+	var testCases = []struct {
+		sizeInGiB string
+		expected  *EphemeralStorageMetricsResponse
+	}{
+		{
+			sizeInGiB: "20",
+			expected: &EphemeralStorageMetricsResponse{
+				Reserved: 20480,
+			},
+		},
+		{
+			sizeInGiB: "200",
+			expected: &EphemeralStorageMetricsResponse{
+				Reserved: 204800,
+			},
+		},
+		{
+			sizeInGiB: "19",
+			expected:  nil,
+		},
+		{
+			sizeInGiB: "201",
+			expected:  nil,
+		},
+		{
+			sizeInGiB: "lots",
+			expected:  nil,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.sizeInGiB, func(t *testing.T) {
+			os.Setenv(config.EphemeralStorageGiBVar, testCase.sizeInGiB)
+			defer os.Unsetenv(config.EphemeralStorageGiBVar)
+
+			actual := GetTaskMetadata(nil, nil, nil, nil)
+			assert.Equal(t, testCase.expected, actual.EphemeralStorageMetrics, "Expected ephemeral storage to match")
+		})
+	}
+}
+
+func TestGetTaskMetadataStoppedTask(t *testing.T) {
+	container1 := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	container2 := testingutils.BaseDockerContainer("sidecar", containerID2).WithNetwork("bridge", ipAddress).Get()
+	inspect1 := testingutils.BaseDockerInspect(containerName, containerID).Get()
+	inspect2 := testingutils.BaseDockerInspect("sidecar", containerID2).Get()
+	for _, inspect := range []*types.ContainerJSON{inspect1, inspect2} {
+		inspect.State.StartedAt = "2019-03-01T20:55:11.064236631Z"
+	}
+	inspects := map[string]*types.ContainerJSON{
+		containerID:  inspect1,
+		containerID2: inspect2,
+	}
+
+	actual := GetTaskMetadata([]types.Container{container1, container2}, inspects, nil, nil)
+	assert.Equal(t, ecs.DesiredStatusRunning, actual.KnownStatus, "Expected the task to be RUNNING")
+	assert.Nil(t, actual.ExecutionStoppedAt, "Expected no ExecutionStoppedAt while the task is running")
+
+	// only one container has stopped
+	inspect1.State.Running = false
+	inspect1.State.Status = "exited"
+	inspect1.State.FinishedAt = "2019-03-01T21:00:00Z"
+	inspect1.State.ExitCode = 1
+
+	actual = GetTaskMetadata([]types.Container{container1, container2}, inspects, nil, nil)
+	assert.Equal(t, ecs.DesiredStatusRunning, actual.KnownStatus, "Expected the task to be RUNNING")
+	assert.Nil(t, actual.ExecutionStoppedAt, "Expected no ExecutionStoppedAt while the task is running")
+	assert.Equal(t, ecs.DesiredStatusStopped, actual.Containers[0].KnownStatus, "Expected the container to be STOPPED")
+	if assert.NotNil(t, actual.Containers[0].ExitCode, "Expected the exit code of the stopped container") {
+		assert.Equal(t, 1, *actual.Containers[0].ExitCode, "Expected the exit code to match")
+	}
+
+	inspect2.State.Running = false
+	inspect2.State.Status = "exited"
+	inspect2.State.FinishedAt = "2019-03-01T21:05:00Z"
+
+	actual = GetTaskMetadata([]types.Container{container1, container2}, inspects, nil, nil)
+	assert.Equal(t, ecs.DesiredStatusStopped, actual.KnownStatus, "Expected the task to be STOPPED")
+	expectedStoppedAt, _ := time.Parse(time.RFC3339, inspect2.State.FinishedAt)
+	if assert.NotNil(t, actual.ExecutionStoppedAt, "Expected ExecutionStoppedAt to be set") {
+		assert.True(t, expectedStoppedAt.Equal(*actual.ExecutionStoppedAt), "Expected ExecutionStoppedAt to be when the last container finished")
+	}
+}
+
+func TestGetTaskMetadataWithTaskMap(t *testing.T) {
+	mappedContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	unmappedContainer := testingutils.BaseDockerContainer("unmapped", containerID2).WithNetwork("bridge", ipAddress).Get()
+
+	os.Setenv(config.ContainerTaskMapVar, fmt.Sprintf("%s=%s", containerName, taskARN))
+	defer os.Unsetenv(config.ContainerTaskMapVar)
+
+	mappedTask := GetTaskMetadata([]types.Container{mappedContainer}, nil, nil, nil)
+	assert.Equal(t, taskARN, mappedTask.TaskARN, "Expected task ARN to be the mapped task ARN")
+	assert.Equal(t, taskARN, mappedTask.Containers[0].TaskARN, "Expected container task ARN to be the mapped task ARN")
+
+	unmappedTask := GetTaskMetadata([]types.Container{unmappedContainer}, nil, nil, nil)
+	assert.Equal(t, config.DefaultTaskARN, unmappedTask.TaskARN, "Expected task ARN to be the default task ARN")
+	assert.Equal(t, config.DefaultTaskARN, unmappedTask.Containers[0].TaskARN, "Expected container task ARN to be the default task ARN")
+}
+
+func TestGetTaskMetadataSynthesizePullTimings(t *testing.T) {
+	container1 := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	container2 := testingutils.BaseDockerContainer("sidecar", containerID2).WithNetwork("bridge", ipAddress).Get()
+	inspect1 := testingutils.BaseDockerInspect(containerName, containerID).Get()
+	inspect1.State.StartedAt = "2019-03-01T20:55:12Z"
+	inspect2 := testingutils.BaseDockerInspect("sidecar", containerID2).Get()
+	inspect2.State.StartedAt = "2019-03-01T20:55:11Z"
+	containers := []types.Container{container1, container2}
+	inspects := map[string]*types.ContainerJSON{
+		containerID:  inspect1,
+		containerID2: inspect2,
+	}
+
+	actual := GetTaskMetadata(containers, inspects, nil, nil)
+	assert.Nil(t, actual.PullStoppedAt, "Expected no PullStoppedAt by default")
+
+	os.Setenv(config.SynthesizePullTimingsVar, "true")
+	defer os.Unsetenv(config.SynthesizePullTimingsVar)
+
+	actual = GetTaskMetadata(containers, inspects, nil, nil)
+	if assert.NotNil(t, actual.PullStoppedAt, "Expected PullStoppedAt to be set") {
+		for _, container := range actual.Containers {
+			assert.True(t, actual.PullStoppedAt.Before(*container.StartedAt), "Expected PullStoppedAt to be before every container start")
+		}
+		earliestStart, _ := time.Parse(time.RFC3339, inspect2.State.StartedAt)
+		assert.True(t, earliestStart.Sub(*actual.PullStoppedAt) < time.Second, "Expected PullStoppedAt to be just before the earliest container start")
+	}
+}
+
+func TestGetContainerMetadataWithNetworkAliases(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).
+		WithNetwork("frontend", ipAddress).
+		WithNetwork("backend", "172.18.0.2").
+		Get()
+	inspect := testingutils.BaseDockerInspect(containerName, containerID).Get()
+	inspect.NetworkSettings.Networks["frontend"] = &network.EndpointSettings{
+		Aliases: []string{"web", "www"},
+	}
+
+	actual := GetContainerMetadata(&dockerContainer, inspect)
+	aliases := make(map[string][]string)
+	for _, containerNetwork := range actual.Networks {
+		aliases[containerNetwork.NetworkMode] = containerNetwork.Aliases
+	}
+	expected := map[string][]string{
+		"frontend": []string{"web", "www"},
+		"backend":  nil,
+	}
+	assert.Equal(t, expected, aliases, "Expected the aliases of each network to match")
+
+	serialized := marshalInTest(t, actual)
+	for _, containerNetwork := range serialized["Networks"].([]interface{}) {
+		networkMode := containerNetwork.(map[string]interface{})["NetworkMode"]
+		assert.NotEmpty(t, networkMode, "Expected the ECS Agent's network fields")
+	}
+}
+
+func TestGetContainerMetadataWithMACAddress(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).
+		WithNetwork("frontend", ipAddress).
+		WithNetwork("backend", "172.18.0.2").
+		Get()
+	inspect := testingutils.BaseDockerInspect(containerName, containerID).Get()
+	inspect.NetworkSettings.Networks["frontend"] = &network.EndpointSettings{
+		MacAddress: "02:42:ac:11:00:02",
+	}
+
+	actual := GetContainerMetadata(&dockerContainer, inspect)
+	macAddresses := make(map[string]string)
+	for _, containerNetwork := range actual.Networks {
+		macAddresses[containerNetwork.NetworkMode] = containerNetwork.MACAddress
+	}
+	expected := map[string]string{
+		"frontend": "02:42:ac:11:00:02",
+		"backend":  "",
+	}
+	assert.Equal(t, expected, macAddresses, "Expected the MAC address on each network to match")
+
+	serialized := marshalInTest(t, actual)
+	for _, containerNetwork := range serialized["Networks"].([]interface{}) {
+		assert.Contains(t, containerNetwork, "MACAddress", "Expected the MAC address to be emitted even when unavailable")
+	}
+}
+
+func TestGetContainerMetadataLabelsHash(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithComposeProject(projectName).WithNetwork("bridge", ipAddress).Get()
+
+	actual := GetContainerMetadata(&dockerContainer, nil)
+	assert.Empty(t, actual.LabelsHash, "Expected no labels hash by default")
+	assert.Nil(t, actual.LabelsCount, "Expected no labels count by default")
+
+	os.Setenv(config.IncludeLabelHashVar, "true")
+	defer os.Unsetenv(config.IncludeLabelHashVar)
+
+	actual = GetContainerMetadata(&dockerContainer, nil)
+	assert.Regexp(t, "^sha256:[0-9a-f]{64}$", actual.LabelsHash, "Expected the hash of the labels")
+	if assert.NotNil(t, actual.LabelsCount, "Expected the labels count") {
+		assert.Equal(t, len(dockerContainer.Labels), *actual.LabelsCount, "Expected labels count to match")
+	}
+
+	// identical labels, in a new map
+	identical := testingutils.BaseDockerContainer(containerName, containerID).WithComposeProject(projectName).WithNetwork("bridge", ipAddress).Get()
+	assert.Equal(t, actual.LabelsHash, GetContainerMetadata(&identical, nil).LabelsHash, "Expected the hash to be stable for identical labels")
+
+	changed := testingutils.BaseDockerContainer(containerName, containerID).WithComposeProject("other-project").WithNetwork("bridge", ipAddress).Get()
+	assert.NotEqual(t, actual.LabelsHash, GetContainerMetadata(&changed, nil).LabelsHash, "Expected the hash to change with a label's value")
+
+	changed.Labels["version"] = "2"
+	changedResponse := GetContainerMetadata(&changed, nil)
+	assert.Equal(t, len(dockerContainer.Labels)+1, *changedResponse.LabelsCount, "Expected the added label to be counted")
+
+	// labels which are not emitted are still hashed
+	os.Setenv(config.IncludeLabelsVar, config.IncludeLabelsNone)
+	defer os.Unsetenv(config.IncludeLabelsVar)
+	assert.Equal(t, actual.LabelsHash, GetContainerMetadata(&dockerContainer, nil).LabelsHash, "Expected the hash of all labels")
+}
+
+func TestGetLabelsHashBoundaries(t *testing.T) {
+	// moving characters between a key and its value changes the hash
+	assert.NotEqual(t, getLabelsHash(map[string]string{"ab": "c"}), getLabelsHash(map[string]string{"a": "bc"}), "Expected the hash to differ")
+	assert.Equal(t, getLabelsHash(nil), getLabelsHash(map[string]string{}), "Expected no labels to have the same hash")
+}
+
+func TestGetContainerMetadataHostname(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	inspect := testingutils.BaseDockerInspect(containerName, containerID).Get()
+	// --hostname web --domainname example.internal
+	inspect.Config.Hostname = "web"
+	inspect.Config.Domainname = "example.internal"
+
+	actual := GetContainerMetadata(&dockerContainer, inspect)
+	assert.Equal(t, "web", actual.Hostname, "Expected hostname to match")
+	assert.Equal(t, "example.internal", actual.Domainname, "Expected domain name to match")
+	assert.Equal(t, "web.example.internal", actual.FQDN, "Expected the FQDN to be composed of the hostname and domain name")
+
+	inspect.Config.Domainname = ""
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	assert.Equal(t, "web", actual.Hostname, "Expected hostname to match")
+	assert.Empty(t, actual.FQDN, "Expected no FQDN without a domain name")
+}
+
+func TestGetContainerMetadataCollapsePortRanges(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	// -p 8000-8002:8000-8002 -p 8443:443 -p 53:53/udp --expose 9000-9001, listed in Docker's order,
+	// with a binding of the published range for both the IPv4 and IPv6 wildcard addresses
+	dockerContainer.Ports = []types.Port{
+		{IP: "0.0.0.0", PrivatePort: 8001, PublicPort: 8001, Type: "tcp"},
+		{IP: "::", PrivatePort: 8001, PublicPort: 8001, Type: "tcp"},
+		{PrivatePort: 9001, Type: "tcp"},
+		{IP: "0.0.0.0", PrivatePort: 8000, PublicPort: 8000, Type: "tcp"},
+		{IP: "::", PrivatePort: 8000, PublicPort: 8000, Type: "tcp"},
+		{IP: "0.0.0.0", PrivatePort: 443, PublicPort: 8443, Type: "tcp"},
+		{IP: "0.0.0.0", PrivatePort: 53, PublicPort: 53, Type: "udp"},
+		{PrivatePort: 9000, Type: "tcp"},
+		{IP: "0.0.0.0", PrivatePort: 8002, PublicPort: 8002, Type: "tcp"},
+		{IP: "::", PrivatePort: 8002, PublicPort: 8002, Type: "tcp"},
+	}
+
+	actual := GetContainerMetadata(&dockerContainer, nil)
+	assert.Len(t, actual.Ports, len(dockerContainer.Ports), "Expected a port for each binding by default")
+
+	os.Setenv(config.CollapsePortRangesVar, "true")
+	defer os.Unsetenv(config.CollapsePortRangesVar)
+
+	actual = GetContainerMetadata(&dockerContainer, nil)
+	expected := []PortResponse{
+		{
+			PortResponse: v1.PortResponse{ContainerPort: 443, HostPort: 8443, Protocol: "tcp"},
+		},
+		{
+			PortResponse:       v1.PortResponse{Protocol: "tcp"},
+			ContainerPortRange: "8000-8002",
+			HostPortRange:      "8000-8002",
+		},
+		{
+			PortResponse:       v1.PortResponse{Protocol: "tcp"},
+			ContainerPortRange: "9000-9001",
+		},
+		{
+			PortResponse: v1.PortResponse{ContainerPort: 53, HostPort: 53, Protocol: "udp"},
+		},
+	}
+	assert.Equal(t, expected, actual.Ports, "Expected contiguous bindings to be collapsed into ranges")
+
+	serialized := marshalInTest(t, actual)
+	portRange := serialized["Ports"].([]interface{})[1].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{
+		"ContainerPortRange": "8000-8002",
+		"HostPortRange":      "8000-8002",
+		"Protocol":           "tcp",
+	}, portRange, "Expected the range to replace the container and host ports")
+}
+
+func TestCollapsePortRangesDiscontiguousHostPorts(t *testing.T) {
+	// contiguous container ports which are published to discontiguous host ports are not a range
+	ports := []PortResponse{
+		{PortResponse: v1.PortResponse{ContainerPort: 80, HostPort: 8080, Protocol: "tcp"}},
+		{PortResponse: v1.PortResponse{ContainerPort: 81, HostPort: 9090, Protocol: "tcp"}},
+		{PortResponse: v1.PortResponse{ContainerPort: 82, Protocol: "tcp"}},
+	}
+	assert.Equal(t, ports, collapsePortRanges(ports), "Expected the bindings to be kept")
+}
+
+func TestAddImageSource(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	created := time.Unix(dockerContainer.Created, 0)
+	repoDigests := []string{"nginx@sha256:0fd68ec4b64b8dbb2bef1f1a5de9d47b658afd3635dc9c45bf0cbeac46e72101"}
+
+	var testCases = []struct {
+		name     string
+		image    *types.ImageInspect
+		expected string
+	}{
+		{"image which could not be inspected", nil, ""},
+		{"image without repo digests", &types.ImageInspect{}, ImageSourceLocal},
+		{"pulled image", &types.ImageInspect{RepoDigests: repoDigests}, ImageSourcePulled},
+		{
+			"pulled image which was tagged before the container was created",
+			&types.ImageInspect{RepoDigests: repoDigests, Metadata: types.ImageMetadata{LastTagTime: created.Add(-time.Hour)}},
+			ImageSourceLocal,
+		},
+		{
+			"pulled image which was tagged after the container was created",
+			&types.ImageInspect{RepoDigests: repoDigests, Metadata: types.ImageMetadata{LastTagTime: created.Add(time.Hour)}},
+			ImageSourcePulled,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			response := GetContainerMetadata(&dockerContainer, nil)
+			AddImageSource(response, test.image)
+			assert.Equal(t, test.expected, response.ImageSource, "Expected image source to match")
+		})
+	}
+}
+
+func TestGetContainerMetadataImageSourceLabel(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	dockerContainer.Labels = map[string]string{
+		"ecs-local.image-source": "pulled",
+	}
+
+	actual := GetContainerMetadata(&dockerContainer, nil)
+	assert.Empty(t, actual.ImageSource, "Expected no image source by default")
+
+	os.Setenv(config.IncludeImageSourceVar, "true")
+	defer os.Unsetenv(config.IncludeImageSourceVar)
+
+	actual = GetContainerMetadata(&dockerContainer, nil)
+	AddImageSource(actual, &types.ImageInspect{})
+	assert.Equal(t, ImageSourcePulled, actual.ImageSource, "Expected the label to take precedence over the image")
+
+	dockerContainer.Labels["ecs-local.image-source"] = "cached"
+	actual = GetContainerMetadata(&dockerContainer, nil)
+	assert.Empty(t, actual.ImageSource, "Expected an invalid label to be ignored")
+}
+
+func TestAddImageRepoDigest(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	response := GetContainerMetadata(&dockerContainer, nil)
+
+	AddImageRepoDigest(response, nil)
+	assert.Empty(t, response.ImageRepoDigest, "Expected no repo digest when the image could not be inspected")
+
+	AddImageRepoDigest(response, &types.ImageInspect{})
+	assert.Empty(t, response.ImageRepoDigest, "Expected no repo digest for an image which was built locally")
+
+	AddImageRepoDigest(response, &types.ImageInspect{
+		RepoTags: []string{"nginx:latest"},
+		RepoDigests: []string{
+			"nginx@sha256:0fd68ec4b64b8dbb2bef1f1a5de9d47b658afd3635dc9c45bf0cbeac46e72101",
+			"registry.example.com/nginx@sha256:0fd68ec4b64b8dbb2bef1f1a5de9d47b658afd3635dc9c45bf0cbeac46e72101",
+		},
+	})
+	assert.Equal(t, "nginx@sha256:0fd68ec4b64b8dbb2bef1f1a5de9d47b658afd3635dc9c45bf0cbeac46e72101", response.ImageRepoDigest, "Expected the first repo digest")
+}
+
+func TestDedupeNetworks(t *testing.T) {
+	networks := []containermetadata.Network{
+		containermetadata.Network{
+			NetworkMode:   "overlay",
+			IPv4Addresses: []string{"10.0.1.5"},
+		},
+		containermetadata.Network{
+			NetworkMode:   "overlay",
+			IPv4Addresses: []string{"10.0.1.5"},
+		},
+		containermetadata.Network{
+			NetworkMode:   "overlay",
+			IPv4Addresses: []string{"10.0.1.6"},
+		},
+		containermetadata.Network{
+			NetworkMode:   "bridge",
+			IPv4Addresses: []string{"10.0.1.5"},
+		},
+	}
+
+	expected := []containermetadata.Network{
+		networks[0],
+		networks[2],
+		networks[3],
+	}
+	assert.Equal(t, expected, dedupeNetworks(networks), "Expected duplicate networks to be removed")
+}
+
+func TestAddTaskNetworksSharedNetworkNamespace(t *testing.T) {
+	// containers which share a network namespace, for example with network_mode: service:app in Compose
+	container1 := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("frontend", ipAddress).Get()
+	container2 := testingutils.BaseDockerContainer("sidecar", containerID2).WithNetwork("frontend", ipAddress).Get()
+
+	os.Setenv(config.TaskNetworkStrategyVar, config.TaskNetworkAll)
+	defer os.Unsetenv(config.TaskNetworkStrategyVar)
+
+	actual := GetTaskMetadata([]types.Container{container1, container2}, nil, nil, nil)
+	AddTaskNetworks(actual, "")
+	expected := []containermetadata.Network{
+		containermetadata.Network{
+			NetworkMode:   "frontend",
+			IPv4Addresses: []string{ipAddress},
+		},
+	}
+	assert.Equal(t, expected, actual.Networks, "Expected each address to be listed once")
+}
+
+func TestAddTaskNetworks(t *testing.T) {
+	container1 := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("frontend", ipAddress).Get()
+	container2 := testingutils.BaseDockerContainer("sidecar", containerID2).WithNetwork("backend", "127.0.0.6").WithNetwork("frontend", "127.0.0.7").Get()
+	containers := []types.Container{container1, container2}
+
+	var testCases = []struct {
+		strategy         string
+		primaryContainer string
+		expected         []containermetadata.Network
+	}{
+		{
+			strategy: "",
+			expected: nil,
+		},
+		{
+			strategy:         config.TaskNetworkPrimary,
+			primaryContainer: containerID2,
+			expected: []containermetadata.Network{
+				containermetadata.Network{
+					NetworkMode:   "backend",
+					IPv4Addresses: []string{"127.0.0.6"},
+				},
+				containermetadata.Network{
+					NetworkMode:   "frontend",
+					IPv4Addresses: []string{"127.0.0.7"},
+				},
+			},
+		},
+		{
+			// without a known primary container, the first container is used
+			strategy: config.TaskNetworkPrimary,
+			expected: []containermetadata.Network{
+				containermetadata.Network{
+					NetworkMode:   "frontend",
+					IPv4Addresses: []string{ipAddress},
+				},
+			},
+		},
+		{
+			strategy: config.TaskNetworkAll,
+			expected: []containermetadata.Network{
+				containermetadata.Network{
+					NetworkMode:   "backend",
+					IPv4Addresses: []string{"127.0.0.6"},
+				},
+				containermetadata.Network{
+					NetworkMode:   "frontend",
+					IPv4Addresses: []string{ipAddress, "127.0.0.7"},
+				},
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.strategy, func(t *testing.T) {
+			os.Setenv(config.TaskNetworkStrategyVar, testCase.strategy)
+			defer os.Unsetenv(config.TaskNetworkStrategyVar)
+
+			actual := GetTaskMetadata(containers, nil, nil, nil)
+			AddTaskNetworks(actual, testCase.primaryContainer)
+			// Docker does not order a container's networks
+			assert.ElementsMatch(t, testCase.expected, actual.Networks, "Expected task networks to match")
+		})
+	}
+}
+
+func TestMergeNetworks(t *testing.T) {
+	container1 := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("frontend", ipAddress).Get()
+	container2 := testingutils.BaseDockerContainer("sidecar", containerID2).WithNetwork("frontend", "127.0.0.7").Get()
+	container3 := testingutils.BaseDockerContainer("backend", containerID2).WithNetwork("backend", "127.0.0.6").Get()
+	task := GetTaskMetadata([]types.Container{container1, container2, container3}, nil, nil, nil)
+
+	expected := []containermetadata.Network{
+		containermetadata.Network{
+			NetworkMode:   "frontend",
+			IPv4Addresses: []string{ipAddress, "127.0.0.7"},
+		},
+		containermetadata.Network{
+			NetworkMode:   "backend",
+			IPv4Addresses: []string{"127.0.0.6"},
+		},
+	}
+	assert.Equal(t, expected, mergeNetworks(task.Containers), "Expected each network to be listed once with all addresses")
+}
+
+func TestIncrementRevision(t *testing.T) {
+	stateDir, err := ioutil.TempDir("", "ecs-local")
+	assert.NoError(t, err, "Unexpected error creating state directory")
+	defer os.RemoveAll(stateDir)
+	stateFile := filepath.Join(stateDir, "revision")
+
+	os.Setenv(config.TDRevisionVar, "5")
+	defer os.Unsetenv(config.TDRevisionVar)
+
+	// each call simulates a restart of Local Endpoints
+	for _, expected := range []string{"5", "6", "7"} {
+		revision, err := IncrementRevision(stateFile)
+		assert.NoError(t, err, "Unexpected error incrementing revision")
+		assert.Equal(t, expected, revision, "Expected revision to increment across restarts")
+	}
+}
+
+func TestIncrementRevisionInvalidStateFile(t *testing.T) {
+	stateFile, err := ioutil.TempFile("", "revision")
+	assert.NoError(t, err, "Unexpected error creating state file")
+	defer os.Remove(stateFile.Name())
+	stateFile.WriteString("meow")
+	stateFile.Close()
+
+	_, err = IncrementRevision(stateFile.Name())
+	assert.Error(t, err, "Expected error for a state file without a revision")
+}
+
+func TestValidateARNs(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		env      map[string]string
+		expected string
+	}{
+		{
+			name:     "defaults",
+			env:      map[string]string{},
+			expected: "",
+		},
+		{
+			name: "consistent",
+			env: map[string]string{
+				config.RegionVar:           "us-east-1",
+				config.TaskARNVar:          "arn:aws:ecs:us-east-1:222222222222:task/meow-cluster/37e873f6-37b4-42a7-af47-eac7275c6152",
+				config.ClusterARNVar:       "arn:aws:ecs:us-east-1:222222222222:cluster/meow-cluster",
+				config.ContainerTaskMapVar: "app=arn:aws:ecs:us-east-1:222222222222:task/meow-cluster/a1ce6a35-0a1b-4c5e-9d3e-5d3b1c1d2d5a",
+				config.DefaultRoleARNVar:   "arn:aws:iam::222222222222:role/meow-role",
+			},
+			expected: "",
+		},
+		{
+			name: "cluster name",
+			env: map[string]string{
+				config.ClusterARNVar: "meow-cluster",
+			},
+			expected: "",
+		},
+		{
+			name: "region differs from AWS_REGION",
+			env: map[string]string{
+				config.RegionVar: "us-east-1",
+			},
+			expected: "TASK_ARN is in region us-west-2, but AWS_REGION is in region us-east-1",
+		},
+		{
+			name: "cluster in another account",
+			env: map[string]string{
+				config.ClusterARNVar: "arn:aws:ecs:us-west-2:222222222222:cluster/meow-cluster",
+			},
+			expected: "CLUSTER_ARN is in account 222222222222, but TASK_ARN is in account 111111111111",
+		},
+		{
+			name: "mapped task in another region",
+			env: map[string]string{
+				config.ContainerTaskMapVar: "app=arn:aws:ecs:us-west-2:111111111111:task/ecs-local-cluster/1,worker=arn:aws:ecs:eu-west-1:111111111111:task/ecs-local-cluster/2",
+			},
+			expected: "ECS_LOCAL_CONTAINER_TASK_MAP (worker) is in region eu-west-1, but TASK_ARN is in region us-west-2",
+		},
+		{
+			name: "role in another account",
+			env: map[string]string{
+				config.DefaultRoleARNVar: "arn:aws:iam::222222222222:role/meow-role",
+			},
+			expected: "ECS_LOCAL_DEFAULT_ROLE_ARN is in account 222222222222, but TASK_ARN is in account 111111111111",
+		},
+		{
+			name: "task definition in another region",
+			env: map[string]string{
+				config.TaskDefARNVar: "arn:aws:ecs:eu-west-1:111111111111:task-definition/meow:3",
+			},
+			expected: "ECS_LOCAL_TASK_DEF_ARN is in region eu-west-1, but TASK_ARN is in region us-west-2",
+		},
+		{
+			name: "invalid task ARN",
+			env: map[string]string{
+				config.TaskARNVar: "meow",
+			},
+			expected: "Invalid value for TASK_ARN",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			for key, value := range testCase.env {
+				os.Setenv(key, value)
+				defer os.Unsetenv(key)
+			}
+
+			err := ValidateARNs()
+			if testCase.expected == "" {
+				assert.NoError(t, err, "Expected consistent ARNs")
+			} else if assert.Error(t, err, "Expected inconsistent ARNs to be an error") {
+				assert.Contains(t, err.Error(), testCase.expected, "Expected the error to name the settings which disagree")
+			}
+		})
+	}
+}
+
+func TestAddTaskDefinitionARN(t *testing.T) {
+	container := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	containers := []types.Container{container}
+
+	response := GetTaskMetadata(containers, nil, nil, nil)
+	AddTaskDefinitionARN(response)
+	assert.Empty(t, response.TaskDefinitionARN, "Expected no TaskDefinitionArn by default")
+
+	os.Setenv(config.TaskARNVar, "arn:aws-cn:ecs:cn-north-1:222222222222:task/meow-cluster/37e873f6-37b4-42a7-af47-eac7275c6152")
+	defer os.Unsetenv(config.TaskARNVar)
+	os.Setenv(config.TDFamilyVar, "meow")
+	defer os.Unsetenv(config.TDFamilyVar)
+	os.Setenv(config.TDRevisionVar, "7")
+	defer os.Unsetenv(config.TDRevisionVar)
+	os.Setenv(config.SynthesizeTaskDefARNVar, "true")
+	defer os.Unsetenv(config.SynthesizeTaskDefARNVar)
+
+	response = GetTaskMetadata(containers, nil, nil, nil)
+	AddTaskDefinitionARN(response)
+	assert.Equal(t, "arn:aws-cn:ecs:cn-north-1:222222222222:task-definition/meow:7", response.TaskDefinitionARN, "Expected the ARN to be synthesized from the task ARN, family, and revision")
+
+	// the synthesized ARN has the final revision, such as from the task revision label
+	response.Revision = "8"
+	AddTaskDefinitionARN(response)
+	assert.Equal(t, "arn:aws-cn:ecs:cn-north-1:222222222222:task-definition/meow:8", response.TaskDefinitionARN, "Expected the ARN to have the task's revision")
+
+	os.Setenv(config.TaskDefARNVar, "arn:aws:ecs:us-east-1:333333333333:task-definition/woof:2")
+	defer os.Unsetenv(config.TaskDefARNVar)
+
+	response = GetTaskMetadata(containers, nil, nil, nil)
+	AddTaskDefinitionARN(response)
+	assert.Equal(t, "arn:aws:ecs:us-east-1:333333333333:task-definition/woof:2", response.TaskDefinitionARN, "Expected the configured ARN")
+}
+
+func TestGetContainerMetadataWithSecurityOptions(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	inspect := testingutils.BaseDockerInspect(containerName, containerID).Get()
+	securityOpts := []string{
+		"seccomp=unconfined",
+		"apparmor=docker-default",
+		"no-new-privileges",
+	}
+	inspect.HostConfig.SecurityOpt = securityOpts
+
+	actual := GetContainerMetadata(&dockerContainer, inspect)
+	assert.Empty(t, actual.SecurityOptions, "Expected security options to be omitted by default")
+
+	os.Setenv(config.IncludeSecurityOptsVar, "true")
+	defer os.Unsetenv(config.IncludeSecurityOptsVar)
+
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	assert.Equal(t, securityOpts, actual.SecurityOptions, "Expected security options to match")
+}
+
+func TestGetContainerMetadataWithProcessInfo(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	inspect := testingutils.BaseDockerInspect(containerName, containerID).Get()
+	inspect.State.Pid = 4242
+	inspect.HostConfig.CgroupParent = "/docker-ci"
+
+	actual := GetContainerMetadata(&dockerContainer, inspect)
+	assert.Equal(t, 0, actual.Pid, "Expected no PID by default")
+	assert.Empty(t, actual.CgroupParent, "Expected no cgroup parent by default")
+
+	os.Setenv(config.IncludeProcessInfoVar, "true")
+	defer os.Unsetenv(config.IncludeProcessInfoVar)
+
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	assert.Equal(t, 4242, actual.Pid, "Expected PID to match")
+	assert.Equal(t, "/docker-ci", actual.CgroupParent, "Expected cgroup parent to match")
+}
+
+func TestGetContainerMetadataWithInit(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	inspect := testingutils.BaseDockerInspect(containerName, containerID).Get()
+	usesInit := true
+	inspect.HostConfig.Init = &usesInit
+
+	actual := GetContainerMetadata(&dockerContainer, inspect)
+	assert.Nil(t, actual.Init, "Expected no Init by default")
+
+	os.Setenv(config.IncludeInitVar, "true")
+	defer os.Unsetenv(config.IncludeInitVar)
+
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	if assert.NotNil(t, actual.Init, "Expected Init to be set") {
+		assert.True(t, *actual.Init, "Expected the container to run with init")
+	}
+
+	inspect.HostConfig.Init = nil
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	if assert.NotNil(t, actual.Init, "Expected Init to be set") {
+		assert.False(t, *actual.Init, "Expected the container to run without init")
+	}
+}
+
+func TestGetContainerMetadataWithEntrypointForm(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	inspect := testingutils.BaseDockerInspect(containerName, containerID).Get()
+	inspect.Config.Cmd = []string{"/bin/sh", "-c", "node server.js"}
+
+	actual := GetContainerMetadata(&dockerContainer, inspect)
+	assert.Empty(t, actual.EntrypointForm, "Expected no EntrypointForm by default")
+
+	os.Setenv(config.IncludeEntrypointFormVar, "true")
+	defer os.Unsetenv(config.IncludeEntrypointFormVar)
+
+	var testCases = []struct {
+		name          string
+		entrypoint    []string
+		cmd           []string
+		expectedForm  string
+		expectedShell string
+	}{
+		{"shell form cmd", nil, []string{"/bin/sh", "-c", "node server.js"}, "shell", "/bin/sh"},
+		{"shell form entrypoint", []string{"/bin/bash", "-o", "pipefail", "-c", "exec node server.js"}, nil, "shell", "/bin/bash"},
+		{"windows shell form", nil, []string{"cmd", "/S", "/C", "node server.js"}, "shell", "cmd"},
+		{"shell entrypoint with cmd", []string{"/bin/sh", "-c"}, []string{"node server.js"}, "shell", "/bin/sh"},
+		{"exec form cmd", nil, []string{"node", "server.js"}, "exec", ""},
+		{"exec form entrypoint with shell form cmd", []string{"docker-entrypoint.sh"}, []string{"/bin/sh", "-c", "node server.js"}, "exec", ""},
+		{"shell script", []string{"/bin/sh", "/usr/local/bin/start.sh"}, nil, "exec", ""},
+		{"no command", nil, nil, "", ""},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			inspect.Config.Entrypoint = testCase.entrypoint
+			inspect.Config.Cmd = testCase.cmd
+			actual := GetContainerMetadata(&dockerContainer, inspect)
+			assert.Equal(t, testCase.expectedForm, actual.EntrypointForm, "Expected EntrypointForm to match")
+			assert.Equal(t, testCase.expectedShell, actual.EntrypointShell, "Expected EntrypointShell to match")
+		})
+	}
+}
+
+func TestGetContainerMetadataWithUlimits(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	inspect := testingutils.BaseDockerInspect(containerName, containerID).Get()
+	inspect.HostConfig.Ulimits = []*units.Ulimit{
+		&units.Ulimit{
+			Name: "nofile",
+			Soft: 1024,
+			Hard: 4096,
+		},
+	}
+
+	actual := GetContainerMetadata(&dockerContainer, inspect)
+	assert.Nil(t, actual.Ulimits, "Expected no ulimits by default")
+
+	os.Setenv(config.IncludeUlimitsVar, "true")
+	defer os.Unsetenv(config.IncludeUlimitsVar)
+
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	expected := []UlimitResponse{
+		UlimitResponse{
+			Name:      "nofile",
+			SoftLimit: 1024,
+			HardLimit: 4096,
+		},
+	}
+	assert.Equal(t, expected, actual.Ulimits, "Expected ulimits to match")
+}
+
+func TestGetContainerMetadataWithCapabilities(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	inspect := testingutils.BaseDockerInspect(containerName, containerID).Get()
+	inspect.HostConfig.CapAdd = strslice.StrSlice{"NET_ADMIN"}
+	inspect.HostConfig.CapDrop = strslice.StrSlice{"ALL"}
+
+	actual := GetContainerMetadata(&dockerContainer, inspect)
+	assert.Nil(t, actual.Capabilities, "Expected no capabilities by default")
+
+	os.Setenv(config.IncludeCapabilitiesVar, "true")
+	defer os.Unsetenv(config.IncludeCapabilitiesVar)
+
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	expected := &CapabilitiesResponse{
+		Add:  []string{"NET_ADMIN"},
+		Drop: []string{"ALL"},
+	}
+	assert.Equal(t, expected, actual.Capabilities, "Expected capabilities to match")
+
+	inspect.HostConfig.CapAdd = nil
+	inspect.HostConfig.CapDrop = nil
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	assert.Nil(t, actual.Capabilities, "Expected no capabilities for a container with the default set")
+}
+
+func TestGetContainerMetadataWithExtraHosts(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	inspect := testingutils.BaseDockerInspect(containerName, containerID).Get()
+
+	actual := GetContainerMetadata(&dockerContainer, inspect)
+	assert.Nil(t, actual.ExtraHosts, "Expected no extra hosts for a container without them")
+
+	inspect.HostConfig.ExtraHosts = []string{
+		"db.local:10.0.0.5",
+		"ipv6.local:2001:db8::1",
+		"invalid",
+	}
+
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	expected := map[string]string{
+		"db.local":   "10.0.0.5",
+		"ipv6.local": "2001:db8::1",
+	}
+	assert.Equal(t, expected, actual.ExtraHosts, "Expected extra hosts to match")
+}
+
+func TestGetContainerMetadataWithReadonlyRootfs(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	inspect := testingutils.BaseDockerInspect(containerName, containerID).Get()
+
+	actual := GetContainerMetadata(&dockerContainer, inspect)
+	assert.False(t, actual.ReadonlyRootfs, "Expected a writable root filesystem by default")
+
+	inspect.HostConfig.ReadonlyRootfs = true
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	assert.True(t, actual.ReadonlyRootfs, "Expected a read-only root filesystem")
+}
+
+func TestGetContainerMetadataWithUptime(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	inspect := testingutils.BaseDockerInspect(containerName, containerID).Get()
+	inspect.State.StartedAt = time.Now().Add(-90 * time.Minute).Format(time.RFC3339Nano)
+
+	actual := GetContainerMetadata(&dockerContainer, inspect)
+	assert.Nil(t, actual.Uptime, "Expected no uptime by default")
+
+	os.Setenv(config.IncludeUptimeVar, "true")
+	defer os.Unsetenv(config.IncludeUptimeVar)
+
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	if assert.NotNil(t, actual.Uptime, "Expected uptime for a running container") {
+		assert.InDelta(t, 90*60, *actual.Uptime, 1, "Expected uptime to be the time since the container started")
+	}
+
+	inspect.State.Running = false
+	inspect.State.FinishedAt = time.Now().Add(-time.Minute).Format(time.RFC3339Nano)
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	if assert.NotNil(t, actual.Uptime, "Expected uptime for a stopped container") {
+		assert.Equal(t, int64(0), *actual.Uptime, "Expected stopped containers to have no uptime")
+	}
+}
+
+func TestGetContainerMetadataWithStartupLatency(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	inspect := testingutils.BaseDockerInspect(containerName, containerID).Get()
+	inspect.Created = "2019-03-22T18:04:22.123456789Z"
+	inspect.State.StartedAt = "2019-03-22T18:04:23.873456789Z"
+
+	actual := GetContainerMetadata(&dockerContainer, inspect)
+	assert.Nil(t, actual.StartupLatency, "Expected no startup latency by default")
+
+	os.Setenv(config.IncludeStartupLatencyVar, "true")
+	defer os.Unsetenv(config.IncludeStartupLatencyVar)
+
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	if assert.NotNil(t, actual.StartupLatency, "Expected startup latency for a started container") {
+		assert.Equal(t, int64(1750), *actual.StartupLatency, "Expected the milliseconds between creation and start")
+	}
+
+	// Docker reports the zero time for containers which have never started
+	inspect.State.Running = false
+	inspect.State.Status = "created"
+	inspect.State.StartedAt = "0001-01-01T00:00:00Z"
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	assert.Nil(t, actual.StartupLatency, "Expected no startup latency for a container which has not started")
+}
+
+func TestGetContainerMetadataWithPrivileged(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	inspect := testingutils.BaseDockerInspect(containerName, containerID).Get()
+
+	actual := GetContainerMetadata(&dockerContainer, inspect)
+	assert.False(t, actual.Privileged, "Expected an unprivileged container by default")
+
+	inspect.HostConfig.Privileged = true
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	assert.True(t, actual.Privileged, "Expected a privileged container")
+}
+
+func TestGetContainerMetadataWithSysctls(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	inspect := testingutils.BaseDockerInspect(containerName, containerID).Get()
+	inspect.HostConfig.Sysctls = map[string]string{
+		"net.core.somaxconn":       "1024",
+		"net.core.rmem_max":        "16777216",
+		"net.ipv4.tcp_fin_timeout": "30",
+	}
+
+	actual := GetContainerMetadata(&dockerContainer, inspect)
+	assert.Nil(t, actual.Sysctls, "Expected no sysctls by default")
+
+	os.Setenv(config.IncludeSysctlsVar, "true")
+	defer os.Unsetenv(config.IncludeSysctlsVar)
+
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	expected := map[string]string{
+		"net.core.somaxconn":       "1024",
+		"net.core.rmem_max":        "16777216",
+		"net.ipv4.tcp_fin_timeout": "30",
+	}
+	assert.Equal(t, expected, actual.Sysctls, "Expected sysctls to match")
+}
+
+func TestGetContainerMetadataWithStorageOpt(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	inspect := testingutils.BaseDockerInspect(containerName, containerID).Get()
+	// --storage-opt size=20G
+	inspect.HostConfig.StorageOpt = map[string]string{
+		"size": "20G",
+	}
+
+	actual := GetContainerMetadata(&dockerContainer, inspect)
+	assert.Nil(t, actual.StorageOptions, "Expected no storage options by default")
+
+	os.Setenv(config.IncludeStorageOptVar, "true")
+	defer os.Unsetenv(config.IncludeStorageOptVar)
+
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	assert.Equal(t, map[string]string{"size": "20G"}, actual.StorageOptions, "Expected storage options to match")
+
+	inspect.HostConfig.StorageOpt = nil
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	assert.Nil(t, actual.StorageOptions, "Expected no storage options for a container without any")
+}
+
+func TestGetContainerMetadataWithIsolation(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("nat", ipAddress).Get()
+	inspect := testingutils.BaseDockerInspect(containerName, containerID).Get()
+	inspect.HostConfig.Isolation = container.IsolationHyperV
+
+	inspect.Platform = "linux"
+	actual := GetContainerMetadata(&dockerContainer, inspect)
+	assert.Empty(t, actual.Isolation, "Expected no isolation mode for a Linux container")
+
+	inspect.Platform = "windows"
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	assert.Equal(t, "hyperv", actual.Isolation, "Expected the isolation mode of the Windows container")
+
+	inspect.HostConfig.Isolation = container.IsolationDefault
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	assert.Empty(t, actual.Isolation, "Expected no isolation mode when the daemon's default applies")
+}
+
+func TestGetContainerMetadataWithDevices(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	inspect := testingutils.BaseDockerInspect(containerName, containerID).Get()
+	inspect.HostConfig.Devices = []container.DeviceMapping{
+		container.DeviceMapping{
+			PathOnHost:        "/dev/nvidia0",
+			PathInContainer:   "/dev/nvidia0",
+			CgroupPermissions: "rwm",
+		},
+		container.DeviceMapping{
+			PathOnHost:        "/dev/fuse",
+			PathInContainer:   "/dev/fuse-local",
+			CgroupPermissions: "rw",
+		},
+	}
+
+	actual := GetContainerMetadata(&dockerContainer, inspect)
+	assert.Nil(t, actual.Devices, "Expected no devices by default")
+
+	os.Setenv(config.IncludeDevicesVar, "true")
+	defer os.Unsetenv(config.IncludeDevicesVar)
+
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	expected := []DeviceResponse{
+		DeviceResponse{
+			HostPath:      "/dev/nvidia0",
+			ContainerPath: "/dev/nvidia0",
+			Permissions:   "rwm",
+		},
+		DeviceResponse{
+			HostPath:      "/dev/fuse",
+			ContainerPath: "/dev/fuse-local",
+			Permissions:   "rw",
+		},
+	}
+	assert.Equal(t, expected, actual.Devices, "Expected devices to match")
+}
+
+func TestGetContainerMetadataWithShmSize(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	inspect := testingutils.BaseDockerInspect(containerName, containerID).Get()
+	inspect.HostConfig.ShmSize = 2 * 1024 * 1024 * 1024
+
+	actual := GetContainerMetadata(&dockerContainer, inspect)
+	assert.Zero(t, actual.ShmSize, "Expected no shm size by default")
+
+	os.Setenv(config.IncludeShmSizeVar, "true")
+	defer os.Unsetenv(config.IncludeShmSizeVar)
+
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	assert.Equal(t, int64(2147483648), actual.ShmSize, "Expected shm size to match")
+}
+
+func TestGetContainerMetadataWithRuntime(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	inspect := testingutils.BaseDockerInspect(containerName, containerID).Get()
+	inspect.HostConfig.Runtime = "nvidia"
+
+	actual := GetContainerMetadata(&dockerContainer, inspect)
+	assert.Empty(t, actual.Runtime, "Expected no runtime by default")
+
+	os.Setenv(config.IncludeRuntimeVar, "true")
+	defer os.Unsetenv(config.IncludeRuntimeVar)
+
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	assert.Equal(t, "nvidia", actual.Runtime, "Expected runtime to match")
+}
+
+func TestGetContainerMetadataWithEnvironmentNames(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	inspect := testingutils.BaseDockerInspect(containerName, containerID).Get()
+	inspect.Config.Env = []string{
+		"PATH=/usr/local/sbin:/usr/local/bin",
+		"DB_PASSWORD=hunter2",
+		"EMPTY=",
+		"WITH_EQUALS=a=b",
+	}
+
+	actual := GetContainerMetadata(&dockerContainer, inspect)
+	assert.Nil(t, actual.EnvironmentNames, "Expected no environment variable names by default")
+
+	os.Setenv(config.IncludeEnvNamesVar, "true")
+	defer os.Unsetenv(config.IncludeEnvNamesVar)
+
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	assert.Equal(t, []string{"PATH", "DB_PASSWORD", "EMPTY", "WITH_EQUALS"}, actual.EnvironmentNames, "Expected only the names of the environment variables")
+	serialized, err := json.Marshal(actual)
+	assert.NoError(t, err, "Unexpected error marshalling response")
+	assert.NotContains(t, string(serialized), "hunter2", "Expected no environment variable values")
+}
+
+func TestGetContainerMetadataWithGroupAdd(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	inspect := testingutils.BaseDockerInspect(containerName, containerID).Get()
+	inspect.HostConfig.GroupAdd = []string{"audio", "1001"}
+
+	actual := GetContainerMetadata(&dockerContainer, inspect)
+	assert.Nil(t, actual.GroupAdd, "Expected no supplementary groups by default")
+
+	os.Setenv(config.IncludeGroupAddVar, "true")
+	defer os.Unsetenv(config.IncludeGroupAddVar)
+
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	assert.Equal(t, []string{"audio", "1001"}, actual.GroupAdd, "Expected supplementary groups to match")
+}
+
+func TestGetContainerMetadataWithNamespaceModes(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	inspect := testingutils.BaseDockerInspect(containerName, containerID).Get()
+	inspect.HostConfig.PidMode = container.PidMode("container:" + containerID2)
+	inspect.HostConfig.IpcMode = container.IpcMode("host")
+
+	actual := GetContainerMetadata(&dockerContainer, inspect)
+	assert.Empty(t, actual.PidMode, "Expected no PID mode by default")
+	assert.Empty(t, actual.IpcMode, "Expected no IPC mode by default")
+
+	os.Setenv(config.IncludeNamespaceModesVar, "true")
+	defer os.Unsetenv(config.IncludeNamespaceModesVar)
+
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	assert.Equal(t, "container:"+containerID2, actual.PidMode, "Expected the PID namespace to be shared with the other container")
+	assert.Equal(t, "host", actual.IpcMode, "Expected the host IPC namespace")
+
+	inspect.HostConfig.PidMode = container.PidMode("host")
+	inspect.HostConfig.IpcMode = container.IpcMode("container:" + containerID2)
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	assert.Equal(t, "host", actual.PidMode, "Expected the host PID namespace")
+	assert.Equal(t, "container:"+containerID2, actual.IpcMode, "Expected the IPC namespace to be shared with the other container")
+}
+
+func TestGetContainerMetadataWithOomScoreAdj(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	inspect := testingutils.BaseDockerInspect(containerName, containerID).Get()
+	inspect.HostConfig.OomScoreAdj = 500
+
+	actual := GetContainerMetadata(&dockerContainer, inspect)
+	assert.Zero(t, actual.OomScoreAdj, "Expected no OOM score adjustment by default")
+
+	os.Setenv(config.IncludeOomScoreAdjVar, "true")
+	defer os.Unsetenv(config.IncludeOomScoreAdjVar)
+
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	assert.Equal(t, 500, actual.OomScoreAdj, "Expected OOM score adjustment to match")
+
+	inspect.HostConfig.OomScoreAdj = -1000
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	assert.Equal(t, -1000, actual.OomScoreAdj, "Expected negative OOM score adjustments to be included")
+}
+
+func TestGetContainerMetadataWithDNS(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	inspect := testingutils.BaseDockerInspect(containerName, containerID).Get()
+	inspect.HostConfig.DNS = []string{"10.0.0.2"}
+	inspect.HostConfig.DNSSearch = []string{"svc.cluster.local", "example.com"}
+
+	actual := GetContainerMetadata(&dockerContainer, inspect)
+	assert.Empty(t, actual.DNSServers, "Expected no DNS servers by default")
+	assert.Empty(t, actual.DNSSearchDomains, "Expected no DNS search domains by default")
+
+	os.Setenv(config.IncludeDNSVar, "true")
+	defer os.Unsetenv(config.IncludeDNSVar)
+
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	assert.Equal(t, []string{"10.0.0.2"}, actual.DNSServers, "Expected DNS servers to match")
+	assert.Equal(t, []string{"svc.cluster.local", "example.com"}, actual.DNSSearchDomains, "Expected DNS search domains to match")
+}
+
+func TestGetContainerMetadataWithCpuset(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	inspect := testingutils.BaseDockerInspect(containerName, containerID).Get()
+	inspect.HostConfig.CpusetCpus = "0-3,6"
+	inspect.HostConfig.CpusetMems = "0"
+
+	actual := GetContainerMetadata(&dockerContainer, inspect)
+	assert.Empty(t, actual.CpusetCpus, "Expected no CPUs by default")
+	assert.Empty(t, actual.CpusetMems, "Expected no memory nodes by default")
+
+	os.Setenv(config.IncludeCpusetVar, "true")
+	defer os.Unsetenv(config.IncludeCpusetVar)
+
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	assert.Equal(t, "0-3,6", actual.CpusetCpus, "Expected CPUs to match")
+	assert.Equal(t, "0", actual.CpusetMems, "Expected memory nodes to match")
+}
+
+func TestGetContainerMetadataWithCPURealtime(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	inspect := testingutils.BaseDockerInspect(containerName, containerID).Get()
+	// --cpu-rt-runtime 950000 --cpu-rt-period 1000000
+	inspect.HostConfig.CPURealtimeRuntime = 950000
+	inspect.HostConfig.CPURealtimePeriod = 1000000
+
+	actual := GetContainerMetadata(&dockerContainer, inspect)
+	fields := marshalInTest(t, actual)
+	assert.NotContains(t, fields, "CpuRealtimeRuntime", "Expected no real-time runtime by default")
+	assert.NotContains(t, fields, "CpuRealtimePeriod", "Expected no real-time period by default")
+
+	os.Setenv(config.IncludeCPURealtimeVar, "true")
+	defer os.Unsetenv(config.IncludeCPURealtimeVar)
+
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	fields = marshalInTest(t, actual)
+	assert.Equal(t, float64(950000), fields["CpuRealtimeRuntime"], "Expected real-time runtime to match")
+	assert.Equal(t, float64(1000000), fields["CpuRealtimePeriod"], "Expected real-time period to match")
+}
+
+func TestGetContainerMetadataWithBlkioWeight(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	inspect := testingutils.BaseDockerInspect(containerName, containerID).Get()
+	inspect.HostConfig.BlkioWeight = 300
+	inspect.HostConfig.BlkioWeightDevice = []*blkiodev.WeightDevice{
+		{Path: "/dev/sda", Weight: 200},
+		{Path: "/dev/nvme0n1", Weight: 800},
+	}
+
+	actual := GetContainerMetadata(&dockerContainer, inspect)
+	assert.Zero(t, actual.BlkioWeight, "Expected no block I/O weight by default")
+	assert.Empty(t, actual.BlkioDeviceWeights, "Expected no device weights by default")
+
+	os.Setenv(config.IncludeBlkioWeightVar, "true")
+	defer os.Unsetenv(config.IncludeBlkioWeightVar)
+
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	assert.Equal(t, uint16(300), actual.BlkioWeight, "Expected block I/O weight to match")
+	expected := []BlkioDeviceWeightResponse{
+		{Path: "/dev/sda", Weight: 200},
+		{Path: "/dev/nvme0n1", Weight: 800},
+	}
+	assert.Equal(t, expected, actual.BlkioDeviceWeights, "Expected device weights to match")
+}
+
+func TestGetContainerMetadataWithStopSignal(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	inspect := testingutils.BaseDockerInspect(containerName, containerID).Get()
+
+	actual := GetContainerMetadata(&dockerContainer, inspect)
+	assert.Empty(t, actual.StopSignal, "Expected no stop signal when Docker's default applies")
+
+	inspect.Config.StopSignal = "SIGQUIT"
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	assert.Equal(t, "SIGQUIT", actual.StopSignal, "Expected stop signal to match")
+}
+
+func TestGetContainerMetadataWithHealthCheckConfig(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	inspect := testingutils.BaseDockerInspect(containerName, containerID).Get()
+	inspect.Config.Healthcheck = &container.HealthConfig{
+		Test:     []string{"CMD-SHELL", "curl -f http://localhost/ || exit 1"},
+		Interval: 30 * time.Second,
+		Timeout:  5 * time.Second,
+		Retries:  3,
+	}
+
+	actual := GetContainerMetadata(&dockerContainer, inspect)
+	assert.Nil(t, actual.HealthCheck, "Expected no health check by default")
+
+	os.Setenv(config.IncludeHealthCheckConfigVar, "true")
+	defer os.Unsetenv(config.IncludeHealthCheckConfigVar)
+
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	expected := &HealthCheckResponse{
+		Command:  []string{"CMD-SHELL", "curl -f http://localhost/ || exit 1"},
+		Interval: 30,
+		Timeout:  5,
+		Retries:  3,
+	}
+	assert.Equal(t, expected, actual.HealthCheck, "Expected health check to match")
+
+	inspect.Config.Healthcheck = nil
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	assert.Nil(t, actual.HealthCheck, "Expected no health check for a container without one")
+}
+
+func TestGetContainerMetadataWithHealthCheck(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	inspect := testingutils.BaseDockerInspect(containerName, containerID).Get()
+
+	actual := GetContainerMetadata(&dockerContainer, inspect)
+	assert.Nil(t, actual.Health, "Expected no health for containers without a health check")
+
+	inspect.State.Health = &types.Health{
+		Status: types.Unhealthy,
+		Log: []*types.HealthcheckResult{
+			{ExitCode: 0, Output: "ok"},
+			{ExitCode: 1, Output: "connection refused"},
+		},
+	}
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	if assert.NotNil(t, actual.Health, "Expected health from the Docker health check") {
+		assert.Equal(t, apicontainerstatus.ContainerUnhealthy, actual.Health.Status, "Expected the container to be unhealthy")
+		assert.Equal(t, 1, actual.Health.ExitCode, "Expected the exit code of the most recent check")
+		assert.Equal(t, "connection refused", actual.Health.Output, "Expected the output of the most recent check")
+	}
+
+	inspect.State.Health = &types.Health{Status: types.Starting}
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	if assert.NotNil(t, actual.Health, "Expected health from the Docker health check") {
+		assert.Equal(t, apicontainerstatus.ContainerHealthUnknown, actual.Health.Status, "Expected starting containers to have unknown health")
+	}
+}
+
+func TestGetContainerMetadataHealthLog(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	inspect := testingutils.BaseDockerInspect(containerName, containerID).Get()
+	checkTime := func(seconds int) time.Time {
+		return time.Date(2019, time.March, 1, 20, 55, seconds, 0, time.UTC)
+	}
+	// Docker keeps the results of the last 5 checks, oldest first; the last check is still running
+	inspect.State.Health = &types.Health{
+		Status:        types.Unhealthy,
+		FailingStreak: 2,
+		Log: []*types.HealthcheckResult{
+			{Start: checkTime(0), End: checkTime(1), ExitCode: 0, Output: "ok"},
+			{Start: checkTime(10), End: checkTime(11), ExitCode: 0, Output: "ok"},
+			{Start: checkTime(20), End: checkTime(21), ExitCode: 1, Output: "connection refused"},
+			{Start: checkTime(30), End: checkTime(31), ExitCode: 1, Output: "connection refused"},
+			{Start: checkTime(40)},
+		},
+	}
+
+	actual := GetContainerMetadata(&dockerContainer, inspect)
+	assert.Nil(t, actual.HealthLog, "Expected no health log by default")
+
+	os.Setenv(config.HealthLogEntriesVar, "3")
+	defer os.Unsetenv(config.HealthLogEntriesVar)
+
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	start20, end21, start30, end31, start40 := checkTime(20), checkTime(21), checkTime(30), checkTime(31), checkTime(40)
+	expected := []HealthLogResponse{
+		{ExitCode: 1, Output: "connection refused", StartedAt: &start20, FinishedAt: &end21},
+		{ExitCode: 1, Output: "connection refused", StartedAt: &start30, FinishedAt: &end31},
+		{ExitCode: 0, StartedAt: &start40},
+	}
+	assert.Equal(t, expected, actual.HealthLog, "Expected the most recent health checks, oldest first")
+
+	os.Setenv(config.HealthLogEntriesVar, "10")
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	assert.Len(t, actual.HealthLog, 5, "Expected every check when fewer than the configured count are kept")
+
+	inspect.State.Health = nil
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	assert.Nil(t, actual.HealthLog, "Expected no health log for containers without a health check")
+}
+
+func TestGetContainerMetadataWithHealthLabel(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	inspect := testingutils.BaseDockerInspect(containerName, containerID).Get()
+	inspect.Config.Labels = map[string]string{
+		"com.example.health": "HEALTHY",
+	}
+
+	actual := GetContainerMetadata(&dockerContainer, inspect)
+	assert.Nil(t, actual.Health, "Expected no health from labels by default")
+
+	os.Setenv(config.HealthLabelVar, "com.example.health")
+	defer os.Unsetenv(config.HealthLabelVar)
+
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	if assert.NotNil(t, actual.Health, "Expected health from the label") {
+		assert.Equal(t, apicontainerstatus.ContainerHealthy, actual.Health.Status, "Expected the container to be healthy")
+	}
+
+	inspect.Config.Labels["com.example.health"] = "unhealthy"
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	if assert.NotNil(t, actual.Health, "Expected health from the label") {
+		assert.Equal(t, apicontainerstatus.ContainerUnhealthy, actual.Health.Status, "Expected the container to be unhealthy")
+	}
+
+	inspect.Config.Labels["com.example.health"] = "degraded"
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	if assert.NotNil(t, actual.Health, "Expected health from the label") {
+		assert.Equal(t, apicontainerstatus.ContainerHealthUnknown, actual.Health.Status, "Expected unrecognized values to be unknown")
+	}
+
+	// a Docker health check takes precedence over the label
+	inspect.State.Health = &types.Health{Status: types.Healthy}
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	if assert.NotNil(t, actual.Health, "Expected health from the Docker health check") {
+		assert.Equal(t, apicontainerstatus.ContainerHealthy, actual.Health.Status, "Expected health from the Docker health check")
+	}
+
+	delete(inspect.Config.Labels, "com.example.health")
+	inspect.State.Health = nil
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	assert.Nil(t, actual.Health, "Expected no health for containers without the label")
+}
+
+func TestGetContainerMetadataWithAWSLogs(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	inspect := testingutils.BaseDockerInspect(containerName, containerID).Get()
+	inspect.HostConfig.LogConfig = container.LogConfig{
+		Type: "json-file",
+		Config: map[string]string{
+			"max-size": "10m",
+		},
+	}
+
+	actual := GetContainerMetadata(&dockerContainer, inspect)
+	assert.Empty(t, actual.LogDriver, "Expected no log driver for other drivers")
+	assert.Nil(t, actual.LogOptions, "Expected no log options for other drivers")
+
+	inspect.HostConfig.LogConfig = container.LogConfig{
+		Type: "awslogs",
+		Config: map[string]string{
+			"awslogs-group":  "/ecs/local",
+			"awslogs-region": "us-west-2",
+			"awslogs-stream": "web",
+			"tag":            "{{.Name}}",
+		},
+	}
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	assert.Equal(t, "awslogs", actual.LogDriver, "Expected log driver to match")
+	expected := map[string]string{
+		"awslogs-group":  "/ecs/local",
+		"awslogs-region": "us-west-2",
+		"awslogs-stream": "web",
+	}
+	assert.Equal(t, expected, actual.LogOptions, "Expected log options to match")
+
+	// the awslogs driver defaults the stream to the container ID
+	delete(inspect.HostConfig.LogConfig.Config, "awslogs-stream")
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	assert.Equal(t, containerID, actual.LogOptions["awslogs-stream"], "Expected the stream to default to the container ID")
+}
+
+func TestGetContainerMetadataPausedContainer(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	inspect := testingutils.BaseDockerInspect(containerName, containerID).Get()
+	inspect.State.Status = "paused"
+	inspect.State.Paused = true
+
+	actual := GetContainerMetadata(&dockerContainer, inspect)
+	assert.Equal(t, ecs.DesiredStatusRunning, actual.KnownStatus, "Expected paused container to be RUNNING by default")
+	assert.True(t, actual.Paused, "Expected paused container to be flagged")
+
+	os.Setenv(config.PausedStatusVar, "PAUSED")
+	defer os.Unsetenv(config.PausedStatusVar)
+
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	assert.Equal(t, "PAUSED", actual.KnownStatus, "Expected the configured status for a paused container")
+	assert.True(t, actual.Paused, "Expected paused container to be flagged")
+}
+
+func TestGetContainerMetadataRestartingContainer(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	inspect := testingutils.BaseDockerInspect(containerName, containerID).Get()
+	inspect.State.Status = "restarting"
+	inspect.State.Running = true
+	inspect.State.Restarting = true
+
+	actual := GetContainerMetadata(&dockerContainer, inspect)
+	assert.Equal(t, ecs.DesiredStatusPending, actual.KnownStatus, "Expected restarting container to be PENDING by default")
+
+	os.Setenv(config.RestartingStatusVar, ecs.DesiredStatusStopped)
+	defer os.Unsetenv(config.RestartingStatusVar)
+
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	assert.Equal(t, ecs.DesiredStatusStopped, actual.KnownStatus, "Expected the configured status for a restarting container")
+}
+
+func TestGetContainerMetadataEntrypointAndCmd(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	dockerContainer.Command = "/docker-entrypoint.sh nginx -g 'daemon off;'"
+	inspect := testingutils.BaseDockerInspect(containerName, containerID).Get()
+	inspect.Config.Entrypoint = []string{"/docker-entrypoint.sh"}
+	inspect.Config.Cmd = []string{"nginx", "-g", "daemon off;"}
+
+	actual := GetContainerMetadata(&dockerContainer, inspect)
+	assert.Equal(t, []string{"/docker-entrypoint.sh"}, actual.Entrypoint, "Expected entrypoint to match")
+	assert.Equal(t, []string{"nginx", "-g", "daemon off;"}, actual.Cmd, "Expected cmd to match")
+}
+
+func TestGetContainerMetadataRestartedContainer(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	inspect := testingutils.BaseDockerInspect(containerName, containerID).Get()
+	inspect.State.StartedAt = "2019-03-01T20:55:11.064236631Z"
+	inspect.State.FinishedAt = "0001-01-01T00:00:00Z"
+
+	actual := GetContainerMetadata(&dockerContainer, inspect)
+	expectedStartedAt, _ := time.Parse(time.RFC3339Nano, inspect.State.StartedAt)
+	if assert.NotNil(t, actual.StartedAt, "Expected StartedAt to be set") {
+		assert.True(t, expectedStartedAt.Equal(*actual.StartedAt), "Expected StartedAt to come from the container state")
+	}
+	assert.Nil(t, actual.PreviousFinishedAt, "Expected no PreviousFinishedAt for a container which has not restarted")
+	assert.Equal(t, 0, actual.RestartCount, "Expected restart count to match")
+
+	// the container exited and was restarted by its restart policy
+	inspect.RestartCount = 2
+	inspect.State.FinishedAt = "2019-03-01T20:55:10.554941919Z"
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	expectedFinishedAt, _ := time.Parse(time.RFC3339Nano, inspect.State.FinishedAt)
+	if assert.NotNil(t, actual.PreviousFinishedAt, "Expected PreviousFinishedAt to be set") {
+		assert.True(t, expectedFinishedAt.Equal(*actual.PreviousFinishedAt), "Expected PreviousFinishedAt to come from the container state")
+	}
+	assert.Equal(t, 2, actual.RestartCount, "Expected restart count to match")
+}
+
+func TestGetContainerMetadataStableFor(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	inspect := testingutils.BaseDockerInspect(containerName, containerID).Get()
+	inspect.State.StartedAt = time.Now().Add(-5 * time.Minute).Format(time.RFC3339Nano)
+
+	actual := GetContainerMetadata(&dockerContainer, inspect)
+	assert.False(t, actual.Restarted, "Expected a container which has not restarted")
+	assert.Nil(t, actual.StableFor, "Expected no StableFor for a container which has not restarted")
+
+	// the container exited and was restarted by its restart policy
+	inspect.RestartCount = 3
+	inspect.State.FinishedAt = time.Now().Add(-6 * time.Minute).Format(time.RFC3339Nano)
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	assert.True(t, actual.Restarted, "Expected a restarted container")
+	if assert.NotNil(t, actual.StableFor, "Expected StableFor for a restarted container") {
+		assert.InDelta(t, 5*60, *actual.StableFor, 1, "Expected StableFor to be the time since the container last started")
+	}
+}
+
+func TestGetContainerMetadataWithTmpfsMounts(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	dockerContainer.Mounts = append(dockerContainer.Mounts, types.MountPoint{
+		Type:        mount.TypeTmpfs,
+		Destination: "/scratch",
+	})
+	inspect := testingutils.BaseDockerInspect(containerName, containerID).Get()
+	inspect.HostConfig.Tmpfs = map[string]string{
+		"/tmp":     "rw,size=64m",
+		"/scratch": "",
+	}
+
+	actual := GetContainerMetadata(&dockerContainer, inspect)
+	if assert.Len(t, actual.Volumes, 3, "Expected the volume and tmpfs mounts") {
+		assert.Equal(t, "", actual.Volumes[0].Type, "Expected the volume to have no type")
+		assert.Equal(t, "/scratch", actual.Volumes[1].Destination, "Expected tmpfs destination to match")
+		assert.Equal(t, "tmpfs", actual.Volumes[1].Type, "Expected tmpfs type")
+		assert.Equal(t, "/tmp", actual.Volumes[2].Destination, "Expected tmpfs destination to match")
+		assert.Equal(t, "tmpfs", actual.Volumes[2].Type, "Expected tmpfs type")
+	}
+}
+
+func TestGetContainerMetadataIncludeLabels(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	dockerContainer.Labels = map[string]string{
+		"com.amazonaws.ecs.container-name": containerName,
+		"com.amazonaws.ecs.cluster":        cluster,
+		"com.docker.compose.project":       projectName,
+	}
+
+	var testCases = []struct {
+		mode     string
+		expected map[string]string
+	}{
+		{
+			mode:     "",
+			expected: dockerContainer.Labels,
+		},
+		{
+			mode:     config.IncludeLabelsAll,
+			expected: dockerContainer.Labels,
+		},
+		{
+			mode: config.IncludeLabelsECS,
+			expected: map[string]string{
+				"com.amazonaws.ecs.container-name": containerName,
+				"com.amazonaws.ecs.cluster":        cluster,
+			},
+		},
+		{
+			mode:     config.IncludeLabelsNone,
+			expected: nil,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.mode, func(t *testing.T) {
+			os.Setenv(config.IncludeLabelsVar, testCase.mode)
+			defer os.Unsetenv(config.IncludeLabelsVar)
+
+			actual := GetContainerMetadata(&dockerContainer, nil)
+			assert.Equal(t, testCase.expected, actual.Labels, "Expected labels to match")
+		})
+	}
+}
+
+func TestGetContainerMetadataECSContainerName(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+
+	actual := GetContainerMetadata(&dockerContainer, nil)
+	assert.Equal(t, containerName, actual.Name, "Expected the Docker name without the ECS container name label")
+
+	dockerContainer.Labels = map[string]string{
+		"com.amazonaws.ecs.container-name": "web",
+	}
+	actual = GetContainerMetadata(&dockerContainer, nil)
+	assert.Equal(t, "web", actual.Name, "Expected the name from the ECS container name label")
+	assert.Equal(t, containerName, actual.DockerName, "Expected the Docker name to match")
+}
+
+func TestGetContainerMetadataAnnotations(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	dockerContainer.Labels = map[string]string{
+		"com.example.annotations.owner":              "web-team",
+		"com.example.annotations.prometheus.io/port": "9090",
+		"com.example.annotations.":                   "no name",
+		"com.docker.compose.project":                 projectName,
+	}
+
+	actual := GetContainerMetadata(&dockerContainer, nil)
+	assert.Nil(t, actual.Annotations, "Expected no annotations by default")
+
+	os.Setenv(config.AnnotationLabelPrefixVar, "com.example.annotations.")
+	defer os.Unsetenv(config.AnnotationLabelPrefixVar)
+
+	actual = GetContainerMetadata(&dockerContainer, nil)
+	expected := map[string]string{
+		"owner":              "web-team",
+		"prometheus.io/port": "9090",
+	}
+	assert.Equal(t, expected, actual.Annotations, "Expected the prefixed labels as annotations")
+	assert.Equal(t, dockerContainer.Labels, actual.Labels, "Expected the labels to be unchanged")
+}
+
+func TestGetContainerMetadataLabelIncludeKeys(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	dockerContainer.Labels = map[string]string{
+		"com.amazonaws.ecs.container-name": containerName,
+		"com.docker.compose.project":       projectName,
+		"com.docker.compose.service":       "web",
+		"build.commit":                     "0fd68ec",
+	}
+
+	actual := GetContainerMetadata(&dockerContainer, nil)
+	assert.Equal(t, dockerContainer.Labels, actual.Labels, "Expected all labels by default")
+
+	os.Setenv(config.LabelIncludeKeysVar, "com.docker.compose.service, build.commit,missing.label")
+	defer os.Unsetenv(config.LabelIncludeKeysVar)
+
+	actual = GetContainerMetadata(&dockerContainer, nil)
+	expected := map[string]string{
+		"com.docker.compose.service": "web",
+		"build.commit":               "0fd68ec",
+	}
+	assert.Equal(t, expected, actual.Labels, "Expected only the listed labels")
+
+	os.Setenv(config.IncludeLabelsVar, config.IncludeLabelsECS)
+	defer os.Unsetenv(config.IncludeLabelsVar)
+
+	actual = GetContainerMetadata(&dockerContainer, nil)
+	assert.Nil(t, actual.Labels, "Expected the listed labels to be limited by ECS_LOCAL_INCLUDE_LABELS")
+}
+
+func TestGetContainerMetadataGroupLabels(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	dockerContainer.Labels = map[string]string{
+		"com.amazonaws.ecs.container-name": containerName,
+		"com.docker.compose.project":       projectName,
+		"com.docker.compose.service":       "web",
+		"com.example.build.commit":         "0fd68ec",
+		"org.example.build.commit":         "a1b2c3d",
+		"maintainer":                       "web-team",
+	}
+
+	actual := GetContainerMetadata(&dockerContainer, nil)
+	assert.Nil(t, actual.LabelGroups, "Expected no label groups by default")
+
+	os.Setenv(config.GroupLabelsVar, "true")
+	defer os.Unsetenv(config.GroupLabelsVar)
+
+	actual = GetContainerMetadata(&dockerContainer, nil)
+	expected := LabelGroupsResponse{
+		"ecs": {
+			"container-name": containerName,
+		},
+		"compose": {
+			"project": projectName,
+			"service": "web",
+		},
+		// both namespaces end in build, so their groups are named after the whole namespace
+		"com.example.build": {
+			"commit": "0fd68ec",
+		},
+		"org.example.build": {
+			"commit": "a1b2c3d",
+		},
+		"default": {
+			"maintainer": "web-team",
+		},
+	}
+	assert.Equal(t, expected, actual.LabelGroups, "Expected the labels to be grouped by namespace")
+	assert.Equal(t, dockerContainer.Labels, actual.Labels, "Expected the flat labels to be unchanged")
+}
+
+func TestGetContainerMetadataComposeLabels(t *testing.T) {
+	var testCases = []struct {
+		name            string
+		labels          map[string]string
+		expectedService string
+		expectedNumber  int
+	}{
+		{
+			name: "first replica",
+			labels: map[string]string{
+				"com.docker.compose.project":          projectName,
+				"com.docker.compose.service":          "web",
+				"com.docker.compose.container-number": "1",
+			},
+			expectedService: "web",
+			expectedNumber:  1,
+		},
+		{
+			name: "scaled replica",
+			labels: map[string]string{
+				"com.docker.compose.project":          projectName,
+				"com.docker.compose.service":          "worker",
+				"com.docker.compose.container-number": "3",
+			},
+			expectedService: "worker",
+			expectedNumber:  3,
+		},
+		{
+			name: "invalid container number",
+			labels: map[string]string{
+				"com.docker.compose.service":          "worker",
+				"com.docker.compose.container-number": "three",
+			},
+			expectedService: "worker",
+		},
+		{
+			name:   "not started by compose",
+			labels: map[string]string{},
+		},
+	}
+
+	os.Setenv(config.IncludeComposeLabelsVar, "true")
+	defer os.Unsetenv(config.IncludeComposeLabelsVar)
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+			dockerContainer.Labels = testCase.labels
+
+			actual := GetContainerMetadata(&dockerContainer, nil)
+			assert.Equal(t, testCase.expectedService, actual.ComposeService, "Expected the compose service to match")
+			assert.Equal(t, testCase.expectedNumber, actual.ComposeContainerNumber, "Expected the compose container number to match")
+		})
+	}
+}
+
+func TestGetContainerMetadataComposeLabelsDisabled(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	dockerContainer.Labels = map[string]string{
+		"com.docker.compose.service":          "web",
+		"com.docker.compose.container-number": "2",
+	}
+
+	actual := GetContainerMetadata(&dockerContainer, nil)
+	assert.Empty(t, actual.ComposeService, "Expected no compose service by default")
+	assert.Zero(t, actual.ComposeContainerNumber, "Expected no compose container number by default")
+}
+
+func TestGetContainerMetadataNetworkMode(t *testing.T) {
+	var testCases = []struct {
+		name              string
+		dockerNetworkMode string
+		networkModeMap    string
+		expected          string
+	}{
+		{"default network", "default", "app-network=awsvpc", "bridge"},
+		{"bridge network", "bridge", "app-network=awsvpc", "bridge"},
+		{"host network", "host", "app-network=awsvpc", "host"},
+		{"no network", "none", "app-network=awsvpc", "none"},
+		{"shared network namespace", "container:" + containerID, "app-network=awsvpc", "awsvpc"},
+		{"mapped shared network namespace", "container:" + containerID, "container=bridge", "bridge"},
+		{"mapped user-defined network", "app-network", "app-network=awsvpc", "awsvpc"},
+		{"unmapped user-defined network", "other-network", "app-network=awsvpc", "bridge"},
+		{"mapped builtin network", "bridge", "bridge=awsvpc", "awsvpc"},
+		{"invalid mapping", "app-network", "app-network=overlay", "bridge"},
+		{"not configured", "app-network", "", ""},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			os.Setenv(config.NetworkModeMapVar, testCase.networkModeMap)
+			defer os.Unsetenv(config.NetworkModeMapVar)
+
+			dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+			dockerContainer.HostConfig.NetworkMode = testCase.dockerNetworkMode
+
+			actual := GetContainerMetadata(&dockerContainer, nil)
+			assert.Equal(t, testCase.expected, actual.NetworkMode, "Expected the ECS network mode to match")
+		})
+	}
+}
+
+func TestGetContainerMetadataMaxLabels(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	dockerContainer.Labels = make(map[string]string)
+	for i := 0; i < 5000; i++ {
+		dockerContainer.Labels[fmt.Sprintf("build.label.%04d", i)] = "value"
+	}
+
+	actual := GetContainerMetadata(&dockerContainer, nil)
+	assert.Len(t, actual.Labels, 5000, "Expected all labels by default")
+	assert.False(t, actual.LabelsTruncated, "Expected labels not to be truncated by default")
+
+	os.Setenv(config.MaxLabelsVar, "100")
+	defer os.Unsetenv(config.MaxLabelsVar)
+
+	actual = GetContainerMetadata(&dockerContainer, nil)
+	assert.Len(t, actual.Labels, 100, "Expected labels to be capped")
+	assert.True(t, actual.LabelsTruncated, "Expected labels to be flagged as truncated")
+	assert.Contains(t, actual.Labels, "build.label.0000", "Expected the first labels in key order to be kept")
+	assert.NotContains(t, actual.Labels, "build.label.0100", "Expected the remaining labels to be dropped")
+}
+
+func TestGetContainerMetadataMatchesAgentFields(t *testing.T) {
+	// testdata/agent_v3_container_metadata.json is the container metadata response from a real ECS Agent
+	data, err := ioutil.ReadFile("testdata/agent_v3_container_metadata.json")
+	assert.NoError(t, err, "Unexpected error reading the agent response")
+	var expected map[string]interface{}
+	err = json.Unmarshal(data, &expected)
+	assert.NoError(t, err, "Unexpected error parsing the agent response")
+
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithNetwork("bridge", ipAddress).Get()
+	dockerContainer.Labels = map[string]string{
+		"com.amazonaws.ecs.container-name": containerName,
+	}
+	inspect := testingutils.BaseDockerInspect(containerName, containerID).Get()
+	inspect.HostConfig.CPUShares = 512
+	inspect.HostConfig.Memory = 512 * 1024 * 1024
+
+	actual := marshalInTest(t, GetContainerMetadata(&dockerContainer, inspect))
+	for key := range expected {
+		assert.Contains(t, actual, key, "Expected container metadata to contain the ECS Agent field")
+	}
+	assert.NotContains(t, actual, "DockerLabels", "Expected no DockerLabels alias by default")
+
+	os.Setenv(config.IncludeDockerLabelsAliasVar, "true")
+	defer os.Unsetenv(config.IncludeDockerLabelsAliasVar)
+
+	actual = marshalInTest(t, GetContainerMetadata(&dockerContainer, inspect))
+	assert.Equal(t, actual["Labels"], actual["DockerLabels"], "Expected DockerLabels to match Labels")
+}
+
+func TestGetContainerMetadataLimitsFromComposeFile(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithComposeProject(projectName).WithNetwork("bridge", ipAddress).Get()
+
+	composeFile, err := ioutil.TempFile("", "docker-compose")
+	assert.NoError(t, err, "Unexpected error creating compose file")
+	defer os.Remove(composeFile.Name())
+	_, err = composeFile.WriteString(`{
+		"services": {
+			"ecs-local": {
+				"deploy": {
+					"resources": {
+						"limits": {
+							"cpus": "0.5",
+							"memory": "256M"
+						}
+					}
+				}
+			}
+		}
+	}`)
+	assert.NoError(t, err, "Unexpected error writing compose file")
+	composeFile.Close()
+
+	actual := GetContainerMetadata(&dockerContainer, nil)
+	assert.Nil(t, actual.Limits.CPU, "Expected no CPU limit without a compose file")
+	assert.Nil(t, actual.Limits.Memory, "Expected no memory limit without a compose file")
+
+	os.Setenv(config.ComposeFileVar, composeFile.Name())
+	defer os.Unsetenv(config.ComposeFileVar)
+
+	actual = GetContainerMetadata(&dockerContainer, nil)
+	if assert.NotNil(t, actual.Limits.CPU, "Expected CPU limit from the compose file") {
+		assert.Equal(t, float64(512), *actual.Limits.CPU, "Expected CPU limit in CPU units")
+	}
+	if assert.NotNil(t, actual.Limits.Memory, "Expected memory limit from the compose file") {
+		assert.Equal(t, int64(256), *actual.Limits.Memory, "Expected memory limit in MiB")
+	}
+
+	// limits which Docker applied take precedence
+	inspect := testingutils.BaseDockerInspect(containerName, containerID).Get()
+	inspect.HostConfig.Memory = 128 * 1024 * 1024
+	actual = GetContainerMetadata(&dockerContainer, inspect)
+	if assert.NotNil(t, actual.Limits.Memory, "Expected memory limit from the host config") {
+		assert.Equal(t, int64(128), *actual.Limits.Memory, "Expected memory limit in MiB")
+	}
+	if assert.NotNil(t, actual.Limits.CPU, "Expected CPU limit from the compose file") {
+		assert.Equal(t, float64(512), *actual.Limits.CPU, "Expected CPU limit in CPU units")
+	}
+}
+
+func TestGetContainerMetadataGPUsFromComposeFile(t *testing.T) {
+	dockerContainer := testingutils.BaseDockerContainer(containerName, containerID).WithComposeProject(projectName).WithNetwork("bridge", ipAddress).Get()
+
+	composeFile, err := ioutil.TempFile("", "docker-compose")
+	assert.NoError(t, err, "Unexpected error creating compose file")
+	defer os.Remove(composeFile.Name())
+	_, err = composeFile.WriteString(`{
+		"services": {
+			"ecs-local": {
+				"deploy": {
+					"resources": {
+						"reservations": {
+							"devices": [
+								{
+									"capabilities": ["gpu"],
+									"device_ids": ["0", "3"]
+								},
+								{
+									"capabilities": ["gpu", "utility"],
+									"count": 1
+								},
+								{
+									"capabilities": ["tpu"],
+									"count": 4
+								}
+							]
+						}
+					}
+				}
+			}
+		}
+	}`)
+	assert.NoError(t, err, "Unexpected error writing compose file")
+	composeFile.Close()
+
+	actual := GetContainerMetadata(&dockerContainer, nil)
+	assert.Nil(t, actual.ResourceRequirements, "Expected no resource requirements without a compose file")
+
+	os.Setenv(config.ComposeFileVar, composeFile.Name())
+	defer os.Unsetenv(config.ComposeFileVar)
+
+	actual = GetContainerMetadata(&dockerContainer, nil)
+	expected := []ResourceRequirementResponse{
+		{
+			Type:  "GPU",
+			Value: "3",
+		},
+	}
+	assert.Equal(t, expected, actual.ResourceRequirements, "Expected the GPUs reserved in the compose file")
+	assert.Equal(t, []string{"0", "3"}, actual.GPUIDs, "Expected the IDs of the GPUs reserved in the compose file")
+}
+
+func TestFormatTimestamps(t *testing.T) {
+	createdAt := time.Date(2019, time.March, 4, 5, 6, 7, 890000000, time.UTC)
+	startedAt := createdAt.Add(time.Second)
+	response := &TaskResponse{
+		TaskResponse: v2.TaskResponse{
+			TaskARN:       config.DefaultTaskARN,
+			PullStoppedAt: &createdAt,
+		},
+		Containers: []ContainerResponse{
+			ContainerResponse{
+				ContainerResponse: v2.ContainerResponse{
+					Name:      containerName,
+					CreatedAt: &createdAt,
+					StartedAt: &startedAt,
+				},
+				RestartCount: 2,
+			},
+		},
+	}
+
+	var testCases = []struct {
+		format            string
+		expectedCreatedAt interface{}
+		expectedStartedAt interface{}
+	}{
+		{
+			format:            config.TimestampFormatRFC3339Nano,
+			expectedCreatedAt: "2019-03-04T05:06:07.89Z",
+			expectedStartedAt: "2019-03-04T05:06:08.89Z",
+		},
+		{
+			format:            config.TimestampFormatRFC3339,
+			expectedCreatedAt: "2019-03-04T05:06:07Z",
+			expectedStartedAt: "2019-03-04T05:06:08Z",
+		},
+		{
+			format:            config.TimestampFormatUnix,
+			expectedCreatedAt: float64(createdAt.Unix()),
+			expectedStartedAt: float64(startedAt.Unix()),
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.format, func(t *testing.T) {
+			formatted, err := FormatTimestamps(response, testCase.format)
+			assert.NoError(t, err, "Unexpected error formatting timestamps")
+
+			fields := marshalInTest(t, formatted)
+			assert.Equal(t, testCase.expectedCreatedAt, fields["PullStoppedAt"], "Expected task timestamp to match")
+			assert.Equal(t, config.DefaultTaskARN, fields["TaskARN"], "Expected other values to be unchanged")
+
+			container := fields["Containers"].([]interface{})[0].(map[string]interface{})
+			assert.Equal(t, testCase.expectedCreatedAt, container["CreatedAt"], "Expected container timestamp to match")
+			assert.Equal(t, testCase.expectedStartedAt, container["StartedAt"], "Expected container timestamp to match")
+			assert.Equal(t, float64(2), container["RestartCount"], "Expected other values to be unchanged")
+		})
+	}
+}
+
+func TestAddRelativeTimes(t *testing.T) {
+	now := time.Now()
+	createdAt := now.Add(-90 * time.Minute)
+	startedAt := now.Add(-time.Hour)
+	response := &TaskResponse{
+		TaskResponse: v2.TaskResponse{
+			TaskARN:       config.DefaultTaskARN,
+			PullStoppedAt: &createdAt,
+		},
+		Containers: []ContainerResponse{
+			ContainerResponse{
+				ContainerResponse: v2.ContainerResponse{
+					Name:      containerName,
+					CreatedAt: &createdAt,
+					StartedAt: &startedAt,
+				},
+			},
+		},
+	}
+
+	withRelativeTimes, err := AddRelativeTimes(response, now)
+	assert.NoError(t, err, "Unexpected error adding relative times")
+	// the relative times are kept when the timestamps are then formatted
+	formatted, err := FormatTimestamps(withRelativeTimes, config.TimestampFormatUnix)
+	assert.NoError(t, err, "Unexpected error formatting timestamps")
+
+	fields := marshalInTest(t, formatted)
+	assert.Equal(t, float64(5400), fields["PullStoppedAtAgo"], "Expected task relative time to match")
+	assert.Equal(t, float64(createdAt.Unix()), fields["PullStoppedAt"], "Expected the absolute timestamp to be kept")
+	assert.NotContains(t, fields, "PullStartedAtAgo", "Expected no relative time for a timestamp which is not set")
+
+	container := fields["Containers"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, float64(5400), container["CreatedAtAgo"], "Expected container relative time to match")
+	assert.Equal(t, float64(3600), container["StartedAtAgo"], "Expected container relative time to match")
+	assert.NotContains(t, container, "FinishedAtAgo", "Expected no relative time for a timestamp which is not set")
+	assert.Equal(t, containerName, container["Name"], "Expected other values to be unchanged")
+}
+
+func marshalInTest(t *testing.T, response interface{}) map[string]interface{} {
+	data, err := json.Marshal(response)
+	assert.NoError(t, err, "Unexpected error marshaling the response")
+	var fields map[string]interface{}
+	err = json.Unmarshal(data, &fields)
+	assert.NoError(t, err, "Unexpected error unmarshaling the response")
+	return fields
+}
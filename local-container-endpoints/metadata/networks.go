@@ -0,0 +1,165 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metadata
+
+import (
+	"strings"
+
+	"github.com/aws/amazon-ecs-agent/agent/containermetadata"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// dockerContainerNetworkModePrefix is the prefix of the network mode of containers which share another
+	// container's network namespace
+	dockerContainerNetworkModePrefix = "container:"
+	// networkModeMapContainerKey is the key in ECS_LOCAL_NETWORK_MODE_MAP for all containers which share another
+	// container's network namespace
+	networkModeMapContainerKey = "container"
+)
+
+// getECSNetworkMode translates the container's Docker network mode into an ECS network mode, or returns "" if
+// ECS_LOCAL_NETWORK_MODE_MAP is not set. The map sets the ECS network mode of Docker networks by name, or of containers
+// which share another container's network namespace with the 'container' key. Network modes which the map does not
+// set, or sets to an invalid mode, keep their ECS equivalent: Docker's bridge, host, and none modes are the same in
+// ECS, a shared network namespace is awsvpc, since that is how the containers in awsvpc tasks share their network,
+// and user-defined networks are bridge.
+func getECSNetworkMode(dockerNetworkMode string) string {
+	value := utils.GetValue("", config.NetworkModeMapVar)
+	if value == "" {
+		return ""
+	}
+	modeMap, err := utils.GetTagsMap(value)
+	if err != nil {
+		logrus.Warnf("Ignoring %s: %s", config.NetworkModeMapVar, err)
+		modeMap = nil
+	}
+
+	key := dockerNetworkMode
+	if strings.HasPrefix(dockerNetworkMode, dockerContainerNetworkModePrefix) {
+		key = networkModeMapContainerKey
+	}
+	if mode, ok := modeMap[key]; ok {
+		switch mode {
+		case ecs.NetworkModeBridge, ecs.NetworkModeHost, ecs.NetworkModeNone, ecs.NetworkModeAwsvpc:
+			return mode
+		default:
+			logrus.Warnf("Ignoring invalid network mode for %s in %s: %s", key, config.NetworkModeMapVar, mode)
+		}
+	}
+
+	switch key {
+	case ecs.NetworkModeHost, ecs.NetworkModeNone:
+		return key
+	case networkModeMapContainerKey:
+		return ecs.NetworkModeAwsvpc
+	default:
+		// Docker's default network is the bridge network, and user-defined networks are usually bridge networks too
+		return ecs.NetworkModeBridge
+	}
+}
+
+// AddTaskNetworks sets the task level networks, which are ambiguous when the containers in a local 'task'
+// are on different networks, as configured by ECS_LOCAL_TASK_NETWORK_STRATEGY.
+// primaryContainerID is the container which made the request; if it is not known, the first container is the primary.
+func AddTaskNetworks(response *TaskResponse, primaryContainerID string) {
+	if len(response.Containers) == 0 {
+		return
+	}
+
+	switch strategy := utils.GetValue("", config.TaskNetworkStrategyVar); strategy {
+	case "":
+		return
+	case config.TaskNetworkPrimary:
+		for _, network := range getPrimaryContainer(response.Containers, primaryContainerID).Networks {
+			response.Networks = append(response.Networks, network.Network)
+		}
+	case config.TaskNetworkAll:
+		response.Networks = mergeNetworks(response.Containers)
+	default:
+		logrus.Warnf("Ignoring invalid value for %s: %s", config.TaskNetworkStrategyVar, strategy)
+	}
+}
+
+func getPrimaryContainer(containers []ContainerResponse, primaryContainerID string) *ContainerResponse {
+	for i := range containers {
+		if containers[i].ID == primaryContainerID {
+			return &containers[i]
+		}
+	}
+	return &containers[0]
+}
+
+// mergeNetworks lists each network once, with the addresses of all of the containers on it
+func mergeNetworks(containers []ContainerResponse) []containermetadata.Network {
+	var networks []containermetadata.Network
+	networkIndexes := make(map[string]int)
+	for _, container := range containers {
+		for _, network := range container.Networks {
+			i, ok := networkIndexes[network.NetworkMode]
+			if !ok {
+				networkIndexes[network.NetworkMode] = len(networks)
+				networks = append(networks, containermetadata.Network{
+					NetworkMode: network.NetworkMode,
+				})
+				i = len(networks) - 1
+			}
+			// containers which share a network namespace have the same addresses
+			networks[i].IPv4Addresses = appendNewAddresses(networks[i].IPv4Addresses, network.IPv4Addresses)
+			networks[i].IPv6Addresses = appendNewAddresses(networks[i].IPv6Addresses, network.IPv6Addresses)
+		}
+	}
+	return networks
+}
+
+func appendNewAddresses(addresses []string, newAddresses []string) []string {
+	for _, newAddress := range newAddresses {
+		if !containsAddress(addresses, newAddress) {
+			addresses = append(addresses, newAddress)
+		}
+	}
+	return addresses
+}
+
+func containsAddress(addresses []string, address string) bool {
+	for _, existing := range addresses {
+		if existing == address {
+			return true
+		}
+	}
+	return false
+}
+
+// dedupeNetworks removes repeated entries for the same network and addresses, which Docker can report
+// for containers on overlay networks
+func dedupeNetworks(networks []containermetadata.Network) []containermetadata.Network {
+	var deduped []containermetadata.Network
+	seen := make(map[string]bool)
+	for _, network := range networks {
+		key := strings.Join([]string{
+			network.NetworkMode,
+			strings.Join(network.IPv4Addresses, ","),
+			strings.Join(network.IPv6Addresses, ","),
+		}, "/")
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		deduped = append(deduped, network)
+	}
+	return deduped
+}
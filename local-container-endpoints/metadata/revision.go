@@ -0,0 +1,88 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metadata
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/utils"
+	"github.com/docker/docker/api/types"
+	"github.com/pkg/errors"
+)
+
+// taskRevisionLabel sets the task definition revision of a container, for setups which run containers from
+// different revisions side by side
+const taskRevisionLabel = "com.aws.ecs.local.task-revision"
+
+// GetLabeledTaskRevision returns the task definition revision in the container's task revision label,
+// and whether it was labeled at all
+func GetLabeledTaskRevision(dockerContainer *types.Container) (string, bool) {
+	revision, ok := dockerContainer.Labels[taskRevisionLabel]
+	return revision, ok && revision != ""
+}
+
+// AddLabeledTaskRevision sets the task's revision to the revision its containers are labeled with, if any
+// The containers in a local 'task' all share the same revision label
+func AddLabeledTaskRevision(response *TaskResponse) {
+	if len(response.Containers) > 0 && response.Containers[0].TaskRevision != "" {
+		response.Revision = response.Containers[0].TaskRevision
+	}
+}
+
+// IncrementRevision returns the task definition revision for this run of Local Endpoints, which is
+// one more than the revision of the previous run, as recorded in the stateFile.
+// On the first run, the revision is TASK_DEFINITION_REVISION.
+func IncrementRevision(stateFile string) (string, error) {
+	revision, err := strconv.Atoi(utils.GetValue(config.DefaultTDRevision, config.TDRevisionVar))
+	if err != nil {
+		return "", errors.Wrapf(err, "%s must be a number to auto increment it", config.TDRevisionVar)
+	}
+
+	data, err := ioutil.ReadFile(stateFile)
+	switch {
+	case err == nil:
+		previous, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err != nil {
+			return "", errors.Wrapf(err, "Failed to parse the revision in %s", stateFile)
+		}
+		revision = previous + 1
+	case !os.IsNotExist(err):
+		return "", errors.Wrapf(err, "Failed to read %s", stateFile)
+	}
+
+	// write the new revision to a temporary file first, so a crash never leaves a partial state file
+	tempFile, err := ioutil.TempFile(filepath.Dir(stateFile), filepath.Base(stateFile))
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to write %s", stateFile)
+	}
+	_, err = tempFile.WriteString(strconv.Itoa(revision) + "\n")
+	closeErr := tempFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tempFile.Name(), stateFile)
+	}
+	if err != nil {
+		os.Remove(tempFile.Name())
+		return "", errors.Wrapf(err, "Failed to write %s", stateFile)
+	}
+
+	return strconv.Itoa(revision), nil
+}
@@ -0,0 +1,212 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metadata
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/utils"
+)
+
+// schemaDialect is the version of JSON schema which Schema returns
+const schemaDialect = "http://json-schema.org/draft-07/schema#"
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	rawMessageType    = reflect.TypeOf(json.RawMessage{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// Schema returns the JSON schema of the encoding of the given response
+// The timestamps in the schema are in the given format, as they are after FormatTimestamps
+func Schema(response interface{}, timestampFormat string) map[string]interface{} {
+	generator := schemaGenerator{
+		timestampFormat: timestampFormat,
+		relativeTimes:   utils.GetBoolValue(false, config.IncludeRelativeTimesVar),
+		visiting:        make(map[reflect.Type]bool),
+	}
+	schema := generator.schema(reflect.TypeOf(response), "")
+	schema["$schema"] = schemaDialect
+	return schema
+}
+
+type schemaGenerator struct {
+	timestampFormat string
+	// relativeTimes adds the relative time of each metadata timestamp, as AddRelativeTimes does
+	relativeTimes bool
+	// visiting holds the structs being generated, so that recursive types do not recurse forever
+	visiting map[reflect.Type]bool
+}
+
+// schema returns the schema of the given type, which is encoded as the field with the given name
+func (generator *schemaGenerator) schema(t reflect.Type, name string) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return generator.timestampSchema(name)
+	case t == rawMessageType, t.Implements(jsonMarshalerType), reflect.PtrTo(t).Implements(jsonMarshalerType):
+		// Types which encode themselves can be any JSON value
+		return map[string]interface{}{}
+	case t.Implements(textMarshalerType), reflect.PtrTo(t).Implements(textMarshalerType):
+		return map[string]interface{}{"type": "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			// byte slices are encoded as base64 strings
+			return map[string]interface{}{"type": "string", "contentEncoding": "base64"}
+		}
+		return map[string]interface{}{
+			"type":  "array",
+			"items": generator.schema(t.Elem(), ""),
+		}
+	case reflect.Map:
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": generator.schema(t.Elem(), ""),
+		}
+	case reflect.Struct:
+		return generator.structSchema(t)
+	}
+	// Interfaces can hold any JSON value
+	return map[string]interface{}{}
+}
+
+// timestampSchema returns the schema of a timestamp
+// Only the metadata timestamps are reformatted; any others are always encoded as RFC 3339
+func (generator *schemaGenerator) timestampSchema(name string) map[string]interface{} {
+	if timestampFields[name] && generator.timestampFormat == config.TimestampFormatUnix {
+		return map[string]interface{}{"type": "integer"}
+	}
+	return map[string]interface{}{"type": "string", "format": "date-time"}
+}
+
+func (generator *schemaGenerator) structSchema(t reflect.Type) map[string]interface{} {
+	if generator.visiting[t] {
+		return map[string]interface{}{}
+	}
+	generator.visiting[t] = true
+	defer delete(generator.visiting, t)
+
+	properties := make(map[string]interface{})
+	required := []string{}
+	generator.addProperties(t, properties, &required)
+
+	schema := map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// addProperties adds the fields of the struct to the properties
+// Like encoding/json, the fields of embedded structs are promoted, unless a shallower field has the same name
+func (generator *schemaGenerator) addProperties(t reflect.Type, properties map[string]interface{}, required *[]string) {
+	var embedded []reflect.Type
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options := parseTag(tag)
+
+		fieldType := field.Type
+		for fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if field.Anonymous && name == "" && fieldType.Kind() == reflect.Struct {
+			embedded = append(embedded, fieldType)
+			continue
+		}
+		if field.PkgPath != "" {
+			// unexported fields are not encoded
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if _, ok := properties[name]; ok {
+			continue
+		}
+
+		schema := generator.schema(field.Type, name)
+		if generator.relativeTimes && timestampFields[name] && fieldType == timeType {
+			// the relative time is omitted with its timestamp, so it is never required
+			properties[name+relativeTimeSuffix] = map[string]interface{}{"type": "integer"}
+		}
+		if strings.Contains(options, "string") && isScalar(fieldType) {
+			schema = map[string]interface{}{"type": "string"}
+		}
+		if strings.Contains(options, "omitempty") {
+			properties[name] = schema
+			continue
+		}
+		switch field.Type.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface:
+			// nil values are encoded as null when they are not omitted
+			if len(schema) > 0 {
+				schema = map[string]interface{}{
+					"anyOf": []interface{}{schema, map[string]interface{}{"type": "null"}},
+				}
+			}
+		}
+		properties[name] = schema
+		*required = append(*required, name)
+	}
+
+	for _, embeddedType := range embedded {
+		generator.addProperties(embeddedType, properties, required)
+	}
+}
+
+// parseTag splits a json struct tag into the field name and its options
+func parseTag(tag string) (string, string) {
+	if i := strings.Index(tag, ","); i != -1 {
+		return tag[:i], tag[i+1:]
+	}
+	return tag, ""
+}
+
+func isScalar(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Bool, reflect.String, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,139 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metadata
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// timestampFields are the names of all of the timestamps in task and container metadata responses
+var timestampFields = map[string]bool{
+	"CreatedAt":          true,
+	"StartedAt":          true,
+	"FinishedAt":         true,
+	"PreviousFinishedAt": true,
+	"PullStartedAt":      true,
+	"PullStoppedAt":      true,
+	"ExecutionStoppedAt": true,
+	"generatedAt":        true,
+}
+
+// relativeTimeSuffix names the field which is added alongside each timestamp by AddRelativeTimes
+const relativeTimeSuffix = "Ago"
+
+// FormatTimestamps returns the metadata response with all of its timestamps in the given format
+// Go encodes timestamps as RFC 3339 with sub-second precision, so for that format the response is returned as is
+func FormatTimestamps(response interface{}, format string) (interface{}, error) {
+	switch format {
+	case config.TimestampFormatRFC3339Nano:
+		return response, nil
+	case config.TimestampFormatRFC3339, config.TimestampFormatUnix:
+	default:
+		logrus.Warnf("Ignoring invalid value for %s: %s", config.TimestampFormatVar, format)
+		return response, nil
+	}
+
+	generic, err := toGeneric(response)
+	if err != nil {
+		return nil, err
+	}
+	formatTimestamps(generic, format)
+	return generic, nil
+}
+
+// AddRelativeTimes returns the metadata response with the number of seconds between each of its timestamps and now
+// alongside the timestamp, such as CreatedAtAgo alongside CreatedAt. It must be called before FormatTimestamps.
+func AddRelativeTimes(response interface{}, now time.Time) (interface{}, error) {
+	generic, err := toGeneric(response)
+	if err != nil {
+		return nil, err
+	}
+	addRelativeTimes(generic, now)
+	return generic, nil
+}
+
+// toGeneric returns the response as the maps and slices it is encoded as, so that its fields can be changed by name
+func toGeneric(response interface{}) (interface{}, error) {
+	data, err := json.Marshal(response)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode metadata response")
+	}
+	// Decode numbers as json.Number, so that they are encoded again exactly as they were
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var generic interface{}
+	if err = decoder.Decode(&generic); err != nil {
+		return nil, errors.Wrap(err, "failed to decode metadata response")
+	}
+	return generic, nil
+}
+
+func addRelativeTimes(value interface{}, now time.Time) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		relativeTimes := make(map[string]interface{})
+		for key, field := range value {
+			if timestamp, ok := field.(string); ok && timestampFields[key] {
+				if t, err := time.Parse(time.RFC3339Nano, timestamp); err == nil && !t.IsZero() {
+					// negative when the clocks of Docker and Local Endpoints disagree
+					relativeTimes[key+relativeTimeSuffix] = json.Number(strconv.FormatInt(int64(now.Sub(t)/time.Second), 10))
+				}
+				continue
+			}
+			addRelativeTimes(field, now)
+		}
+		for key, relativeTime := range relativeTimes {
+			value[key] = relativeTime
+		}
+	case []interface{}:
+		for _, element := range value {
+			addRelativeTimes(element, now)
+		}
+	}
+}
+
+func formatTimestamps(value interface{}, format string) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, field := range value {
+			if timestamp, ok := field.(string); ok && timestampFields[key] {
+				value[key] = formatTimestamp(timestamp, format)
+				continue
+			}
+			formatTimestamps(field, format)
+		}
+	case []interface{}:
+		for _, element := range value {
+			formatTimestamps(element, format)
+		}
+	}
+}
+
+func formatTimestamp(timestamp string, format string) interface{} {
+	t, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		return timestamp
+	}
+	if format == config.TimestampFormatUnix {
+		return json.Number(strconv.FormatInt(t.Unix(), 10))
+	}
+	return t.Format(time.RFC3339)
+}
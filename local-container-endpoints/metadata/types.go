@@ -0,0 +1,212 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package metadata
+
+import (
+	"time"
+
+	"github.com/aws/amazon-ecs-agent/agent/containermetadata"
+	"github.com/aws/amazon-ecs-agent/agent/handlers/v1"
+	"github.com/aws/amazon-ecs-agent/agent/handlers/v2"
+)
+
+// TaskResponse extends the ECS Agent's task response with the fields that only Local Endpoints emits
+type TaskResponse struct {
+	v2.TaskResponse
+	Containers []ContainerResponse         `json:"Containers,omitempty"`
+	Networks   []containermetadata.Network `json:"Networks,omitempty"`
+	Partial    bool                        `json:"Partial,omitempty"`
+	// TaskDefinitionARN is only set when configured or synthesized
+	TaskDefinitionARN string `json:"TaskDefinitionArn,omitempty"`
+	// PlatformFamily and PlatformVersion are only set when configured, to simulate Fargate
+	PlatformFamily  string `json:"PlatformFamily,omitempty"`
+	PlatformVersion string `json:"PlatformVersion,omitempty"`
+	// DeploymentID is only set when configured, to simulate a task which was started by a service deployment
+	DeploymentID string `json:"DeploymentId,omitempty"`
+	// VPCID and SubnetID are only set when configured, to simulate a task in awsvpc network mode
+	VPCID    string `json:"VPCID,omitempty"`
+	SubnetID string `json:"SubnetId,omitempty"`
+	// EphemeralStorageMetrics is only set when configured, to simulate Fargate
+	EphemeralStorageMetrics *EphemeralStorageMetricsResponse `json:"EphemeralStorageMetrics,omitempty"`
+	// Sequence is only set when configured; it increases each time the state of the containers changes
+	Sequence uint64 `json:"Sequence,omitempty"`
+	// GeneratedAt is only set when configured; it is when the containers were listed, or when the snapshot was taken
+	GeneratedAt *time.Time `json:"generatedAt,omitempty"`
+}
+
+// EphemeralStorageMetricsResponse is the task's ephemeral storage, in MiB, in the same format as Fargate's Task Metadata V4
+// Locally, only the reserved size is known
+type EphemeralStorageMetricsResponse struct {
+	Reserved int64 `json:"Reserved"`
+}
+
+// ContainerResponse extends the ECS Agent's container response with the fields that only Local Endpoints emits
+type ContainerResponse struct {
+	v2.ContainerResponse
+	// Networks replaces the ECS Agent's networks, to add the container's aliases on each network
+	Networks []NetworkResponse `json:"Networks,omitempty"`
+	// NetworkMode is the container's Docker network mode translated into an ECS network mode, if configured
+	NetworkMode string `json:"NetworkMode,omitempty"`
+	// Ports replaces the ECS Agent's ports, so that contiguous bindings can be collapsed into ranges
+	Ports   []PortResponse `json:"Ports,omitempty"`
+	TaskARN string         `json:"TaskARN,omitempty"`
+	// TaskRevision is only set for containers with the com.aws.ecs.local.task-revision label
+	TaskRevision    string `json:"TaskRevision,omitempty"`
+	ImageRepoDigest string `json:"ImageRepoDigest,omitempty"`
+	ImageSource     string `json:"ImageSource,omitempty"`
+	// ComposeService and ComposeContainerNumber are only set when configured, for containers started by Docker Compose
+	ComposeService         string                `json:"ComposeService,omitempty"`
+	ComposeContainerNumber int                   `json:"ComposeContainerNumber,omitempty"`
+	DockerLabels           map[string]string     `json:"DockerLabels,omitempty"`
+	LabelGroups            LabelGroupsResponse   `json:"LabelGroups,omitempty"`
+	LabelsTruncated        bool                  `json:"LabelsTruncated,omitempty"`
+	LabelsHash             string                `json:"LabelsHash,omitempty"`
+	LabelsCount            *int                  `json:"LabelsCount,omitempty"`
+	Annotations            map[string]string     `json:"Annotations,omitempty"`
+	Entrypoint             []string              `json:"Entrypoint,omitempty"`
+	Cmd                    []string              `json:"Cmd,omitempty"`
+	EntrypointForm         string                `json:"EntrypointForm,omitempty"`
+	EntrypointShell        string                `json:"EntrypointShell,omitempty"`
+	StopSignal             string                `json:"StopSignal,omitempty"`
+	Hostname               string                `json:"Hostname,omitempty"`
+	Domainname             string                `json:"Domainname,omitempty"`
+	FQDN                   string                `json:"FQDN,omitempty"`
+	EnvironmentNames       []string              `json:"EnvironmentNames,omitempty"`
+	Paused                 bool                  `json:"Paused,omitempty"`
+	PreviousFinishedAt     *time.Time            `json:"PreviousFinishedAt,omitempty"`
+	Uptime                 *int64                `json:"Uptime,omitempty"`
+	StartupLatency         *int64                `json:"StartupLatency,omitempty"`
+	RestartCount           int                   `json:"RestartCount,omitempty"`
+	Restarted              bool                  `json:"Restarted,omitempty"`
+	StableFor              *int64                `json:"StableFor,omitempty"`
+	SecurityOptions        []string              `json:"SecurityOptions,omitempty"`
+	CgroupParent           string                `json:"CgroupParent,omitempty"`
+	Pid                    int                   `json:"Pid,omitempty"`
+	Init                   *bool                 `json:"Init,omitempty"`
+	Ulimits                []UlimitResponse      `json:"Ulimits,omitempty"`
+	Devices                []DeviceResponse      `json:"Devices,omitempty"`
+	HealthCheck            *HealthCheckResponse  `json:"HealthCheck,omitempty"`
+	HealthLog              []HealthLogResponse   `json:"HealthLog,omitempty"`
+	Capabilities           *CapabilitiesResponse `json:"Capabilities,omitempty"`
+	ExtraHosts             map[string]string     `json:"ExtraHosts,omitempty"`
+	DNSServers             []string              `json:"DnsServers,omitempty"`
+	DNSSearchDomains       []string              `json:"DnsSearchDomains,omitempty"`
+	Sysctls                map[string]string     `json:"Sysctls,omitempty"`
+	StorageOptions         map[string]string     `json:"StorageOptions,omitempty"`
+	ShmSize                int64                 `json:"ShmSize,omitempty"`
+	Runtime                string                `json:"Runtime,omitempty"`
+	GroupAdd               []string              `json:"GroupAdd,omitempty"`
+	PidMode                string                `json:"PidMode,omitempty"`
+	IpcMode                string                `json:"IpcMode,omitempty"`
+	CgroupnsMode           string                `json:"CgroupnsMode,omitempty"`
+	OomScoreAdj            int                   `json:"OomScoreAdj,omitempty"`
+	CpusetCpus             string                `json:"CpusetCpus,omitempty"`
+	CpusetMems             string                `json:"CpusetMems,omitempty"`
+	CPURealtimeRuntime     int64                 `json:"CpuRealtimeRuntime,omitempty"`
+	CPURealtimePeriod      int64                 `json:"CpuRealtimePeriod,omitempty"`
+	ReadonlyRootfs         bool                  `json:"ReadonlyRootfs,omitempty"`
+	Privileged             bool                  `json:"Privileged,omitempty"`
+	Isolation              string                `json:"Isolation,omitempty"`
+	LogDriver              string                `json:"LogDriver,omitempty"`
+	LogOptions             map[string]string     `json:"LogOptions,omitempty"`
+	Volumes                []VolumeResponse      `json:"Volumes,omitempty"`
+	// ResourceRequirements and GPUIDs are the GPUs reserved for the container in the Compose file
+	ResourceRequirements []ResourceRequirementResponse `json:"ResourceRequirements,omitempty"`
+	GPUIDs               []string                      `json:"GpuIDs,omitempty"`
+	// BlkioWeight and BlkioDeviceWeights are the container's block I/O weights, overall and for specific devices
+	BlkioWeight        uint16                      `json:"BlkioWeight,omitempty"`
+	BlkioDeviceWeights []BlkioDeviceWeightResponse `json:"BlkioDeviceWeights,omitempty"`
+	// Sequence is only set when configured; it increases each time the state of the containers changes
+	Sequence uint64 `json:"Sequence,omitempty"`
+	// GeneratedAt is only set when configured, and only for the container metadata path; it is the same as in TaskResponse
+	GeneratedAt *time.Time `json:"generatedAt,omitempty"`
+}
+
+// NetworkResponse extends the ECS Agent's network response with the container's aliases on the network,
+// which other containers on the network can use to reach it, and its MAC address on the network
+// MACAddress is named in the same way as in Task Metadata V4, and is empty when Docker does not report it
+type NetworkResponse struct {
+	containermetadata.Network
+	Aliases    []string `json:"Aliases,omitempty"`
+	MACAddress string   `json:"MACAddress"`
+}
+
+// PortResponse extends the ECS Agent's port response with port ranges, named in the same way as in the network
+// bindings of ECS tasks. The ranges replace ContainerPort and HostPort when contiguous bindings are collapsed.
+type PortResponse struct {
+	v1.PortResponse
+	ContainerPortRange string `json:"ContainerPortRange,omitempty"`
+	HostPortRange      string `json:"HostPortRange,omitempty"`
+}
+
+// VolumeResponse extends the ECS Agent's volume response with the type of the mount
+type VolumeResponse struct {
+	v1.VolumeResponse
+	Type string `json:"Type,omitempty"`
+}
+
+// ResourceRequirementResponse is a resource reserved for the container, named in the same way as in ECS Task Definitions
+type ResourceRequirementResponse struct {
+	Type  string `json:"Type"`
+	Value string `json:"Value"`
+}
+
+// UlimitResponse is a ulimit of the container, named in the same way as in ECS Task Definitions
+type UlimitResponse struct {
+	Name      string `json:"Name"`
+	SoftLimit int64  `json:"SoftLimit"`
+	HardLimit int64  `json:"HardLimit"`
+}
+
+// DeviceResponse is a host device mapped into the container, named in the same way as in ECS Task Definitions
+type DeviceResponse struct {
+	HostPath      string `json:"HostPath"`
+	ContainerPath string `json:"ContainerPath,omitempty"`
+	Permissions   string `json:"Permissions,omitempty"`
+}
+
+// BlkioDeviceWeightResponse is the container's block I/O weight for a host device
+type BlkioDeviceWeightResponse struct {
+	Path   string `json:"Path"`
+	Weight uint16 `json:"Weight"`
+}
+
+// HealthCheckResponse is the container's health check definition, named in the same way as in ECS Task Definitions
+// Durations are in seconds, and are zero if the image's or Docker's default applies
+type HealthCheckResponse struct {
+	Command     []string `json:"Command"`
+	Interval    int64    `json:"Interval,omitempty"`
+	Timeout     int64    `json:"Timeout,omitempty"`
+	Retries     int      `json:"Retries,omitempty"`
+	StartPeriod int64    `json:"StartPeriod,omitempty"`
+}
+
+// HealthLogResponse is the result of one of the container's most recent health checks
+// The times are named like the other metadata timestamps, so that they are formatted in the same way
+type HealthLogResponse struct {
+	ExitCode   int        `json:"ExitCode"`
+	Output     string     `json:"Output,omitempty"`
+	StartedAt  *time.Time `json:"StartedAt,omitempty"`
+	FinishedAt *time.Time `json:"FinishedAt,omitempty"`
+}
+
+// CapabilitiesResponse is the Linux capabilities added to and dropped from the container's default set,
+// named in the same way as in ECS Task Definitions
+type CapabilitiesResponse struct {
+	Add  []string `json:"Add,omitempty"`
+	Drop []string `json:"Drop,omitempty"`
+}
+
+// LabelGroupsResponse is the container's labels grouped by namespace, keyed by group and then by name
+type LabelGroupsResponse map[string]map[string]string
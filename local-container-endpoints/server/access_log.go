@@ -0,0 +1,146 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// clfTimeFormat is the timestamp format of Apache-style access logs
+	clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+)
+
+// WithAccessLog wraps the handler so that each request is logged to out, in the format set in ECS_LOCAL_ACCESS_LOG_FORMAT
+// By default, there is no access log, and the handler is returned as is
+func WithAccessLog(handler http.Handler, out io.Writer) http.Handler {
+	format := strings.ToLower(strings.TrimSpace(os.Getenv(config.AccessLogFormatVar)))
+	switch format {
+	case "":
+		return handler
+	case config.AccessLogFormatCLF, config.AccessLogFormatCombined:
+		return &accessLogHandler{
+			handler: handler,
+			out:     out,
+			format:  format,
+		}
+	default:
+		logrus.Warnf("Ignoring invalid value for %s: %s", config.AccessLogFormatVar, format)
+		return handler
+	}
+}
+
+type accessLogHandler struct {
+	handler http.Handler
+	out     io.Writer
+	format  string
+	// lock keeps the lines of concurrent requests from being interleaved
+	lock sync.Mutex
+}
+
+func (h *accessLogHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	recorder := &responseRecorder{
+		ResponseWriter: w,
+	}
+	received := time.Now()
+	h.handler.ServeHTTP(recorder, r)
+
+	line := h.formatLine(r, recorder, received)
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if _, err := io.WriteString(h.out, line); err != nil {
+		logrus.Warnf("Failed to write access log: %s", err)
+	}
+}
+
+// formatLine formats the request in the Common Log Format, or the Combined Log Format, which adds the referer and user agent
+func (h *accessLogHandler) formatLine(r *http.Request, recorder *responseRecorder, received time.Time) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	user := "-"
+	if username, _, ok := r.BasicAuth(); ok && username != "" {
+		user = username
+	}
+	size := "-"
+	if recorder.bytes > 0 {
+		size = fmt.Sprint(recorder.bytes)
+	}
+
+	line := fmt.Sprintf("%s - %s [%s] \"%s\" %d %s", host, user, received.Format(clfTimeFormat),
+		escapeLogValue(fmt.Sprintf("%s %s %s", r.Method, utils.RedactQueryParam(r.RequestURI, os.Getenv(config.CredsQueryTokenParamVar)), r.Proto)), recorder.status(), size)
+	if h.format == config.AccessLogFormatCombined {
+		line += fmt.Sprintf(" \"%s\" \"%s\"", logValueOrDash(r.Referer()), logValueOrDash(r.UserAgent()))
+	}
+	return line + "\n"
+}
+
+// logValueOrDash escapes the value, or returns a dash for an empty value, as Apache does
+func logValueOrDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return escapeLogValue(value)
+}
+
+// escapeLogValue escapes a value which is quoted in the access log, so it can't break the line's format
+func escapeLogValue(value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, `"`, `\"`, -1)
+	value = strings.Replace(value, "\n", `\n`, -1)
+	return value
+}
+
+// responseRecorder records the status code and the size of the response
+type responseRecorder struct {
+	http.ResponseWriter
+	code  int
+	bytes int
+}
+
+func (recorder *responseRecorder) WriteHeader(code int) {
+	if recorder.code == 0 {
+		recorder.code = code
+	}
+	recorder.ResponseWriter.WriteHeader(code)
+}
+
+func (recorder *responseRecorder) Write(data []byte) (int, error) {
+	if recorder.code == 0 {
+		recorder.code = http.StatusOK
+	}
+	n, err := recorder.ResponseWriter.Write(data)
+	recorder.bytes += n
+	return n, err
+}
+
+func (recorder *responseRecorder) status() int {
+	if recorder.code == 0 {
+		// nothing was written, so net/http sends an empty 200 response
+		return http.StatusOK
+	}
+	return recorder.code
+}
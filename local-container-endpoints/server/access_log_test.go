@@ -0,0 +1,82 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"testing"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithAccessLogCLF(t *testing.T) {
+	os.Setenv(config.AccessLogFormatVar, config.AccessLogFormatCLF)
+	defer os.Unsetenv(config.AccessLogFormatVar)
+
+	var out bytes.Buffer
+	handler := WithAccessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("not found"))
+	}), &out)
+
+	request := httptest.NewRequest("GET", "/v3/task?foo=bar", nil)
+	request.RemoteAddr = "172.17.0.3:41234"
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+
+	clf := regexp.MustCompile(`^172\.17\.0\.3 - - \[\d{2}/[A-Z][a-z]{2}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "GET /v3/task\?foo=bar HTTP/1\.1" 404 9\n$`)
+	assert.Regexp(t, clf, out.String(), "Expected a Common Log Format line")
+}
+
+func TestWithAccessLogCombined(t *testing.T) {
+	os.Setenv(config.AccessLogFormatVar, config.AccessLogFormatCombined)
+	defer os.Unsetenv(config.AccessLogFormatVar)
+
+	var out bytes.Buffer
+	handler := WithAccessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), &out)
+
+	request := httptest.NewRequest("GET", "/creds", nil)
+	request.RemoteAddr = "172.17.0.3:41234"
+	request.Header.Set("User-Agent", `aws-sdk-go/1.17.9 "quoted"`)
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+
+	combined := regexp.MustCompile(`^172\.17\.0\.3 - - \[[^\]]+\] "GET /creds HTTP/1\.1" 200 - "-" "aws-sdk-go/1\.17\.9 \\"quoted\\""\n$`)
+	assert.Regexp(t, combined, out.String(), "Expected a Combined Log Format line")
+}
+
+func TestWithAccessLogRedactsQueryToken(t *testing.T) {
+	os.Setenv(config.AccessLogFormatVar, config.AccessLogFormatCLF)
+	defer os.Unsetenv(config.AccessLogFormatVar)
+	os.Setenv(config.CredsQueryTokenParamVar, "token")
+	defer os.Unsetenv(config.CredsQueryTokenParamVar)
+
+	var out bytes.Buffer
+	handler := WithAccessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), &out)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/creds?role=clyde&token=s3cr3t", nil))
+
+	assert.Contains(t, out.String(), `"GET /creds?role=clyde&token=REDACTED HTTP/1.1"`, "Expected the query token to be redacted")
+	assert.NotContains(t, out.String(), "s3cr3t", "Expected the query token not to be logged")
+}
+
+func TestWithAccessLogDisabled(t *testing.T) {
+	var out bytes.Buffer
+	handler := WithAccessLog(http.NotFoundHandler(), &out)
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/creds", nil))
+	assert.Empty(t, out.String(), "Expected no access log by default")
+}
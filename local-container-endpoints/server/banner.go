@@ -0,0 +1,102 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package server
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	bannerEnabled  = "on"
+	bannerDisabled = "off"
+)
+
+// LogStartupBanner logs a single line which summarizes the features that are enabled, if ECS_LOCAL_STARTUP_BANNER is set
+func LogStartupBanner() {
+	if !utils.GetBoolValue(false, config.StartupBannerVar) {
+		return
+	}
+	logrus.WithFields(getStartupBannerFields()).Info("Local Endpoints configuration")
+}
+
+// getStartupBannerFields returns the resolved configuration of each feature in the startup banner
+// Secrets are never included: only whether they are set, and URLs without their credentials or query
+func getStartupBannerFields() logrus.Fields {
+	fields := logrus.Fields{
+		"port":              utils.GetValue(config.DefaultPort, config.PortVar),
+		"credentials":       getBannerCredentialsSource(),
+		"metadata_versions": getBannerMetadataVersions(),
+		"tls":               bannerDisabled,
+		"metrics":           bannerFlag(utils.GetBoolValue(false, config.EnableMetricsVar)),
+		"creds_cache_file":  bannerFlag(utils.GetValue("", config.CredsCacheFileVar) != ""),
+		"metadata_snapshot": utils.GetValue(config.DefaultMetadataSnapshotTTL, config.MetadataSnapshotTTLVar),
+		"creds_query_token": bannerFlag(utils.GetValue("", config.CredsQueryTokenValueVar) != ""),
+		"access_log":        utils.GetValue(bannerDisabled, config.AccessLogFormatVar),
+		"pprof":             bannerFlag(utils.GetBoolValue(false, config.EnablePprofVar)),
+		"debug_endpoints":   bannerFlag(utils.GetBoolValue(false, config.DebugEndpointsVar)),
+	}
+	if utils.GetValue("", config.TLSCertFileVar) != "" {
+		fields["tls"] = "min " + utils.GetValue(config.DefaultTLSMinVersion, config.TLSMinVersionVar)
+	}
+	return fields
+}
+
+// getBannerCredentialsSource returns where credentials for /creds come from: the upstream endpoint,
+// the configured source order, or the AWS SDK for Go's default chain
+func getBannerCredentialsSource() string {
+	if upstreamURI := utils.GetValue("", config.UpstreamCredsURIVar); upstreamURI != "" {
+		return "upstream " + redactURL(upstreamURI)
+	}
+	if order := utils.GetValue("", config.CredsSourceOrderVar); order != "" {
+		return strings.Replace(order, " ", "", -1)
+	}
+	return "default-chain"
+}
+
+// getBannerMetadataVersions returns the metadata versions which are served, and the optional paths which serve them
+func getBannerMetadataVersions() string {
+	versions := []string{"v2", "v3"}
+	if utils.GetBoolValue(false, config.TaskAliasVar) {
+		versions = append(versions, config.TaskAliasPath)
+	}
+	if utils.GetBoolValue(false, config.MetadataVersionsVar) {
+		versions = append(versions, config.MetadataVersionsPath)
+	}
+	return strings.Join(versions, ",")
+}
+
+// redactURL returns the URL without its user info or query, either of which may hold a secret
+func redactURL(value string) string {
+	parsed, err := url.Parse(value)
+	if err != nil {
+		return "REDACTED"
+	}
+	if parsed.User != nil {
+		parsed.User = url.User("REDACTED")
+	}
+	parsed.RawQuery = ""
+	return parsed.String()
+}
+
+func bannerFlag(enabled bool) string {
+	if enabled {
+		return bannerEnabled
+	}
+	return bannerDisabled
+}
@@ -0,0 +1,137 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package server sets up the network listener for the Local Endpoints HTTP server
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	bindRetryInterval = 500 * time.Millisecond
+)
+
+// Listen binds to the given port on all interfaces
+// If the port is already in use, binding is retried until the configured bind retry period has elapsed
+func Listen(port string) (net.Listener, error) {
+	retryPeriod := utils.GetDurationValue(config.DefaultBindRetry, config.BindRetryVar)
+	deadline := time.Now().Add(retryPeriod)
+
+	for {
+		listener, err := net.Listen("tcp", fmt.Sprintf(":%s", port))
+		if err == nil {
+			return listener, nil
+		}
+		if !isAddressInUse(err) {
+			return nil, err
+		}
+		if time.Now().Add(bindRetryInterval).After(deadline) {
+			return nil, fmt.Errorf("Port %s is already in use; set %s to use a different port, or set %s to keep retrying while the port is freed: %s", port, config.PortVar, config.BindRetryVar, err)
+		}
+		logrus.Infof("Port %s is already in use; will retry", port)
+		time.Sleep(bindRetryInterval)
+	}
+}
+
+func isAddressInUse(err error) bool {
+	opErr, ok := err.(*net.OpError)
+	if !ok {
+		return false
+	}
+	sysErr, ok := opErr.Err.(*os.SyscallError)
+	if !ok {
+		return false
+	}
+	return sysErr.Err == syscall.EADDRINUSE
+}
+
+// WithConnectionLimit limits the listener to ECS_LOCAL_MAX_CONNECTIONS connections at once, if it is set
+// Once the limit is reached, Accept waits until one of the connections is closed, so further connections
+// queue in the listen backlog
+func WithConnectionLimit(listener net.Listener) (net.Listener, error) {
+	value := utils.GetValue("", config.MaxConnectionsVar)
+	if value == "" {
+		return listener, nil
+	}
+	maxConnections, err := strconv.Atoi(value)
+	if err != nil || maxConnections <= 0 {
+		return nil, fmt.Errorf("Invalid value for %s: %s; expected a positive number", config.MaxConnectionsVar, value)
+	}
+	logrus.Infof("Serving at most %d connections at once", maxConnections)
+	return &limitListener{
+		Listener: listener,
+		slots:    make(chan struct{}, maxConnections),
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// limitListener holds a slot for each accepted connection, until the connection is closed
+type limitListener struct {
+	net.Listener
+	slots     chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (listener *limitListener) Accept() (net.Conn, error) {
+	select {
+	case listener.slots <- struct{}{}:
+	case <-listener.done:
+		return nil, fmt.Errorf("accept %s: listener closed", listener.Addr())
+	}
+	conn, err := listener.Listener.Accept()
+	if err != nil {
+		listener.release()
+		return nil, err
+	}
+	return &limitConn{
+		Conn:    conn,
+		release: listener.release,
+	}, nil
+}
+
+// Close also unblocks the Accept calls which are waiting for a slot
+func (listener *limitListener) Close() error {
+	listener.closeOnce.Do(func() {
+		close(listener.done)
+	})
+	return listener.Listener.Close()
+}
+
+func (listener *limitListener) release() {
+	<-listener.slots
+}
+
+// limitConn releases its slot once, when it is first closed
+type limitConn struct {
+	net.Conn
+	release     func()
+	releaseOnce sync.Once
+}
+
+func (conn *limitConn) Close() error {
+	err := conn.Conn.Close()
+	conn.releaseOnce.Do(conn.release)
+	return err
+}
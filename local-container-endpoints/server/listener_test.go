@@ -0,0 +1,130 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package server
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListenPortInUse(t *testing.T) {
+	occupied, port := occupyPort(t)
+	defer occupied.Close()
+
+	_, err := Listen(port)
+	if assert.Error(t, err, "Expected error listening on a port which is in use") {
+		assert.Contains(t, err.Error(), "Port "+port+" is already in use", "Expected the error to identify the port")
+	}
+}
+
+func TestListenRetriesWhilePortInUse(t *testing.T) {
+	occupied, port := occupyPort(t)
+
+	os.Setenv(config.BindRetryVar, "5s")
+	defer os.Unsetenv(config.BindRetryVar)
+
+	go func() {
+		time.Sleep(time.Second)
+		occupied.Close()
+	}()
+
+	listener, err := Listen(port)
+	if assert.NoError(t, err, "Expected listen to succeed once the port was freed") {
+		listener.Close()
+	}
+}
+
+func TestWithConnectionLimit(t *testing.T) {
+	os.Setenv(config.MaxConnectionsVar, "2")
+	defer os.Unsetenv(config.MaxConnectionsVar)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	limited, err := WithConnectionLimit(listener)
+	if !assert.NoError(t, err, "Unexpected error limiting connections") {
+		listener.Close()
+		return
+	}
+	defer limited.Close()
+
+	accepted := make(chan net.Conn, 3)
+	go func() {
+		for {
+			conn, err := limited.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	for i := 0; i < 3; i++ {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+	}
+
+	first := <-accepted
+	<-accepted
+	select {
+	case <-accepted:
+		t.Fatal("Expected connections beyond the limit to wait")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	first.Close()
+	select {
+	case conn := <-accepted:
+		conn.Close()
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the queued connection to be accepted once a connection was closed")
+	}
+}
+
+func TestWithConnectionLimitInvalid(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	unlimited, err := WithConnectionLimit(listener)
+	assert.NoError(t, err, "Unexpected error without a limit")
+	assert.Equal(t, listener, unlimited, "Expected the listener to be unchanged without a limit")
+
+	for _, value := range []string{"0", "-1", "many"} {
+		os.Setenv(config.MaxConnectionsVar, value)
+		_, err = WithConnectionLimit(listener)
+		assert.Error(t, err, "Expected an error for an invalid limit")
+	}
+	os.Unsetenv(config.MaxConnectionsVar)
+}
+
+func occupyPort(t *testing.T) (net.Listener, string) {
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return listener, strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+}
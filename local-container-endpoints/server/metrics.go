@@ -0,0 +1,191 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	latencyMetricName  = "ecs_local_http_request_duration_seconds"
+	openMetricsType    = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+	requestIDHeader    = "X-Request-Id"
+	traceIDHeader      = "X-Amzn-Trace-Id"
+	traceIDRootField   = "Root="
+	maxExemplarRunes   = 128
+	exemplarTimeFormat = "%.3f"
+)
+
+// latencyBuckets are the upper bounds of the latency histogram's buckets, in seconds, which are the Prometheus defaults
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// WithMetrics wraps the handler so that the latency of each request is recorded in a histogram, which is served
+// in the OpenMetrics format at MetricsPath, if ECS_LOCAL_ENABLE_METRICS is set
+// By default, there are no metrics, and the handler is returned as is
+func WithMetrics(handler http.Handler) http.Handler {
+	if !utils.GetBoolValue(false, config.EnableMetricsVar) {
+		return handler
+	}
+	return &metricsHandler{
+		handler: handler,
+		latency: newLatencyHistogram(),
+	}
+}
+
+type metricsHandler struct {
+	handler http.Handler
+	latency *latencyHistogram
+}
+
+func (h *metricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == config.MetricsPath {
+		w.Header().Set("Content-Type", openMetricsType)
+		if err := h.latency.write(w); err != nil {
+			logrus.Warnf("Failed to write metrics: %s", err)
+		}
+		return
+	}
+
+	received := time.Now()
+	h.handler.ServeHTTP(w, r)
+	h.latency.observe(time.Since(received).Seconds(), getExemplarLabels(r), time.Now())
+}
+
+// latencyHistogram counts the requests in each latency bucket, and keeps the most recent request in each bucket
+// as its exemplar, so that slow requests can be correlated with their traces
+type latencyHistogram struct {
+	lock      sync.Mutex
+	counts    []uint64
+	exemplars []*exemplar
+	sum       float64
+	count     uint64
+}
+
+// exemplar is a request whose latency fell into a bucket
+type exemplar struct {
+	labels    string
+	value     float64
+	timestamp time.Time
+}
+
+func newLatencyHistogram() *latencyHistogram {
+	// the last bucket is +Inf
+	return &latencyHistogram{
+		counts:    make([]uint64, len(latencyBuckets)+1),
+		exemplars: make([]*exemplar, len(latencyBuckets)+1),
+	}
+}
+
+// observe records a request which took value seconds
+// labels are the exemplar's labels in the OpenMetrics format, or empty if the request can not be correlated with a trace
+func (histogram *latencyHistogram) observe(value float64, labels string, timestamp time.Time) {
+	bucket := len(latencyBuckets)
+	for i, upperBound := range latencyBuckets {
+		if value <= upperBound {
+			bucket = i
+			break
+		}
+	}
+
+	histogram.lock.Lock()
+	defer histogram.lock.Unlock()
+	histogram.counts[bucket]++
+	histogram.sum += value
+	histogram.count++
+	if labels != "" {
+		histogram.exemplars[bucket] = &exemplar{
+			labels:    labels,
+			value:     value,
+			timestamp: timestamp,
+		}
+	}
+}
+
+// write writes the histogram in the OpenMetrics text format, in which the bucket counts are cumulative
+func (histogram *latencyHistogram) write(out io.Writer) error {
+	histogram.lock.Lock()
+	defer histogram.lock.Unlock()
+
+	var lines strings.Builder
+	fmt.Fprintf(&lines, "# TYPE %s histogram\n", latencyMetricName)
+	fmt.Fprintf(&lines, "# HELP %s The latency of the requests served by Local Endpoints.\n", latencyMetricName)
+	var cumulative uint64
+	for i, count := range histogram.counts {
+		cumulative += count
+		upperBound := "+Inf"
+		if i < len(latencyBuckets) {
+			upperBound = formatMetricValue(latencyBuckets[i])
+		}
+		fmt.Fprintf(&lines, "%s_bucket{le=\"%s\"} %d", latencyMetricName, upperBound, cumulative)
+		if e := histogram.exemplars[i]; e != nil {
+			fmt.Fprintf(&lines, " # {%s} %s "+exemplarTimeFormat, e.labels, formatMetricValue(e.value),
+				float64(e.timestamp.UnixNano())/float64(time.Second))
+		}
+		lines.WriteString("\n")
+	}
+	fmt.Fprintf(&lines, "%s_sum %s\n", latencyMetricName, formatMetricValue(histogram.sum))
+	fmt.Fprintf(&lines, "%s_count %d\n", latencyMetricName, histogram.count)
+	lines.WriteString("# EOF\n")
+
+	_, err := io.WriteString(out, lines.String())
+	return err
+}
+
+// getExemplarLabels returns the exemplar labels which link the request to its trace: the request's X-Amzn-Trace-Id
+// root as trace_id, or otherwise its X-Request-Id as request_id
+func getExemplarLabels(r *http.Request) string {
+	var labels string
+	if traceID := getTraceRoot(r.Header.Get(traceIDHeader)); traceID != "" {
+		labels = fmt.Sprintf("trace_id=\"%s\"", escapeLabelValue(traceID))
+	} else if requestID := r.Header.Get(requestIDHeader); requestID != "" {
+		labels = fmt.Sprintf("request_id=\"%s\"", escapeLabelValue(requestID))
+	}
+	// OpenMetrics limits the length of an exemplar's labels
+	if len([]rune(labels)) > maxExemplarRunes {
+		return ""
+	}
+	return labels
+}
+
+// getTraceRoot returns the trace ID from an X-Amzn-Trace-Id header, for example Root=1-5759e988-bd862e3fe1be46a994272793;Sampled=1
+func getTraceRoot(header string) string {
+	for _, field := range strings.Split(header, ";") {
+		field = strings.TrimSpace(field)
+		if strings.HasPrefix(field, traceIDRootField) {
+			return strings.TrimPrefix(field, traceIDRootField)
+		}
+	}
+	return ""
+}
+
+func escapeLabelValue(value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, `"`, `\"`, -1)
+	value = strings.Replace(value, "\n", `\n`, -1)
+	return value
+}
+
+func formatMetricValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
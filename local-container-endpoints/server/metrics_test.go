@@ -0,0 +1,89 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithMetricsDisabled(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	recorder := httptest.NewRecorder()
+	WithMetrics(handler).ServeHTTP(recorder, httptest.NewRequest("GET", config.MetricsPath, nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code, "Expected no metrics by default")
+}
+
+func TestWithMetricsExemplars(t *testing.T) {
+	os.Setenv(config.EnableMetricsVar, "true")
+	defer os.Unsetenv(config.EnableMetricsVar)
+
+	handler := WithMetrics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(30 * time.Millisecond)
+		}
+	}))
+
+	request := httptest.NewRequest("GET", "/slow", nil)
+	request.Header.Set("X-Amzn-Trace-Id", "Root=1-5759e988-bd862e3fe1be46a994272793;Sampled=1")
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+	request = httptest.NewRequest("GET", "/v3/task", nil)
+	request.Header.Set("X-Request-Id", "req-1234")
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+	// requests which can not be correlated with a trace are counted, but are never exemplars
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/creds", nil))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", config.MetricsPath, nil))
+	assert.Equal(t, http.StatusOK, recorder.Code, "Expected status code to match")
+	assert.Equal(t, "application/openmetrics-text; version=1.0.0; charset=utf-8", recorder.Header().Get("Content-Type"), "Expected the OpenMetrics content type")
+
+	output := recorder.Body.String()
+	assert.True(t, strings.HasSuffix(output, "# EOF\n"), "Expected the OpenMetrics terminator")
+	assert.Contains(t, output, "# TYPE ecs_local_http_request_duration_seconds histogram\n", "Expected a histogram")
+	assert.Contains(t, output, "ecs_local_http_request_duration_seconds_bucket{le=\"+Inf\"} 3", "Expected all requests to be counted")
+	assert.Contains(t, output, "ecs_local_http_request_duration_seconds_count 3\n", "Expected all requests to be counted")
+
+	slowExemplar := regexp.MustCompile(`ecs_local_http_request_duration_seconds_bucket\{le="0\.05"\} \d+ # \{trace_id="1-5759e988-bd862e3fe1be46a994272793"\} 0\.0[3-4]\d* \d+\.\d{3}\n`)
+	assert.Regexp(t, slowExemplar, output, "Expected the slow request to be the exemplar of its bucket")
+	fastExemplar := regexp.MustCompile(`ecs_local_http_request_duration_seconds_bucket\{le="0\.005"\} \d+ # \{request_id="req-1234"\} [\d.e-]+ \d+\.\d{3}\n`)
+	assert.Regexp(t, fastExemplar, output, "Expected the request ID to be the exemplar of its bucket")
+	assert.Equal(t, 2, strings.Count(output, " # {"), "Expected only the requests with a trace or request ID to be exemplars")
+}
+
+func TestGetExemplarLabels(t *testing.T) {
+	request := httptest.NewRequest("GET", "/", nil)
+	assert.Empty(t, getExemplarLabels(request), "Expected no exemplar labels without a trace or request ID")
+
+	request.Header.Set("X-Request-Id", `id"with"quotes`)
+	assert.Equal(t, `request_id="id\"with\"quotes"`, getExemplarLabels(request), "Expected the request ID to be escaped")
+
+	request.Header.Set("X-Amzn-Trace-Id", "Self=1-67891234-12456789abcdef012345678;Root=1-67891233-abcdef012345678912345678")
+	assert.Equal(t, `trace_id="1-67891233-abcdef012345678912345678"`, getExemplarLabels(request), "Expected the trace ID to take precedence")
+
+	request.Header.Del("X-Amzn-Trace-Id")
+	request.Header.Set("X-Request-Id", strings.Repeat("a", 128))
+	assert.Empty(t, getExemplarLabels(request), "Expected no exemplar labels when they are too long for OpenMetrics")
+}
@@ -0,0 +1,59 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package server
+
+import (
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/utils"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// SetupPprofRoutes mounts the pprof handlers if ECS_LOCAL_ENABLE_PPROF is set, unless they are served at their own port
+func SetupPprofRoutes(router *mux.Router) {
+	if !utils.GetBoolValue(false, config.EnablePprofVar) || utils.GetValue("", config.PprofPortVar) != "" {
+		return
+	}
+	addPprofRoutes(router)
+}
+
+// ServePprof serves the pprof handlers at ECS_LOCAL_PPROF_PORT, if pprof is enabled and the port is set
+// Otherwise, it returns immediately
+func ServePprof() error {
+	port := utils.GetValue("", config.PprofPortVar)
+	if !utils.GetBoolValue(false, config.EnablePprofVar) || port == "" {
+		return nil
+	}
+
+	router := mux.NewRouter()
+	addPprofRoutes(router)
+	listener, err := Listen(port)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("Serving %s at port %s", config.PprofPath, port)
+	return http.Serve(listener, router)
+}
+
+func addPprofRoutes(router *mux.Router) {
+	router.HandleFunc(config.PprofPath+"cmdline", pprof.Cmdline)
+	router.HandleFunc(config.PprofPath+"profile", pprof.Profile)
+	router.HandleFunc(config.PprofPath+"symbol", pprof.Symbol)
+	router.HandleFunc(config.PprofPath+"trace", pprof.Trace)
+	// Index serves the index, and each of the runtime's named profiles, such as heap and goroutine
+	router.PathPrefix(config.PprofPath).HandlerFunc(pprof.Index)
+}
@@ -0,0 +1,71 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+var pprofPaths = []string{
+	"/debug/pprof/",
+	"/debug/pprof/cmdline",
+	"/debug/pprof/heap",
+	"/debug/pprof/goroutine",
+}
+
+func TestSetupPprofRoutesEnabled(t *testing.T) {
+	os.Setenv(config.EnablePprofVar, "true")
+	defer os.Unsetenv(config.EnablePprofVar)
+
+	router := mux.NewRouter()
+	SetupPprofRoutes(router)
+
+	for _, path := range pprofPaths {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+		assert.Equal(t, http.StatusOK, recorder.Code, "Expected %s to be served", path)
+	}
+}
+
+func TestSetupPprofRoutesDisabled(t *testing.T) {
+	router := mux.NewRouter()
+	SetupPprofRoutes(router)
+
+	for _, path := range pprofPaths {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+		assert.Equal(t, http.StatusNotFound, recorder.Code, "Expected %s to not be served by default", path)
+	}
+}
+
+func TestSetupPprofRoutesSeparatePort(t *testing.T) {
+	os.Setenv(config.EnablePprofVar, "true")
+	defer os.Unsetenv(config.EnablePprofVar)
+	os.Setenv(config.PprofPortVar, "6060")
+	defer os.Unsetenv(config.PprofPortVar)
+
+	router := mux.NewRouter()
+	SetupPprofRoutes(router)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", config.PprofPath, nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code, "Expected pprof to not be served with the endpoints when it has its own port")
+}
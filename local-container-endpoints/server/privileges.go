@@ -0,0 +1,76 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// unchangedID is used for a uid or gid which is not configured
+const unchangedID = -1
+
+// DropPrivileges switches to the uid and gid set in ECS_LOCAL_RUN_AS_UID and ECS_LOCAL_RUN_AS_GID, if either is set
+// It is called once the server is listening, so that Local Endpoints can run as root to bind a privileged port
+// Invalid values are an error rather than ignored, since otherwise Local Endpoints would keep running as root
+func DropPrivileges() error {
+	uid, err := getRunAsID(config.RunAsUIDVar)
+	if err != nil {
+		return err
+	}
+	gid, err := getRunAsID(config.RunAsGIDVar)
+	if err != nil {
+		return err
+	}
+	if uid == unchangedID && gid == unchangedID {
+		return nil
+	}
+
+	// the group must be changed first, since changing the user gives up the privilege to change it
+	if os.Geteuid() == 0 {
+		if err = syscall.Setgroups(nil); err != nil {
+			return fmt.Errorf("Failed to drop the supplementary groups: %s", err)
+		}
+	}
+	if gid != unchangedID {
+		if err = syscall.Setgid(gid); err != nil {
+			return fmt.Errorf("Failed to set the gid to %d: %s", gid, err)
+		}
+	}
+	if uid != unchangedID {
+		if err = syscall.Setuid(uid); err != nil {
+			return fmt.Errorf("Failed to set the uid to %d: %s", uid, err)
+		}
+	}
+	logrus.Infof("Running as uid %d and gid %d", os.Getuid(), os.Getgid())
+	return nil
+}
+
+func getRunAsID(envVar string) (int, error) {
+	value := utils.GetValue("", envVar)
+	if value == "" {
+		return unchangedID, nil
+	}
+	id, err := strconv.Atoi(value)
+	if err != nil || id < 0 {
+		return unchangedID, fmt.Errorf("Invalid value for %s: %s", envVar, value)
+	}
+	return id, nil
+}
@@ -0,0 +1,79 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package server
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"testing"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	// dropPrivilegesHelperVar runs TestDropPrivilegesHelperProcess, so that the privileges of the test process are unchanged
+	dropPrivilegesHelperVar = "ECS_LOCAL_TEST_DROP_PRIVILEGES_HELPER"
+	nobodyID                = 65534
+)
+
+func TestDropPrivilegesNotConfigured(t *testing.T) {
+	uid, gid := os.Getuid(), os.Getgid()
+	assert.NoError(t, DropPrivileges(), "Unexpected error when no uid or gid is set")
+	assert.Equal(t, uid, os.Getuid(), "Expected the uid to be unchanged")
+	assert.Equal(t, gid, os.Getgid(), "Expected the gid to be unchanged")
+}
+
+func TestDropPrivilegesInvalidValue(t *testing.T) {
+	uid := os.Getuid()
+	for _, value := range []string{"nobody", "-5"} {
+		os.Setenv(config.RunAsUIDVar, value)
+		err := DropPrivileges()
+		os.Unsetenv(config.RunAsUIDVar)
+		assert.Error(t, err, "Expected an error for the invalid uid %s", value)
+		assert.Equal(t, uid, os.Getuid(), "Expected the uid to be unchanged")
+	}
+}
+
+func TestDropPrivileges(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Dropping privileges requires running the tests as root")
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=TestDropPrivilegesHelperProcess")
+	cmd.Env = append(os.Environ(),
+		dropPrivilegesHelperVar+"=true",
+		config.RunAsUIDVar+"="+strconv.Itoa(nobodyID),
+		config.RunAsGIDVar+"="+strconv.Itoa(nobodyID),
+	)
+	output, err := cmd.CombinedOutput()
+	assert.NoError(t, err, "Expected privileges to be dropped: %s", output)
+}
+
+// TestDropPrivilegesHelperProcess drops the privileges of its process, which TestDropPrivileges starts
+func TestDropPrivilegesHelperProcess(t *testing.T) {
+	if os.Getenv(dropPrivilegesHelperVar) != "true" {
+		return
+	}
+
+	err := DropPrivileges()
+	if assert.NoError(t, err, "Unexpected error dropping privileges") {
+		assert.Equal(t, nobodyID, os.Getuid(), "Expected the uid to be changed")
+		assert.Equal(t, nobodyID, os.Geteuid(), "Expected the effective uid to be changed")
+		assert.Equal(t, nobodyID, os.Getgid(), "Expected the gid to be changed")
+		groups, _ := os.Getgroups()
+		assert.Empty(t, groups, "Expected the supplementary groups to be dropped")
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package server
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/utils"
+	"github.com/pkg/errors"
+)
+
+const forwardedForHeader = "X-Forwarded-For"
+
+// WithTrustedProxies wraps the handler so that requests from the proxies in ECS_LOCAL_TRUSTED_PROXIES are treated
+// as coming from the client in their X-Forwarded-For header, which then applies to everything that identifies the
+// caller by IP, such as the caller's container. Forwarded headers from any other peer are ignored, since the peer
+// could have set them to spoof another client.
+// By default, no proxies are trusted, and the handler is returned as is
+func WithTrustedProxies(handler http.Handler) (http.Handler, error) {
+	value := utils.GetValue("", config.TrustedProxiesVar)
+	if value == "" {
+		return handler, nil
+	}
+	proxies, err := parseTrustedProxies(value)
+	if err != nil {
+		return nil, err
+	}
+	return &trustedProxiesHandler{
+		handler: handler,
+		proxies: proxies,
+	}, nil
+}
+
+// parseTrustedProxies parses a comma separated list of CIDR blocks; single IP addresses are also accepted
+func parseTrustedProxies(value string) ([]*net.IPNet, error) {
+	var proxies []*net.IPNet
+	for _, proxy := range strings.Split(value, ",") {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, errors.Errorf("invalid value for %s: %s is not an IP address or CIDR block", config.TrustedProxiesVar, proxy)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, block, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid value for %s", config.TrustedProxiesVar)
+		}
+		proxies = append(proxies, block)
+	}
+	return proxies, nil
+}
+
+type trustedProxiesHandler struct {
+	handler http.Handler
+	proxies []*net.IPNet
+}
+
+func (h *trustedProxiesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if clientIP := h.clientIP(r); clientIP != "" {
+		// copy the request, rather than changing the one which belongs to the server
+		forwarded := r.WithContext(r.Context())
+		forwarded.RemoteAddr = net.JoinHostPort(clientIP, "0")
+		r = forwarded
+	}
+	h.handler.ServeHTTP(w, r)
+}
+
+// clientIP returns the client in the X-Forwarded-For header, if the request comes from a trusted proxy
+// Each proxy appends the address it received the request from, so the client is the right-most address which is
+// not itself a trusted proxy; any addresses to its left could have been set by the client.
+func (h *trustedProxiesHandler) clientIP(r *http.Request) string {
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil || !h.isTrusted(peer) {
+		return ""
+	}
+	var forwardedFor []string
+	for _, header := range r.Header[forwardedForHeader] {
+		forwardedFor = append(forwardedFor, strings.Split(header, ",")...)
+	}
+
+	clientIP := ""
+	for i := len(forwardedFor) - 1; i >= 0; i-- {
+		address := strings.TrimSpace(forwardedFor[i])
+		if net.ParseIP(address) == nil {
+			// the header can not be relied on past an address which is not valid
+			break
+		}
+		clientIP = address
+		if !h.isTrusted(address) {
+			break
+		}
+	}
+	return clientIP
+}
+
+func (h *trustedProxiesHandler) isTrusted(address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, proxy := range h.proxies {
+		if proxy.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
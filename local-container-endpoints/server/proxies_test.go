@@ -0,0 +1,131 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// callerIPInTest returns the IP which the handler sees the request as coming from
+func callerIPInTest(t *testing.T, remoteAddr string, forwardedFor ...string) string {
+	var callerIP string
+	wrapped, err := WithTrustedProxies(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		callerIP, _, _ = net.SplitHostPort(r.RemoteAddr)
+	}))
+	if !assert.NoError(t, err, "Unexpected error wrapping the handler") {
+		return ""
+	}
+	request := httptest.NewRequest("GET", "/v3/task", nil)
+	request.RemoteAddr = remoteAddr
+	for _, header := range forwardedFor {
+		request.Header.Add(forwardedForHeader, header)
+	}
+	wrapped.ServeHTTP(httptest.NewRecorder(), request)
+	return callerIP
+}
+
+func TestWithTrustedProxies(t *testing.T) {
+	os.Setenv(config.TrustedProxiesVar, "10.0.0.0/8, 192.168.1.10,fd00::/8")
+	defer os.Unsetenv(config.TrustedProxiesVar)
+
+	var testCases = []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		expected     string
+	}{
+		{
+			name:         "trusted proxy",
+			remoteAddr:   "10.1.2.3:41234",
+			forwardedFor: []string{"172.17.0.3"},
+			expected:     "172.17.0.3",
+		},
+		{
+			name:         "trusted proxy by IP",
+			remoteAddr:   "192.168.1.10:41234",
+			forwardedFor: []string{"172.17.0.3"},
+			expected:     "172.17.0.3",
+		},
+		{
+			name:         "trusted IPv6 proxy",
+			remoteAddr:   "[fd00::1]:41234",
+			forwardedFor: []string{"2001:db8::3"},
+			expected:     "2001:db8::3",
+		},
+		{
+			name:         "chain of trusted proxies",
+			remoteAddr:   "10.1.2.3:41234",
+			forwardedFor: []string{"172.17.0.3, 10.4.5.6", "10.7.8.9"},
+			expected:     "172.17.0.3",
+		},
+		{
+			name:         "client spoofs an address through a trusted proxy",
+			remoteAddr:   "10.1.2.3:41234",
+			forwardedFor: []string{"172.17.0.99, 172.17.0.3"},
+			expected:     "172.17.0.3",
+		},
+		{
+			name:         "invalid address in the header",
+			remoteAddr:   "10.1.2.3:41234",
+			forwardedFor: []string{"172.17.0.99, unknown, 10.4.5.6"},
+			expected:     "10.4.5.6",
+		},
+		{
+			name:       "trusted proxy without the header",
+			remoteAddr: "10.1.2.3:41234",
+			expected:   "10.1.2.3",
+		},
+		{
+			name:         "untrusted peer",
+			remoteAddr:   "172.17.0.3:41234",
+			forwardedFor: []string{"172.17.0.99"},
+			expected:     "172.17.0.3",
+		},
+		{
+			name:         "untrusted peer which claims to be a trusted proxy",
+			remoteAddr:   "192.168.1.11:41234",
+			forwardedFor: []string{"172.17.0.99, 10.4.5.6"},
+			expected:     "192.168.1.11",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			callerIP := callerIPInTest(t, testCase.remoteAddr, testCase.forwardedFor...)
+			assert.Equal(t, testCase.expected, callerIP, "Expected caller IP to match")
+		})
+	}
+}
+
+func TestWithTrustedProxiesDefault(t *testing.T) {
+	// forwarded headers are never trusted by default
+	callerIP := callerIPInTest(t, "10.1.2.3:41234", "172.17.0.99")
+	assert.Equal(t, "10.1.2.3", callerIP, "Expected the direct peer to be the caller")
+}
+
+func TestWithTrustedProxiesInvalid(t *testing.T) {
+	for _, value := range []string{"10.0.0.0/33", "proxy.local", "10.0.0.0/8,nope"} {
+		os.Setenv(config.TrustedProxiesVar, value)
+		_, err := WithTrustedProxies(http.NotFoundHandler())
+		assert.Error(t, err, "Expected error for invalid trusted proxies %s", value)
+	}
+	os.Unsetenv(config.TrustedProxiesVar)
+}
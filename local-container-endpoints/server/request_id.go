@@ -0,0 +1,70 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// maxRequestIDLength bounds the incoming request IDs which are honored, since they are written to the logs
+const maxRequestIDLength = 128
+
+// WithRequestID wraps the handler so that each request has an ID, if ECS_LOCAL_REQUEST_ID_HEADER is set. The ID is
+// read from the header it names, or generated if the request has none, and is echoed in the same response header.
+// Handlers log the ID with utils.RequestLogger. By default, the handler is returned as is.
+func WithRequestID(handler http.Handler) http.Handler {
+	header := strings.TrimSpace(utils.GetValue("", config.RequestIDHeaderVar))
+	if header == "" {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(header)
+		if !isRequestIDValid(requestID) {
+			requestID = newRequestID()
+			// inner handlers, such as the metrics exemplars, see the generated ID as if it had been sent
+			r.Header.Set(header, requestID)
+		}
+		w.Header().Set(header, requestID)
+		handler.ServeHTTP(w, utils.WithRequestID(r, requestID))
+	})
+}
+
+// isRequestIDValid checks that the incoming request ID is printable ASCII, so that it can't forge log lines
+func isRequestIDValid(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range requestID {
+		if c < ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID returns a random 128 bit ID
+func newRequestID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		logrus.Warnf("Failed to generate a request ID: %s", err)
+	}
+	return hex.EncodeToString(id)
+}
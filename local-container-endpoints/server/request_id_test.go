@@ -0,0 +1,77 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/utils"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithRequestIDDisabledByDefault(t *testing.T) {
+	handler := WithRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Empty(t, utils.GetRequestID(r), "Expected no request ID by default")
+	}))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/creds", nil))
+	assert.Empty(t, recorder.Header().Get("X-Request-ID"), "Expected no request ID header by default")
+}
+
+func TestWithRequestIDEchoedAndLogged(t *testing.T) {
+	os.Setenv(config.RequestIDHeaderVar, "X-Request-ID")
+	defer os.Unsetenv(config.RequestIDHeaderVar)
+
+	var out bytes.Buffer
+	logrus.SetOutput(&out)
+	defer logrus.SetOutput(os.Stderr)
+
+	handler := WithRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		utils.RequestLogger(r).Info("Received request")
+	}))
+
+	request := httptest.NewRequest("GET", "/creds", nil)
+	request.Header.Set("X-Request-ID", "abc-123")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, "abc-123", recorder.Header().Get("X-Request-ID"), "Expected the incoming request ID to be echoed")
+	assert.Contains(t, out.String(), "request_id=abc-123", "Expected the request ID to be logged")
+}
+
+func TestWithRequestIDGenerated(t *testing.T) {
+	os.Setenv(config.RequestIDHeaderVar, "X-Correlation-ID")
+	defer os.Unsetenv(config.RequestIDHeaderVar)
+
+	var logged string
+	handler := WithRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logged = utils.GetRequestID(r)
+	}))
+
+	request := httptest.NewRequest("GET", "/creds", nil)
+	request.Header.Set("X-Correlation-ID", "bad\nid")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	requestID := recorder.Header().Get("X-Correlation-ID")
+	assert.Regexp(t, "^[0-9a-f]{32}$", requestID, "Expected a generated request ID in place of the invalid one")
+	assert.Equal(t, requestID, logged, "Expected the handler to see the generated request ID")
+}
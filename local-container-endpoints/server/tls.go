@@ -0,0 +1,98 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/utils"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsCipherSuites are the cipher suites which can be set in ECS_LOCAL_TLS_CIPHER_SUITES, by their IANA names
+var tlsCipherSuites = map[string]uint16{
+	"TLS_RSA_WITH_AES_128_CBC_SHA":                  tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_RSA_WITH_AES_256_CBC_SHA":                  tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_RSA_WITH_AES_128_GCM_SHA256":               tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_RSA_WITH_AES_256_GCM_SHA384":               tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA":          tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA":          tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA":            tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA":            tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256":         tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256":       tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384":         tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384":       tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256":   tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256": tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+}
+
+// WithTLS serves TLS on the listener if ECS_LOCAL_TLS_CERT_FILE and ECS_LOCAL_TLS_KEY_FILE are set,
+// and otherwise returns the listener unchanged
+// Invalid settings are an error rather than ignored, since otherwise Local Endpoints would accept weaker connections than configured
+func WithTLS(listener net.Listener) (net.Listener, error) {
+	certFile := utils.GetValue("", config.TLSCertFileVar)
+	keyFile := utils.GetValue("", config.TLSKeyFileVar)
+	if certFile == "" && keyFile == "" {
+		return listener, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("Both %s and %s must be set to enable TLS", config.TLSCertFileVar, config.TLSKeyFileVar)
+	}
+
+	tlsConfig, err := getTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load the TLS certificate: %s", err)
+	}
+	tlsConfig.Certificates = []tls.Certificate{certificate}
+	return tls.NewListener(listener, tlsConfig), nil
+}
+
+func getTLSConfig() (*tls.Config, error) {
+	value := utils.GetValue(config.DefaultTLSMinVersion, config.TLSMinVersionVar)
+	minVersion, ok := tlsVersions[value]
+	if !ok {
+		return nil, fmt.Errorf("Invalid value for %s: %s", config.TLSMinVersionVar, value)
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: minVersion,
+	}
+	value = utils.GetValue("", config.TLSCipherSuitesVar)
+	if value == "" {
+		return tlsConfig, nil
+	}
+	for _, name := range strings.Split(value, ",") {
+		cipherSuite, ok := tlsCipherSuites[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("Invalid value for %s: unsupported cipher suite %s", config.TLSCipherSuitesVar, name)
+		}
+		tlsConfig.CipherSuites = append(tlsConfig.CipherSuites, cipherSuite)
+	}
+	return tlsConfig, nil
+}
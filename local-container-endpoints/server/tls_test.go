@@ -0,0 +1,193 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithTLSDisabled(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	actual, err := WithTLS(listener)
+	assert.NoError(t, err, "Unexpected error without TLS settings")
+	assert.Equal(t, listener, actual, "Expected the listener to be unchanged")
+}
+
+func TestWithTLSRejectsOlderVersions(t *testing.T) {
+	defer os.RemoveAll(setTLSCertificateInTest(t))
+	defer os.Unsetenv(config.TLSCertFileVar)
+	defer os.Unsetenv(config.TLSKeyFileVar)
+
+	listener := listenTLSInTest(t)
+	defer listener.Close()
+
+	err := dialTLSInTest(listener, tls.VersionTLS11)
+	assert.Error(t, err, "Expected a TLS 1.1 client to be rejected when the minimum is 1.2")
+
+	err = dialTLSInTest(listener, tls.VersionTLS12)
+	assert.NoError(t, err, "Expected a TLS 1.2 client to be accepted")
+}
+
+func TestWithTLSMinVersion(t *testing.T) {
+	defer os.RemoveAll(setTLSCertificateInTest(t))
+	defer os.Unsetenv(config.TLSCertFileVar)
+	defer os.Unsetenv(config.TLSKeyFileVar)
+
+	os.Setenv(config.TLSMinVersionVar, "1.3")
+	defer os.Unsetenv(config.TLSMinVersionVar)
+
+	listener := listenTLSInTest(t)
+	defer listener.Close()
+
+	err := dialTLSInTest(listener, tls.VersionTLS12)
+	assert.Error(t, err, "Expected a TLS 1.2 client to be rejected when the minimum is 1.3")
+}
+
+func TestWithTLSInvalidSettings(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	os.Setenv(config.TLSCertFileVar, "/cert.pem")
+	_, err = WithTLS(listener)
+	assert.Error(t, err, "Expected error when only the certificate is set")
+
+	defer os.RemoveAll(setTLSCertificateInTest(t))
+	defer os.Unsetenv(config.TLSCertFileVar)
+	defer os.Unsetenv(config.TLSKeyFileVar)
+
+	os.Setenv(config.TLSMinVersionVar, "1.4")
+	_, err = WithTLS(listener)
+	assert.Error(t, err, "Expected error for an invalid minimum version")
+	os.Unsetenv(config.TLSMinVersionVar)
+
+	os.Setenv(config.TLSCipherSuitesVar, "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,TLS_RSA_WITH_RC4_128_SHA")
+	defer os.Unsetenv(config.TLSCipherSuitesVar)
+	_, err = WithTLS(listener)
+	if assert.Error(t, err, "Expected error for an unsupported cipher suite") {
+		assert.Contains(t, err.Error(), "TLS_RSA_WITH_RC4_128_SHA", "Expected the error to name the cipher suite")
+	}
+}
+
+func TestGetTLSConfigCipherSuites(t *testing.T) {
+	os.Setenv(config.TLSCipherSuitesVar, "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384")
+	defer os.Unsetenv(config.TLSCipherSuitesVar)
+
+	tlsConfig, err := getTLSConfig()
+	if assert.NoError(t, err, "Unexpected error getting the TLS config") {
+		assert.Equal(t, uint16(tls.VersionTLS12), tlsConfig.MinVersion, "Expected TLS 1.2 to be the default minimum")
+		assert.Equal(t, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384}, tlsConfig.CipherSuites, "Expected cipher suites to match")
+	}
+}
+
+// listenTLSInTest serves TLS handshakes on a local port until the listener is closed
+func listenTLSInTest(t *testing.T) net.Listener {
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := WithTLS(tcpListener)
+	if err != nil {
+		tcpListener.Close()
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+	return listener
+}
+
+func dialTLSInTest(listener net.Listener, version uint16) error {
+	conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{
+		MinVersion:         version,
+		MaxVersion:         version,
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// setTLSCertificateInTest creates a self-signed certificate, and sets it in ECS_LOCAL_TLS_CERT_FILE and ECS_LOCAL_TLS_KEY_FILE
+// It returns the directory of the certificate files, which the caller removes
+func setTLSCertificateInTest(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "ecs-local-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	if err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv(config.TLSCertFileVar, certFile)
+	os.Setenv(config.TLSKeyFileVar, keyFile)
+	return dir
+}
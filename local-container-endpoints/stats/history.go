@@ -0,0 +1,154 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package stats
+
+import (
+	"sync"
+	"time"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/utils"
+	"github.com/docker/docker/api/types"
+)
+
+// History keeps the most recent stats of each container, so that moving averages can be computed over them
+// The zero value is an empty history ready for use
+type History struct {
+	lock   sync.Mutex
+	frames map[string][]frame
+}
+
+// frame holds the values of a stats response which the moving averages are computed from
+type frame struct {
+	read        time.Time
+	cpuUsage    uint64
+	systemUsage uint64
+	onlineCPUs  uint32
+	// rxBytes and txBytes are summed across the container's interfaces; hasNetworks is false for containers
+	// without network stats, such as those in the host's network namespace
+	hasNetworks bool
+	rxBytes     uint64
+	txBytes     uint64
+}
+
+// AddMovingAverages records the container's stats, and adds the average CPU utilization and network rates over the
+// last ECS_LOCAL_STATS_MOVING_AVERAGE_WINDOW stats of the container to the response
+// networks are the container's network stats from the same payload, keyed by interface, which may be nil
+// The raw values from Docker in the response are left as they are
+func (history *History) AddMovingAverages(containerID string, response *ContainerStatsResponse, networks map[string]types.NetworkStats) {
+	window := utils.GetIntValue(0, config.StatsMovingAverageWindowVar)
+	if window < 2 {
+		// an average rate needs at least two stats
+		return
+	}
+
+	frames := history.record(containerID, newFrame(&response.Stats, networks), window)
+	if len(frames) < 2 {
+		return
+	}
+	first, last := frames[0], frames[len(frames)-1]
+	response.CPUUtilizationAverage = getCPUUtilizationAverage(first, last)
+	response.NetworkRxBytesPerSecondAverage, response.NetworkTxBytesPerSecondAverage = getNetworkRateAverages(first, last)
+}
+
+// Prune removes the stats of the containers which are no longer running, so that the history does not keep
+// the stats of every container which was ever polled
+func (history *History) Prune(containers []types.Container) {
+	history.lock.Lock()
+	defer history.lock.Unlock()
+
+	if len(history.frames) == 0 {
+		return
+	}
+	running := make(map[string]bool, len(containers))
+	for _, container := range containers {
+		running[container.ID] = true
+	}
+	for containerID := range history.frames {
+		if !running[containerID] {
+			delete(history.frames, containerID)
+		}
+	}
+}
+
+func getCPUUtilizationAverage(first, last frame) *float64 {
+	if last.cpuUsage < first.cpuUsage || last.systemUsage <= first.systemUsage {
+		// the container was restarted, and its usage counters were reset
+		return nil
+	}
+	// the system usage is summed across all CPUs, so this is the share of the whole host
+	cpuDelta := float64(last.cpuUsage - first.cpuUsage)
+	systemDelta := float64(last.systemUsage - first.systemUsage)
+	utilization := cpuDelta / systemDelta * 100
+	if getCPUPercentMode() == config.CPUPercentModeTotal {
+		// in the same way as the Docker CLI, as a percentage of one CPU
+		utilization *= float64(last.onlineCPUs)
+	}
+	return &utilization
+}
+
+// getNetworkRateAverages returns the bytes received and transmitted per second between the two frames
+func getNetworkRateAverages(first, last frame) (*float64, *float64) {
+	if !first.hasNetworks || !last.hasNetworks || last.rxBytes < first.rxBytes || last.txBytes < first.txBytes {
+		// the container was restarted, and its network counters were reset
+		return nil, nil
+	}
+	seconds := last.read.Sub(first.read).Seconds()
+	if seconds <= 0 {
+		return nil, nil
+	}
+	rxRate := float64(last.rxBytes-first.rxBytes) / seconds
+	txRate := float64(last.txBytes-first.txBytes) / seconds
+	return &rxRate, &txRate
+}
+
+// record adds the frame to the container's ring buffer, and returns a copy of the buffer's frames, oldest first
+func (history *History) record(containerID string, latest frame, window int) []frame {
+	history.lock.Lock()
+	defer history.lock.Unlock()
+
+	if history.frames == nil {
+		history.frames = make(map[string][]frame)
+	}
+	frames := history.frames[containerID]
+	// Docker computes stats about once per second, so concurrent requests can receive the same stats
+	if len(frames) == 0 || latest.read.After(frames[len(frames)-1].read) {
+		frames = append(frames, latest)
+	}
+	if len(frames) > window {
+		frames = frames[len(frames)-window:]
+	}
+	history.frames[containerID] = frames
+
+	return append([]frame(nil), frames...)
+}
+
+func newFrame(dockerStats *types.Stats, networks map[string]types.NetworkStats) frame {
+	latest := frame{
+		read:        dockerStats.Read,
+		cpuUsage:    dockerStats.CPUStats.CPUUsage.TotalUsage,
+		systemUsage: dockerStats.CPUStats.SystemUsage,
+		onlineCPUs:  dockerStats.CPUStats.OnlineCPUs,
+	}
+	if latest.onlineCPUs == 0 {
+		// older versions of Docker only report the usage of each CPU
+		latest.onlineCPUs = uint32(len(dockerStats.CPUStats.CPUUsage.PercpuUsage))
+	}
+	latest.hasNetworks = len(networks) > 0
+	for _, network := range networks {
+		latest.rxBytes += network.RxBytes
+		latest.txBytes += network.TxBytes
+	}
+	return latest
+}
@@ -0,0 +1,212 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package stats creates stats responses
+package stats
+
+import (
+	"encoding/json"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/utils"
+	"github.com/docker/docker/api/types"
+	"github.com/sirupsen/logrus"
+)
+
+// ContainerStatsResponse extends the Docker stats response with the values that Local Endpoints computes
+type ContainerStatsResponse struct {
+	types.Stats
+	// CPUUtilization is normalized as set in ECS_LOCAL_CPU_PERCENT_MODE
+	CPUUtilization    *float64 `json:"cpu_utilization,omitempty"`
+	MemoryUtilization *float64 `json:"memory_utilization,omitempty"`
+	MemoryUnlimited   bool     `json:"memory_unlimited,omitempty"`
+	MemoryWorkingSet  *uint64  `json:"memory_working_set,omitempty"`
+	// CPUUtilizationAverage and the network rate averages are only set if ECS_LOCAL_STATS_MOVING_AVERAGE_WINDOW is set
+	CPUUtilizationAverage          *float64 `json:"cpu_utilization_average,omitempty"`
+	NetworkRxBytesPerSecondAverage *float64 `json:"network_rx_bytes_per_second_average,omitempty"`
+	NetworkTxBytesPerSecondAverage *float64 `json:"network_tx_bytes_per_second_average,omitempty"`
+	// GPUStats is only set if ECS_LOCAL_INCLUDE_GPU_STATS is set, and the stats payload from Docker has GPU stats
+	GPUStats json.RawMessage `json:"gpu_stats,omitempty"`
+	// CPUThrottlingRatio is only set if ECS_LOCAL_INCLUDE_CPU_THROTTLING is set, and the container has a CPU quota
+	CPUThrottlingRatio *float64 `json:"cpu_throttling_ratio,omitempty"`
+	// MemoryBreakdown is only set if ECS_LOCAL_INCLUDE_MEMORY_BREAKDOWN is set, and Docker reports memory stats
+	MemoryBreakdown *MemoryBreakdownResponse `json:"memory_breakdown,omitempty"`
+	// SizeRw and SizeRootFs are only set if ECS_LOCAL_INCLUDE_STORAGE_STATS is set
+	SizeRw     *int64 `json:"size_rw,omitempty"`
+	SizeRootFs *int64 `json:"size_root_fs,omitempty"`
+}
+
+// MemoryBreakdownResponse is the container's memory usage by type, with the same names for cgroup v1 and v2
+// Each value is omitted if Docker does not report it; in particular, Docker does not report swap with cgroup v2
+type MemoryBreakdownResponse struct {
+	RSS   *uint64 `json:"rss,omitempty"`
+	Cache *uint64 `json:"cache,omitempty"`
+	Swap  *uint64 `json:"swap,omitempty"`
+}
+
+// Keys in Docker's memory stats for each value in the breakdown, in order of precedence. With cgroup v1, the total_
+// keys include child cgroups; with cgroup v2, anonymous memory and the page cache are reported as anon and file.
+var (
+	memoryRSSKeys   = []string{"total_rss", "rss", "anon"}
+	memoryCacheKeys = []string{"total_cache", "cache", "file"}
+	memorySwapKeys  = []string{"total_swap", "swap"}
+)
+
+// GetContainerStats returns the stats response for the container
+// inspect is the container's Docker inspect response, which may be nil if it could not be inspected
+func GetContainerStats(dockerStats *types.Stats, inspect *types.ContainerJSON) *ContainerStatsResponse {
+	response := &ContainerStatsResponse{
+		Stats:            *dockerStats,
+		CPUUtilization:   GetCPUUtilization(dockerStats),
+		MemoryWorkingSet: getMemoryWorkingSet(&dockerStats.MemoryStats),
+	}
+	if utils.GetBoolValue(false, config.IncludeCPUThrottlingVar) {
+		response.CPUThrottlingRatio = getCPUThrottlingRatio(dockerStats)
+	}
+	if utils.GetBoolValue(false, config.IncludeMemoryBreakdownVar) {
+		response.MemoryBreakdown = getMemoryBreakdown(&dockerStats.MemoryStats)
+	}
+
+	// Docker reports the host's memory as the limit of containers which have no memory limit
+	response.MemoryUnlimited = inspect != nil && inspect.ContainerJSONBase != nil &&
+		inspect.HostConfig != nil && inspect.HostConfig.Memory == 0
+	if response.MemoryUnlimited && getUnlimitedMemoryBehavior() == config.UnlimitedMemoryOmit {
+		return response
+	}
+	response.MemoryUtilization = getMemoryUtilization(&dockerStats.MemoryStats)
+
+	return response
+}
+
+// GetCPUUtilization returns the container's CPU utilization between Docker's previous and latest samples, normalized
+// as set in ECS_LOCAL_CPU_PERCENT_MODE, or nil if there is no previous sample
+func GetCPUUtilization(dockerStats *types.Stats) *float64 {
+	latest := newFrame(dockerStats, nil)
+	previous := frame{
+		cpuUsage:    dockerStats.PreCPUStats.CPUUsage.TotalUsage,
+		systemUsage: dockerStats.PreCPUStats.SystemUsage,
+	}
+	if latest.cpuUsage < previous.cpuUsage || latest.systemUsage <= previous.systemUsage {
+		return nil
+	}
+	utilization := float64(latest.cpuUsage-previous.cpuUsage) / float64(latest.systemUsage-previous.systemUsage) * 100
+	if getCPUPercentMode() == config.CPUPercentModeTotal {
+		utilization *= float64(latest.onlineCPUs)
+	}
+	return &utilization
+}
+
+// OmitPerCPUUsage removes the usage of each CPU core, which is large on hosts with many cores
+func (response *ContainerStatsResponse) OmitPerCPUUsage() {
+	response.CPUStats.CPUUsage.PercpuUsage = nil
+	response.PreCPUStats.CPUUsage.PercpuUsage = nil
+}
+
+// getCPUThrottlingRatio returns the fraction of the CFS enforcement periods in which the container was throttled,
+// between Docker's previous and latest samples. Without a previous sample, or if no periods elapsed between them,
+// it is the fraction since the container started. Containers without a CPU quota have no periods.
+func getCPUThrottlingRatio(dockerStats *types.Stats) *float64 {
+	latest := dockerStats.CPUStats.ThrottlingData
+	previous := dockerStats.PreCPUStats.ThrottlingData
+	periods := latest.Periods
+	throttledPeriods := latest.ThrottledPeriods
+	if latest.Periods > previous.Periods && latest.ThrottledPeriods >= previous.ThrottledPeriods {
+		periods -= previous.Periods
+		throttledPeriods -= previous.ThrottledPeriods
+	}
+	if periods == 0 {
+		return nil
+	}
+	ratio := float64(throttledPeriods) / float64(periods)
+	return &ratio
+}
+
+// getMemoryUtilization returns the percentage of the memory limit which is used, not counting the page cache,
+// in the same way as the Docker CLI
+func getMemoryUtilization(memoryStats *types.MemoryStats) *float64 {
+	if memoryStats.Limit == 0 {
+		return nil
+	}
+	used := memoryStats.Usage
+	if cache, ok := memoryStats.Stats["cache"]; ok && cache < used {
+		used -= cache
+	}
+	utilization := float64(used) / float64(memoryStats.Limit) * 100
+	return &utilization
+}
+
+// getMemoryWorkingSet returns the memory which is used, not counting the page cache which the kernel can reclaim
+// first, in the same way as cAdvisor. Docker reports the inactive page cache as total_inactive_file with cgroup v1,
+// and as inactive_file with cgroup v2, where there is no total_ prefix since the stats already include child cgroups.
+func getMemoryWorkingSet(memoryStats *types.MemoryStats) *uint64 {
+	if memoryStats.Usage == 0 {
+		return nil
+	}
+	inactiveFile, ok := memoryStats.Stats["total_inactive_file"]
+	if !ok {
+		inactiveFile = memoryStats.Stats["inactive_file"]
+	}
+	workingSet := memoryStats.Usage
+	if inactiveFile < workingSet {
+		workingSet -= inactiveFile
+	} else {
+		workingSet = 0
+	}
+	return &workingSet
+}
+
+// getMemoryBreakdown returns the container's RSS, page cache, and swap, normalized across cgroup v1 and v2,
+// or nil if Docker reports none of them
+func getMemoryBreakdown(memoryStats *types.MemoryStats) *MemoryBreakdownResponse {
+	breakdown := &MemoryBreakdownResponse{
+		RSS:   getMemoryStat(memoryStats, memoryRSSKeys),
+		Cache: getMemoryStat(memoryStats, memoryCacheKeys),
+		Swap:  getMemoryStat(memoryStats, memorySwapKeys),
+	}
+	if breakdown.RSS == nil && breakdown.Cache == nil && breakdown.Swap == nil {
+		return nil
+	}
+	return breakdown
+}
+
+// getMemoryStat returns the value of the first key which Docker reports
+func getMemoryStat(memoryStats *types.MemoryStats, keys []string) *uint64 {
+	for _, key := range keys {
+		if value, ok := memoryStats.Stats[key]; ok {
+			return &value
+		}
+	}
+	return nil
+}
+
+func getUnlimitedMemoryBehavior() string {
+	behavior := utils.GetValue(config.DefaultUnlimitedMemoryBehavior, config.UnlimitedMemoryBehaviorVar)
+	switch behavior {
+	case config.UnlimitedMemoryHost, config.UnlimitedMemoryOmit:
+		return behavior
+	default:
+		logrus.Warnf("Ignoring invalid value for %s: %s", config.UnlimitedMemoryBehaviorVar, behavior)
+		return config.DefaultUnlimitedMemoryBehavior
+	}
+}
+
+func getCPUPercentMode() string {
+	mode := utils.GetValue(config.DefaultCPUPercentMode, config.CPUPercentModeVar)
+	switch mode {
+	case config.CPUPercentModeTotal, config.CPUPercentModePerCore:
+		return mode
+	default:
+		logrus.Warnf("Ignoring invalid value for %s: %s", config.CPUPercentModeVar, mode)
+		return config.DefaultCPUPercentMode
+	}
+}
@@ -0,0 +1,531 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package stats
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/testingutils"
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	containerName = "ecs-local-endpoints"
+	containerID   = "c3439823c17dc7a35c7e272b7dc51cb2dcdedcef428242fcd0f5473d2c724d0"
+	hostMemory    = 2 * 1024 * 1024 * 1024
+)
+
+func TestGetContainerStatsMemoryUtilization(t *testing.T) {
+	dockerStats := &types.Stats{
+		MemoryStats: types.MemoryStats{
+			Usage: 300 * 1024 * 1024,
+			Limit: 1024 * 1024 * 1024,
+			Stats: map[string]uint64{
+				"cache": 44 * 1024 * 1024,
+			},
+		},
+	}
+	inspect := testingutils.BaseDockerInspect(containerName, containerID).Get()
+	inspect.HostConfig.Memory = 1024 * 1024 * 1024
+
+	actual := GetContainerStats(dockerStats, inspect)
+	assert.False(t, actual.MemoryUnlimited, "Expected the container to have a memory limit")
+	if assert.NotNil(t, actual.MemoryUtilization, "Expected memory utilization") {
+		assert.Equal(t, float64(25), *actual.MemoryUtilization, "Expected memory utilization to exclude the page cache")
+	}
+}
+
+func TestGetContainerStatsMemoryWorkingSet(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		stats    map[string]uint64
+		expected uint64
+	}{
+		{
+			name: "cgroup v1",
+			stats: map[string]uint64{
+				"cache":               100 * 1024 * 1024,
+				"inactive_file":       10 * 1024 * 1024,
+				"total_inactive_file": 60 * 1024 * 1024,
+			},
+			expected: 240 * 1024 * 1024,
+		},
+		{
+			name: "cgroup v2",
+			stats: map[string]uint64{
+				"file":          100 * 1024 * 1024,
+				"inactive_file": 60 * 1024 * 1024,
+			},
+			expected: 240 * 1024 * 1024,
+		},
+		{
+			name:     "no inactive file cache",
+			stats:    map[string]uint64{},
+			expected: 300 * 1024 * 1024,
+		},
+		{
+			name: "inactive file cache exceeds usage",
+			stats: map[string]uint64{
+				"inactive_file": 400 * 1024 * 1024,
+			},
+			expected: 0,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			dockerStats := &types.Stats{
+				MemoryStats: types.MemoryStats{
+					Usage: 300 * 1024 * 1024,
+					Limit: 1024 * 1024 * 1024,
+					Stats: testCase.stats,
+				},
+			}
+
+			actual := GetContainerStats(dockerStats, nil)
+			if assert.NotNil(t, actual.MemoryWorkingSet, "Expected memory working set") {
+				assert.Equal(t, testCase.expected, *actual.MemoryWorkingSet, "Expected memory working set to exclude the inactive file cache")
+			}
+		})
+	}
+
+	actual := GetContainerStats(&types.Stats{}, nil)
+	assert.Nil(t, actual.MemoryWorkingSet, "Expected no memory working set without memory stats")
+}
+
+func TestGetContainerStatsMemoryBreakdown(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		stats    map[string]uint64
+		expected *MemoryBreakdownResponse
+	}{
+		{
+			name: "cgroup v1",
+			stats: map[string]uint64{
+				"cache":       90 * 1024 * 1024,
+				"rss":         150 * 1024 * 1024,
+				"swap":        5 * 1024 * 1024,
+				"total_cache": 100 * 1024 * 1024,
+				"total_rss":   180 * 1024 * 1024,
+				"total_swap":  8 * 1024 * 1024,
+			},
+			expected: &MemoryBreakdownResponse{
+				RSS:   uint64Pointer(180 * 1024 * 1024),
+				Cache: uint64Pointer(100 * 1024 * 1024),
+				Swap:  uint64Pointer(8 * 1024 * 1024),
+			},
+		},
+		{
+			name: "cgroup v2",
+			stats: map[string]uint64{
+				"anon":          180 * 1024 * 1024,
+				"file":          100 * 1024 * 1024,
+				"inactive_file": 60 * 1024 * 1024,
+			},
+			expected: &MemoryBreakdownResponse{
+				RSS:   uint64Pointer(180 * 1024 * 1024),
+				Cache: uint64Pointer(100 * 1024 * 1024),
+			},
+		},
+		{
+			name:     "no memory stats",
+			stats:    map[string]uint64{},
+			expected: nil,
+		},
+	}
+
+	dockerStats := &types.Stats{
+		MemoryStats: types.MemoryStats{
+			Stats: testCases[0].stats,
+		},
+	}
+	assert.Nil(t, GetContainerStats(dockerStats, nil).MemoryBreakdown, "Expected no memory breakdown by default")
+
+	os.Setenv(config.IncludeMemoryBreakdownVar, "true")
+	defer os.Unsetenv(config.IncludeMemoryBreakdownVar)
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			dockerStats := &types.Stats{
+				MemoryStats: types.MemoryStats{
+					Usage: 300 * 1024 * 1024,
+					Limit: 1024 * 1024 * 1024,
+					Stats: testCase.stats,
+				},
+			}
+
+			actual := GetContainerStats(dockerStats, nil)
+			assert.Equal(t, testCase.expected, actual.MemoryBreakdown, "Expected the normalized memory breakdown")
+		})
+	}
+}
+
+func TestGetContainerStatsUnlimitedMemory(t *testing.T) {
+	dockerStats := &types.Stats{
+		MemoryStats: types.MemoryStats{
+			Usage: 512 * 1024 * 1024,
+			Limit: hostMemory,
+		},
+	}
+	inspect := testingutils.BaseDockerInspect(containerName, containerID).Get()
+
+	var testCases = []struct {
+		behavior string
+		expected *float64
+	}{
+		{
+			behavior: "",
+			expected: float64Pointer(25),
+		},
+		{
+			behavior: config.UnlimitedMemoryHost,
+			expected: float64Pointer(25),
+		},
+		{
+			behavior: config.UnlimitedMemoryOmit,
+			expected: nil,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.behavior, func(t *testing.T) {
+			os.Setenv(config.UnlimitedMemoryBehaviorVar, testCase.behavior)
+			defer os.Unsetenv(config.UnlimitedMemoryBehaviorVar)
+
+			actual := GetContainerStats(dockerStats, inspect)
+			assert.True(t, actual.MemoryUnlimited, "Expected the container to be flagged as having no memory limit")
+			assert.Equal(t, testCase.expected, actual.MemoryUtilization, "Expected memory utilization to match")
+		})
+	}
+}
+
+func TestGetCPUUtilization(t *testing.T) {
+	// a container using two cores fully on a four core host; the system usage is summed across cores
+	dockerStats := &types.Stats{}
+	dockerStats.PreCPUStats.CPUUsage.TotalUsage = 2000
+	dockerStats.PreCPUStats.SystemUsage = 4000
+	dockerStats.CPUStats.CPUUsage.TotalUsage = 4000
+	dockerStats.CPUStats.SystemUsage = 8000
+	dockerStats.CPUStats.OnlineCPUs = 4
+
+	var testCases = []struct {
+		mode     string
+		expected float64
+	}{
+		{
+			mode:     config.CPUPercentModeTotal,
+			expected: 200,
+		},
+		{
+			mode:     config.CPUPercentModePerCore,
+			expected: 50,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.mode, func(t *testing.T) {
+			os.Setenv(config.CPUPercentModeVar, testCase.mode)
+			defer os.Unsetenv(config.CPUPercentModeVar)
+
+			if utilization := GetCPUUtilization(dockerStats); assert.NotNil(t, utilization, "Expected CPU utilization") {
+				assert.InDelta(t, testCase.expected, *utilization, 0.0001, "Expected CPU utilization to match")
+			}
+			if response := GetContainerStats(dockerStats, nil); assert.NotNil(t, response.CPUUtilization, "Expected CPU utilization in the response") {
+				assert.InDelta(t, testCase.expected, *response.CPUUtilization, 0.0001, "Expected CPU utilization in the response to match")
+			}
+		})
+	}
+
+	assert.Nil(t, GetCPUUtilization(&types.Stats{}), "Expected no CPU utilization without a previous sample")
+}
+
+func TestGetContainerStatsCPUThrottlingRatio(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		previous types.ThrottlingData
+		latest   types.ThrottlingData
+		expected *float64
+	}{
+		{
+			name:     "throttled between samples",
+			previous: types.ThrottlingData{Periods: 100, ThrottledPeriods: 10, ThrottledTime: 5000000},
+			latest:   types.ThrottlingData{Periods: 200, ThrottledPeriods: 35, ThrottledTime: 9000000},
+			expected: float64Pointer(0.25),
+		},
+		{
+			name:     "no previous sample",
+			latest:   types.ThrottlingData{Periods: 200, ThrottledPeriods: 50, ThrottledTime: 9000000},
+			expected: float64Pointer(0.25),
+		},
+		{
+			name:     "no periods between samples",
+			previous: types.ThrottlingData{Periods: 200, ThrottledPeriods: 20},
+			latest:   types.ThrottlingData{Periods: 200, ThrottledPeriods: 20},
+			expected: float64Pointer(0.1),
+		},
+		{
+			name:     "no CPU quota",
+			expected: nil,
+		},
+	}
+
+	os.Setenv(config.IncludeCPUThrottlingVar, "true")
+	defer os.Unsetenv(config.IncludeCPUThrottlingVar)
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			dockerStats := &types.Stats{}
+			dockerStats.PreCPUStats.ThrottlingData = testCase.previous
+			dockerStats.CPUStats.ThrottlingData = testCase.latest
+
+			actual := GetContainerStats(dockerStats, nil)
+			assert.Equal(t, testCase.expected, actual.CPUThrottlingRatio, "Expected the throttling ratio to match")
+			assert.Equal(t, testCase.latest, actual.CPUStats.ThrottlingData, "Expected the throttling data to be passed through")
+		})
+	}
+}
+
+func TestGetContainerStatsCPUThrottlingRatioDisabled(t *testing.T) {
+	dockerStats := &types.Stats{}
+	dockerStats.CPUStats.ThrottlingData = types.ThrottlingData{Periods: 200, ThrottledPeriods: 50}
+
+	actual := GetContainerStats(dockerStats, nil)
+	assert.Nil(t, actual.CPUThrottlingRatio, "Expected no throttling ratio by default")
+}
+
+func TestGetContainerStatsPreservesTimestamps(t *testing.T) {
+	read := time.Date(2019, 3, 1, 20, 55, 11, 64236631, time.UTC)
+	dockerStats := &types.Stats{
+		Read:    read,
+		PreRead: read.Add(-1 * time.Second),
+	}
+
+	data, err := json.Marshal(GetContainerStats(dockerStats, nil))
+	assert.NoError(t, err, "Unexpected error marshaling stats")
+
+	var fields map[string]interface{}
+	err = json.Unmarshal(data, &fields)
+	assert.NoError(t, err, "Unexpected error unmarshaling stats")
+	assert.Equal(t, "2019-03-01T20:55:11.064236631Z", fields["read"], "Expected the read timestamp from Docker")
+	assert.Equal(t, "2019-03-01T20:55:10.064236631Z", fields["preread"], "Expected the preread timestamp from Docker")
+}
+
+func TestAddMovingAverages(t *testing.T) {
+	var history History
+	read := time.Date(2019, 3, 1, 20, 55, 11, 0, time.UTC)
+	newFrameInTest := func(seconds int, cpuUsage uint64) *ContainerStatsResponse {
+		dockerStats := &types.Stats{
+			Read: read.Add(time.Duration(seconds) * time.Second),
+		}
+		dockerStats.CPUStats.CPUUsage.TotalUsage = cpuUsage
+		dockerStats.CPUStats.SystemUsage = uint64(seconds) * 1000
+		dockerStats.CPUStats.OnlineCPUs = 2
+		return GetContainerStats(dockerStats, nil)
+	}
+
+	response := newFrameInTest(0, 0)
+	history.AddMovingAverages("container", response, nil)
+	assert.Nil(t, response.CPUUtilizationAverage, "Expected no moving average by default")
+
+	os.Setenv(config.StatsMovingAverageWindowVar, "3")
+	defer os.Unsetenv(config.StatsMovingAverageWindowVar)
+
+	// The CPU usage increases by 300, 200, and 400 over each 1000 of system usage
+	var testCases = []struct {
+		name     string
+		seconds  int
+		cpuUsage uint64
+		expected *float64
+	}{
+		{
+			name:     "first frame",
+			seconds:  1,
+			cpuUsage: 100,
+			expected: nil,
+		},
+		{
+			name:     "second frame",
+			seconds:  2,
+			cpuUsage: 400,
+			expected: float64Pointer(60),
+		},
+		{
+			name:     "third frame",
+			seconds:  3,
+			cpuUsage: 600,
+			expected: float64Pointer(50),
+		},
+		{
+			name:     "oldest frame leaves the window",
+			seconds:  4,
+			cpuUsage: 1000,
+			expected: float64Pointer(60),
+		},
+		{
+			name:     "duplicate frame",
+			seconds:  4,
+			cpuUsage: 1000,
+			expected: float64Pointer(60),
+		},
+	}
+
+	var smoothed ContainerStatsResponse
+	for _, testCase := range testCases {
+		response := newFrameInTest(testCase.seconds, testCase.cpuUsage)
+		history.AddMovingAverages("other", response, nil)
+		if testCase.expected == nil {
+			assert.Nil(t, response.CPUUtilizationAverage, testCase.name)
+		} else if assert.NotNil(t, response.CPUUtilizationAverage, testCase.name) {
+			assert.InDelta(t, *testCase.expected, *response.CPUUtilizationAverage, 0.0001, testCase.name)
+		}
+		smoothed = *response
+	}
+	assert.Equal(t, uint64(1000), smoothed.CPUStats.CPUUsage.TotalUsage, "Expected the raw values from Docker to be unchanged")
+}
+
+func TestAddMovingAveragesCPUPercentMode(t *testing.T) {
+	os.Setenv(config.StatsMovingAverageWindowVar, "2")
+	defer os.Unsetenv(config.StatsMovingAverageWindowVar)
+
+	read := time.Date(2019, 3, 1, 20, 55, 11, 0, time.UTC)
+	// a container using two cores fully on a four core host; the system usage is summed across cores
+	newFrameInTest := func(seconds int) *ContainerStatsResponse {
+		dockerStats := &types.Stats{
+			Read: read.Add(time.Duration(seconds) * time.Second),
+		}
+		dockerStats.CPUStats.CPUUsage.TotalUsage = uint64(seconds) * 2000
+		dockerStats.CPUStats.SystemUsage = uint64(seconds) * 4000
+		dockerStats.CPUStats.CPUUsage.PercpuUsage = []uint64{0, 0, 0, 0}
+		return GetContainerStats(dockerStats, nil)
+	}
+
+	var testCases = []struct {
+		mode     string
+		expected float64
+	}{
+		{
+			mode:     "",
+			expected: 200,
+		},
+		{
+			mode:     config.CPUPercentModeTotal,
+			expected: 200,
+		},
+		{
+			mode:     config.CPUPercentModePerCore,
+			expected: 50,
+		},
+		{
+			mode:     "invalid",
+			expected: 200,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.mode, func(t *testing.T) {
+			os.Setenv(config.CPUPercentModeVar, testCase.mode)
+			defer os.Unsetenv(config.CPUPercentModeVar)
+
+			var history History
+			history.AddMovingAverages("container", newFrameInTest(1), nil)
+			response := newFrameInTest(2)
+			history.AddMovingAverages("container", response, nil)
+			if assert.NotNil(t, response.CPUUtilizationAverage, "Expected a moving average") {
+				assert.InDelta(t, testCase.expected, *response.CPUUtilizationAverage, 0.0001, "Expected CPU utilization to match")
+			}
+		})
+	}
+}
+
+func TestAddMovingAveragesNetworkRates(t *testing.T) {
+	os.Setenv(config.StatsMovingAverageWindowVar, "3")
+	defer os.Unsetenv(config.StatsMovingAverageWindowVar)
+
+	read := time.Date(2019, 3, 1, 20, 55, 11, 0, time.UTC)
+	addFrame := func(history *History, seconds int, networks map[string]types.NetworkStats) *ContainerStatsResponse {
+		response := GetContainerStats(&types.Stats{
+			Read: read.Add(time.Duration(seconds) * time.Second),
+		}, nil)
+		history.AddMovingAverages("container", response, networks)
+		return response
+	}
+
+	// the counters are summed across interfaces
+	var history History
+	addFrame(&history, 0, map[string]types.NetworkStats{
+		"eth0": {RxBytes: 1000, TxBytes: 500},
+		"eth1": {RxBytes: 0, TxBytes: 0},
+	})
+	response := addFrame(&history, 2, map[string]types.NetworkStats{
+		"eth0": {RxBytes: 3000, TxBytes: 1500},
+		"eth1": {RxBytes: 2000, TxBytes: 0},
+	})
+	if assert.NotNil(t, response.NetworkRxBytesPerSecondAverage, "Expected a received rate") {
+		assert.InDelta(t, 2000, *response.NetworkRxBytesPerSecondAverage, 0.0001, "Expected the bytes received per second")
+	}
+	if assert.NotNil(t, response.NetworkTxBytesPerSecondAverage, "Expected a transmitted rate") {
+		assert.InDelta(t, 500, *response.NetworkTxBytesPerSecondAverage, 0.0001, "Expected the bytes transmitted per second")
+	}
+
+	// the oldest frame leaves the window
+	response = addFrame(&history, 3, map[string]types.NetworkStats{
+		"eth0": {RxBytes: 5000, TxBytes: 2000},
+		"eth1": {RxBytes: 2000, TxBytes: 0},
+	})
+	response = addFrame(&history, 4, map[string]types.NetworkStats{
+		"eth0": {RxBytes: 5000, TxBytes: 3500},
+		"eth1": {RxBytes: 3000, TxBytes: 0},
+	})
+	if assert.NotNil(t, response.NetworkRxBytesPerSecondAverage, "Expected a received rate") {
+		assert.InDelta(t, 1500, *response.NetworkRxBytesPerSecondAverage, 0.0001, "Expected the bytes received per second over the window")
+	}
+	if assert.NotNil(t, response.NetworkTxBytesPerSecondAverage, "Expected a transmitted rate") {
+		assert.InDelta(t, 1000, *response.NetworkTxBytesPerSecondAverage, 0.0001, "Expected the bytes transmitted per second over the window")
+	}
+
+	// containers in the host's network namespace have no network stats
+	var hostHistory History
+	addFrame(&hostHistory, 0, nil)
+	response = addFrame(&hostHistory, 1, nil)
+	assert.Nil(t, response.NetworkRxBytesPerSecondAverage, "Expected no received rate without network stats")
+	assert.Nil(t, response.NetworkTxBytesPerSecondAverage, "Expected no transmitted rate without network stats")
+}
+
+func TestHistoryPrune(t *testing.T) {
+	os.Setenv(config.StatsMovingAverageWindowVar, "2")
+	defer os.Unsetenv(config.StatsMovingAverageWindowVar)
+
+	var history History
+	for _, containerID := range []string{"running", "removed"} {
+		history.AddMovingAverages(containerID, GetContainerStats(&types.Stats{Read: time.Now()}, nil), nil)
+	}
+	assert.Len(t, history.frames, 2, "Expected the stats of both containers")
+
+	history.Prune([]types.Container{{ID: "running"}})
+	assert.Len(t, history.frames, 1, "Expected the stats of the removed container to be discarded")
+	assert.Contains(t, history.frames, "running", "Expected the stats of the running container to be kept")
+}
+
+func float64Pointer(f float64) *float64 {
+	return &f
+}
+
+func uint64Pointer(u uint64) *uint64 {
+	return &u
+}
@@ -0,0 +1,61 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package testingutils provides functionality that is useful in tests accross this project
+package testingutils
+
+import (
+	"fmt"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+)
+
+// DockerInspect wraps types.ContainerJSON, and makes it easy to create
+// mock inspect responses in tests
+type DockerInspect struct {
+	inspect types.ContainerJSON
+}
+
+// BaseDockerInspect returns a base inspect response that can be customized
+// All of the optional sections of the response are present, so tests can set values in them directly
+func BaseDockerInspect(name, containerID string) *DockerInspect {
+	inspect := types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:   containerID,
+			Name: fmt.Sprintf("/%s", name),
+			State: &types.ContainerState{
+				Status:  "running",
+				Running: true,
+			},
+			Image:      imageID,
+			HostConfig: &container.HostConfig{},
+		},
+		Config: &container.Config{
+			Image: image,
+		},
+		NetworkSettings: &types.NetworkSettings{
+			Networks: make(map[string]*network.EndpointSettings),
+		},
+	}
+
+	return &DockerInspect{
+		inspect: inspect,
+	}
+}
+
+// Get returns the underlying types.ContainerJSON
+func (inspect *DockerInspect) Get() *types.ContainerJSON {
+	return &inspect.inspect
+}
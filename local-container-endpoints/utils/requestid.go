@@ -0,0 +1,43 @@
+// Copyright 2019 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may
+// not use this file except in compliance with the License. A copy of the
+// License is located at
+//
+//	http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either
+// express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package utils
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+)
+
+// requestIDKey is the context key of the request's ID
+type requestIDKey struct{}
+
+// WithRequestID returns a shallow copy of the request, whose context carries the request's ID
+func WithRequestID(r *http.Request, requestID string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), requestIDKey{}, requestID))
+}
+
+// GetRequestID returns the request's ID, or an empty string if it has none
+func GetRequestID(r *http.Request) string {
+	requestID, _ := r.Context().Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// RequestLogger returns a logger whose lines include the request's ID as request_id, if it has one
+func RequestLogger(r *http.Request) *logrus.Entry {
+	if requestID := GetRequestID(r); requestID != "" {
+		return logrus.WithField("request_id", requestID)
+	}
+	return logrus.NewEntry(logrus.StandardLogger())
+}
@@ -16,8 +16,13 @@ package utils
 
 import (
 	"fmt"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
 )
 
 // Truncate truncates a string
@@ -29,6 +34,23 @@ func Truncate(s string, length int) string {
 	return s
 }
 
+// RedactQueryParam returns the request URI with the value of the query parameter replaced, so that a secret
+// passed in the query is not logged
+func RedactQueryParam(requestURI, param string) string {
+	questionMark := strings.Index(requestURI, "?")
+	if param == "" || questionMark < 0 {
+		return requestURI
+	}
+	fields := strings.Split(requestURI[questionMark+1:], "&")
+	for i, field := range fields {
+		key := strings.SplitN(field, "=", 2)[0]
+		if unescaped, err := url.QueryUnescape(key); err == nil && unescaped == param {
+			fields[i] = key + "=REDACTED"
+		}
+	}
+	return requestURI[:questionMark+1] + strings.Join(fields, "&")
+}
+
 // GetTagsMap parses tags in the format key1=value1,key2=value2
 func GetTagsMap(value string) (map[string]string, error) {
 	tags := make(map[string]string)
@@ -51,3 +73,43 @@ func GetValue(defaultVal, envVar string) string {
 
 	return defaultVal
 }
+
+// GetDurationValue returns the duration parsed from the envVar, or the default
+func GetDurationValue(defaultVal, envVar string) time.Duration {
+	if val := os.Getenv(envVar); val != "" {
+		duration, err := time.ParseDuration(val)
+		if err == nil {
+			return duration
+		}
+		logrus.Warnf("Ignoring %s: %s", envVar, err)
+	}
+
+	duration, _ := time.ParseDuration(defaultVal)
+	return duration
+}
+
+// GetBoolValue returns the boolean value of the envVar, or the default
+func GetBoolValue(defaultVal bool, envVar string) bool {
+	if val := os.Getenv(envVar); val != "" {
+		parsed, err := strconv.ParseBool(val)
+		if err == nil {
+			return parsed
+		}
+		logrus.Warnf("Ignoring %s: %s", envVar, err)
+	}
+
+	return defaultVal
+}
+
+// GetIntValue returns the integer value of the envVar, or the default
+func GetIntValue(defaultVal int, envVar string) int {
+	if val := os.Getenv(envVar); val != "" {
+		parsed, err := strconv.Atoi(val)
+		if err == nil {
+			return parsed
+		}
+		logrus.Warnf("Ignoring %s: %s", envVar, err)
+	}
+
+	return defaultVal
+}
@@ -14,11 +14,14 @@
 package main
 
 import (
-	"fmt"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/config"
 	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/handlers"
+	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/server"
 	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/utils"
 	"github.com/awslabs/amazon-ecs-local-container-endpoints/local-container-endpoints/version"
 	"github.com/gorilla/mux"
@@ -38,18 +41,59 @@ func main() {
 		logrus.Fatal("Failed to create Metadata Service: ", err)
 	}
 
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		for range hangups {
+			credentialsService.Reload()
+		}
+	}()
+
 	port := utils.GetValue(config.DefaultPort, config.PortVar)
 
 	router := mux.NewRouter()
 	metadataService.SetupV2Routes(router)
 	metadataService.SetupV3Routes(router)
+	handlers.SetupMetadataVersionsRoutes(router)
 	credentialsService.SetupRoutes(router)
+	server.SetupPprofRoutes(router)
+	metadataService.SetupMetricsRoutes(router)
+	handlers.SetupSchemaRoutes(router)
+	handlers.SetupDebugRoutes(router)
+	handlers.SetupHealthRoutes(router, metadataService, credentialsService)
+
+	go func() {
+		if err := server.ServePprof(); err != nil {
+			logrus.Error("Profiling HTTP Server exited with error: ", err)
+		}
+	}()
+
+	listener, err := server.Listen(port)
+	if err != nil {
+		logrus.Fatal("Failed to start HTTP Server: ", err)
+	}
+	if listener, err = server.WithConnectionLimit(listener); err != nil {
+		logrus.Fatal("Failed to limit connections: ", err)
+	}
+	// the certificate is loaded before dropping privileges, so that its private key can be readable only by root
+	if listener, err = server.WithTLS(listener); err != nil {
+		logrus.Fatal("Failed to enable TLS: ", err)
+	}
+	if err = server.DropPrivileges(); err != nil {
+		logrus.Fatal("Failed to drop privileges: ", err)
+	}
 
-	server := http.Server{
-		Addr:    fmt.Sprintf(":%s", port),
-		Handler: router,
+	// the client is found before the access log, so that it logs the client rather than the proxy
+	// the request ID is set before the metrics, so that generated IDs are in their exemplars
+	handler, err := server.WithTrustedProxies(server.WithAccessLog(server.WithRequestID(server.WithMetrics(router)), os.Stdout))
+	if err != nil {
+		logrus.Fatal("Failed to configure trusted proxies: ", err)
+	}
+	server.LogStartupBanner()
+	httpServer := http.Server{
+		Handler: handler,
 	}
-	err = server.ListenAndServe()
+	err = httpServer.Serve(listener)
 	if err != nil {
 		logrus.Fatal("HTTP Server exited with error: ", err)
 	}